@@ -0,0 +1,392 @@
+package logdb
+
+import (
+	"github.com/worldiety/ioutil"
+	"math"
+	"math/bits"
+)
+
+// Aggregator computes a state over objects in parallel. Each go routine gets its own state from New, which
+// is updated without any locking. Afterwards all states are combined using Merge.
+type Aggregator interface {
+	// New returns an empty state, usually a pointer.
+	New() interface{}
+
+	// Update adds the object to the state.
+	Update(state interface{}, obj *Object)
+
+	// Merge combines both states and returns the result. It may modify and return a.
+	Merge(a, b interface{}) interface{}
+}
+
+// AggregatorFuncs implements an Aggregator using functions.
+type AggregatorFuncs struct {
+	NewState    func() interface{}
+	UpdateState func(state interface{}, obj *Object)
+	MergeStates func(a, b interface{}) interface{}
+}
+
+func (a AggregatorFuncs) New() interface{} {
+	return a.NewState()
+}
+
+func (a AggregatorFuncs) Update(state interface{}, obj *Object) {
+	a.UpdateState(state, obj)
+}
+
+func (a AggregatorFuncs) Merge(x, y interface{}) interface{} {
+	return a.MergeStates(x, y)
+}
+
+// Aggregate walks in parallel over all flushed objects, see ForEachP, and returns the merged state.
+func (db *DB) Aggregate(routines int, agg Aggregator) (interface{}, error) {
+	if routines < 1 {
+		routines = 1
+	}
+
+	states := make([]interface{}, routines)
+	for i := range states {
+		states[i] = agg.New()
+	}
+
+	err := db.ForEachP(routines, func(gid int, id uint64, obj *Object) error {
+		agg.Update(states[gid], obj)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := states[0]
+	for _, state := range states[1:] {
+		res = agg.Merge(res, state)
+	}
+
+	return res, nil
+}
+
+// numberField returns the first numeric value of the field with the given name.
+func numberField(obj *Object, name uint16) (float64, bool) {
+	var v float64
+	found := false
+	obj.WithFields(func(fieldName uint16, kind ioutil.Type, f *FieldReader) {
+		if !found && fieldName == name && kind.IsNumber() {
+			v = f.ReadFloat()
+			found = !math.IsNaN(v)
+		}
+	})
+
+	return v, found
+}
+
+// Aggregators combines multiple aggregators into one, whose state is a []interface{} with the states of
+// each aggregator in the same order.
+func Aggregators(aggs ...Aggregator) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} {
+			states := make([]interface{}, len(aggs))
+			for i, agg := range aggs {
+				states[i] = agg.New()
+			}
+			return states
+		},
+		UpdateState: func(state interface{}, obj *Object) {
+			for i, s := range state.([]interface{}) {
+				aggs[i].Update(s, obj)
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			x, y := a.([]interface{}), b.([]interface{})
+			for i := range x {
+				x[i] = aggs[i].Merge(x[i], y[i])
+			}
+			return x
+		},
+	}
+}
+
+// CountState is the state of Count.
+type CountState struct {
+	N uint64
+}
+
+// Count counts the objects which contain a numeric field with the given name.
+func Count(name uint16) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} { return &CountState{} },
+		UpdateState: func(state interface{}, obj *Object) {
+			if _, ok := numberField(obj, name); ok {
+				state.(*CountState).N++
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			a.(*CountState).N += b.(*CountState).N
+			return a
+		},
+	}
+}
+
+// SumState is the state of Sum.
+type SumState struct {
+	N   uint64
+	Sum float64
+}
+
+// Sum adds up the values of the numeric field with the given name.
+func Sum(name uint16) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} { return &SumState{} },
+		UpdateState: func(state interface{}, obj *Object) {
+			if v, ok := numberField(obj, name); ok {
+				s := state.(*SumState)
+				s.N++
+				s.Sum += v
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			x, y := a.(*SumState), b.(*SumState)
+			x.N += y.N
+			x.Sum += y.Sum
+			return x
+		},
+	}
+}
+
+// MinMaxState is the state of MinMax. Min and Max are only valid, if N is not zero.
+type MinMaxState struct {
+	N   uint64
+	Min float64
+	Max float64
+}
+
+func (s *MinMaxState) add(v float64) {
+	if s.N == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.N == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.N++
+}
+
+// MinMax determines the smallest and largest value of the numeric field with the given name.
+func MinMax(name uint16) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} { return &MinMaxState{} },
+		UpdateState: func(state interface{}, obj *Object) {
+			if v, ok := numberField(obj, name); ok {
+				state.(*MinMaxState).add(v)
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			x, y := a.(*MinMaxState), b.(*MinMaxState)
+			if y.N > 0 {
+				n := x.N
+				x.add(y.Min)
+				x.add(y.Max)
+				x.N = n + y.N
+			}
+			return x
+		},
+	}
+}
+
+// MeanVarianceState is the state of MeanVariance, using Welford's online algorithm.
+type MeanVarianceState struct {
+	N    uint64
+	Mean float64
+	M2   float64 // sum of squared differences from the mean
+}
+
+// Variance returns the population variance.
+func (s *MeanVarianceState) Variance() float64 {
+	if s.N == 0 {
+		return math.NaN()
+	}
+	return s.M2 / float64(s.N)
+}
+
+// SampleVariance returns the unbiased sample variance.
+func (s *MeanVarianceState) SampleVariance() float64 {
+	if s.N < 2 {
+		return math.NaN()
+	}
+	return s.M2 / float64(s.N-1)
+}
+
+// StdDev returns the population standard deviation.
+func (s *MeanVarianceState) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// MeanVariance computes the mean and variance of the numeric field with the given name in a single pass.
+func MeanVariance(name uint16) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} { return &MeanVarianceState{} },
+		UpdateState: func(state interface{}, obj *Object) {
+			if v, ok := numberField(obj, name); ok {
+				s := state.(*MeanVarianceState)
+				s.N++
+				delta := v - s.Mean
+				s.Mean += delta / float64(s.N)
+				s.M2 += delta * (v - s.Mean)
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			x, y := a.(*MeanVarianceState), b.(*MeanVarianceState)
+			if y.N == 0 {
+				return x
+			}
+
+			n := x.N + y.N
+			delta := y.Mean - x.Mean
+			x.M2 += y.M2 + delta*delta*float64(x.N)*float64(y.N)/float64(n)
+			x.Mean += delta * float64(y.N) / float64(n)
+			x.N = n
+			return x
+		},
+	}
+}
+
+// HistogramState is the state of Histogram. Bucket i counts the values within
+// [Min + i*width, Min + (i+1)*width), where the width is (Max-Min)/len(Buckets).
+type HistogramState struct {
+	Min     float64
+	Max     float64
+	Buckets []uint64
+	Under   uint64 // amount of values less than Min
+	Over    uint64 // amount of values greater than or equal to Max
+}
+
+// add counts the value. NaN values are not counted at all.
+func (s *HistogramState) add(v float64) {
+	switch {
+	case math.IsNaN(v):
+	case v < s.Min:
+		s.Under++
+	case v >= s.Max:
+		s.Over++
+	default:
+		// rounding or infinite bounds may produce an index outside of the buckets
+		i := int((v - s.Min) / (s.Max - s.Min) * float64(len(s.Buckets)))
+		if i < 0 {
+			i = 0
+		} else if i >= len(s.Buckets) {
+			i = len(s.Buckets) - 1
+		}
+		s.Buckets[i]++
+	}
+}
+
+// Histogram counts the values of the numeric field with the given name in equally sized buckets. At least one
+// bucket is used and, if max is not greater than min, all values are counted as Under or Over.
+func Histogram(name uint16, min, max float64, buckets int) Aggregator {
+	if buckets < 1 {
+		buckets = 1
+	}
+
+	return AggregatorFuncs{
+		NewState: func() interface{} {
+			return &HistogramState{Min: min, Max: max, Buckets: make([]uint64, buckets)}
+		},
+		UpdateState: func(state interface{}, obj *Object) {
+			if v, ok := numberField(obj, name); ok {
+				state.(*HistogramState).add(v)
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			x, y := a.(*HistogramState), b.(*HistogramState)
+			for i, n := range y.Buckets {
+				x.Buckets[i] += n
+			}
+			x.Under += y.Under
+			x.Over += y.Over
+			return x
+		},
+	}
+}
+
+// hllPrecision is the amount of hash bits which select a register, resulting in a standard error of
+// about 0.8%.
+const hllPrecision = 14
+
+// HyperLogLog estimates the amount of distinct values using a fixed amount of memory.
+type HyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+	tmp       []byte
+}
+
+// mix64 is the finalizer of splitmix64, which distributes the bits of the FNV hash evenly.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// AddHash adds a well distributed 64 bit hash of a value.
+func (h *HyperLogLog) AddHash(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Merge combines the registers of both estimators.
+func (h *HyperLogLog) Merge(o *HyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// Estimate returns the estimated amount of distinct values.
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// DistinctCount estimates the amount of distinct values of the field with the given name, which may have any
+// type. Integers and integral floats compare equal independent of their encoded width, see EqualInt. The
+// state is a *HyperLogLog.
+func DistinctCount(name uint16) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} { return &HyperLogLog{} },
+		UpdateState: func(state interface{}, obj *Object) {
+			h := state.(*HyperLogLog)
+			found := false
+			obj.WithFields(func(fieldName uint16, kind ioutil.Type, f *FieldReader) {
+				if !found && fieldName == name {
+					h.tmp = appendValue(h.tmp[:0], kind, f)
+					h.AddHash(mix64(bloomHash(h.tmp)))
+					found = true
+				}
+			})
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			a.(*HyperLogLog).Merge(b.(*HyperLogLog))
+			return a
+		},
+	}
+}
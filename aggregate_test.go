@@ -0,0 +1,128 @@
+package logdb
+
+import (
+	ioutil2 "io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "aggregateTest")
+	assertNil(t, err)
+
+	db, err := OpenOptions(filepath.Join(dir, "agg.bin"), Options{})
+	assertNil(t, err)
+	defer db.Close()
+
+	colSensorId := db.PutName("SensorId")
+	colTemperature := db.PutName("Temperature")
+
+	const count = 10_000
+	exactMean, exactM2 := 0.0, 0.0
+	for i := 0; i < count; i++ {
+		temperature := i % 100
+		err := db.Add(func(obj *Object) error {
+			obj.AddUint32(colSensorId, uint32(i%37))
+			obj.AddInt8(colTemperature, int8(temperature))
+			return nil
+		})
+		assertNil(t, err)
+
+		if i%1000 == 999 {
+			assertNil(t, db.Flush())
+		}
+
+		delta := float64(temperature) - exactMean
+		exactMean += delta / float64(i+1)
+		exactM2 += delta * (float64(temperature) - exactMean)
+	}
+	assertNil(t, db.Flush())
+
+	res, err := db.Aggregate(3, Aggregators(
+		Count(colTemperature),
+		Sum(colTemperature),
+		MinMax(colTemperature),
+		MeanVariance(colTemperature),
+		Histogram(colTemperature, 0, 100, 10),
+		DistinctCount(colSensorId),
+		Quantiles(colTemperature, 100),
+	))
+	assertNil(t, err)
+
+	states := res.([]interface{})
+	if n := states[0].(*CountState).N; n != count {
+		t.Fatalf("expected count %d but got %d", count, n)
+	}
+
+	if sum := states[1].(*SumState).Sum; sum != 100*4950 {
+		t.Fatalf("unexpected sum %f", sum)
+	}
+
+	if mm := states[2].(*MinMaxState); mm.Min != 0 || mm.Max != 99 {
+		t.Fatalf("unexpected min max %+v", mm)
+	}
+
+	mv := states[3].(*MeanVarianceState)
+	if math.Abs(mv.Mean-exactMean) > 1e-9 || math.Abs(mv.Variance()-exactM2/count) > 1e-6 {
+		t.Fatalf("unexpected mean %f and variance %f", mv.Mean, mv.Variance())
+	}
+
+	for i, n := range states[4].(*HistogramState).Buckets {
+		if n != count/10 {
+			t.Fatalf("unexpected histogram bucket %d: %d", i, n)
+		}
+	}
+
+	if distinct := states[5].(*HyperLogLog).Estimate(); distinct != 37 {
+		t.Fatalf("expected 37 distinct sensors but got %d", distinct)
+	}
+
+	digest := states[6].(*TDigest)
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		if v := digest.Quantile(q); math.Abs(v-q*100) > 1.5 {
+			t.Fatalf("unexpected quantile %f: %f", q, v)
+		}
+	}
+
+	// a custom aggregator, which counts the objects per sensor
+	res, err = db.Aggregate(2, AggregatorFuncs{
+		NewState: func() interface{} { return make(map[uint32]int) },
+		UpdateState: func(state interface{}, obj *Object) {
+			v, _ := numberField(obj, colSensorId)
+			state.(map[uint32]int)[uint32(v)]++
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			for k, v := range b.(map[uint32]int) {
+				a.(map[uint32]int)[k] += v
+			}
+			return a
+		},
+	})
+	assertNil(t, err)
+
+	if perSensor := res.(map[uint32]int); len(perSensor) != 37 || perSensor[0] != 271 {
+		t.Fatalf("unexpected counts per sensor %v", perSensor)
+	}
+}
+
+func TestHistogramArguments(t *testing.T) {
+	for _, tc := range []struct {
+		min, max float64
+		buckets  int
+	}{{0, 10, 0}, {0, 10, -1}, {10, 10, 4}, {10, 0, 4}, {math.Inf(-1), math.Inf(1), 4}} {
+		s := Histogram(0, tc.min, tc.max, tc.buckets).New().(*HistogramState)
+		for _, v := range []float64{-5, 0, 5, 10, 15, math.NaN()} {
+			s.add(v)
+		}
+
+		var total uint64
+		for _, n := range s.Buckets {
+			total += n
+		}
+
+		if total+s.Under+s.Over != 5 {
+			t.Fatalf("unexpected histogram %+v for %+v", s, tc)
+		}
+	}
+}
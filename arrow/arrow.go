@@ -0,0 +1,326 @@
+// Package arrow converts objects into Apache Arrow record batches and reads and writes them in the Arrow IPC
+// file and stream formats, so that analytics libraries can consume them without parsing. Only the flat
+// types which correspond to field types are supported: null, integers, floats, bools, utf8 and binary.
+package arrow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// TypeID identifies an Arrow type, using the ids of the Type union of the Arrow schema.
+type TypeID uint8
+
+const (
+	Null        TypeID = 1
+	Int         TypeID = 2
+	Float       TypeID = 3
+	Binary      TypeID = 4
+	Utf8        TypeID = 5
+	Bool        TypeID = 6
+	LargeBinary TypeID = 19
+	LargeUtf8   TypeID = 20
+)
+
+var typeNames = map[TypeID]string{
+	Null: "null", Int: "int", Float: "float", Binary: "binary", Utf8: "utf8", Bool: "bool",
+	LargeBinary: "large_binary", LargeUtf8: "large_utf8",
+}
+
+// Type is an Arrow data type.
+type Type struct {
+	ID       TypeID
+	BitWidth int  // of integers and floats, i.e. 8, 16, 32 or 64 respectively 32 or 64
+	Signed   bool // of integers
+}
+
+func (t Type) String() string {
+	switch t.ID {
+	case Int:
+		if t.Signed {
+			return fmt.Sprintf("int%d", t.BitWidth)
+		}
+		return fmt.Sprintf("uint%d", t.BitWidth)
+	case Float:
+		return fmt.Sprintf("float%d", t.BitWidth)
+	default:
+		if name, ok := typeNames[t.ID]; ok {
+			return name
+		}
+		return fmt.Sprintf("type(%d)", t.ID)
+	}
+}
+
+// fixedWidth returns the bytes per value of integers and floats or 0.
+func (t Type) fixedWidth() int {
+	if t.ID == Int || t.ID == Float {
+		return t.BitWidth / 8
+	}
+	return 0
+}
+
+// offsetWidth returns the bytes per offset of variable sized types or 0.
+func (t Type) offsetWidth() int {
+	switch t.ID {
+	case Binary, Utf8:
+		return 4
+	case LargeBinary, LargeUtf8:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// Field is a nullable column of a schema.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// Schema describes the columns of record batches.
+type Schema struct {
+	Fields []Field
+}
+
+// Array contains the values of a column in the Arrow memory layout. Validity is empty if there are no
+// nulls.
+type Array struct {
+	Type      Type
+	Length    int
+	NullCount int
+	Validity  []byte // bitmap, least significant bit first
+	Offsets   []byte // of variable sized values, Length+1 offsets
+	Values    []byte
+}
+
+// IsNull returns whether the i-th value is null.
+func (a *Array) IsNull(i int) bool {
+	if a.Type.ID == Null {
+		return true
+	}
+	return len(a.Validity) > 0 && a.Validity[i/8]&(1<<(i%8)) == 0
+}
+
+// Int returns the i-th value of a signed or unsigned integer or bool array.
+func (a *Array) Int(i int) int64 {
+	if a.Type.ID == Bool {
+		if a.Values[i/8]&(1<<(i%8)) != 0 {
+			return 1
+		}
+		return 0
+	}
+
+	b := a.Values[i*a.Type.BitWidth/8:]
+	switch a.Type.BitWidth {
+	case 8:
+		if a.Type.Signed {
+			return int64(int8(b[0]))
+		}
+		return int64(b[0])
+	case 16:
+		if a.Type.Signed {
+			return int64(int16(binary.LittleEndian.Uint16(b)))
+		}
+		return int64(binary.LittleEndian.Uint16(b))
+	case 32:
+		if a.Type.Signed {
+			return int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		return int64(binary.LittleEndian.Uint32(b))
+	default:
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+}
+
+// Float returns the i-th value of a float array.
+func (a *Array) Float(i int) float64 {
+	if a.Type.BitWidth == 32 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(a.Values[i*4:])))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(a.Values[i*8:]))
+}
+
+// Bytes returns the i-th value of a utf8 or binary array without copying.
+func (a *Array) Bytes(i int) []byte {
+	return a.Values[a.offset(i):a.offset(i+1)]
+}
+
+func (a *Array) offset(i int) int {
+	if a.Type.offsetWidth() == 8 {
+		return int(binary.LittleEndian.Uint64(a.Offsets[i*8:]))
+	}
+	return int(binary.LittleEndian.Uint32(a.Offsets[i*4:]))
+}
+
+// validate checks that the buffers are large enough for the length of the array.
+func (a *Array) validate() error {
+	if a.Type.ID == Null {
+		return nil
+	}
+
+	if a.NullCount > 0 && len(a.Validity) < (a.Length+7)/8 {
+		return fmt.Errorf("validity bitmap of %d bytes is too small for %d values", len(a.Validity), a.Length)
+	}
+
+	switch {
+	case a.Type.ID == Bool:
+		if len(a.Values) < (a.Length+7)/8 {
+			return fmt.Errorf("bool bitmap of %d bytes is too small for %d values", len(a.Values), a.Length)
+		}
+	case a.Type.fixedWidth() > 0:
+		if len(a.Values) < a.Length*a.Type.fixedWidth() {
+			return fmt.Errorf("%s buffer of %d bytes is too small for %d values", a.Type, len(a.Values), a.Length)
+		}
+	case a.Type.offsetWidth() > 0:
+		if len(a.Offsets) < (a.Length+1)*a.Type.offsetWidth() {
+			return fmt.Errorf("offsets of %d bytes are too small for %d values", len(a.Offsets), a.Length)
+		}
+
+		for i := 0; i < a.Length; i++ {
+			if start, end := a.offset(i), a.offset(i+1); start < 0 || start > end || end > len(a.Values) {
+				return fmt.Errorf("invalid offsets %d and %d of value %d", start, end, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordBatch is a set of equally long columns.
+type RecordBatch struct {
+	Schema  *Schema
+	Length  int
+	Columns []*Array
+}
+
+// Column returns the column of a field or nil.
+func (b *RecordBatch) Column(name string) *Array {
+	for i, f := range b.Schema.Fields {
+		if f.Name == name {
+			return b.Columns[i]
+		}
+	}
+	return nil
+}
+
+// arrayBuilder appends values to an array.
+type arrayBuilder struct {
+	array Array
+}
+
+func newArrayBuilder(t Type) *arrayBuilder {
+	b := &arrayBuilder{array: Array{Type: t}}
+	if t.offsetWidth() > 0 {
+		b.array.Offsets = make([]byte, t.offsetWidth())
+	}
+	return b
+}
+
+// appendNull appends a null value.
+func (b *arrayBuilder) appendNull() {
+	a := &b.array
+	if a.Type.ID != Null {
+		if len(a.Validity) == 0 {
+			// all previous values are valid
+			for i := 0; i < a.Length; i++ {
+				b.setValid(i)
+			}
+		}
+		b.grow()
+
+		switch {
+		case a.Type.fixedWidth() > 0:
+			a.Values = append(a.Values, make([]byte, a.Type.fixedWidth())...)
+		case a.Type.offsetWidth() > 0:
+			b.appendOffset()
+		}
+	}
+
+	a.Length++
+	a.NullCount++
+}
+
+// grow extends the validity bitmap, if required for the next value.
+func (b *arrayBuilder) grow() {
+	if b.array.Length%8 == 0 {
+		b.array.Validity = append(b.array.Validity, 0)
+	}
+}
+
+func (b *arrayBuilder) setValid(i int) {
+	for len(b.array.Validity) <= i/8 {
+		b.array.Validity = append(b.array.Validity, 0)
+	}
+	b.array.Validity[i/8] |= 1 << (i % 8)
+}
+
+// appended marks the last value as valid.
+func (b *arrayBuilder) appended() {
+	if b.array.NullCount > 0 {
+		b.setValid(b.array.Length)
+	}
+	b.array.Length++
+}
+
+func (b *arrayBuilder) appendInt(v int64) {
+	a := &b.array
+	switch a.Type.BitWidth {
+	case 8:
+		a.Values = append(a.Values, byte(v))
+	case 16:
+		a.Values = append(a.Values, byte(v), byte(v>>8))
+	case 32:
+		a.Values = append(a.Values, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	default:
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], uint64(v))
+		a.Values = append(a.Values, tmp[:]...)
+	}
+	b.appended()
+}
+
+func (b *arrayBuilder) appendFloat(v float64) {
+	a := &b.array
+	if a.Type.BitWidth == 32 {
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(float32(v)))
+		a.Values = append(a.Values, tmp[:]...)
+	} else {
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+		a.Values = append(a.Values, tmp[:]...)
+	}
+	b.appended()
+}
+
+func (b *arrayBuilder) appendBytes(v []byte) {
+	b.array.Values = append(b.array.Values, v...)
+	b.appendOffset()
+	b.appended()
+}
+
+func (b *arrayBuilder) appendOffset() {
+	a := &b.array
+	if a.Type.offsetWidth() == 8 {
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], uint64(len(a.Values)))
+		a.Offsets = append(a.Offsets, tmp[:]...)
+		return
+	}
+
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(a.Values)))
+	a.Offsets = append(a.Offsets, tmp[:]...)
+}
+
+// finish returns the array and resets the builder.
+func (b *arrayBuilder) finish() *Array {
+	a := b.array
+	if a.NullCount == 0 {
+		a.Validity = nil
+	}
+
+	*b = *newArrayBuilder(a.Type)
+	return &a
+}
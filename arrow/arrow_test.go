@@ -0,0 +1,190 @@
+package arrow
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/exporter"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"strings"
+	"testing"
+)
+
+// fillTestDB adds five objects with differing fields.
+func fillTestDB(t *testing.T, db *logdb.DB) {
+	id, temp, name, data := db.PutName("id"), db.PutName("temp"), db.PutName("name"), db.PutName("data")
+	for i := 0; i < 5; i++ {
+		logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+			obj.AddInt(id, int64(i*100-200))
+			if i%2 == 0 {
+				obj.AddField(temp, func(f *logdb.FieldWriter) { f.WriteFloat64(float64(i) + 0.5) })
+			}
+			obj.AddString(name, strings.Repeat("x", i))
+			if i == 3 {
+				obj.AddField(data, func(f *logdb.FieldWriter) { f.WriteBlob([]byte{1, 2, 3}) })
+			}
+			return nil
+		}))
+	}
+	logdbtest.AssertNil(t, db.Flush())
+}
+
+// describeBatch formats the values of a batch column by column.
+func describeBatch(b *RecordBatch) string {
+	var columns []string
+	for i, a := range b.Columns {
+		var values []string
+		for row := 0; row < a.Length; row++ {
+			switch {
+			case a.IsNull(row):
+				values = append(values, "null")
+			case a.Type.ID == Int:
+				values = append(values, fmt.Sprint(a.Int(row)))
+			case a.Type.ID == Float:
+				values = append(values, fmt.Sprint(a.Float(row)))
+			default:
+				values = append(values, fmt.Sprintf("%q", a.Bytes(row)))
+			}
+		}
+		columns = append(columns, b.Schema.Fields[i].Name+":"+a.Type.String()+"="+strings.Join(values, ","))
+	}
+	return strings.Join(columns, " ")
+}
+
+// describeDB formats all fields of all objects as name:type=value.
+func describeDB(t *testing.T, db *logdb.DB) []string {
+	var objects []string
+	err := db.ForEach(func(id uint64, obj *logdb.Object) error {
+		var fields []string
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+			var v interface{}
+			switch {
+			case kind == ioutil.TFloat32 || kind == ioutil.TFloat64:
+				v = f.ReadFloat()
+			case kind.IsNumber():
+				v = f.ReadInt()
+			default:
+				v = f.ReadRaw()
+			}
+			fields = append(fields, fmt.Sprintf("%s:%d=%v", db.NameByIndex(int(name)), kind, v))
+		})
+		objects = append(objects, strings.Join(fields, " "))
+		return nil
+	})
+	logdbtest.AssertNil(t, err)
+	return objects
+}
+
+func TestToArrow(t *testing.T) {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+	fillTestDB(t, db)
+
+	batches, err := ToArrow(db, nil, 2)
+	logdbtest.AssertNil(t, err)
+
+	var got []string
+	for batches.Next() {
+		got = append(got, describeBatch(batches.Batch()))
+	}
+	logdbtest.AssertNil(t, batches.Err())
+
+	expected := []string{
+		`id:int16=-200,-100 temp:float64=0.5,null name:utf8="","x" data:binary=null,null`,
+		`id:int16=0,100 temp:float64=2.5,null name:utf8="xx","xxx" data:binary=null,"\x01\x02\x03"`,
+		`id:int16=200 temp:float64=4.5 name:utf8="xxxx" data:binary=null`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	batches, err = ToArrow(db, []string{"name", "missing"}, 10)
+	logdbtest.AssertNil(t, err)
+	if !batches.Next() {
+		t.Fatal(batches.Err())
+	}
+
+	expected = []string{`name:utf8="","x","xx","xxx","xxxx" missing:null=null,null,null,null,null`}
+	if got := describeBatch(batches.Batch()); got != expected[0] {
+		t.Fatalf("expected %s but got %s", expected[0], got)
+	}
+
+	if batches.Next() {
+		t.Fatal("expected a single batch")
+	}
+}
+
+func TestIPC(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		db := logdbtest.OpenDB(t, logdb.Options{})
+		fillTestDB(t, db)
+
+		var buf bytes.Buffer
+		n, err := Export(db, &buf, exporter.Options{}, stream)
+		logdbtest.AssertNil(t, err)
+		if n != 5 {
+			t.Fatalf("expected 5 objects but got %d", n)
+		}
+
+		if stream == bytes.HasPrefix(buf.Bytes(), fileMagic) {
+			t.Fatalf("expected stream %v", stream)
+		}
+
+		rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+		logdbtest.AssertNil(t, err)
+		if fmt.Sprint(rd.Schema()) != "&{[{id int16} {temp float64} {name utf8} {data binary}]}" {
+			t.Fatalf("unexpected schema %v", rd.Schema())
+		}
+
+		batches := 0
+		for rd.Next() {
+			batches++
+			if rd.Batch().Length != 5 {
+				t.Fatalf("expected 5 rows but got %d", rd.Batch().Length)
+			}
+		}
+		logdbtest.AssertNil(t, rd.Err())
+		if batches != 1 {
+			t.Fatalf("expected a single batch but got %d", batches)
+		}
+
+		imported := logdbtest.OpenDB(t, logdb.Options{})
+		n, err = Import(imported, bytes.NewReader(buf.Bytes()))
+		logdbtest.AssertNil(t, err)
+		if n != 5 {
+			t.Fatalf("expected 5 imported objects but got %d", n)
+		}
+
+		expected, got := describeDB(t, db), describeDB(t, imported)
+		if fmt.Sprint(expected) != fmt.Sprint(got) {
+			t.Fatalf("expected\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+
+		logdbtest.AssertNil(t, db.Close())
+		logdbtest.AssertNil(t, imported.Close())
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+	fillTestDB(t, db)
+
+	var buf bytes.Buffer
+	_, err := Export(db, &buf, exporter.Options{}, false)
+	logdbtest.AssertNil(t, err)
+
+	// truncating the footer or overwriting the metadata must not panic
+	file := buf.Bytes()
+	for _, corrupt := range [][]byte{file[:len(file)-20], append(file[:64:64], make([]byte, len(file)-64)...)} {
+		rd, err := NewReader(bytes.NewReader(corrupt))
+		if err == nil {
+			for rd.Next() {
+			}
+			err = rd.Err()
+		}
+
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+}
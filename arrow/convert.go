@@ -0,0 +1,265 @@
+package arrow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/exporter"
+	"io"
+	"strconv"
+)
+
+// defaultBatchSize is the amount of rows per batch of an export.
+const defaultBatchSize = 64 * 1024
+
+// errBatchFull ends a walk over the objects when a batch is complete.
+var errBatchFull = errors.New("batch is full")
+
+// Batches iterates over the objects of a database as record batches. The schema is the union schema of
+// the selected objects, see exporter.Schema, and only the first value of repeated names is converted.
+type Batches struct {
+	db        *logdb.DB
+	opts      exporter.Options
+	batchSize int
+	schema    *Schema
+	builders  []*arrayBuilder
+	slots     []int // name index to column index or -1
+	next      uint64
+	to        uint64
+	done      bool
+	batch     *RecordBatch
+	err       error
+}
+
+// ToArrow converts the objects with the given names, or all names if empty, into batches with at most
+// batchSize rows. The objects are scanned once upfront to determine the types of the columns.
+func ToArrow(db *logdb.DB, names []string, batchSize int) (*Batches, error) {
+	return Scan(db, exporter.Options{Names: names}, batchSize)
+}
+
+// Scan is like ToArrow, but selects the objects and columns by the options of an export. Routines are
+// ignored, the objects are converted in the order of the database.
+func Scan(db *logdb.DB, opts exporter.Options, batchSize int) (*Batches, error) {
+	if batchSize < 1 {
+		return nil, fmt.Errorf("invalid batch size %d", batchSize)
+	}
+
+	columns, err := exporter.Schema(db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, err := exporter.IDRange(db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Batches{db: db, opts: opts, batchSize: batchSize, schema: &Schema{}, next: from, to: to}
+	b.slots = make([]int, len(db.Names()))
+	for i := range b.slots {
+		b.slots[i] = -1
+	}
+
+	for i, c := range columns {
+		b.schema.Fields = append(b.schema.Fields, Field{Name: c.Name, Type: arrowType(c)})
+		b.builders = append(b.builders, newArrayBuilder(arrowType(c)))
+		if opts.IncludeID && i == 0 {
+			continue
+		}
+
+		if idx := db.IndexByName(c.Name); idx >= 0 {
+			b.slots[idx] = i
+		}
+	}
+
+	return b, nil
+}
+
+// arrowType returns the Arrow type of a column of the union schema.
+func arrowType(c exporter.Column) Type {
+	switch c.Kind {
+	case exporter.KindInt, exporter.KindUint:
+		return Type{ID: Int, BitWidth: c.Bits, Signed: c.Kind == exporter.KindInt}
+	case exporter.KindFloat32:
+		return Type{ID: Float, BitWidth: 32}
+	case exporter.KindFloat64:
+		return Type{ID: Float, BitWidth: 64}
+	case exporter.KindString:
+		return Type{ID: Utf8}
+	case exporter.KindBlob:
+		return Type{ID: Binary}
+	default:
+		return Type{ID: Null}
+	}
+}
+
+// Schema returns the schema of all batches.
+func (b *Batches) Schema() *Schema {
+	return b.schema
+}
+
+// Next converts the next batch and returns false at the end or if an error occurred, see Err.
+func (b *Batches) Next() bool {
+	if b.done || b.err != nil {
+		return false
+	}
+
+	rows := 0
+	set := make([]bool, len(b.builders))
+	err := b.db.ForEachFrom(b.next, func(id uint64, obj *logdb.Object) error {
+		if id >= b.to {
+			b.done = true
+			return errBatchFull
+		}
+
+		if b.opts.Filter != nil && !b.opts.Filter(obj) {
+			return nil
+		}
+
+		if rows == b.batchSize {
+			b.next = id
+			return errBatchFull
+		}
+
+		b.add(id, obj, set)
+		rows++
+		return nil
+	})
+
+	switch err {
+	case nil:
+		b.done = true
+	case errBatchFull:
+	default:
+		b.err = err
+		return false
+	}
+
+	if rows == 0 {
+		return false
+	}
+
+	b.batch = &RecordBatch{Schema: b.schema, Length: rows}
+	for _, builder := range b.builders {
+		b.batch.Columns = append(b.batch.Columns, builder.finish())
+	}
+
+	return true
+}
+
+// add appends the first value of each selected name and nulls for all others.
+func (b *Batches) add(id uint64, obj *logdb.Object, set []bool) {
+	for i := range set {
+		set[i] = false
+	}
+
+	if b.opts.IncludeID {
+		b.builders[0].appendInt(int64(id))
+		set[0] = true
+	}
+
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+		if int(name) >= len(b.slots) || b.slots[name] < 0 || set[b.slots[name]] {
+			return
+		}
+
+		i := b.slots[name]
+		builder := b.builders[i]
+		switch t := builder.array.Type; {
+		case t.ID == Int && (kind <= ioutil.TInt64 || kind == logdb.TBool || kind == logdb.TTimestamp):
+			builder.appendInt(f.ReadInt())
+		case t.ID == Float && isNumeric(kind):
+			if kind >= ioutil.TUint8 && kind <= ioutil.TUint64 {
+				builder.appendFloat(float64(uint64(f.ReadInt())))
+			} else {
+				builder.appendFloat(f.ReadFloat())
+			}
+		case t.ID == Utf8 && isNumeric(kind):
+			builder.appendBytes([]byte(numberText(kind, f)))
+		case t.ID == Utf8 && kind == logdb.TUUID:
+			builder.appendBytes([]byte(f.ReadUUID().String()))
+		case (t.ID == Utf8 || t.ID == Binary) && (kind >= ioutil.TBlob8 && kind <= ioutil.TString32 || kind == logdb.TDictString):
+			builder.appendBytes(f.ReadRaw())
+		default:
+			return
+		}
+		set[i] = true
+	})
+
+	for i, builder := range b.builders {
+		if !set[i] {
+			builder.appendNull()
+		}
+	}
+}
+
+// isNumeric returns true for the field types which are converted into numbers, which are all numbers except
+// complex ones, booleans, timestamps and decimals.
+func isNumeric(kind ioutil.Type) bool {
+	switch kind {
+	case logdb.TBool, logdb.TTimestamp, logdb.TDecimal:
+		return true
+	default:
+		return kind.IsNumber() && kind < ioutil.TComplex64
+	}
+}
+
+// numberText formats a number for a utf8 column. Booleans are formatted as integers, timestamps as their
+// nanoseconds and decimals exactly, like the exporter does.
+func numberText(kind ioutil.Type, f *logdb.FieldReader) string {
+	switch {
+	case kind == logdb.TDecimal:
+		return f.ReadDecimal().String()
+	case kind >= ioutil.TUint8 && kind <= ioutil.TUint64:
+		return strconv.FormatUint(uint64(f.ReadInt()), 10)
+	case kind <= ioutil.TInt64 || kind == logdb.TBool || kind == logdb.TTimestamp:
+		return strconv.FormatInt(f.ReadInt(), 10)
+	case kind == ioutil.TFloat32:
+		return strconv.FormatFloat(f.ReadFloat(), 'g', -1, 32)
+	default:
+		return strconv.FormatFloat(f.ReadFloat(), 'g', -1, 64)
+	}
+}
+
+// Batch returns the current batch.
+func (b *Batches) Batch() *RecordBatch {
+	return b.batch
+}
+
+// Err returns the first error which occurred while reading the database.
+func (b *Batches) Err() error {
+	return b.err
+}
+
+// Export writes the selected objects as an IPC file or stream and returns their amount.
+func Export(db *logdb.DB, w io.Writer, opts exporter.Options, stream bool) (uint64, error) {
+	batches, err := Scan(db, opts, defaultBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wr *Writer
+	if stream {
+		wr, err = NewStreamWriter(w, batches.Schema())
+	} else {
+		wr, err = NewFileWriter(w, batches.Schema())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count := uint64(0)
+	for batches.Next() {
+		if err := wr.Write(batches.Batch()); err != nil {
+			return count, err
+		}
+		count += uint64(batches.Batch().Length)
+	}
+
+	if err := batches.Err(); err != nil {
+		return count, err
+	}
+
+	return count, wr.Close()
+}
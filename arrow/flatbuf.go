@@ -0,0 +1,275 @@
+package arrow
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// fbTable is a flatbuffers table to be encoded. Tables, strings and vectors are written behind the table
+// which refers to them, so that the whole buffer is built front to back.
+type fbTable struct {
+	fields []fbField
+}
+
+// fbField is a scalar or a reference to a string, vector or table.
+type fbField struct {
+	id     int
+	scalar []byte
+	ref    interface{} // string, *fbTable, []*fbTable or fbStructs
+}
+
+// fbStructs is a vector of structs with 8 byte alignment.
+type fbStructs struct {
+	size int // bytes per struct
+	data []byte
+}
+
+func (t *fbTable) uint8(id int, v uint8) *fbTable {
+	t.fields = append(t.fields, fbField{id: id, scalar: []byte{v}})
+	return t
+}
+
+func (t *fbTable) bool(id int, v bool) *fbTable {
+	if v {
+		return t.uint8(id, 1)
+	}
+	return t.uint8(id, 0)
+}
+
+func (t *fbTable) int16(id int, v int16) *fbTable {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(v))
+	t.fields = append(t.fields, fbField{id: id, scalar: b})
+	return t
+}
+
+func (t *fbTable) int32(id int, v int32) *fbTable {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	t.fields = append(t.fields, fbField{id: id, scalar: b})
+	return t
+}
+
+func (t *fbTable) int64(id int, v int64) *fbTable {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	t.fields = append(t.fields, fbField{id: id, scalar: b})
+	return t
+}
+
+func (t *fbTable) ref(id int, v interface{}) *fbTable {
+	t.fields = append(t.fields, fbField{id: id, ref: v})
+	return t
+}
+
+// fbBuilder writes a flatbuffer, whose start must be 8 byte aligned when it is read.
+type fbBuilder struct {
+	buf []byte
+}
+
+// encodeFlatbuffer returns the buffer with the given root table.
+func encodeFlatbuffer(root *fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	pos := b.table(root)
+	binary.LittleEndian.PutUint32(b.buf, uint32(pos))
+	return b.buf
+}
+
+func (b *fbBuilder) align(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) putUint32(pos int, v uint32) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], v)
+}
+
+// table writes the vtable, the table and then everything it refers to, and returns the position of the
+// table.
+func (b *fbBuilder) table(t *fbTable) int {
+	// layout of the inline fields, which start behind the offset to the vtable
+	offsets := make(map[int]int)
+	size := 4
+	maxID := -1
+	for _, f := range t.fields {
+		n := 4
+		if f.ref == nil {
+			n = len(f.scalar)
+		}
+
+		for size%n != 0 {
+			size++
+		}
+		offsets[f.id] = size
+		size += n
+
+		if f.id > maxID {
+			maxID = f.id
+		}
+	}
+
+	b.align(2)
+	vtable := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*(maxID+1))...)
+	binary.LittleEndian.PutUint16(b.buf[vtable:], uint16(4+2*(maxID+1)))
+	binary.LittleEndian.PutUint16(b.buf[vtable+2:], uint16(size))
+	for id, offset := range offsets {
+		binary.LittleEndian.PutUint16(b.buf[vtable+4+2*id:], uint16(offset))
+	}
+
+	b.align(8)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	b.putUint32(pos, uint32(pos-vtable))
+	for _, f := range t.fields {
+		if f.ref == nil {
+			copy(b.buf[pos+offsets[f.id]:], f.scalar)
+		}
+	}
+
+	for _, f := range t.fields {
+		if f.ref != nil {
+			slot := pos + offsets[f.id]
+			b.putUint32(slot, uint32(b.object(f.ref)-slot))
+		}
+	}
+
+	return pos
+}
+
+// object writes a string, vector or table and returns its position.
+func (b *fbBuilder) object(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		b.align(4)
+		pos := len(b.buf)
+		b.buf = append(b.buf, 0, 0, 0, 0)
+		b.putUint32(pos, uint32(len(v)))
+		b.buf = append(b.buf, v...)
+		b.buf = append(b.buf, 0)
+		return pos
+	case *fbTable:
+		return b.table(v)
+	case []*fbTable:
+		b.align(4)
+		pos := len(b.buf)
+		b.buf = append(b.buf, make([]byte, 4+4*len(v))...)
+		b.putUint32(pos, uint32(len(v)))
+		for i, t := range v {
+			slot := pos + 4 + 4*i
+			b.putUint32(slot, uint32(b.table(t)-slot))
+		}
+		return pos
+	case fbStructs:
+		// the length precedes the 8 byte aligned structs
+		b.align(8)
+		b.buf = append(b.buf, 0, 0, 0, 0)
+		pos := len(b.buf)
+		b.buf = append(b.buf, 0, 0, 0, 0)
+		b.putUint32(pos, uint32(len(v.data)/v.size))
+		b.buf = append(b.buf, v.data...)
+		return pos
+	default:
+		panic(fmt.Sprintf("unsupported flatbuffers type %T", v))
+	}
+}
+
+// fbReader reads a table of a flatbuffer. Malformed buffers cause panics, which the callers recover from.
+type fbReader struct {
+	buf []byte
+	pos int
+}
+
+// rootTable returns the root table of a flatbuffer.
+func rootTable(buf []byte) fbReader {
+	return fbReader{buf: buf, pos: int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns the absolute position of a field or 0, if it is absent.
+func (r fbReader) field(id int) int {
+	vtable := r.pos - int(int32(binary.LittleEndian.Uint32(r.buf[r.pos:])))
+	vsize := int(binary.LittleEndian.Uint16(r.buf[vtable:]))
+	if 4+2*id >= vsize {
+		return 0
+	}
+
+	offset := int(binary.LittleEndian.Uint16(r.buf[vtable+4+2*id:]))
+	if offset == 0 {
+		return 0
+	}
+	return r.pos + offset
+}
+
+func (r fbReader) uint8(id int, def uint8) uint8 {
+	if pos := r.field(id); pos != 0 {
+		return r.buf[pos]
+	}
+	return def
+}
+
+func (r fbReader) int16(id int, def int16) int16 {
+	if pos := r.field(id); pos != 0 {
+		return int16(binary.LittleEndian.Uint16(r.buf[pos:]))
+	}
+	return def
+}
+
+func (r fbReader) int32(id int, def int32) int32 {
+	if pos := r.field(id); pos != 0 {
+		return int32(binary.LittleEndian.Uint32(r.buf[pos:]))
+	}
+	return def
+}
+
+func (r fbReader) int64(id int, def int64) int64 {
+	if pos := r.field(id); pos != 0 {
+		return int64(binary.LittleEndian.Uint64(r.buf[pos:]))
+	}
+	return def
+}
+
+// deref follows the offset at the given position.
+func (r fbReader) deref(pos int) int {
+	return pos + int(binary.LittleEndian.Uint32(r.buf[pos:]))
+}
+
+func (r fbReader) table(id int) (fbReader, bool) {
+	pos := r.field(id)
+	if pos == 0 {
+		return fbReader{}, false
+	}
+	return fbReader{buf: r.buf, pos: r.deref(pos)}, true
+}
+
+func (r fbReader) string(id int) string {
+	pos := r.field(id)
+	if pos == 0 {
+		return ""
+	}
+
+	pos = r.deref(pos)
+	n := int(binary.LittleEndian.Uint32(r.buf[pos:]))
+	return string(r.buf[pos+4 : pos+4+n])
+}
+
+// vector returns the position of the first element and the amount of elements.
+func (r fbReader) vector(id int) (int, int) {
+	pos := r.field(id)
+	if pos == 0 {
+		return 0, 0
+	}
+
+	pos = r.deref(pos)
+	return pos + 4, int(binary.LittleEndian.Uint32(r.buf[pos:]))
+}
+
+// tables returns the tables of a vector.
+func (r fbReader) tables(id int) []fbReader {
+	pos, n := r.vector(id)
+	tables := make([]fbReader, n)
+	for i := range tables {
+		tables[i] = fbReader{buf: r.buf, pos: r.deref(pos + 4*i)}
+	}
+	return tables
+}
@@ -0,0 +1,95 @@
+package arrow
+
+import (
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io"
+	"math"
+)
+
+// maxObjectSize is the size limit of a single object in the database, see Object.
+const maxObjectSize = 64 * 1024
+
+// Import reads an IPC file or stream and adds each row as an object whose fields are the non-null values
+// of the row. Like the importer, integers are stored with the narrowest field type and bools become the
+// integers 0 and 1, but floats keep their precision. It flushes the database and returns the amount of
+// imported objects, which is also valid if an error occurred.
+func Import(db *logdb.DB, r io.Reader) (uint64, error) {
+	rd, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]uint16, len(rd.Schema().Fields))
+	for i, f := range rd.Schema().Fields {
+		names[i] = db.PutName(f.Name)
+	}
+
+	count := uint64(0)
+	for rd.Next() {
+		batch := rd.Batch()
+		for row := 0; row < batch.Length; row++ {
+			if err := addRow(db, names, batch, row); err != nil {
+				return count, fmt.Errorf("row %d: %w", count, err)
+			}
+			count++
+		}
+	}
+
+	if err := rd.Err(); err != nil {
+		return count, err
+	}
+
+	return count, db.Flush()
+}
+
+// addRow adds a single row of a batch as an object.
+func addRow(db *logdb.DB, names []uint16, batch *RecordBatch, row int) error {
+	size := 5
+	for _, a := range batch.Columns {
+		size += 2 + 1 + 8
+		if a.Type.offsetWidth() > 0 && !a.IsNull(row) {
+			size += 4 + len(a.Bytes(row))
+		}
+	}
+
+	if size > maxObjectSize {
+		return fmt.Errorf("object with %d fields exceeds the maximum object size", len(batch.Columns))
+	}
+
+	return db.Add(func(obj *logdb.Object) error {
+		for i, a := range batch.Columns {
+			if a.IsNull(row) {
+				continue
+			}
+
+			switch a.Type.ID {
+			case Int, Bool:
+				v := a.Int(row)
+				if a.Type.ID == Int && !a.Type.Signed && a.Type.BitWidth == 64 && uint64(v) > math.MaxInt64 {
+					obj.AddField(names[i], func(f *logdb.FieldWriter) {
+						f.WriteUint64(uint64(v))
+					})
+				} else {
+					obj.AddInt(names[i], v)
+				}
+			case Float:
+				v := a.Float(row)
+				obj.AddField(names[i], func(f *logdb.FieldWriter) {
+					if a.Type.BitWidth == 32 {
+						f.WriteFloat32(float32(v))
+					} else {
+						f.WriteFloat64(v)
+					}
+				})
+			case Utf8, LargeUtf8:
+				obj.AddString(names[i], string(a.Bytes(row)))
+			case Binary, LargeBinary:
+				obj.AddField(names[i], func(f *logdb.FieldWriter) {
+					f.WriteBlob(a.Bytes(row))
+				})
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,511 @@
+package arrow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	ioutil2 "io/ioutil"
+)
+
+// Message header types, the metadata version and the magic of the IPC format, see
+// https://arrow.apache.org/docs/format/Columnar.html#serialization-and-interprocess-communication-ipc.
+const (
+	headerSchema      = 1
+	headerDictionary  = 2
+	headerRecordBatch = 3
+	metadataV5        = 4
+	continuation      = 0xFFFFFFFF
+	precisionSingle   = 1
+	precisionDouble   = 2
+)
+
+var fileMagic = []byte("ARROW1")
+
+// block locates a record batch within a file.
+type block struct {
+	offset   int64
+	metaSize int32
+	bodySize int64
+}
+
+// Writer writes record batches in the IPC stream or file format.
+type Writer struct {
+	w      io.Writer
+	schema *Schema
+	file   bool
+	offset int64
+	blocks []block
+	err    error
+}
+
+// NewStreamWriter writes the schema and returns a writer for the IPC stream format.
+func NewStreamWriter(w io.Writer, schema *Schema) (*Writer, error) {
+	wr := &Writer{w: w, schema: schema}
+	return wr, wr.writeMessage(headerSchema, schemaTable(schema), nil)
+}
+
+// NewFileWriter writes the magic and the schema and returns a writer for the IPC file format, which is
+// the stream format followed by a footer to access the batches randomly.
+func NewFileWriter(w io.Writer, schema *Schema) (*Writer, error) {
+	wr := &Writer{w: w, schema: schema, file: true}
+	if err := wr.write(append(append([]byte(nil), fileMagic...), 0, 0)); err != nil {
+		return nil, err
+	}
+	return wr, wr.writeMessage(headerSchema, schemaTable(schema), nil)
+}
+
+func (wr *Writer) write(b []byte) error {
+	if wr.err != nil {
+		return wr.err
+	}
+
+	n, err := wr.w.Write(b)
+	wr.offset += int64(n)
+	wr.err = err
+	return err
+}
+
+// Write appends a record batch, whose columns must match the schema.
+func (wr *Writer) Write(batch *RecordBatch) error {
+	if len(batch.Columns) != len(wr.schema.Fields) {
+		return fmt.Errorf("expected %d columns but got %d", len(wr.schema.Fields), len(batch.Columns))
+	}
+
+	var nodes, buffers, body []byte
+	addBuffer := func(b []byte) {
+		buffers = appendInt64(appendInt64(buffers, int64(len(body))), int64(len(b)))
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	for i, a := range batch.Columns {
+		if a.Type != wr.schema.Fields[i].Type {
+			return fmt.Errorf("column %s: expected type %s but got %s", wr.schema.Fields[i].Name,
+				wr.schema.Fields[i].Type, a.Type)
+		}
+
+		nodes = appendInt64(appendInt64(nodes, int64(a.Length)), int64(a.NullCount))
+		if a.Type.ID == Null {
+			continue
+		}
+
+		if a.NullCount == 0 {
+			addBuffer(nil)
+		} else {
+			addBuffer(a.Validity)
+		}
+
+		if a.Type.offsetWidth() > 0 {
+			addBuffer(a.Offsets)
+		}
+		addBuffer(a.Values)
+	}
+
+	header := (&fbTable{}).
+		int64(0, int64(batch.Length)).
+		ref(1, fbStructs{size: 16, data: nodes}).
+		ref(2, fbStructs{size: 16, data: buffers})
+
+	return wr.writeMessage(headerRecordBatch, header, body)
+}
+
+// writeMessage writes an encapsulated message: the continuation marker, the size of the metadata, the
+// metadata padded to 8 bytes and the body.
+func (wr *Writer) writeMessage(headerType uint8, header *fbTable, body []byte) error {
+	msg := (&fbTable{}).
+		int16(0, metadataV5).
+		uint8(1, headerType).
+		ref(2, header).
+		int64(3, int64(len(body)))
+
+	meta := encodeFlatbuffer(msg)
+	for (len(meta)+8)%8 != 0 {
+		meta = append(meta, 0)
+	}
+
+	offset := wr.offset
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix, continuation)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(meta)))
+	if err := wr.write(prefix); err != nil {
+		return err
+	}
+	if err := wr.write(meta); err != nil {
+		return err
+	}
+	if err := wr.write(body); err != nil {
+		return err
+	}
+
+	if headerType == headerRecordBatch {
+		wr.blocks = append(wr.blocks, block{offset: offset, metaSize: int32(8 + len(meta)), bodySize: int64(len(body))})
+	}
+
+	return nil
+}
+
+// Close writes the end of the stream and the footer of a file. It does not close the underlying writer.
+func (wr *Writer) Close() error {
+	eos := make([]byte, 8)
+	binary.LittleEndian.PutUint32(eos, continuation)
+	if err := wr.write(eos); err != nil || !wr.file {
+		return err
+	}
+
+	var blocks []byte
+	for _, b := range wr.blocks {
+		blocks = appendInt64(blocks, b.offset)
+		blocks = appendInt64(blocks, int64(b.metaSize)) // int32 followed by 4 bytes of padding
+		blocks = appendInt64(blocks, b.bodySize)
+	}
+
+	footer := encodeFlatbuffer((&fbTable{}).
+		int16(0, metadataV5).
+		ref(1, schemaTable(wr.schema)).
+		ref(2, fbStructs{size: 24}).
+		ref(3, fbStructs{size: 24, data: blocks}))
+
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(footer)))
+	footer = append(footer, size...)
+	return wr.write(append(footer, fileMagic...))
+}
+
+func appendInt64(dst []byte, v int64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], uint64(v))
+	return append(dst, tmp[:]...)
+}
+
+// schemaTable encodes a schema with little endian data.
+func schemaTable(schema *Schema) *fbTable {
+	fields := make([]*fbTable, len(schema.Fields))
+	for i, f := range schema.Fields {
+		t := &fbTable{}
+		switch f.Type.ID {
+		case Int:
+			t.int32(0, int32(f.Type.BitWidth)).bool(1, f.Type.Signed)
+		case Float:
+			precision := int16(precisionDouble)
+			if f.Type.BitWidth == 32 {
+				precision = precisionSingle
+			}
+			t.int16(0, precision)
+		}
+
+		fields[i] = (&fbTable{}).
+			ref(0, f.Name).
+			bool(1, true).
+			uint8(2, uint8(f.Type.ID)).
+			ref(3, t).
+			ref(5, []*fbTable{})
+	}
+
+	return (&fbTable{}).int16(0, 0).ref(1, fields)
+}
+
+// parseSchema decodes a schema table.
+func parseSchema(r fbReader) (*Schema, error) {
+	if r.int16(0, 0) != 0 {
+		return nil, fmt.Errorf("big endian data is not supported")
+	}
+
+	schema := &Schema{}
+	for _, f := range r.tables(1) {
+		field := Field{Name: f.string(0), Type: Type{ID: TypeID(f.uint8(2, 0))}}
+		if _, ok := f.table(4); ok {
+			return nil, fmt.Errorf("%s: dictionary encoding is not supported", field.Name)
+		}
+
+		t, _ := f.table(3)
+		switch field.Type.ID {
+		case Int:
+			field.Type.BitWidth = int(t.int32(0, 0))
+			field.Type.Signed = t.uint8(1, 0) != 0
+			if w := field.Type.BitWidth; w != 8 && w != 16 && w != 32 && w != 64 {
+				return nil, fmt.Errorf("%s: invalid bit width %d", field.Name, w)
+			}
+		case Float:
+			switch t.int16(0, 0) {
+			case precisionSingle:
+				field.Type.BitWidth = 32
+			case precisionDouble:
+				field.Type.BitWidth = 64
+			default:
+				return nil, fmt.Errorf("%s: half precision floats are not supported", field.Name)
+			}
+		case Null, Binary, Utf8, Bool, LargeBinary, LargeUtf8:
+		default:
+			return nil, fmt.Errorf("%s: unsupported type %d", field.Name, field.Type.ID)
+		}
+
+		schema.Fields = append(schema.Fields, field)
+	}
+
+	return schema, nil
+}
+
+// Reader reads record batches in the IPC stream or file format. Files are read entirely into memory and
+// their batches in the order of the footer, streams are read message by message.
+type Reader struct {
+	r      *bufio.Reader
+	schema *Schema
+	file   []byte
+	blocks []block
+	batch  *RecordBatch
+	err    error
+}
+
+// NewReader reads the schema of a stream or file, which is detected by its magic.
+func NewReader(r io.Reader) (rd *Reader, err error) {
+	rd = &Reader{r: bufio.NewReader(r)}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed arrow data: %v", r)
+		}
+	}()
+
+	if magic, _ := rd.r.Peek(len(fileMagic)); bytes.Equal(magic, fileMagic) {
+		return rd, rd.readFooter()
+	}
+
+	headerType, meta, _, err := rd.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if headerType != headerSchema {
+		return nil, fmt.Errorf("expected a schema but got message type %d", headerType)
+	}
+
+	rd.schema, err = parseSchema(meta)
+	return rd, err
+}
+
+// readFooter reads the file and the schema and the batch locations of its footer.
+func (rd *Reader) readFooter() error {
+	file, err := ioutil2.ReadAll(rd.r)
+	if err != nil {
+		return err
+	}
+
+	n := len(file)
+	if n < 2*len(fileMagic)+6 || !bytes.Equal(file[n-len(fileMagic):], fileMagic) {
+		return fmt.Errorf("missing the magic at the end of the file")
+	}
+
+	size := int(binary.LittleEndian.Uint32(file[n-len(fileMagic)-4:]))
+	start := n - len(fileMagic) - 4 - size
+	if size <= 0 || start < len(fileMagic) {
+		return fmt.Errorf("invalid footer size %d", size)
+	}
+
+	footer := rootTable(file[start : start+size])
+	schema, ok := footer.table(1)
+	if !ok {
+		return fmt.Errorf("footer without schema")
+	}
+
+	if rd.schema, err = parseSchema(schema); err != nil {
+		return err
+	}
+
+	if _, n := footer.vector(2); n > 0 {
+		return fmt.Errorf("dictionaries are not supported")
+	}
+
+	pos, n := footer.vector(3)
+	for i := 0; i < n; i++ {
+		b := footer.buf[pos+24*i:]
+		rd.blocks = append(rd.blocks, block{
+			offset:   int64(binary.LittleEndian.Uint64(b)),
+			metaSize: int32(binary.LittleEndian.Uint32(b[8:])),
+			bodySize: int64(binary.LittleEndian.Uint64(b[16:])),
+		})
+	}
+
+	rd.file = file
+	return nil
+}
+
+// Schema returns the schema of all batches.
+func (rd *Reader) Schema() *Schema {
+	return rd.schema
+}
+
+// Next reads the next batch and returns false at the end or if an error occurred, see Err.
+func (rd *Reader) Next() bool {
+	if rd.err != nil {
+		return false
+	}
+
+	var err error
+	rd.batch, err = rd.next()
+	if err != nil {
+		rd.err = err
+	}
+
+	return rd.batch != nil
+}
+
+// Batch returns the current batch, which refers to the memory of the reader and is valid until the next call
+// of Next.
+func (rd *Reader) Batch() *RecordBatch {
+	return rd.batch
+}
+
+// Err returns the first error which occurred while reading.
+func (rd *Reader) Err() error {
+	return rd.err
+}
+
+func (rd *Reader) next() (batch *RecordBatch, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed arrow data: %v", r)
+		}
+	}()
+
+	if rd.file != nil {
+		if len(rd.blocks) == 0 {
+			return nil, nil
+		}
+
+		b := rd.blocks[0]
+		rd.blocks = rd.blocks[1:]
+		rd.r = bufio.NewReader(bytes.NewReader(rd.file[b.offset : b.offset+int64(b.metaSize)+b.bodySize]))
+	}
+
+	headerType, meta, body, err := rd.readMessage()
+	if err != nil || meta.buf == nil {
+		return nil, err
+	}
+
+	switch headerType {
+	case headerRecordBatch:
+		return rd.parseBatch(meta, body)
+	case headerDictionary:
+		return nil, fmt.Errorf("dictionaries are not supported")
+	default:
+		return nil, fmt.Errorf("unexpected message type %d", headerType)
+	}
+}
+
+// readMessage reads an encapsulated message and returns its header. The header is empty at the end of the
+// stream.
+func (rd *Reader) readMessage() (uint8, fbReader, []byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(rd.r, prefix[:]); err != nil {
+		if err == io.EOF {
+			// a stream may also end without a marker
+			return 0, fbReader{}, nil, nil
+		}
+		return 0, fbReader{}, nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(prefix[:])
+	if size == continuation {
+		if _, err := io.ReadFull(rd.r, prefix[:]); err != nil {
+			return 0, fbReader{}, nil, err
+		}
+		// messages before version 0.15 have no continuation marker
+		size = binary.LittleEndian.Uint32(prefix[:])
+	}
+
+	if size == 0 {
+		return 0, fbReader{}, nil, nil
+	}
+
+	meta := make([]byte, size)
+	if _, err := io.ReadFull(rd.r, meta); err != nil {
+		return 0, fbReader{}, nil, err
+	}
+
+	msg := rootTable(meta)
+	header, ok := msg.table(2)
+	if !ok {
+		return 0, fbReader{}, nil, fmt.Errorf("message without header")
+	}
+
+	body := make([]byte, msg.int64(3, 0))
+	if _, err := io.ReadFull(rd.r, body); err != nil {
+		return 0, fbReader{}, nil, err
+	}
+
+	return msg.uint8(1, 0), header, body, nil
+}
+
+// parseBatch decodes a record batch, whose arrays refer to the body.
+func (rd *Reader) parseBatch(meta fbReader, body []byte) (*RecordBatch, error) {
+	if _, ok := meta.table(3); ok {
+		return nil, fmt.Errorf("compressed record batches are not supported")
+	}
+
+	batch := &RecordBatch{Schema: rd.schema, Length: int(meta.int64(0, 0))}
+	nodes, nodeCount := meta.vector(1)
+	buffers, bufferCount := meta.vector(2)
+	if nodeCount != len(rd.schema.Fields) {
+		return nil, fmt.Errorf("expected %d field nodes but got %d", len(rd.schema.Fields), nodeCount)
+	}
+
+	nextBuffer := func() ([]byte, error) {
+		if bufferCount == 0 {
+			return nil, fmt.Errorf("missing buffer")
+		}
+
+		offset := int64(binary.LittleEndian.Uint64(meta.buf[buffers:]))
+		length := int64(binary.LittleEndian.Uint64(meta.buf[buffers+8:]))
+		buffers += 16
+		bufferCount--
+		if offset < 0 || length < 0 || offset+length > int64(len(body)) {
+			return nil, fmt.Errorf("buffer at %d with %d bytes exceeds the body", offset, length)
+		}
+		return body[offset : offset+length], nil
+	}
+
+	for i, f := range rd.schema.Fields {
+		node := meta.buf[nodes+16*i:]
+		a := &Array{
+			Type:      f.Type,
+			Length:    int(binary.LittleEndian.Uint64(node)),
+			NullCount: int(binary.LittleEndian.Uint64(node[8:])),
+		}
+
+		if a.Length != batch.Length {
+			return nil, fmt.Errorf("%s: expected %d values but got %d", f.Name, batch.Length, a.Length)
+		}
+
+		if f.Type.ID != Null {
+			var err error
+			if a.Validity, err = nextBuffer(); err != nil {
+				return nil, err
+			}
+
+			if f.Type.offsetWidth() > 0 {
+				if a.Offsets, err = nextBuffer(); err != nil {
+					return nil, err
+				}
+			}
+
+			if a.Values, err = nextBuffer(); err != nil {
+				return nil, err
+			}
+
+			if a.NullCount == 0 {
+				a.Validity = nil
+			}
+		}
+
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		batch.Columns = append(batch.Columns, a)
+	}
+
+	return batch, nil
+}
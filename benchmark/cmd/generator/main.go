@@ -12,6 +12,7 @@ import (
 
 func main() {
 	compress := flag.Bool("lz4", false, "lz4 compression")
+	columnar := flag.Bool("columnar", false, "columnar record layout")
 	points2gen := flag.Int("points", 1_000_000_000, "points to generate")
 	flag.Parse()
 
@@ -19,14 +20,14 @@ func main() {
 	tmpFile := filepath.Join(dir, "sensor.logdb")
 	fmt.Printf("database file is '%s'\n", tmpFile)
 
-	if err := create(tmpFile, *compress, *points2gen); err != nil {
+	if err := create(tmpFile, *compress, *columnar, *points2gen); err != nil {
 		panic(err)
 	}
 }
 
-func create(fname string, compress bool, pointsToGenerate int) error {
+func create(fname string, compress bool, columnar bool, pointsToGenerate int) error {
 	_ = os.Remove(fname)
-	db, err := logdb.Open(fname, false, compress)
+	db, err := logdb.OpenOptions(fname, logdb.Options{Compression: compress, Columnar: columnar})
 	if err != nil {
 		return err
 	}
@@ -213,7 +213,10 @@ func scanTable(fname string, concurrency int, mmap, compression bool) (*processR
 	}
 
 	start := time.Now()
-	err = db.ForEachP(concurrency, func(gid int, id uint64, obj *logdb.Object) error {
+	// the histogram only needs the temperature, so columnar records skip decoding the other chunks, but
+	// they are still read and decompressed as a whole
+	opts := logdb.ScanOptions{Routines: concurrency, Columns: []uint16{colTemperature}}
+	err = db.Scan(opts, func(gid int, id uint64, obj *logdb.Object) error {
 		var point benchmark.TemperaturePoint
 		threadLocal := threadLocals[gid]
 
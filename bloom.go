@@ -0,0 +1,444 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// bloomSuffix is appended to the database file name to get the name of the bloom filter file.
+const bloomSuffix = ".bloom"
+
+const (
+	bloomBitsPerObject = 10 // results in a false positive rate of about 1%
+	bloomHashCount     = 7
+)
+
+// Equal is a predicate which matches all objects containing a field with the given name and value. Integers
+// and integral floats compare equal independent of their encoded width.
+type Equal struct {
+	Name  uint16
+	value []byte // normalized, see appendValue
+}
+
+// EqualInt matches integral numbers of any width.
+func EqualInt(name uint16, v int64) Equal {
+	return Equal{Name: name, value: appendUint64([]byte{'i'}, uint64(v))}
+}
+
+// EqualFloat matches numbers of any width.
+func EqualFloat(name uint16, v float64) Equal {
+	if _, frac := math.Modf(v); frac == 0 && v >= math.MinInt64 && v <= math.MaxInt64 {
+		return EqualInt(name, int64(v))
+	}
+
+	return Equal{Name: name, value: appendUint64([]byte{'f'}, math.Float64bits(v))}
+}
+
+// EqualString matches strings of any length prefix.
+func EqualString(name uint16, v string) Equal {
+	return Equal{Name: name, value: appendBytes([]byte{'s'}, []byte(v))}
+}
+
+// EqualBlob matches blobs of any length prefix.
+func EqualBlob(name uint16, v []byte) Equal {
+	return Equal{Name: name, value: appendBytes([]byte{'b'}, v)}
+}
+
+// Match returns true, if the object contains the field with the value.
+func (e Equal) Match(obj *Object) bool {
+	var tmp [16]byte
+	matched := false
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+		if !matched && name == e.Name {
+			matched = string(appendValue(tmp[:0], kind, f)) == string(e.value)
+		}
+	})
+
+	return matched
+}
+
+// bloomHash is a 64 bit FNV-1a hash.
+func bloomHash(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+
+	return h
+}
+
+// bloomBit returns the i-th bit position of a hash within a filter of m bits, using double hashing.
+func bloomBit(h uint64, i int, m uint32) uint32 {
+	h1, h2 := h&0xffffffff, h>>32|1
+	return uint32((h1 + uint64(i)*h2) % uint64(m))
+}
+
+// bloomBuilder collects the hashes of the configured names of the pending record.
+type bloomBuilder struct {
+	names  []uint16
+	slots  []int // name to index into names or -1
+	hashes [][]uint64
+	tmp    []byte
+}
+
+func newBloomBuilder(names []uint16) *bloomBuilder {
+	b := &bloomBuilder{
+		names:  names,
+		slots:  make([]int, int(ioutil.MaxUint16)+1),
+		hashes: make([][]uint64, len(names)),
+	}
+
+	for i := range b.slots {
+		b.slots[i] = -1
+	}
+
+	for i, name := range names {
+		b.slots[name] = i
+	}
+
+	return b
+}
+
+func (b *bloomBuilder) add(obj *Object) {
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+		if slot := b.slots[name]; slot >= 0 {
+			b.tmp = appendValue(b.tmp[:0], kind, f)
+			b.hashes[slot] = append(b.hashes[slot], bloomHash(b.tmp))
+		}
+	})
+}
+
+// build returns the serialized entry of all added objects and resets the builder.
+//
+// Format specification of an entry:
+//  - length              uint32, byte length of the following entry data
+//  - offset              uint64, file offset of the record
+//  - filterCount         uint16
+//  - []                  filterCount times
+//     {
+//       - name           uint16
+//       - hashCount      uint8
+//       - bitCount       uint32, at least 64 and always a multiple of 64
+//       - bits           bitCount/8 bytes
+//     }
+func (b *bloomBuilder) build(offset int64) []byte {
+	size := 14
+	for _, hashes := range b.hashes {
+		size += 7 + int(bloomBitCount(len(hashes))/8)
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, size)}
+	buf.WriteUint32(uint32(size - 4))
+	buf.WriteUint64(uint64(offset))
+	buf.WriteUint16(uint16(len(b.names)))
+	for i, hashes := range b.hashes {
+		m := bloomBitCount(len(hashes))
+		buf.WriteUint16(b.names[i])
+		buf.WriteUint8(bloomHashCount)
+		buf.WriteUint32(m)
+
+		bits := buf.Bytes[buf.Pos : buf.Pos+int(m/8)]
+		for _, h := range hashes {
+			for k := 0; k < bloomHashCount; k++ {
+				bit := bloomBit(h, k, m)
+				bits[bit/8] |= 1 << (bit % 8)
+			}
+		}
+		buf.Pos += len(bits)
+
+		b.hashes[i] = hashes[:0]
+	}
+
+	return buf.Bytes
+}
+
+func bloomBitCount(n int) uint32 {
+	m := uint32(n * bloomBitsPerObject)
+	return (m/64 + 1) * 64
+}
+
+// bloomFilter locates the bits of a single filter within the bloom file.
+type bloomFilter struct {
+	name      uint16
+	hashCount uint8
+	bitCount  uint32
+	pos       int64
+}
+
+// bloomEntry contains the filters of a single record. Only the locations of the bits are kept in memory.
+type bloomEntry struct {
+	offset  int64
+	filters []bloomFilter
+}
+
+// bloomIndex appends the bloom filters of each record to the bloom file and reads the bits lazily when
+// filtering records.
+type bloomIndex struct {
+	file     *os.File // nil for a read-only database without bloom filter file
+	readOnly bool     // the file is never written and missing filters are not built
+	entries  []bloomEntry
+	size     int64
+	mutex    sync.RWMutex
+	builder  *bloomBuilder
+}
+
+func openBloomIndex(fname string, names []uint16, readOnly bool) (*bloomIndex, error) {
+	file, err := openSideFile(fname, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bloomIndex{file: file, readOnly: readOnly, builder: newBloomBuilder(names)}, nil
+}
+
+// readBloomNames returns the names of the filters of the first entry in the bloom file, which are the names
+// the file has been built for. A missing or empty file has no names.
+func readBloomNames(fname string) ([]uint16, error) {
+	file, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	hdr := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 14)}
+	if _, err := io.ReadFull(file, hdr.Bytes); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	hdr.Pos = 12
+	count := int(hdr.ReadUint16())
+	names := make([]uint16, 0, count)
+	flt := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 7)}
+	pos := int64(len(hdr.Bytes))
+	for i := 0; i < count; i++ {
+		if _, err := file.ReadAt(flt.Bytes, pos); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		flt.Pos = 0
+		names = append(names, flt.ReadUint16())
+		flt.ReadUint8()
+		pos += int64(len(flt.Bytes)) + int64(flt.ReadUint32()/8)
+	}
+
+	return names, nil
+}
+
+// load parses the locations of all filters which belong to the given records in the same order. Entries
+// whose configured names differ from the current builder are dropped and rebuilt.
+func (x *bloomIndex) load(records []recordInfo) error {
+	x.entries, x.size = x.entries[:0], 0
+	if x.file == nil {
+		return nil
+	}
+
+	stat, err := x.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 14)}
+	flt := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 7)}
+
+	for len(x.entries) < len(records) && x.size+int64(len(hdr.Bytes)) <= stat.Size() {
+		if _, err := x.file.ReadAt(hdr.Bytes, x.size); err != nil && err != io.EOF {
+			return err
+		}
+
+		hdr.Pos = 0
+		length := int64(hdr.ReadUint32())
+		entry := bloomEntry{offset: int64(hdr.ReadUint64())}
+		count := int(hdr.ReadUint16())
+		if x.size+4+length > stat.Size() || entry.offset != records[len(x.entries)].offset || count != len(x.builder.names) {
+			break
+		}
+
+		pos := x.size + int64(len(hdr.Bytes))
+		valid := true
+		for i := 0; i < count; i++ {
+			if _, err := x.file.ReadAt(flt.Bytes, pos); err != nil && err != io.EOF {
+				return err
+			}
+
+			flt.Pos = 0
+			filter := bloomFilter{name: flt.ReadUint16(), hashCount: flt.ReadUint8(), bitCount: flt.ReadUint32()}
+			filter.pos = pos + int64(len(flt.Bytes))
+			pos = filter.pos + int64(filter.bitCount/8)
+			if filter.name != x.builder.names[i] {
+				valid = false
+				break
+			}
+			entry.filters = append(entry.filters, filter)
+		}
+
+		if !valid || pos != x.size+4+length {
+			break
+		}
+
+		x.entries = append(x.entries, entry)
+		x.size = pos
+	}
+
+	if x.readOnly {
+		return nil
+	}
+
+	return x.file.Truncate(x.size)
+}
+
+// add appends the serialized entry and parses the filter locations.
+func (x *bloomIndex) add(data []byte) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if _, err := x.file.WriteAt(data, x.size); err != nil {
+		return fmt.Errorf("unable to write bloom filter: %w", err)
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: data, Pos: 4}
+	entry := bloomEntry{offset: int64(buf.ReadUint64())}
+	count := int(buf.ReadUint16())
+	for i := 0; i < count; i++ {
+		filter := bloomFilter{name: buf.ReadUint16(), hashCount: buf.ReadUint8(), bitCount: buf.ReadUint32()}
+		filter.pos = x.size + int64(buf.Pos)
+		buf.Pos += int(filter.bitCount / 8)
+		entry.filters = append(entry.filters, filter)
+	}
+
+	x.entries = append(x.entries, entry)
+	x.size += int64(len(data))
+	return nil
+}
+
+// filter returns only those records, which may contain objects matching all equality predicates. Records
+// without a bloom filter for a name are always returned.
+func (x *bloomIndex) filter(records []recordInfo, equals []Equal) ([]recordInfo, error) {
+	x.mutex.RLock()
+	entries := x.entries
+	x.mutex.RUnlock()
+
+	hashes := make([]uint64, len(equals))
+	for i, e := range equals {
+		hashes[i] = bloomHash(e.value)
+	}
+
+	var bits []byte
+	res := make([]recordInfo, 0, len(records))
+	j := 0
+	for _, info := range records {
+		for j < len(entries) && entries[j].offset < info.offset {
+			j++
+		}
+
+		if j < len(entries) && entries[j].offset == info.offset {
+			match := true
+			for i, e := range equals {
+				for _, filter := range entries[j].filters {
+					if filter.name != e.Name {
+						continue
+					}
+
+					if cap(bits) < int(filter.bitCount/8) {
+						bits = make([]byte, filter.bitCount/8)
+					}
+					bits = bits[:filter.bitCount/8]
+					if _, err := x.file.ReadAt(bits, filter.pos); err != nil {
+						return nil, err
+					}
+
+					for k := 0; k < int(filter.hashCount); k++ {
+						bit := bloomBit(hashes[i], k, filter.bitCount)
+						if bits[bit/8]&(1<<(bit%8)) == 0 {
+							match = false
+							break
+						}
+					}
+				}
+
+				if !match {
+					break
+				}
+			}
+
+			if !match {
+				continue
+			}
+		}
+
+		res = append(res, info)
+	}
+
+	return res, nil
+}
+
+func (x *bloomIndex) Close() error {
+	if x.file == nil {
+		return nil
+	}
+
+	return x.file.Close()
+}
+
+// openBloomFilters loads the bloom filters and builds those which are missing for already existing records.
+func (db *DB) openBloomFilters() error {
+	names := make([]uint16, 0, len(db.opts.BloomFilters))
+	for _, name := range db.opts.BloomFilters {
+		names = append(names, db.PutName(name))
+	}
+
+	blooms, err := openBloomIndex(db.fname+bloomSuffix, names, db.opts.ReadOnly)
+	if err != nil {
+		return err
+	}
+
+	records := db.findRecords()
+	if err := blooms.load(records); err != nil {
+		_ = blooms.Close()
+		return err
+	}
+
+	db.blooms = blooms
+	if blooms.readOnly {
+		return nil
+	}
+
+	if len(blooms.entries) < len(records) {
+		db.logf("building bloom filters for %d records\n", len(records)-len(blooms.entries))
+	}
+
+	reader := newRecordReader(db)
+	obj := newObject(db.maxObjSize)
+	for _, info := range records[len(blooms.entries):] {
+		record, err := reader.readInfo(info)
+		if err != nil {
+			return err
+		}
+
+		_ = record.ForEach(obj, func(offset int, object *Object) error {
+			blooms.builder.add(object)
+			return nil
+		})
+
+		if err := blooms.add(blooms.builder.build(info.offset)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
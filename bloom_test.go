@@ -0,0 +1,105 @@
+package logdb
+
+import (
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBloomFilterEquals(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "bloomTest")
+	assertNil(t, err)
+
+	fname := filepath.Join(dir, "bloomdb.bin")
+	opts := Options{BloomFilters: []string{"SensorId", "Station"}}
+	db, err := OpenOptions(fname, opts)
+	assertNil(t, err)
+
+	colSensorId := db.PutName("SensorId")
+	colStation := db.PutName("Station")
+
+	const count = 10_000
+	for i := 0; i < count; i++ {
+		err := db.Add(func(obj *Object) error {
+			obj.AddUint32(colSensorId, uint32(i))
+			obj.AddString(colStation, "station"+string(rune('a'+i/1000)))
+			return nil
+		})
+		assertNil(t, err)
+
+		if i%1000 == 999 {
+			assertNil(t, db.Flush())
+		}
+	}
+
+	assertNil(t, db.Close())
+
+	db, err = OpenOptions(fname, opts)
+	assertNil(t, err)
+	defer db.Close()
+
+	equals := []Equal{EqualInt(colSensorId, 4242)}
+	records, err := db.blooms.filter(db.findRecords(), equals)
+	assertNil(t, err)
+	if len(records) > 2 {
+		t.Fatalf("expected at most 2 records but got %d", len(records))
+	}
+
+	scan := func(equals ...Equal) uint64 {
+		var visited uint64
+		err := db.Scan(ScanOptions{Routines: 2, Equals: equals}, func(gid int, id uint64, obj *Object) error {
+			atomic.AddUint64(&visited, 1)
+			return nil
+		})
+		assertNil(t, err)
+		return visited
+	}
+
+	if n := scan(equals...); n != 1 {
+		t.Fatalf("expected 1 object but got %d", n)
+	}
+
+	if n := scan(EqualString(colStation, "stationc")); n != 1000 {
+		t.Fatalf("expected 1000 objects but got %d", n)
+	}
+
+	if n := scan(EqualString(colStation, "stationc"), EqualFloat(colSensorId, 2001)); n != 1 {
+		t.Fatalf("expected 1 object but got %d", n)
+	}
+
+	if n := scan(EqualInt(colSensorId, count)); n != 0 {
+		t.Fatalf("expected no object but got %d", n)
+	}
+}
+
+func TestDetectSideFiles(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "bloomTest")
+	assertNil(t, err)
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "bloomdb.bin")
+	db, err := OpenOptions(fname, Options{Quiet: true, ZoneMaps: true, BloomFilters: []string{"Station", "SensorId"}})
+	assertNil(t, err)
+
+	colSensorId := db.PutName("SensorId")
+	assertNil(t, db.Add(func(obj *Object) error {
+		obj.AddUint32(colSensorId, 1)
+		return nil
+	}))
+	assertNil(t, db.Close())
+
+	// the side files configure a database, which is opened without options
+	for _, readOnly := range []bool{true, false} {
+		db, err = OpenOptions(fname, Options{Quiet: true, ReadOnly: readOnly})
+		assertNil(t, err)
+
+		opts := db.Options()
+		assertNil(t, db.Close())
+		if !opts.ZoneMaps || !reflect.DeepEqual(opts.BloomFilters, []string{"Station", "SensorId"}) {
+			t.Fatalf("side files not detected: %+v", opts)
+		}
+	}
+}
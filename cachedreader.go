@@ -8,7 +8,8 @@ import (
 
 type concurrentCachedReader struct {
 	file             *os.File
-	record           *Record
+	maxRecordSize    int
+	record           *Record // allocated with the first page in
 	recordOffset     int64
 	mutex            sync.RWMutex
 	actualRecordSize int64
@@ -16,12 +17,12 @@ type concurrentCachedReader struct {
 
 func newConcurrentCachedReader(file *os.File, maxRecordSize int) (*concurrentCachedReader, error) {
 	r := &concurrentCachedReader{
-		file:         file,
-		record:       newRecord(maxRecordSize),
-		recordOffset: 0,
-		mutex:        sync.RWMutex{},
+		file:          file,
+		maxRecordSize: maxRecordSize,
+		recordOffset:  0,
+		mutex:         sync.RWMutex{},
 	}
-	return r, r.pageIn(0)
+	return r, nil
 }
 
 func (r *concurrentCachedReader) inPage(from, to int64) bool {
@@ -29,6 +30,10 @@ func (r *concurrentCachedReader) inPage(from, to int64) bool {
 }
 
 func (r *concurrentCachedReader) pageIn(offset int64) error {
+	if r.record == nil {
+		r.record = newRecord(r.maxRecordSize)
+	}
+
 	r.recordOffset = offset
 	n, err := r.file.ReadAt(r.record.buf.Bytes, offset)
 	r.actualRecordSize = int64(n)
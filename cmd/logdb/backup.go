@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestSuffix and snapshotSuffix are appended to the name of a backup for its manifest and data file.
+const (
+	manifestSuffix = ".manifest.json"
+	snapshotSuffix = ".snapshot"
+)
+
+// runBackup writes a full or incremental snapshot and its manifest into a directory.
+func runBackup(args []string) error {
+	set := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := set.String("o", ".", "output directory")
+	incremental := set.String("incremental", "", "manifest of the previous backup to continue")
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	from := int64(0)
+	if *incremental != "" {
+		prev, err := readManifest(*incremental)
+		if err != nil {
+			return err
+		}
+		from = prev.EOF
+	}
+
+	// the name is only known afterwards, so the data is written to a temporary file first
+	tmp, err := ioutil.TempFile(*dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriterSize(tmp, 1024*1024)
+	m, err := db.SnapshotFrom(w, from)
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	name := filepath.Join(*dir, fmt.Sprintf("%s-%d-%d", strings.TrimSuffix(filepath.Base(set.Arg(0)), filepath.Ext(set.Arg(0))), m.From, m.EOF))
+	if err := os.Rename(tmp.Name(), name+snapshotSuffix); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(name+manifestSuffix, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("backed up %d records with %d bytes into %s\n", m.Records, m.EOF-m.From, name+manifestSuffix)
+	return nil
+}
+
+// runRestore creates a database from a full backup and its incremental backups.
+func runRestore(args []string) error {
+	set := flag.NewFlagSet("restore", flag.ContinueOnError)
+	output := set.String("o", "", "database file to create")
+	kfl := addKeyFlags(set)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := kfl.provider()
+	if err != nil {
+		return err
+	}
+
+	if *output == "" || set.NArg() == 0 {
+		return fmt.Errorf("expected an output file and at least one manifest")
+	}
+
+	var snapshots []*logdb.Manifest
+	for _, fname := range set.Args() {
+		m, err := readManifest(fname)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, m)
+	}
+
+	err = logdb.RestoreEncrypted(*output, snapshots, func(i int) (io.ReadCloser, error) {
+		return os.Open(strings.TrimSuffix(set.Arg(i), manifestSuffix) + snapshotSuffix)
+	}, keys)
+	if err != nil {
+		return err
+	}
+
+	last := snapshots[len(snapshots)-1]
+	fmt.Printf("restored %d objects with %d bytes into %s\n", last.Objects, last.EOF-last.HeaderSize, *output)
+	return nil
+}
+
+func readManifest(fname string) (*logdb.Manifest, error) {
+	if !strings.HasSuffix(fname, manifestSuffix) {
+		return nil, fmt.Errorf("%s is no manifest", fname)
+	}
+
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &logdb.Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", fname, err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/exporter"
+	"io"
+	"os"
+)
+
+// errStop ends a walk over the objects early.
+var errStop = errors.New("stop")
+
+// jsonWriter prints objects as JSON lines. Each line contains the id as _id followed by the fields in their
+// encoded order. Names which occur multiple times within an object become arrays.
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *exporter.JSONEncoder
+	buf []byte
+}
+
+func newJSONWriter(w io.Writer, db *logdb.DB) *jsonWriter {
+	return &jsonWriter{w: bufio.NewWriter(w), enc: exporter.NewJSONEncoder(db, nil, true)}
+}
+
+func (j *jsonWriter) write(id uint64, obj *logdb.Object) error {
+	j.buf = j.enc.Append(j.buf[:0], id, obj)
+	_, err := j.w.Write(j.buf)
+	return err
+}
+
+func (j *jsonWriter) flush() error {
+	return j.w.Flush()
+}
+
+// runDump prints all objects as JSON lines.
+func runDump(args []string) error {
+	set := flag.NewFlagSet("dump", flag.ContinueOnError)
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	j := newJSONWriter(os.Stdout, db)
+	if err := db.ForEach(j.write); err != nil {
+		return err
+	}
+
+	return j.flush()
+}
+
+// runHead prints the first objects as JSON lines.
+func runHead(args []string) error {
+	set := flag.NewFlagSet("head", flag.ContinueOnError)
+	n := set.Int("n", 10, "amount of objects")
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	j := newJSONWriter(os.Stdout, db)
+	count := 0
+	err = db.ForEach(func(id uint64, obj *logdb.Object) error {
+		if count >= *n {
+			return errStop
+		}
+		count++
+		return j.write(id, obj)
+	})
+
+	if err != nil && err != errStop {
+		return err
+	}
+
+	return j.flush()
+}
+
+// runTail prints the last objects as JSON lines.
+func runTail(args []string) error {
+	set := flag.NewFlagSet("tail", flag.ContinueOnError)
+	n := set.Int("n", 10, "amount of objects")
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var count uint64
+	for _, r := range db.Records() {
+		count += uint64(r.ObjectCount)
+	}
+
+	if count == 0 || *n <= 0 {
+		return nil
+	}
+
+	start := uint64(0)
+	if count > uint64(*n) {
+		start = count - uint64(*n)
+	}
+
+	id, err := db.SeekOrdinal(start)
+	if err != nil {
+		return err
+	}
+
+	j := newJSONWriter(os.Stdout, db)
+	if err := db.ForEachFrom(id, j.write); err != nil {
+		return err
+	}
+
+	return j.flush()
+}
+
+// runCat prints all objects starting at the given id as JSON lines.
+func runCat(args []string) error {
+	set := flag.NewFlagSet("cat", flag.ContinueOnError)
+	fromID := set.Uint64("from-id", 0, "id of the first object")
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	j := newJSONWriter(os.Stdout, db)
+	if err := db.ForEachFrom(*fromID, j.write); err != nil {
+		return err
+	}
+
+	return j.flush()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb/arrow"
+	"github.com/worldiety/logdb/exporter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runExport writes the objects of a database as CSV, NDJSON, Parquet or an Arrow IPC file or stream to a file
+// or stdout.
+func runExport(args []string) error {
+	set := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := set.String("format", "", "csv, ndjson, parquet, arrow or arrows for an arrow stream, derived from the output extension by default")
+	output := set.String("o", "", "output file instead of stdout")
+	names := set.String("names", "", "comma separated names to export, all by default")
+	includeID := set.Bool("id", false, "add the object id as the column _id")
+	fromID := set.Uint64("from-id", 0, "first object id")
+	toID := set.Uint64("to-id", 0, "object id after the last exported one, unbounded by default")
+	fromOrdinal := set.Uint64("from-ordinal", 0, "first object ordinal")
+	toOrdinal := set.Uint64("to-ordinal", 0, "object ordinal after the last exported one, unbounded by default")
+	routines := set.Int("p", 1, "amount of go routines, more than one do not keep the order of the objects")
+	fl := addDBFlags(set)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	if set.NArg() != 1 {
+		return fmt.Errorf("expected a single database file")
+	}
+
+	if *format == "" {
+		*format = "csv"
+		if ext := strings.TrimPrefix(filepath.Ext(*output), "."); ext != "" {
+			*format = ext
+		}
+	}
+
+	opts := exporter.Options{
+		IncludeID:   *includeID,
+		FromID:      *fromID,
+		ToID:        *toID,
+		FromOrdinal: *fromOrdinal,
+		ToOrdinal:   *toOrdinal,
+		Routines:    *routines,
+	}
+
+	// arrow files and streams are written by their own package
+	arrowFormat := strings.EqualFold(*format, "arrow") || strings.EqualFold(*format, "arrows")
+
+	var err error
+	if !arrowFormat {
+		if opts.Format, err = exporter.ParseFormat(*format); err != nil {
+			return err
+		}
+	}
+
+	if *names != "" {
+		opts.Names = strings.Split(*names, ",")
+	}
+
+	db, err := openDB(set.Arg(0), fl, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	file := os.Stdout
+	if *output != "" {
+		if file, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	w := bufio.NewWriterSize(file, 1024*1024)
+	if arrowFormat {
+		_, err = arrow.Export(db, w, opts, strings.EqualFold(*format, "arrows"))
+	} else {
+		_, err = exporter.Export(db, w, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if *output != "" {
+		return file.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/replication"
+	"os"
+	"os/signal"
+)
+
+// runFollow replicates a leader into a database until it is interrupted or the leader is lost. With
+// -promote, the database is promoted afterwards and ingests objects itself.
+func runFollow(args []string) error {
+	set := flag.NewFlagSet("follow", flag.ContinueOnError)
+	leader := set.String("leader", "", "replication address of the leader, see ingest -replicate")
+	promote := set.Bool("promote", false, "ingest objects after the leader is lost")
+	ifl := addIngestFlags(set, ":9090")
+	fl := addDBFlags(set)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	if set.NArg() != 1 || *leader == "" {
+		return fmt.Errorf("expected a leader address and a single database file")
+	}
+
+	// unlike the other commands, a new follower creates its file
+	opts, err := fl.options()
+	if err != nil {
+		return err
+	}
+
+	db, err := logdb.OpenOptions(set.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	follower := replication.NewFollower(db, *leader, replication.FollowerOptions{})
+	done := make(chan error, 1)
+	go func() { done <- follower.Run() }()
+	fmt.Fprintf(os.Stderr, "following %s into %s\n", *leader, set.Arg(0))
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	select {
+	case <-interrupt:
+		signal.Stop(interrupt)
+		follower.Stop()
+		return <-done
+	case err = <-done:
+		signal.Stop(interrupt)
+	}
+
+	if !*promote {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "promoting %s after: %v\n", set.Arg(0), err)
+	if _, err := follower.Promote(); err != nil {
+		return err
+	}
+
+	return serveIngest(db, set.Arg(0), ifl)
+}
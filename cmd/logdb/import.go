@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/arrow"
+	"github.com/worldiety/logdb/importer"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runImport appends CSV, NDJSON or Arrow IPC from the given files or stdin to a database, which is created if
+// required.
+func runImport(args []string) error {
+	set := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := set.String("format", "", "csv, ndjson or arrow, derived from the file extension by default")
+	comma := set.String("comma", ",", "csv field delimiter")
+	schema := set.String("schema", "", "comma separated name:type pairs with the types auto, int, float, string or skip")
+	encodings := set.String("encoding", "", "comma separated name:encoding pairs with the encodings varint, delta or xor")
+	columnar := set.Bool("columnar", false, "store the records in the columnar layout")
+	nulls := set.String("null", "", "comma separated values which denote null in csv")
+	keepEmpty := set.Bool("keep-empty", false, "store empty values as empty strings instead of null")
+	fl := addDBFlags(set)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	if set.NArg() < 1 {
+		return fmt.Errorf("expected a database file")
+	}
+
+	opts := importer.Options{KeepEmpty: *keepEmpty, Schema: make(map[string]importer.Type)}
+	if runes := []rune(*comma); len(runes) == 1 {
+		opts.Comma = runes[0]
+	} else {
+		return fmt.Errorf("invalid delimiter %q", *comma)
+	}
+
+	if *nulls != "" {
+		opts.NullValues = strings.Split(*nulls, ",")
+	}
+
+	if *schema != "" {
+		for _, pair := range strings.Split(*schema, ",") {
+			i := strings.LastIndexByte(pair, ':')
+			if i < 0 {
+				return fmt.Errorf("invalid schema entry %s", pair)
+			}
+
+			t, err := importer.ParseType(pair[i+1:])
+			if err != nil {
+				return err
+			}
+			opts.Schema[pair[:i]] = t
+		}
+	}
+
+	start := time.Now()
+	opts.Progress = func(objects uint64) {
+		fmt.Fprintf(os.Stderr, "imported %d objects in %v\n", objects, time.Since(start).Round(time.Millisecond))
+	}
+
+	dbOpts, err := fl.options()
+	if err != nil {
+		return err
+	}
+
+	dbOpts.Columnar = *columnar
+	if *encodings != "" {
+		dbOpts.Encodings = make(map[string]logdb.Encoding)
+		for _, pair := range strings.Split(*encodings, ",") {
+			i := strings.LastIndexByte(pair, ':')
+			if i < 0 {
+				return fmt.Errorf("invalid encoding entry %s", pair)
+			}
+
+			e, err := logdb.ParseEncoding(pair[i+1:])
+			if err != nil {
+				return err
+			}
+			dbOpts.Encodings[pair[:i]] = e
+		}
+	}
+
+	db, err := logdb.OpenOptions(set.Arg(0), dbOpts)
+	if err != nil {
+		return err
+	}
+
+	inputs := set.Args()[1:]
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	for _, input := range inputs {
+		if err := importFile(db, input, *format, opts); err != nil {
+			_ = db.Close()
+			return fmt.Errorf("%s: %w", input, err)
+		}
+	}
+
+	return db.Close()
+}
+
+func importFile(db *logdb.DB, input string, format string, opts importer.Options) error {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(input), ".")
+		if input == "-" {
+			format = "csv"
+		}
+	}
+
+	arrowFormat := strings.EqualFold(format, "arrow") || strings.EqualFold(format, "arrows")
+
+	var err error
+	if !arrowFormat {
+		if opts.Format, err = importer.ParseFormat(format); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	if arrowFormat {
+		// arrow files and streams are detected by their magic
+		_, err = arrow.Import(db, r)
+		return err
+	}
+
+	_, err = importer.Import(db, r, opts)
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// runInfo prints the header, the names, counts and sizes of a database.
+func runInfo(args []string) error {
+	set := flag.NewFlagSet("info", flag.ContinueOnError)
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fname := set.Arg(0)
+	stat, err := os.Stat(fname)
+	if err != nil {
+		return err
+	}
+
+	var stored, uncompressed uint64
+	records := db.Records()
+	for _, r := range records {
+		stored += uint64(r.Length)
+		uncompressed += uint64(r.Size)
+	}
+
+	codec := "none"
+	if db.Options().Compression {
+		codec = "lz4"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "file:\t%s\n", fname)
+	fmt.Fprintf(w, "file size:\t%d\n", stat.Size())
+	fmt.Fprintf(w, "header size:\t%d\n", stat.Size()-int64(stored))
+	fmt.Fprintf(w, "codec:\t%s\n", codec)
+	if id := db.KeyID(); id != "" {
+		fmt.Fprintf(w, "encryption:\taes-256-gcm with key %s\n", id)
+	}
+	fmt.Fprintf(w, "objects:\t%d\n", db.ObjectCount())
+	fmt.Fprintf(w, "transactions:\t%d\n", db.TxCount())
+	fmt.Fprintf(w, "records:\t%d\n", len(records))
+	fmt.Fprintf(w, "stored size:\t%d\n", stored)
+	fmt.Fprintf(w, "uncompressed size:\t%d\n", uncompressed)
+	if stored > 0 {
+		fmt.Fprintf(w, "ratio:\t%.2f\n", float64(uncompressed)/float64(stored))
+	}
+
+	for _, side := range db.SideFiles() {
+		if stat, err := os.Stat(side); err == nil {
+			fmt.Fprintf(w, "%s:\t%d\n", filepath.Base(side), stat.Size())
+		}
+	}
+
+	names := db.Names()
+	fmt.Fprintf(w, "names:\t%d\n", len(names))
+	for i, name := range names {
+		fmt.Fprintf(w, "  %d\t%s\n", i, name)
+	}
+
+	return w.Flush()
+}
+
+// runRecords prints the record table.
+func runRecords(args []string) error {
+	set := flag.NewFlagSet("records", flag.ContinueOnError)
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "record\toffset\tlength\tsize\tobjects\tfirst id\tfirst ordinal\tlayout\t\n")
+	for i, r := range db.Records() {
+		layout := "row"
+		if r.Columnar {
+			layout = "columnar"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n", i, r.Offset, r.Length, r.Size, r.ObjectCount, r.FirstID, r.FirstOrdinal, layout)
+	}
+
+	return w.Flush()
+}
+
+// runVerify checks the structure of the entire database file.
+func runVerify(args []string) error {
+	set := flag.NewFlagSet("verify", flag.ContinueOnError)
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Verify(); err != nil {
+		return err
+	}
+
+	fmt.Printf("ok, %d records with %d objects\n", len(db.Records()), db.ObjectCount())
+	return nil
+}
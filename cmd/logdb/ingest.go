@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/ingest"
+	"github.com/worldiety/logdb/replication"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+)
+
+// ingestFlags are the listen addresses and the durability of an ingestion.
+type ingestFlags struct {
+	addr       *string
+	http       *string
+	replicate  *string
+	durability *string
+}
+
+func addIngestFlags(set *flag.FlagSet, addr string) ingestFlags {
+	return ingestFlags{
+		addr:       set.String("addr", addr, "TCP listen address"),
+		http:       set.String("http", "", "additional HTTP listen address"),
+		replicate:  set.String("replicate", "", "listen address for followers, see follow"),
+		durability: set.String("durability", "flush", "acknowledge batches after add, flush or sync"),
+	}
+}
+
+// runIngest appends the objects of remote clients until it is interrupted.
+func runIngest(args []string) error {
+	set := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	ifl := addIngestFlags(set, ":9090")
+	db, err := parseFileArgs(set, args, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return serveIngest(db, set.Arg(0), ifl)
+}
+
+// serveIngest serves the ingestion and optionally the replication of a database until it is interrupted.
+func serveIngest(db *logdb.DB, fname string, ifl ingestFlags) error {
+	durability, err := ingest.ParseDurability(*ifl.durability)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", *ifl.addr)
+	if err != nil {
+		return err
+	}
+
+	srv, err := ingest.New(db, ingest.Options{Durability: durability, SequenceFile: fname + ".ingest"})
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	errs := make(chan error, 3)
+	go func() { errs <- srv.Serve(l) }()
+	fmt.Fprintf(os.Stderr, "ingesting into %s on %s\n", fname, l.Addr())
+
+	if *ifl.http != "" {
+		httpSrv := &http.Server{Addr: *ifl.http, Handler: srv}
+		defer httpSrv.Close()
+		go func() { errs <- httpSrv.ListenAndServe() }()
+		fmt.Fprintf(os.Stderr, "ingesting into %s on http://%s\n", fname, *ifl.http)
+	}
+
+	if *ifl.replicate != "" {
+		rl, err := net.Listen("tcp", *ifl.replicate)
+		if err != nil {
+			return err
+		}
+
+		leader := replication.NewLeader(db, replication.LeaderOptions{})
+		defer leader.Close()
+		go func() { errs <- leader.Serve(rl) }()
+		fmt.Fprintf(os.Stderr, "replicating %s on %s\n", fname, rl.Addr())
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// the deferred closes stop adding objects before the database is closed
+	select {
+	case <-interrupt:
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
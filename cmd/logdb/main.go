@@ -0,0 +1,181 @@
+// Command logdb inspects and queries database files. Each subcommand opens the database with its own
+// flags, e.g.
+//
+//	logdb info sensor.logdb
+//	logdb tail -n 5 sensor.logdb
+//	logdb query "SELECT count(*) FROM sensor" sensor.logdb
+//
+// The compression of a database is not persisted, so compressed databases require -lz4. Encrypted databases
+// require -key-file with the hex encoded 32 byte key and -key-id, if the key has another id than "default".
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand which parses its own flags.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"info":    {usage: "info [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runInfo},
+	"dump":    {usage: "dump [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runDump},
+	"records": {usage: "records [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runRecords},
+	"verify":  {usage: "verify [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runVerify},
+	"head":    {usage: "head [-n count] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runHead},
+	"tail":    {usage: "tail [-n count] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runTail},
+	"cat":     {usage: "cat --from-id id [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runCat},
+	"query":   {usage: "query [-p routines] [-lz4] [-mmap] [-key-file file] [-key-id id] 'SELECT ...' file.logdb", run: runQuery},
+	"import": {
+		usage: "import [-format csv|ndjson|arrow] [-comma ,] [-schema name:type,...] [-encoding name:encoding,...] [-columnar] [-null NULL,...] [-keep-empty] [-lz4] [-key-file file] [-key-id id] file.logdb [input ...]",
+		run:   runImport,
+	},
+	"export": {
+		usage: "export [-format csv|ndjson|parquet|arrow|arrows] [-o output] [-names a,b,...] [-id] [-from-id id] [-to-id id] [-from-ordinal n] [-to-ordinal n] [-p routines] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb",
+		run:   runExport,
+	},
+	"ingest": {
+		usage: "ingest [-addr :9090] [-http addr] [-replicate addr] [-durability add|flush|sync] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb",
+		run:   runIngest,
+	},
+	"backup":  {usage: "backup [-o dir] [-incremental previous.manifest.json] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runBackup},
+	"restore": {usage: "restore -o file.logdb [-key-file file] [-key-id id] full.manifest.json [incremental.manifest.json ...]", run: runRestore},
+	"follow": {
+		usage: "follow -leader addr [-promote [-addr :9090] [-http addr] [-replicate addr] [-durability add|flush|sync]] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb",
+		run:   runFollow,
+	},
+	"serve": {usage: "serve [-addr :8080] [-p routines] [-max-concurrent n] [-lz4] [-mmap] [-key-file file] [-key-id id] file.logdb", run: runServe},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "logdb %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  logdb %s\n", commands[name].usage)
+	}
+}
+
+// dbFlags are the flags to open a database, which are shared by all subcommands.
+type dbFlags struct {
+	lz4  *bool
+	mmap *bool
+	keys keyFlags
+}
+
+func addDBFlags(set *flag.FlagSet) dbFlags {
+	return dbFlags{
+		lz4:  set.Bool("lz4", false, "lz4 compression"),
+		mmap: set.Bool("mmap", false, "mmap the entire file instead of pread"),
+		keys: addKeyFlags(set),
+	}
+}
+
+// options returns the options to open the database quietly.
+func (fl dbFlags) options() (logdb.Options, error) {
+	keys, err := fl.keys.provider()
+	if err != nil {
+		return logdb.Options{}, err
+	}
+
+	opts := logdb.Options{Mmap: *fl.mmap, Compression: *fl.lz4, Quiet: true}
+	if keys != nil {
+		opts.Keys, opts.KeyID = keys, *fl.keys.id
+	}
+
+	return opts, nil
+}
+
+// keyFlags are the flags to encrypt a new or decrypt an existing database.
+type keyFlags struct {
+	file *string
+	id   *string
+}
+
+func addKeyFlags(set *flag.FlagSet) keyFlags {
+	return keyFlags{
+		file: set.String("key-file", "", "file with the hex encoded 32 byte key to encrypt the database"),
+		id:   set.String("key-id", "default", "id of the key"),
+	}
+}
+
+// provider reads the key file and returns nil without a key file.
+func (fl keyFlags) provider() (logdb.KeyProvider, error) {
+	if *fl.file == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(*fl.file)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", *fl.file, err)
+	}
+
+	return logdb.KeyMap{*fl.id: key}, nil
+}
+
+// parseFileArgs parses the flags of a subcommand which expects a single database file and opens it, see openDB.
+func parseFileArgs(set *flag.FlagSet, args []string, readOnly bool) (*logdb.DB, error) {
+	fl := addDBFlags(set)
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if set.NArg() != 1 {
+		return nil, fmt.Errorf("expected a single database file")
+	}
+
+	return openDB(set.Arg(0), fl, readOnly)
+}
+
+// openDB opens an existing database quietly. A read-only database is neither written nor are missing side
+// files created, so that it can be inspected next to a process which writes into it.
+func openDB(fname string, fl dbFlags, readOnly bool) (*logdb.DB, error) {
+	if _, err := os.Stat(fname); err != nil {
+		return nil, err
+	}
+
+	opts, err := fl.options()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.ReadOnly = readOnly
+	return logdb.OpenOptions(fname, opts)
+}
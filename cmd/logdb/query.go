@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb/sql"
+	"os"
+	"strings"
+)
+
+// runQuery executes a statement and prints the rows tab separated with a header line.
+func runQuery(args []string) error {
+	set := flag.NewFlagSet("query", flag.ContinueOnError)
+	routines := set.Int("p", 2, "amount of go routines")
+	fl := addDBFlags(set)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	if set.NArg() != 2 {
+		return fmt.Errorf("expected a statement and a file")
+	}
+
+	db, err := openDB(set.Arg(1), fl, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := sql.Query(db, set.Arg(0), sql.Options{Routines: *routines})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintln(w, strings.Join(rows.Columns(), "\t"))
+	for rows.Next() {
+		for i, v := range rows.Values() {
+			if i > 0 {
+				w.WriteByte('\t')
+			}
+			w.WriteString(v.String())
+		}
+		w.WriteByte('\n')
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueryZoneMaps(t *testing.T) {
+	dir := logdbtest.TempDir(t)
+	key := bytes.Repeat([]byte{7}, 32)
+	keyFile := filepath.Join(dir, "key")
+	logdbtest.AssertNil(t, ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key)), os.ModePerm))
+
+	fname := filepath.Join(dir, "sensor.logdb")
+	db, err := logdb.OpenOptions(fname, logdb.Options{Quiet: true, ZoneMaps: true, Keys: logdb.KeyMap{"default": key}, KeyID: "default"})
+	logdbtest.AssertNil(t, err)
+
+	id := db.PutName("id")
+	for r := 0; r < 3; r++ {
+		for i := 0; i < 100; i++ {
+			logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+				obj.AddInt(id, int64(r*100+i))
+				return nil
+			}))
+		}
+		logdbtest.AssertNil(t, db.Flush())
+	}
+
+	// the second record fails to decrypt, so that a query can only succeed if its zone map skips it
+	middle := db.Records()[1]
+	logdbtest.AssertNil(t, db.Close())
+
+	file, err := os.OpenFile(fname, os.O_RDWR, os.ModePerm)
+	logdbtest.AssertNil(t, err)
+	_, err = file.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, middle.Offset+int64(middle.Length)/2)
+	logdbtest.AssertNil(t, err)
+	logdbtest.AssertNil(t, file.Close())
+
+	query := func(stmt string) (string, error) {
+		out, err := ioutil.TempFile(dir, "stdout")
+		logdbtest.AssertNil(t, err)
+		defer out.Close()
+
+		stdout := os.Stdout
+		os.Stdout = out
+		defer func() { os.Stdout = stdout }()
+
+		if err := runQuery([]string{"-key-file", keyFile, stmt, fname}); err != nil {
+			return "", err
+		}
+
+		b, err := ioutil.ReadFile(out.Name())
+		logdbtest.AssertNil(t, err)
+		return string(b), nil
+	}
+
+	if _, err := query("SELECT count(*) FROM sensor"); err == nil {
+		t.Fatal("expected the corrupt record to fail")
+	}
+
+	res, err := query("SELECT count(*) FROM sensor WHERE id >= 200")
+	logdbtest.AssertNil(t, err)
+	if lines := strings.Split(strings.TrimSpace(res), "\n"); len(lines) != 2 || lines[1] != "100" {
+		t.Fatalf("unexpected result %q", res)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/worldiety/logdb/server"
+	"net/http"
+	"os"
+	"os/signal"
+)
+
+// runServe serves the HTTP API of a database until it is interrupted.
+func runServe(args []string) error {
+	set := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := set.String("addr", ":8080", "listen address")
+	routines := set.Int("p", 1, "amount of go routines of each scan or aggregation")
+	maxConcurrent := set.Int("max-concurrent", 4, "amount of scans and aggregations which run at the same time")
+	db, err := parseFileArgs(set, args, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: server.New(db, server.Options{MaxConcurrent: *maxConcurrent, Routines: *routines}),
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+
+		// canceling the request contexts also stops the running scans
+		srv.Shutdown(context.Background())
+		close(stopped)
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", set.Arg(0), *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	<-stopped
+	return nil
+}
@@ -0,0 +1,284 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+)
+
+// columnarRecordMagic marks records in the columnar layout, see Options.Columnar.
+var columnarRecordMagic = [8]byte{'w', 'd', 'y', 'c', 'o', 'l', '0', '1'}
+
+// A columnar record contains the fields of its objects transposed into a chunk per name, so that scans which
+// only need a few names skip the chunks of all other names, see ScanOptions.Columns. The fields of all
+// objects must have the order of the chunks, so that the objects are reconstructed byte by byte and their
+// ids, which refer to the row layout, stay valid.
+//
+// Format specification:
+//  - magic               [8]byte "wdycol01"
+//  - size                uint32, of the columnar record including the header
+//  - objCount            uint32
+//  - rowSize             uint32, of the record in row layout, which the object ids refer to
+//  - columnCount         uint16
+//  - []                  objCount times, the headers of the objects in row layout
+//     {
+//       - size           uint24
+//       - fieldCount     uint16
+//     }
+//  - []                  columnCount times, in the order of the fields
+//     {
+//       - name           uint16
+//       - length         uint32, of the presence bitmap and the values
+//       - presence       (objCount+7)/8 bytes, bit i%8 of byte i/8 is set, if object i has the name
+//       - []             the typed values of the objects which have the name, like fields without name
+//     }
+const (
+	offsetColRowSize     = 16
+	offsetColColumnCount = 20
+	offsetColObjList     = 22
+)
+
+// columnChunk is the chunk of a single name of a columnar record.
+type columnChunk struct {
+	name     uint16
+	presence []byte
+	values   []byte
+	pos      int // of the value of the next object which has the name
+}
+
+// columnar is a parsed columnar record, whose chunks slice into the record.
+type columnar struct {
+	objects []byte // the headers of the objects
+	count   int
+	rowSize int
+	chunks  []columnChunk
+}
+
+// transpose writes the record in the columnar layout into dst and returns false, if the record has relative
+// values, if the fields of its objects have no common order, or if the columnar layout would be larger.
+func (d *Record) transpose(dst *Record) bool {
+	if d.magic != recordMagic {
+		return false
+	}
+
+	count := int(d.ObjectCount())
+	index := make(map[uint16]int)
+	var chunks []*columnChunk
+
+	pos := offsetRecObjList
+	for i := 0; i < count; i++ {
+		size := int(d.ReadUint24At(pos))
+		buf := &ioutil.LittleEndianBuffer{Bytes: d.buf.Bytes[:pos+size], Pos: pos + offsetFieldList}
+		last := -1
+		for f := 0; f < int(binary.LittleEndian.Uint16(d.buf.Bytes[pos+offsetFieldCount:])); f++ {
+			name := buf.ReadUint16()
+			value := buf.Pos
+			drain(buf, buf.ReadType())
+
+			c, ok := index[name]
+			if !ok {
+				c = len(chunks)
+				index[name] = c
+				chunks = append(chunks, &columnChunk{name: name, presence: make([]byte, (count+7)/8)})
+			}
+
+			if c <= last {
+				return false
+			}
+			last = c
+
+			chunk := chunks[c]
+			chunk.presence[i/8] |= 1 << (i % 8)
+			chunk.values = append(chunk.values, buf.Bytes[value:buf.Pos]...)
+		}
+
+		pos += size
+	}
+
+	size := offsetColObjList + count*offsetFieldList
+	for _, chunk := range chunks {
+		size += 6 + len(chunk.presence) + len(chunk.values)
+	}
+
+	if size > int(d.Size()) || size > len(dst.buf.Bytes) || len(chunks) > int(ioutil.MaxUint16) {
+		return false
+	}
+
+	b := dst.buf.Bytes
+	binary.LittleEndian.PutUint32(b[offsetColRowSize:], d.Size())
+	binary.LittleEndian.PutUint16(b[offsetColColumnCount:], uint16(len(chunks)))
+
+	n := offsetColObjList
+	for i, pos := 0, offsetRecObjList; i < count; i++ {
+		n += copy(b[n:], d.buf.Bytes[pos:pos+offsetFieldList])
+		pos += int(d.ReadUint24At(pos))
+	}
+
+	for _, chunk := range chunks {
+		binary.LittleEndian.PutUint16(b[n:], chunk.name)
+		binary.LittleEndian.PutUint32(b[n+2:], uint32(len(chunk.presence)+len(chunk.values)))
+		n += 6
+		n += copy(b[n:], chunk.presence)
+		n += copy(b[n:], chunk.values)
+	}
+
+	dst.magic = columnarRecordMagic
+	dst.setSize(uint32(n))
+	dst.setObjectCount(uint32(count))
+	dst.flush()
+	return true
+}
+
+// parse reads the columnar record, which b contains, and checks that its chunks lie within it.
+func (c *columnar) parse(b []byte) error {
+	if len(b) < offsetColObjList {
+		return fmt.Errorf("columnar record is truncated")
+	}
+
+	c.count = int(binary.LittleEndian.Uint32(b[offsetRecObjCount:]))
+	c.rowSize = int(binary.LittleEndian.Uint32(b[offsetColRowSize:]))
+	pos := offsetColObjList + c.count*offsetFieldList
+	if c.count < 0 || pos > len(b) || c.rowSize < offsetRecObjList {
+		return fmt.Errorf("columnar record has an invalid object count of %d", c.count)
+	}
+	c.objects = b[offsetColObjList:pos]
+
+	c.chunks = c.chunks[:0]
+	bitmap := (c.count + 7) / 8
+	for i := 0; i < int(binary.LittleEndian.Uint16(b[offsetColColumnCount:])); i++ {
+		if pos+6 > len(b) {
+			return fmt.Errorf("column %d exceeds the record", i)
+		}
+
+		length := int(binary.LittleEndian.Uint32(b[pos+2:]))
+		if length < bitmap || pos+6+length > len(b) {
+			return fmt.Errorf("column %d has an invalid length of %d bytes", i, length)
+		}
+
+		c.chunks = append(c.chunks, columnChunk{
+			name:     binary.LittleEndian.Uint16(b[pos:]),
+			presence: b[pos+6 : pos+6+bitmap],
+			values:   b[pos+6+bitmap : pos+6+length],
+		})
+		pos += 6 + length
+	}
+
+	if pos != len(b) {
+		return fmt.Errorf("columnar record has %d bytes, but its columns %d", len(b), pos)
+	}
+
+	return nil
+}
+
+// appendObject appends the i-th object with the fields of the given chunks, whose positions must be at the
+// object, and moves the chunks behind its values. Objects must be appended in their order.
+func (c *columnar) appendObject(dst []byte, i int, chunks []*columnChunk) []byte {
+	start := len(dst)
+	dst = append(dst, c.objects[i*offsetFieldList:(i+1)*offsetFieldList]...)
+	fields := 0
+	for _, chunk := range chunks {
+		if chunk.presence[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+
+		buf := &ioutil.LittleEndianBuffer{Bytes: chunk.values, Pos: chunk.pos}
+		drain(buf, buf.ReadType())
+		dst = append(dst, byte(chunk.name), byte(chunk.name>>8))
+		dst = append(dst, chunk.values[chunk.pos:buf.Pos]...)
+		chunk.pos = buf.Pos
+		fields++
+	}
+
+	size := len(dst) - start
+	dst[start], dst[start+1], dst[start+2] = byte(size), byte(size>>8), byte(size>>16)
+	dst[start+3], dst[start+4] = byte(fields), byte(fields>>8)
+	return dst
+}
+
+// selectChunks rewinds the chunks of the given names, or of all names if names is nil, and returns them.
+func (c *columnar) selectChunks(dst []*columnChunk, names []uint16) []*columnChunk {
+	dst = dst[:0]
+	for i := range c.chunks {
+		chunk := &c.chunks[i]
+		chunk.pos = 0
+		if names == nil {
+			dst = append(dst, chunk)
+			continue
+		}
+
+		for _, name := range names {
+			if chunk.name == name {
+				dst = append(dst, chunk)
+				break
+			}
+		}
+	}
+
+	return dst
+}
+
+// untranspose reconstructs the record in row layout into dst, which should have room for it. Malformed values
+// are reported as error.
+func (c *columnar) untranspose(dst *Record) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("columnar record is malformed: %v", r)
+		}
+	}()
+
+	b := dst.buf.Bytes[:offsetRecObjList]
+	pos := offsetRecObjList
+	chunks := c.selectChunks(nil, nil)
+	for i := 0; i < c.count; i++ {
+		b = c.appendObject(b, i, chunks)
+		if !sameObjectHeader(b[pos:], c.objects[i*offsetFieldList:]) {
+			return fmt.Errorf("object %d does not match its columns", i)
+		}
+		pos = len(b)
+	}
+
+	for _, chunk := range chunks {
+		if chunk.pos != len(chunk.values) {
+			return fmt.Errorf("column of name %d has %d bytes, but its values %d", chunk.name, len(chunk.values), chunk.pos)
+		}
+	}
+
+	if len(b) != c.rowSize {
+		return fmt.Errorf("columnar record has a row size of %d bytes, but its objects %d", c.rowSize, len(b))
+	}
+
+	dst.buf.Bytes = b[:cap(b)]
+	dst.magic = recordMagic
+	dst.setSize(uint32(len(b)))
+	dst.setObjectCount(uint32(c.count))
+	dst.flush()
+	dst.columnar = true
+	return nil
+}
+
+// sameObjectHeader returns true, if both objects have the same size and field count.
+func sameObjectHeader(a, b []byte) bool {
+	return a[0] == b[0] && a[1] == b[1] && a[2] == b[2] && a[3] == b[3] && a[4] == b[4]
+}
+
+// forEach visits all objects of the record with the fields of the given names only, or with all fields if
+// names is nil. The offsets are those of the objects in row layout.
+func (c *columnar) forEach(names []uint16, tmp *Object, scratch *[]byte, f func(offset int, object *Object) error) error {
+	var selected [16]*columnChunk
+	chunks := c.selectChunks(selected[:0], names)
+	offset := offsetRecObjList
+	for i := 0; i < c.count; i++ {
+		*scratch = c.appendObject((*scratch)[:0], i, chunks)
+		tmp.buf.Bytes = *scratch
+		tmp.reverseFlush()
+
+		if err := f(offset, tmp); err != nil {
+			return err
+		}
+
+		b := c.objects[i*offsetFieldList:]
+		offset += int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+	}
+
+	return nil
+}
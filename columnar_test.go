@@ -0,0 +1,231 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestColumnar(t *testing.T) {
+	keys := KeyMap{"k1": bytes.Repeat([]byte{1}, 32)}
+
+	for _, opts := range []Options{{}, {Compression: true}, {Keys: keys, KeyID: "k1"}, {Mmap: true}} {
+		opts.Quiet = true
+		opts.Columnar = true
+		dir, err := ioutil2.TempDir("", "columnarTest")
+		assertNil(t, err)
+
+		fname := filepath.Join(dir, "db.bin")
+		db, err := OpenOptions(fname, opts)
+		assertNil(t, err)
+
+		id, temp, label, nested := db.PutName("id"), db.PutName("temp"), db.PutName("label"), db.PutName("nested")
+		var expected []string
+		for r := 0; r < 4; r++ {
+			for i := r * 50; i < r*50+50; i++ {
+				assertNil(t, db.Add(func(obj *Object) error {
+					if r == 2 && i%50 == 49 {
+						// the reversed order keeps the row layout for the whole record
+						obj.AddInt(temp, int64(i%40))
+						obj.AddInt(id, int64(i))
+						expected = append(expected, fmt.Sprintf("temp=%d id=%d", i%40, i))
+						return nil
+					}
+
+					obj.AddInt(id, int64(i))
+					obj.AddInt(temp, int64(i%40))
+					line := fmt.Sprintf("id=%d temp=%d", i, i%40)
+					if i%3 == 0 {
+						obj.AddString(label, fmt.Sprintf("label-%d", i))
+						obj.AddObject(nested, func(f *FieldWriter) {
+							f.WriteName(id)
+							f.WriteInt(int64(-i))
+						})
+						line += fmt.Sprintf(" label=label-%d nested={id=%d}", i, -i)
+					}
+					expected = append(expected, line)
+					return nil
+				}))
+			}
+			assertNil(t, db.Flush())
+		}
+
+		var layouts []bool
+		for _, stat := range db.Records() {
+			layouts = append(layouts, stat.Columnar)
+		}
+		if fmt.Sprint(layouts) != "[true true false true]" {
+			t.Fatalf("unexpected layouts %v", layouts)
+		}
+		assertNil(t, db.CreateIndex(temp, IndexOptions{}))
+		assertNil(t, db.Close())
+
+		// the record index is rebuilt with the layouts
+		assertNil(t, os.Remove(fname+recordIndexSuffix))
+		opts.Columnar = false
+		db, err = OpenOptions(fname, opts)
+		assertNil(t, err)
+		assertNil(t, db.Verify())
+		if stats := db.Records(); !stats[0].Columnar || stats[2].Columnar {
+			t.Fatalf("unexpected records %+v", stats)
+		}
+
+		var visit func(obj *Object) string
+		visit = func(obj *Object) string {
+			line := ""
+			obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+				if line != "" {
+					line += " "
+				}
+				line += db.NameByIndex(int(name)) + "="
+				switch kind {
+				case TObject:
+					line += "{" + visit(f.ReadObject()) + "}"
+				case ioutil.TString8:
+					line += f.ReadMutableString(make([]byte, 64))
+				default:
+					line += fmt.Sprint(f.ReadInt())
+				}
+			})
+			return line
+		}
+
+		var ids []uint64
+		assertNil(t, db.ForEach(func(oid uint64, obj *Object) error {
+			if line := visit(obj); line != expected[len(ids)] {
+				t.Fatalf("expected %q but got %q", expected[len(ids)], line)
+			}
+			ids = append(ids, oid)
+			return nil
+		}))
+
+		for i, oid := range ids {
+			assertNil(t, db.Read(oid, func(obj *Object) error {
+				if line := visit(obj); line != expected[i] {
+					t.Fatalf("expected %q but got %q", expected[i], line)
+				}
+				return nil
+			}))
+		}
+
+		if oid, err := db.SeekOrdinal(120); err != nil || oid != ids[120] {
+			t.Fatalf("unexpected id %d: %v", oid, err)
+		}
+
+		// objects of columnar records only contain the requested columns, but keep their ids
+		scanned := 0
+		assertNil(t, db.Scan(ScanOptions{Columns: []uint16{temp}, Ranges: []Range{{Name: id, Min: 10, Max: 159}}},
+			func(gid int, oid uint64, obj *Object) error {
+				i := int(oid)
+				for n, other := range ids {
+					if other == oid {
+						i = n
+					}
+				}
+
+				line := fmt.Sprintf("id=%d temp=%d", i, i%40)
+				if i >= 100 && i < 150 {
+					line = expected[i]
+				}
+				if s := visit(obj); s != line {
+					t.Fatalf("expected %q but got %q", line, s)
+				}
+				scanned++
+				return nil
+			}))
+		if scanned != 150 {
+			t.Fatalf("expected 150 scanned objects, but got %d", scanned)
+		}
+
+		// the secondary index has been filled from the temp columns
+		matches := 0
+		assertNil(t, db.IndexRange(temp, 39, 39, func(value float64, oid uint64) error {
+			matches++
+			return nil
+		}))
+		if matches != 5 {
+			t.Fatalf("expected 5 index matches, but got %d", matches)
+		}
+
+		// raw columnar records can be appended to another database
+		dst, err := OpenOptions(filepath.Join(dir, "dst.bin"), opts)
+		assertNil(t, err)
+		if opts.Keys != nil {
+			// the data key differs, so only plain databases are compared
+			assertNil(t, dst.Close())
+			assertNil(t, db.Close())
+			continue
+		}
+
+		var raw []byte
+		for _, stat := range db.Records() {
+			raw, err = db.ReadRawRecord(stat, raw)
+			assertNil(t, err)
+			assertNil(t, dst.AppendRawRecord(stat.Offset, raw))
+		}
+		assertNil(t, dst.Verify())
+		assertNil(t, dst.Read(ids[199], func(obj *Object) error {
+			if line := visit(obj); line != expected[199] {
+				t.Fatalf("expected %q but got %q", expected[199], line)
+			}
+			return nil
+		}))
+		assertNil(t, dst.Close())
+		assertNil(t, db.Close())
+	}
+}
+
+func TestStoreColumnar(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "columnarTest")
+	assertNil(t, err)
+
+	store, err := OpenStore(dir, StoreOptions{Options: Options{Quiet: true, Columnar: true}, TimeField: "Timestamp", BucketDuration: time.Hour})
+	assertNil(t, err)
+	defer store.Close()
+
+	// the first record of each segment has the same offset
+	timestamp, value := store.PutName("Timestamp"), store.PutName("Value")
+	for seg := 0; seg < 4; seg++ {
+		for i := 0; i < 10; i++ {
+			assertNil(t, store.Add(func(obj *Object) error {
+				obj.AddUint32(timestamp, uint32(seg*3600+i))
+				obj.AddInt(value, int64(seg*10+i))
+				return nil
+			}))
+		}
+		assertNil(t, store.Flush())
+	}
+
+	for _, info := range store.Segments() {
+		if stats := store.Segment(info.Seq).Records(); len(stats) != 1 || !stats[0].Columnar {
+			t.Fatalf("unexpected records %+v", stats)
+		}
+	}
+
+	var values []int64
+	assertNil(t, store.ForEachP(1, func(gid int, seq uint64, id uint64, obj *Object) error {
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			if name == value {
+				values = append(values, f.ReadInt())
+			}
+		})
+		return nil
+	}))
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for i, v := range values {
+		if v != int64(i) {
+			t.Fatalf("expected value %d but got %d in %v", i, v, values)
+		}
+	}
+
+	if len(values) != 40 {
+		t.Fatalf("expected 40 values but got %d", len(values))
+	}
+}
@@ -0,0 +1,93 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+)
+
+// Dictionary string types. The dictionary is global per database and stored in the header like the names, so
+// that the codes are stable across records and can be used to group values without comparing strings.
+const (
+	// TDictString is a string which is also stored in the dictionary, i.e. its code as uint32, followed by
+	// the string as TString8 to TString32. The code is NoDictionaryCode until the object has been added.
+	TDictString ioutil.Type = 40
+
+	// tDictRef is a dictionary string which is only stored as uvarint code. It only exists within records and
+	// is resolved into a TDictString while reading them.
+	tDictRef ioutil.Type = 41
+)
+
+// NoDictionaryCode is the code of the dictionary strings of an object which has not been added yet.
+const NoDictionaryCode = ^uint32(0)
+
+// PutDictionaryValue returns the code of the value and adds it to the dictionary, if required, like PutName.
+// Values are usually added by adding objects with dictionary strings, see Object.AddDictString, but may be
+// added upfront to assign codes in a certain order.
+func (db *DB) PutDictionaryValue(value string) uint32 {
+	return db.header.AddDictionaryValue(value)
+}
+
+// DictionaryValue returns the value of a dictionary code, see FieldReader.ReadDictionaryCode, and false, if
+// the code is unknown.
+func (db *DB) DictionaryValue(code uint32) (string, bool) {
+	return db.header.DictionaryValue(code)
+}
+
+// Dictionary returns all values of the dictionary, whose indexes are their codes.
+func (db *DB) Dictionary() []string {
+	return db.header.Dictionary()
+}
+
+// isDictString returns true for dictionary strings.
+func isDictString(kind ioutil.Type) bool {
+	return kind == TDictString
+}
+
+// appendTypedString appends the type and the length prefix of the narrowest string type, followed by the
+// string, like FieldWriter.WriteString does.
+func appendTypedString(dst []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= int(ioutil.MaxUint8):
+		dst = append(dst, byte(ioutil.TString8), byte(n))
+	case n <= int(ioutil.MaxUint16):
+		dst = append(dst, byte(ioutil.TString16), byte(n), byte(n>>8))
+	case n <= int(ioutil.MaxUint24):
+		dst = append(dst, byte(ioutil.TString24), byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, byte(ioutil.TString32), byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, s...)
+}
+
+// verifyDictionary checks that all dictionary codes of a record with relative values are known.
+func (d *Record) verifyDictionary() error {
+	if d.magic != relativeRecordMagic {
+		return nil
+	}
+
+	pos := offsetRecObjList
+	for i := 0; i < int(d.ObjectCount()); i++ {
+		buf := &ioutil.LittleEndianBuffer{Bytes: d.buf.Bytes, Pos: pos + offsetFieldList}
+		count := int(binary.LittleEndian.Uint16(d.buf.Bytes[pos+offsetFieldCount:]))
+		for f := 0; f < count; f++ {
+			buf.Pos += 2
+			kind := buf.ReadType()
+			if kind == tDictRef {
+				code, _ := binary.Uvarint(buf.Bytes[buf.Pos:])
+				if _, ok := d.header.DictionaryValue(uint32(code)); !ok || code > uint64(NoDictionaryCode) {
+					return fmt.Errorf("object %d has the unknown dictionary code %d", i, code)
+				}
+			}
+			if err := skip(buf, kind); err != nil {
+				return fmt.Errorf("object %d %w", i, err)
+			}
+		}
+
+		pos += int(d.ReadUint24At(pos))
+	}
+
+	return nil
+}
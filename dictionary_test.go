@@ -0,0 +1,198 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDictionary(t *testing.T) {
+	keys := KeyMap{"k1": bytes.Repeat([]byte{1}, 32)}
+	statuses := []string{"ok", "not found", "internal server error"}
+	read := func(f *FieldReader) (uint32, string) {
+		pos := f.Pos
+		code := f.ReadDictionaryCode()
+		f.Pos = pos
+		return code, string(f.ReadMutableString(make([]byte, 64)))
+	}
+
+	for _, opts := range []Options{{}, {Compression: true}, {Keys: keys, KeyID: "k1"}} {
+		opts.Quiet = true
+		dir, err := ioutil2.TempDir("", "dictionaryTest")
+		assertNil(t, err)
+
+		fname := filepath.Join(dir, "db.bin")
+		db, err := OpenOptions(fname, opts)
+		assertNil(t, err)
+
+		status, plain, nested := db.PutName("status"), db.PutName("plain"), db.PutName("nested")
+		if code := db.PutDictionaryValue("internal server error"); code != 0 {
+			t.Fatalf("unexpected code %d", code)
+		}
+
+		for r := 0; r < 3; r++ {
+			for i := 0; i < 10; i++ {
+				assertNil(t, db.Add(func(obj *Object) error {
+					obj.AddDictString(status, statuses[i%3])
+					obj.AddString(plain, statuses[i%3])
+					obj.AddObject(nested, func(f *FieldWriter) {
+						f.WriteName(status)
+						f.WriteDictString(statuses[i%3])
+					})
+					return nil
+				}))
+			}
+			assertNil(t, db.Flush())
+		}
+		assertNil(t, db.Close())
+
+		db, err = OpenOptions(fname, opts)
+		assertNil(t, err)
+		assertNil(t, db.Verify())
+		if fmt.Sprint(db.Dictionary()) != "[internal server error ok not found]" {
+			t.Fatalf("unexpected dictionary %q", db.Dictionary())
+		}
+
+		var ids []uint64
+		assertNil(t, db.ForEach(func(oid uint64, obj *Object) error {
+			i := len(ids) % 10
+			obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+				switch name {
+				case status:
+					code, s := read(f)
+					if value, _ := db.DictionaryValue(code); kind != TDictString || value != statuses[i%3] || s != value {
+						t.Fatalf("unexpected dictionary string %d %q", code, s)
+					}
+				case nested:
+					f.ReadObject().WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+						if code, s := read(f); code != NoDictionaryCode || s != statuses[i%3] {
+							t.Fatalf("unexpected nested dictionary string %d %q", code, s)
+						}
+					})
+				}
+			})
+			ids = append(ids, oid)
+			return nil
+		}))
+
+		assertNil(t, db.Read(ids[14], func(obj *Object) error {
+			obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+				if name != status {
+					return
+				}
+
+				if code, s := read(f); code != 2 || s != "not found" {
+					t.Fatalf("unexpected dictionary string %d %q", code, s)
+				}
+			})
+			return nil
+		}))
+
+		// the dictionary is part of snapshots
+		var snapshot bytes.Buffer
+		m, err := db.Snapshot(&snapshot)
+		assertNil(t, err)
+		assertNil(t, db.Close())
+
+		restored := filepath.Join(dir, "restored.bin")
+		assertNil(t, RestoreEncrypted(restored, []*Manifest{m}, func(i int) (io.ReadCloser, error) {
+			return ioutil2.NopCloser(bytes.NewReader(snapshot.Bytes())), nil
+		}, keys))
+
+		src, err := ioutil2.ReadFile(fname)
+		assertNil(t, err)
+		dst, err := ioutil2.ReadFile(restored)
+		assertNil(t, err)
+		if opts.Keys == nil && !bytes.Equal(src, dst) {
+			t.Fatalf("restored file with %d bytes differs from the source with %d bytes", len(dst), len(src))
+		}
+
+		db, err = OpenOptions(restored, opts)
+		assertNil(t, err)
+		assertNil(t, db.Verify())
+		if v, ok := db.DictionaryValue(1); !ok || v != "ok" {
+			t.Fatalf("unexpected restored dictionary %q", db.Dictionary())
+		}
+		assertNil(t, db.Close())
+	}
+
+	// codes are smaller than the strings
+	sizes := map[bool]int64{}
+	for _, dict := range []bool{false, true} {
+		dir, err := ioutil2.TempDir("", "dictionaryTest")
+		assertNil(t, err)
+
+		db, err := OpenOptions(filepath.Join(dir, "db.bin"), Options{Quiet: true})
+		assertNil(t, err)
+
+		for i := 0; i < 100; i++ {
+			assertNil(t, db.Add(func(obj *Object) error {
+				if dict {
+					obj.AddDictString(0, statuses[i%3])
+				} else {
+					obj.AddString(0, statuses[i%3])
+				}
+				return nil
+			}))
+		}
+		assertNil(t, db.Flush())
+
+		for _, stat := range db.Records() {
+			sizes[dict] += int64(stat.Size)
+		}
+		assertNil(t, db.Close())
+	}
+
+	if sizes[true] >= sizes[false] {
+		t.Fatalf("expected dictionary strings to shrink the records, but got %v", sizes)
+	}
+}
+
+func TestDictionaryCrash(t *testing.T) {
+	keys := KeyMap{"k1": bytes.Repeat([]byte{1}, 32)}
+	for _, opts := range []Options{{}, {Keys: keys, KeyID: "k1"}} {
+		opts.Quiet = true
+		dir, err := ioutil2.TempDir("", "dictionaryTest")
+		assertNil(t, err)
+		defer os.RemoveAll(dir)
+
+		fname := filepath.Join(dir, "db.bin")
+		db, err := OpenOptions(fname, opts)
+		assertNil(t, err)
+		defer db.Close()
+
+		// the header has been written before, so that only the dictionary of the next record is new
+		status := db.PutName("status")
+		assertNil(t, db.Sync())
+		for r, value := range []string{"ok", "not found"} {
+			assertNil(t, db.Add(func(obj *Object) error {
+				obj.AddDictString(status, value)
+				return nil
+			}))
+			assertNil(t, db.Flush())
+
+			// the process crashes without Close or Sync
+			crashed, err := OpenOptions(fname, Options{Quiet: true, ReadOnly: true, Keys: opts.Keys})
+			assertNil(t, err)
+			assertNil(t, crashed.Verify())
+
+			var values []string
+			assertNil(t, crashed.ForEach(func(id uint64, obj *Object) error {
+				obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+					values = append(values, string(f.ReadMutableString(make([]byte, 64))))
+				})
+				return nil
+			}))
+			assertNil(t, crashed.Close())
+
+			if len(values) != r+1 || values[r] != value {
+				t.Fatalf("unexpected values %q", values)
+			}
+		}
+	}
+}
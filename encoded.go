@@ -0,0 +1,141 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+)
+
+// NewObject allocates an empty object with a buffer of maxSize bytes. It encodes objects without a database,
+// e.g. to send them to a remote server, see Encode and DB.AddEncoded.
+func NewObject(maxSize int) *Object {
+	return newObject(maxSize)
+}
+
+// Encode resets the object, lets f add the fields and returns the encoded object, see Bytes. The slice is
+// only valid until the next call.
+func (d *Object) Encode(f func(obj *Object) error) ([]byte, error) {
+	d.resetWrite()
+	if err := f(d); err != nil {
+		return nil, err
+	}
+
+	d.flush()
+	return d.Bytes(), nil
+}
+
+// VerifyObject checks that the encoded object is well-formed and that the names of all fields are below
+// nameCount.
+func VerifyObject(b []byte, nameCount int) error {
+	if err := verifyObject(b, nameCount); err != nil {
+		return fmt.Errorf("object %w", err)
+	}
+
+	if hasRelativeFields(b) {
+		return fmt.Errorf("object has a value which is relative to a record")
+	}
+
+	return nil
+}
+
+// AddEncoded adds a copy of an encoded object, see Encode. The names of the fields are translated by
+// using them as index into names, so that objects encoded against another name table can be added.
+func (db *DB) AddEncoded(b []byte, names []uint16) error {
+	if len(b) > db.maxObjSize {
+		return fmt.Errorf("object of %d bytes exceeds the maximum object size", len(b))
+	}
+
+	if err := VerifyObject(b, len(names)); err != nil {
+		return err
+	}
+
+	return db.Add(func(obj *Object) error {
+		copy(obj.buf.Bytes, b)
+		obj.reverseFlush()
+		remapNames(obj.buf.Bytes[:obj.Size()], names)
+		return nil
+	})
+}
+
+// AddEncodedBatch adds copies of encoded objects like AddEncoded, but either all or none of them. All objects
+// are verified before the first one is added and they are added to the same record, which is flushed before,
+// if it has not enough space left. It returns the file offset of that record, so that a caller can tell
+// after a crash, whether the objects have been flushed, see RecordsFrom.
+func (db *DB) AddEncodedBatch(objects [][]byte, names []uint16) (int64, error) {
+	if db.opts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	// objects never grow in the record, see Record.addRelative
+	size := 0
+	for i, b := range objects {
+		if len(b) > db.maxObjSize {
+			return 0, fmt.Errorf("object %d of %d bytes exceeds the maximum object size", i, len(b))
+		}
+
+		if err := VerifyObject(b, len(names)); err != nil {
+			return 0, fmt.Errorf("object %d: %w", i, err)
+		}
+
+		size += len(b)
+	}
+
+	// Add reserves the maximum object size for each object
+	if size > db.maxRecSize-offsetRecObjList-db.maxObjSize {
+		return 0, fmt.Errorf("batch of %d bytes exceeds the maximum record size", size)
+	}
+
+	if err := db.reserve(size + db.maxObjSize); err != nil {
+		return 0, err
+	}
+
+	offset := db.eof
+	for _, b := range objects {
+		b := b
+		if err := db.Add(func(obj *Object) error {
+			copy(obj.buf.Bytes, b)
+			obj.reverseFlush()
+			remapNames(obj.buf.Bytes[:obj.Size()], names)
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	return offset, nil
+}
+
+// remapNames translates the names of the fields of an encoded object and of its embedded objects.
+func remapNames(b []byte, names []uint16) {
+	buf := &ioutil.LittleEndianBuffer{Bytes: b}
+	buf.Pos = offsetFieldCount
+	count := int(buf.ReadUint16())
+	for f := 0; f < count; f++ {
+		pos := buf.Pos
+		name := buf.ReadUint16()
+		buf.Pos = pos
+		buf.WriteUint16(names[name])
+
+		kind := buf.ReadType()
+		if kind == TObject {
+			start := buf.Pos
+			drain(buf, kind)
+			remapNames(b[start:buf.Pos], names)
+			continue
+		}
+
+		drain(buf, kind)
+	}
+}
+
+// Sync flushes the pending record and the header and commits the file to stable storage.
+func (db *DB) Sync() error {
+	if err := db.Flush(); err != nil {
+		return err
+	}
+
+	if err := db.flushHeader(); err != nil {
+		return err
+	}
+
+	return db.file.Sync()
+}
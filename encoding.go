@@ -0,0 +1,186 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// An Encoding stores the top level numbers of a name relative to their record, see Options.Encodings.
+type Encoding uint8
+
+const (
+	// EncodingNone stores numbers as they are.
+	EncodingNone Encoding = iota
+
+	// EncodingVarint stores integers as zigzag varint, which needs less bytes for small absolute values.
+	EncodingVarint
+
+	// EncodingDelta stores integers as zigzag varint delta to the previous integer of the name within the
+	// record, e.g. for sequence numbers or timestamps in seconds, which increase by small steps.
+	EncodingDelta
+
+	// EncodingXOR stores floats as XOR to the previous float of the name within the record without its
+	// leading and trailing zero bytes, like Gorilla does on bit level, e.g. for slowly changing measurements.
+	EncodingXOR
+)
+
+// Encoded number types. They only exist within records and are resolved into the original types while
+// reading them.
+const (
+	// tVarint is an integer, whose zigzag value is shifted by 4 bits and ored with its original type - 1, as
+	// uvarint, see EncodingVarint.
+	tVarint ioutil.Type = 42
+
+	// tDelta is an integer like tVarint, but whose value is the delta to the previous integer of the name,
+	// see EncodingDelta.
+	tDelta ioutil.Type = 43
+
+	// tXOR is a float as XOR to the previous float of the name, i.e. a header byte, whose bit 7 denotes a
+	// float32, whose bits 4 to 6 are the amount of trailing zero bytes and whose bits 0 to 3 are the amount
+	// of the following meaningful bytes, see EncodingXOR.
+	tXOR ioutil.Type = 44
+)
+
+var encodingNames = [...]string{"none", "varint", "delta", "xor"}
+
+// ParseEncoding returns the encoding of a name like delta, see Encoding.String.
+func ParseEncoding(name string) (Encoding, error) {
+	for i, n := range encodingNames {
+		if strings.EqualFold(n, name) {
+			return Encoding(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown encoding %s", name)
+}
+
+func (e Encoding) String() string {
+	if int(e) < len(encodingNames) {
+		return encodingNames[e]
+	}
+	return "encoding(" + strconv.Itoa(int(e)) + ")"
+}
+
+// isInteger returns true for the integer types of ioutil.
+func isInteger(kind ioutil.Type) bool {
+	return kind >= ioutil.TUint8 && kind <= ioutil.TInt64
+}
+
+// isFloat returns true for the float types of ioutil.
+func isFloat(kind ioutil.Type) bool {
+	return kind == ioutil.TFloat32 || kind == ioutil.TFloat64
+}
+
+// zigzag maps signed integers to unsigned ones, so that small absolute values have small varints.
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// unzigzag reverts zigzag.
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// appendEncodedInt appends an integer of the given type as tVarint or tDelta and returns false, if it would
+// not be shorter than the value itself.
+func appendEncodedInt(dst []byte, kind ioutil.Type, encoded ioutil.Type, v int64) ([]byte, bool) {
+	z := zigzag(v)
+	if z >= 1<<60 {
+		return dst, false
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], z<<4|uint64(kind-1))
+	if n >= elementSize(kind) {
+		return dst, false
+	}
+
+	return append(append(dst, byte(encoded)), tmp[:n]...), true
+}
+
+// appendDecodedInt appends the original type and value of a tVarint or tDelta, whose payload is u, relative
+// to base.
+func appendDecodedInt(dst []byte, u uint64, base int64) []byte {
+	kind := ioutil.Type(u&15) + 1
+	v := uint64(base + unzigzag(u>>4))
+	dst = append(dst, byte(kind))
+	for i := 0; i < elementSize(kind); i++ {
+		dst = append(dst, byte(v>>(8*i)))
+	}
+	return dst
+}
+
+// floatBits returns the bits of a float of the given type at the beginning of b and of the previous float
+// in the same width.
+func floatBits(kind ioutil.Type, b []byte, prev float64) (v uint64, p uint64) {
+	if kind == ioutil.TFloat32 {
+		return uint64(binary.LittleEndian.Uint32(b)), uint64(math.Float32bits(float32(prev)))
+	}
+	return binary.LittleEndian.Uint64(b), math.Float64bits(prev)
+}
+
+// appendXOR appends a float of the given type as tXOR to the previous float and returns false, if it would
+// not be shorter than the value itself.
+func appendXOR(dst []byte, kind ioutil.Type, b []byte, prev float64) ([]byte, bool) {
+	v, p := floatBits(kind, b, prev)
+	x := v ^ p
+	width := elementSize(kind)
+	header := byte(0)
+	if kind == ioutil.TFloat32 {
+		header = 1 << 7
+	}
+
+	if x == 0 {
+		return append(dst, byte(tXOR), header), true
+	}
+
+	trailing := bits.TrailingZeros64(x) / 8
+	meaningful := width - trailing - (bits.LeadingZeros64(x)-64+8*width)/8
+	if meaningful+1 >= width {
+		return dst, false
+	}
+
+	dst = append(dst, byte(tXOR), header|byte(trailing<<4)|byte(meaningful))
+	for i := trailing; i < trailing+meaningful; i++ {
+		dst = append(dst, byte(x>>(8*i)))
+	}
+	return dst, true
+}
+
+// appendDecodedFloat appends the original type and value of a tXOR, whose header and meaningful bytes b
+// points to, relative to the previous float.
+func appendDecodedFloat(dst []byte, b []byte, prev float64) []byte {
+	header := b[0]
+	trailing, meaningful := int(header>>4&7), int(header&15)
+	var x uint64
+	for i := 0; i < meaningful; i++ {
+		x |= uint64(b[1+i]) << (8 * (trailing + i))
+	}
+
+	if header&(1<<7) != 0 {
+		v := uint32(x) ^ math.Float32bits(float32(prev))
+		return append(dst, byte(ioutil.TFloat32), byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+
+	return appendUint64(append(dst, byte(ioutil.TFloat64)), x^math.Float64bits(prev))
+}
+
+// xorSize returns the size of a tXOR value, whose header b points to.
+func xorSize(b []byte) int {
+	return 1 + int(b[0]&15)
+}
+
+// openEncodings registers the names of the encodings.
+func (db *DB) openEncodings() {
+	db.encodings = make(map[uint16]Encoding, len(db.opts.Encodings))
+	for name, encoding := range db.opts.Encodings {
+		if encoding != EncodingNone {
+			db.encodings[db.PutName(name)] = encoding
+		}
+	}
+}
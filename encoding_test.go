@@ -0,0 +1,109 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodings(t *testing.T) {
+	encodings := map[string]Encoding{"seq": EncodingDelta, "small": EncodingVarint, "temp": EncodingXOR, "ratio": EncodingXOR}
+	sizes := map[bool]int64{}
+	results := map[bool][]string{}
+
+	for _, encoded := range []bool{false, true} {
+		for _, compression := range []bool{false, true} {
+			dir, err := ioutil2.TempDir("", "encodingTest")
+			assertNil(t, err)
+
+			opts := Options{Quiet: true, Compression: compression}
+			if encoded {
+				opts.Encodings = encodings
+			}
+
+			fname := filepath.Join(dir, "db.bin")
+			db, err := OpenOptions(fname, opts)
+			assertNil(t, err)
+
+			seq, small, temp, ratio := db.PutName("seq"), db.PutName("small"), db.PutName("temp"), db.PutName("ratio")
+			for r := 0; r < 3; r++ {
+				for i := r * 100; i < r*100+100; i++ {
+					assertNil(t, db.Add(func(obj *Object) error {
+						obj.AddUint32(seq, uint32(1600000000+i))
+						if i%50 == 7 {
+							// a jump which does not fit into a shorter delta
+							obj.AddInt(seq, math.MinInt64+int64(i))
+						}
+						obj.AddUint32(small, uint32(i%7)*100)
+						obj.AddFloat(temp, 20.1+float64(i/10))
+						obj.AddField(ratio, func(f *FieldWriter) {
+							f.WriteFloat32(float32(i%3) / 3)
+						})
+						return nil
+					}))
+				}
+				assertNil(t, db.Flush())
+			}
+			assertNil(t, db.Close())
+
+			db, err = OpenOptions(fname, Options{Quiet: true, Compression: compression})
+			assertNil(t, err)
+			assertNil(t, db.Verify())
+
+			var lines []string
+			var ids []uint64
+			visit := func(obj *Object) string {
+				line := ""
+				obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+					line += fmt.Sprintf("%s:%d=%v ", db.NameByIndex(int(name)), kind, f.ReadFloat())
+				})
+				return line
+			}
+
+			assertNil(t, db.ForEach(func(oid uint64, obj *Object) error {
+				lines = append(lines, visit(obj))
+				ids = append(ids, oid)
+				return nil
+			}))
+
+			for _, i := range []int{0, 57, 299} {
+				assertNil(t, db.Read(ids[i], func(obj *Object) error {
+					if line := visit(obj); line != lines[i] {
+						t.Fatalf("expected %q but got %q", lines[i], line)
+					}
+					return nil
+				}))
+			}
+
+			if results[encoded] != nil && fmt.Sprint(results[encoded]) != fmt.Sprint(lines) {
+				t.Fatal("compression changed the decoded objects")
+			}
+			results[encoded] = lines
+
+			for _, stat := range db.Records() {
+				if !compression {
+					sizes[encoded] += int64(stat.Size)
+				}
+			}
+			assertNil(t, db.Close())
+		}
+	}
+
+	// the decoded objects are equal to the unencoded ones, including their types
+	for i, line := range results[false] {
+		if results[true][i] != line {
+			t.Fatalf("expected %q but got %q", line, results[true][i])
+		}
+	}
+
+	if sizes[true]*5 >= sizes[false]*4 {
+		t.Fatalf("expected the encodings to shrink the records, but got %v", sizes)
+	}
+
+	if e, err := ParseEncoding("Delta"); err != nil || e != EncodingDelta || e.String() != "delta" {
+		t.Fatalf("unexpected encoding %v: %v", e, err)
+	}
+}
@@ -0,0 +1,235 @@
+package logdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+)
+
+// keySize is the size of the AES-256 keys.
+const keySize = 32
+
+// A KeyProvider supplies the keys of encrypted databases by their id. A key must have 32 bytes and only
+// encrypts the random data key of a database, which is stored in its header together with the key id, so
+// that keys can be rotated for new databases while old databases stay readable.
+type KeyProvider interface {
+	Key(id string) ([]byte, error)
+}
+
+// KeyMap is a KeyProvider for keys which are held in memory.
+type KeyMap map[string][]byte
+
+// Key returns the key of the id or an error, if it is unknown.
+func (m KeyMap) Key(id string) ([]byte, error) {
+	key, ok := m[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	return key, nil
+}
+
+// newAEAD creates AES-256-GCM for the key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must have %d bytes but has %d", keySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// randomNonce returns a new random nonce. It panics, if the system has no randomness left, like AddName does
+// if the header overflows, because there is no way to continue securely.
+func randomNonce(aead cipher.AEAD) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(fmt.Sprintf("unable to create a nonce: %v", err))
+	}
+
+	return nonce
+}
+
+// sealRecord encrypts a record like seal. The nonce is random instead of derived from the file offset,
+// because restored snapshots and replicas share the data key and may write other records at the same offsets.
+// The offset is authenticated as additional data instead, so that records cannot be moved within the file.
+func sealRecord(aead cipher.AEAD, offset int64, plaintext []byte) []byte {
+	return seal(aead, plaintext, recordData(offset))
+}
+
+// openRecord decrypts a record of sealRecord at the file offset and appends the plaintext to dst.
+func openRecord(aead cipher.AEAD, dst []byte, offset int64, sealed []byte) ([]byte, error) {
+	if len(sealed) < sealOverhead(aead) {
+		return nil, fmt.Errorf("ciphertext is truncated")
+	}
+
+	return aead.Open(dst, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], recordData(offset))
+}
+
+func recordData(offset int64) []byte {
+	ad := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ad, uint64(offset))
+	return ad
+}
+
+// seal encrypts the plaintext with a random nonce, which is prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) []byte {
+	nonce := randomNonce(aead)
+	return aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+// unseal decrypts a ciphertext of seal.
+func unseal(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("ciphertext is truncated")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// sealOverhead is the amount of bytes which seal adds to the plaintext.
+func sealOverhead(aead cipher.AEAD) int {
+	return aead.NonceSize() + aead.Overhead()
+}
+
+// newDataKey creates a random data key and returns it together with its copy, which is encrypted by the
+// key of the id.
+func newDataKey(keys KeyProvider, keyID string) (cipher.AEAD, []byte, error) {
+	kek, err := keyEncryption(keys, keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, fmt.Errorf("unable to create a data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, seal(kek, dataKey, []byte(keyID)), nil
+}
+
+// openDataKey decrypts the data key with the key of the id.
+func openDataKey(keys KeyProvider, keyID string, wrapped []byte) (cipher.AEAD, error) {
+	kek, err := keyEncryption(keys, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := unseal(kek, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the data key with key %q: %w", keyID, err)
+	}
+
+	return newAEAD(dataKey)
+}
+
+// keyEncryption returns AES-256-GCM for the key of the id.
+func keyEncryption(keys KeyProvider, keyID string) (cipher.AEAD, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("the database is encrypted with key %q, but no keys are given", keyID)
+	}
+
+	key, err := keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %w", keyID, err)
+	}
+
+	return aead, nil
+}
+
+// sealNames encrypts a name table with the data key.
+func sealNames(aead cipher.AEAD, names []string) []byte {
+	size := 0
+	for _, name := range names {
+		size += nameSize(name)
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, size)}
+	for _, name := range names {
+		(*ioutil.TypedLittleEndianBuffer)(buf).WriteString(name)
+	}
+
+	return seal(aead, buf.Bytes, nil)
+}
+
+// unsealNames decrypts a name table of sealNames.
+func unsealNames(aead cipher.AEAD, sealed []byte) (names []string, err error) {
+	plaintext, err := unseal(aead, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the names: %w", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			names, err = nil, fmt.Errorf("invalid names: %v", r)
+		}
+	}()
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: plaintext}
+	for buf.Pos < len(plaintext) {
+		names = append(names, (*ioutil.TypedLittleEndianBuffer)(buf).ReadString(nil))
+	}
+
+	return names, nil
+}
+
+// openEncryption sets up the encryption of a new database or decrypts the names of an existing one.
+func (db *DB) openEncryption(created bool) error {
+	h := db.header
+	switch {
+	case created && db.opts.Keys != nil:
+		if db.opts.KeyID == "" {
+			return fmt.Errorf("a key id is required to encrypt a new database")
+		}
+
+		aead, wrapped, err := newDataKey(db.opts.Keys, db.opts.KeyID)
+		if err != nil {
+			return err
+		}
+
+		h.setEncryption(db.opts.KeyID, wrapped, aead)
+	case h.keyID == "" && db.opts.Keys != nil:
+		return fmt.Errorf("the database is not encrypted")
+	case h.keyID != "":
+		aead, err := openDataKey(db.opts.Keys, h.keyID, h.wrappedKey)
+		if err != nil {
+			return err
+		}
+
+		if err := h.openNames(aead); err != nil {
+			return err
+		}
+	}
+
+	db.aead = h.aead
+	return nil
+}
+
+// KeyID returns the id of the key which encrypts the database or an empty string, if it is not encrypted.
+func (db *DB) KeyID() string {
+	return db.header.keyID
+}
+
+// prefixed returns true, if the records are stored with a length prefix, because they are compressed or
+// encrypted.
+func (db *DB) prefixed() bool {
+	return db.compress || db.aead != nil
+}
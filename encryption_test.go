@@ -0,0 +1,175 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+	ioutil2 "io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	keys := KeyMap{"k1": bytes.Repeat([]byte{1}, 32), "k2": bytes.Repeat([]byte{2}, 32)}
+
+	for _, compression := range []bool{false, true} {
+		dir, err := ioutil2.TempDir("", "encryptionTest")
+		assertNil(t, err)
+
+		fname := filepath.Join(dir, "db.bin")
+		db, err := OpenOptions(fname, Options{Compression: compression, Quiet: true, Keys: keys, KeyID: "k1"})
+		assertNil(t, err)
+
+		name := db.PutName("secret-name")
+		var ids []uint64
+		for r := 0; r < 3; r++ {
+			for i := 0; i < 10; i++ {
+				assertNil(t, db.Add(func(obj *Object) error {
+					obj.AddString(name, fmt.Sprintf("secret-value-%d", r*10+i))
+					return nil
+				}))
+			}
+			assertNil(t, db.Flush())
+		}
+		assertNil(t, db.ForEach(func(id uint64, obj *Object) error {
+			ids = append(ids, id)
+			return nil
+		}))
+		assertNil(t, db.Close())
+
+		data, err := ioutil2.ReadFile(fname)
+		assertNil(t, err)
+		if bytes.Contains(data, []byte("secret")) {
+			t.Fatal("expected names and values to be encrypted")
+		}
+
+		for _, mmap := range []bool{false, true} {
+			db, err = OpenOptions(fname, Options{Compression: compression, Mmap: mmap, Quiet: true, Keys: keys})
+			assertNil(t, err)
+			if db.KeyID() != "k1" || db.NameByIndex(int(name)) != "secret-name" {
+				t.Fatalf("unexpected key %q or names %v", db.KeyID(), db.Names())
+			}
+
+			values := func(obj *Object) string {
+				var s string
+				obj.WithFields(func(_ uint16, _ ioutil.Type, r *FieldReader) {
+					s = r.ReadMutableString(nil)
+				})
+				return s
+			}
+
+			n := 0
+			assertNil(t, db.ForEach(func(id uint64, obj *Object) error {
+				if v := values(obj); v != fmt.Sprintf("secret-value-%d", n) {
+					t.Fatalf("unexpected value %s", v)
+				}
+				n++
+				return nil
+			}))
+
+			var mutex sync.Mutex
+			count := 0
+			assertNil(t, db.ForEachP(2, func(gid int, id uint64, obj *Object) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				count++
+				return nil
+			}))
+
+			assertNil(t, db.Read(ids[25], func(obj *Object) error {
+				if v := values(obj); v != "secret-value-25" {
+					t.Fatalf("unexpected value %s", v)
+				}
+				return nil
+			}))
+
+			if n != 30 || count != 30 {
+				t.Fatalf("expected 30 objects but got %d and %d", n, count)
+			}
+
+			assertNil(t, db.Verify())
+			assertNil(t, db.Close())
+		}
+
+		// the key is required and must match, and a plain database is not opened as encrypted one
+		for _, opts := range []Options{{}, {Keys: KeyMap{"k1": keys["k2"]}}, {Keys: KeyMap{}}} {
+			opts.Compression, opts.Quiet = compression, true
+			if _, err := OpenOptions(fname, opts); err == nil {
+				t.Fatalf("expected an error for %+v", opts)
+			}
+		}
+
+		plain, err := OpenOptions(filepath.Join(dir, "plain.bin"), Options{Quiet: true})
+		assertNil(t, err)
+		assertNil(t, plain.Close())
+		if _, err := OpenOptions(filepath.Join(dir, "plain.bin"), Options{Quiet: true, Keys: keys}); err == nil {
+			t.Fatal("expected an error for a plain database")
+		}
+
+		// snapshots keep the data key and the names encrypted
+		db, err = OpenOptions(fname, Options{Compression: compression, Quiet: true, Keys: keys})
+		assertNil(t, err)
+		var snapshot bytes.Buffer
+		m, err := db.Snapshot(&snapshot)
+		assertNil(t, err)
+		assertNil(t, db.Close())
+		if m.KeyID != "k1" || len(m.Names) != 0 || len(m.SealedNames) == 0 {
+			t.Fatalf("unexpected manifest %+v", m)
+		}
+
+		snapshotData := func(i int) (io.ReadCloser, error) {
+			return ioutil2.NopCloser(bytes.NewReader(snapshot.Bytes())), nil
+		}
+
+		if err := Restore(filepath.Join(dir, "restored.bin"), []*Manifest{m}, snapshotData); err == nil {
+			t.Fatal("expected an error without keys")
+		}
+
+		restored := filepath.Join(dir, "restored.bin")
+		assertNil(t, RestoreEncrypted(restored, []*Manifest{m}, snapshotData, keys))
+		db, err = OpenOptions(restored, Options{Compression: compression, Quiet: true, Keys: keys})
+		assertNil(t, err)
+		if db.ObjectCount() != 30 || db.NameByIndex(int(name)) != "secret-name" {
+			t.Fatalf("unexpected restored database with %d objects and names %v", db.ObjectCount(), db.Names())
+		}
+		assertNil(t, db.Close())
+
+		// two restored copies share the data key, but their next records at the same offset have other nonces
+		var nonces [][]byte
+		for i, restored := range []string{restored, filepath.Join(dir, "restored2.bin")} {
+			if i > 0 {
+				assertNil(t, RestoreEncrypted(restored, []*Manifest{m}, snapshotData, keys))
+			}
+
+			db, err = OpenOptions(restored, Options{Compression: compression, Quiet: true, Keys: keys})
+			assertNil(t, err)
+			assertNil(t, db.Add(func(obj *Object) error {
+				obj.AddString(name, fmt.Sprintf("copy-%d", i))
+				return nil
+			}))
+			assertNil(t, db.Flush())
+
+			stats := db.Records()
+			raw, err := db.ReadRawRecord(stats[len(stats)-1], nil)
+			assertNil(t, err)
+			nonces = append(nonces, raw[4:16])
+			assertNil(t, db.Verify())
+			assertNil(t, db.Close())
+		}
+		if bytes.Equal(nonces[0], nonces[1]) {
+			t.Fatalf("expected different nonces but got %x twice", nonces[0])
+		}
+
+		// a modified record is detected
+		data[len(data)-1]++
+		assertNil(t, ioutil2.WriteFile(fname, data, 0644))
+		db, err = OpenOptions(fname, Options{Compression: compression, Quiet: true, Keys: keys})
+		assertNil(t, err)
+		if err := db.ForEach(func(id uint64, obj *Object) error { return nil }); err == nil {
+			t.Fatal("expected an error for a modified record")
+		}
+		assertNil(t, db.Close())
+	}
+}
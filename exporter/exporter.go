@@ -0,0 +1,539 @@
+// Package exporter writes the objects of a database as CSV, newline delimited JSON or Parquet, e.g. to hand
+// them over to pandas.
+package exporter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Format is the encoding of the output.
+type Format uint8
+
+const (
+	// CSV writes a header line with the column names, followed by one line per object. Missing values
+	// are empty.
+	CSV Format = iota
+
+	// NDJSON writes one JSON object per line, which only contains the fields of the object. Repeated
+	// fields become arrays.
+	NDJSON
+
+	// Parquet writes an uncompressed parquet file with a single optional column per name. Integer
+	// columns keep the width of their field types.
+	Parquet
+)
+
+// ParseFormat returns the format for its name, which is one of csv, ndjson or parquet.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return CSV, nil
+	case "ndjson", "jsonl", "json":
+		return NDJSON, nil
+	case "parquet":
+		return Parquet, nil
+	default:
+		return 0, fmt.Errorf("unknown format %s", name)
+	}
+}
+
+// IDColumn is the name of the column which contains the object ids, see Options.IncludeID.
+const IDColumn = "_id"
+
+const (
+	defaultRowGroupSize = 64 * 1024
+	flushSize           = 1024 * 1024 // bytes of text which each routine buffers, before writing them
+)
+
+// Options configure an export.
+type Options struct {
+	Format Format
+
+	// Names are the exported names in the order of the columns. By default, all names which occur in any
+	// object are exported in the order of the header.
+	Names []string
+
+	// IncludeID adds the object id as the first column.
+	IncludeID bool
+
+	// FromID and ToID restrict the export to objects with FromID <= id < ToID. A ToID of 0 is unbounded.
+	FromID, ToID uint64
+
+	// FromOrdinal and ToOrdinal restrict the export to objects with FromOrdinal <= ordinal < ToOrdinal,
+	// counted from 0 in the order in which the objects have been added. A ToOrdinal of 0 is unbounded.
+	FromOrdinal, ToOrdinal uint64
+
+	// Filter skips all objects for which it returns false. It is called concurrently.
+	Filter func(obj *logdb.Object) bool
+
+	// Routines is the amount of go routines which scan the database. The objects of different records are
+	// written in the order in which the routines finish them, so only a single routine keeps the order of
+	// the database.
+	Routines int
+
+	// RowGroupSize is the maximum amount of rows per parquet row group. Each routine keeps one row group in
+	// memory. Defaults to 65536.
+	RowGroupSize int
+}
+
+// exporter contains the state which is shared by all routines.
+type exporter struct {
+	db      *logdb.DB
+	opts    Options
+	names   []string
+	columns []Column
+	slots   []int // name index to column index or -1
+	fromID  uint64
+	toID    uint64
+	mutex   sync.Mutex
+	w       io.Writer
+	count   uint64
+	parquet *parquetWriter
+}
+
+// Export writes the selected objects and returns their amount.
+func Export(db *logdb.DB, w io.Writer, opts Options) (uint64, error) {
+	e := &exporter{db: db, opts: opts, w: w, toID: math.MaxUint64}
+	if err := e.resolveRange(); err != nil {
+		return 0, err
+	}
+
+	if err := e.resolveSchema(opts.Format == Parquet); err != nil {
+		return 0, err
+	}
+
+	routines := opts.Routines
+	if routines < 1 {
+		routines = 1
+	}
+
+	workers := make([]*worker, routines)
+	for i := range workers {
+		workers[i] = &worker{e: e}
+	}
+
+	switch opts.Format {
+	case CSV:
+		header := make([]string, 0, len(e.columns)+1)
+		if opts.IncludeID {
+			header = append(header, IDColumn)
+		}
+		for _, c := range e.columns {
+			header = append(header, c.Name)
+		}
+
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return 0, err
+		}
+	case Parquet:
+		size := opts.RowGroupSize
+		if size <= 0 {
+			size = defaultRowGroupSize
+		}
+
+		e.parquet = newParquetWriter(w, e.schema(), size)
+		if err := e.parquet.begin(); err != nil {
+			return 0, err
+		}
+	}
+
+	err := db.ForEachP(routines, func(gid int, id uint64, obj *logdb.Object) error {
+		if id < e.fromID || id >= e.toID {
+			return nil
+		}
+
+		if opts.Filter != nil && !opts.Filter(obj) {
+			return nil
+		}
+
+		return workers[gid].add(id, obj)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, wk := range workers {
+		if err := wk.flush(); err != nil {
+			return 0, err
+		}
+		e.count += wk.count
+	}
+
+	if e.parquet != nil {
+		if err := e.parquet.end(); err != nil {
+			return 0, err
+		}
+	}
+
+	return e.count, nil
+}
+
+// Schema returns the columns which an export with the given options writes, including the id column. Unlike
+// Export, it always scans the selected objects to determine the types of the columns.
+func Schema(db *logdb.DB, opts Options) ([]Column, error) {
+	e := &exporter{db: db, opts: opts, toID: math.MaxUint64}
+	if err := e.resolveRange(); err != nil {
+		return nil, err
+	}
+
+	if err := e.resolveSchema(true); err != nil {
+		return nil, err
+	}
+
+	return e.schema(), nil
+}
+
+// IDRange returns the ids from and to, so that the id and ordinal options select all objects with
+// from <= id < to.
+func IDRange(db *logdb.DB, opts Options) (uint64, uint64, error) {
+	e := &exporter{db: db, opts: opts, toID: math.MaxUint64}
+	err := e.resolveRange()
+	return e.fromID, e.toID, err
+}
+
+// resolveRange converts the ordinals into ids and intersects them with the id range.
+func (e *exporter) resolveRange() error {
+	e.fromID = e.opts.FromID
+	if e.opts.ToID > 0 {
+		e.toID = e.opts.ToID
+	}
+
+	count := uint64(0)
+	if records := e.db.Records(); len(records) > 0 {
+		last := records[len(records)-1]
+		count = last.FirstOrdinal + uint64(last.ObjectCount)
+	}
+
+	if e.opts.FromOrdinal > 0 {
+		if e.opts.FromOrdinal >= count {
+			e.fromID = math.MaxUint64
+			return nil
+		}
+
+		id, err := e.db.SeekOrdinal(e.opts.FromOrdinal)
+		if err != nil {
+			return err
+		}
+
+		if id > e.fromID {
+			e.fromID = id
+		}
+	}
+
+	if e.opts.ToOrdinal > 0 && e.opts.ToOrdinal < count {
+		id, err := e.db.SeekOrdinal(e.opts.ToOrdinal)
+		if err != nil {
+			return err
+		}
+
+		if id < e.toID {
+			e.toID = id
+		}
+	}
+
+	return nil
+}
+
+// resolveSchema determines the columns and, if typed, their types. Without types, the database is only
+// scanned to find all names if none have been selected.
+func (e *exporter) resolveSchema(typed bool) error {
+	names := e.db.Names()
+	e.names = names
+	e.slots = make([]int, len(names))
+	for i := range e.slots {
+		e.slots[i] = -1
+	}
+
+	for _, name := range e.opts.Names {
+		idx := e.db.IndexByName(name)
+		if idx >= 0 {
+			e.slots[idx] = len(e.columns)
+		}
+		e.columns = append(e.columns, Column{Name: name})
+	}
+
+	if !typed && (len(e.opts.Names) > 0 || e.opts.Format == NDJSON) {
+		return nil
+	}
+
+	routines := e.opts.Routines
+	if routines < 1 {
+		routines = 1
+	}
+
+	// each routine widens its own columns, which are merged afterwards
+	all := len(e.opts.Names) == 0
+	columns := make([][]Column, routines)
+	for i := range columns {
+		columns[i] = make([]Column, len(names))
+	}
+
+	err := e.db.ForEachP(routines, func(gid int, id uint64, obj *logdb.Object) error {
+		if id < e.fromID || id >= e.toID {
+			return nil
+		}
+
+		if e.opts.Filter != nil && !e.opts.Filter(obj) {
+			return nil
+		}
+
+		cols := columns[gid]
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+			if int(name) < len(cols) && (all || e.slots[name] >= 0) {
+				c := &cols[name]
+				if c.Kind == KindNull {
+					// mark the name as used, even if its values are not supported
+					c.Name = names[name]
+				}
+				c.widen(kind)
+			}
+		})
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	merged := columns[0]
+	for _, cols := range columns[1:] {
+		for i, c := range cols {
+			if c.Name == "" {
+				continue
+			}
+
+			m := &merged[i]
+			m.Name = c.Name
+			m.merge(c)
+		}
+	}
+
+	if all {
+		for i, c := range merged {
+			if c.Name != "" {
+				e.slots[i] = len(e.columns)
+				e.columns = append(e.columns, c)
+			}
+		}
+		return nil
+	}
+
+	for i, slot := range e.slots {
+		if slot >= 0 {
+			e.columns[slot] = Column{Name: e.columns[slot].Name, Kind: merged[i].Kind, Bits: merged[i].Bits}
+		}
+	}
+
+	return nil
+}
+
+// merge widens the column with the type of another column.
+func (c *Column) merge(o Column) {
+	switch o.Kind {
+	case KindNull:
+	case KindInt:
+		c.widen(ioutil.TInt8 + ioutil.Type(o.Bits/8-1))
+	case KindUint:
+		c.widen(ioutil.TUint8 + ioutil.Type(o.Bits/8-1))
+	case KindFloat32:
+		c.widen(ioutil.TFloat32)
+	case KindFloat64:
+		c.widen(ioutil.TFloat64)
+	case KindString:
+		c.widen(ioutil.TString8)
+	case KindBlob:
+		c.widen(ioutil.TBlob8)
+	}
+}
+
+// schema returns the exported columns including the id column.
+func (e *exporter) schema() []Column {
+	if !e.opts.IncludeID {
+		return e.columns
+	}
+
+	return append([]Column{{Name: IDColumn, Kind: KindUint, Bits: 64}}, e.columns...)
+}
+
+// write appends text to the output.
+func (e *exporter) write(b []byte) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, err := e.w.Write(b)
+	return err
+}
+
+// worker converts the objects of a single routine.
+type worker struct {
+	e     *exporter
+	buf   bytes.Buffer
+	cells []cell
+	csv   *csv.Writer
+	text  []string
+	group *rowGroup
+	count uint64
+	json  *JSONEncoder
+	line  []byte
+}
+
+// cell is a single value of a row. Bytes slice into the object.
+type cell struct {
+	kind Kind
+	set  bool
+	i    int64
+	f    float64
+	b    []byte
+}
+
+func (wk *worker) add(id uint64, obj *logdb.Object) error {
+	e := wk.e
+	wk.count++
+
+	if e.opts.Format == NDJSON {
+		if wk.json == nil {
+			wk.json = NewJSONEncoder(e.db, e.opts.Names, e.opts.IncludeID)
+		}
+		wk.line = wk.json.Append(wk.line[:0], id, obj)
+		wk.buf.Write(wk.line)
+		return wk.flushIfFull()
+	}
+
+	if cap(wk.cells) < len(e.columns) {
+		wk.cells = make([]cell, len(e.columns))
+	}
+	cells := wk.cells[:len(e.columns)]
+	for i := range cells {
+		cells[i] = cell{}
+	}
+
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+		if int(name) >= len(e.slots) || e.slots[name] < 0 || cells[e.slots[name]].set {
+			return
+		}
+
+		c := &cells[e.slots[name]]
+		c.kind, _ = kindOf(kind)
+		switch c.kind {
+		case KindInt, KindUint:
+			c.i = f.ReadInt()
+		case KindFloat32, KindFloat64:
+			c.f = f.ReadFloat()
+		case KindString, KindBlob:
+			switch kind {
+			case logdb.TUUID:
+				c.b = []byte(f.ReadUUID().String())
+			case logdb.TDecimal:
+				c.b = []byte(f.ReadDecimal().String())
+			default:
+				c.b = f.ReadRaw()
+			}
+		default:
+			return
+		}
+		c.set = true
+	})
+
+	if e.opts.Format == Parquet {
+		if wk.group == nil {
+			wk.group = newRowGroup(e.parquet.columns)
+		}
+
+		if e.opts.IncludeID {
+			wk.group.columns[0].add(cell{kind: KindUint, set: true, i: int64(id)})
+		}
+
+		offset := len(wk.group.columns) - len(cells)
+		for i, c := range cells {
+			wk.group.columns[offset+i].add(c)
+		}
+		wk.group.rows++
+
+		if wk.group.rows >= e.parquet.rowGroupSize {
+			return wk.flush()
+		}
+		return nil
+	}
+
+	if wk.csv == nil {
+		wk.csv = csv.NewWriter(&wk.buf)
+	}
+
+	wk.text = wk.text[:0]
+	if e.opts.IncludeID {
+		wk.text = append(wk.text, strconv.FormatUint(id, 10))
+	}
+	for _, c := range cells {
+		wk.text = append(wk.text, c.String())
+	}
+
+	if err := wk.csv.Write(wk.text); err != nil {
+		return err
+	}
+	wk.csv.Flush()
+
+	return wk.flushIfFull()
+}
+
+func (wk *worker) flushIfFull() error {
+	if wk.buf.Len() < flushSize {
+		return nil
+	}
+
+	return wk.flush()
+}
+
+// flush writes the buffered text or row group.
+func (wk *worker) flush() error {
+	if wk.group != nil && wk.group.rows > 0 {
+		err := wk.e.parquet.writeRowGroup(wk.group)
+		wk.group.reset()
+		return err
+	}
+
+	if wk.buf.Len() == 0 {
+		return nil
+	}
+
+	err := wk.e.write(wk.buf.Bytes())
+	wk.buf.Reset()
+	return err
+}
+
+// String formats the value as text, which is empty for missing values. Blobs are base64 encoded.
+func (c cell) String() string {
+	if !c.set {
+		return ""
+	}
+
+	switch c.kind {
+	case KindInt:
+		return strconv.FormatInt(c.i, 10)
+	case KindUint:
+		return strconv.FormatUint(uint64(c.i), 10)
+	case KindFloat32:
+		return strconv.FormatFloat(c.f, 'g', -1, 32)
+	case KindFloat64:
+		return strconv.FormatFloat(c.f, 'g', -1, 64)
+	case KindBlob:
+		return base64.StdEncoding.EncodeToString(c.b)
+	default:
+		return string(c.b)
+	}
+}
@@ -0,0 +1,372 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestDB creates a database with three objects of differing fields.
+func openTestDB(t *testing.T) *logdb.DB {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	id, temp, name, small, data := db.PutName("id"), db.PutName("temp"), db.PutName("name"),
+		db.PutName("small"), db.PutName("data")
+
+	objects := []func(obj *logdb.Object){
+		func(obj *logdb.Object) {
+			obj.AddInt8(id, 5)
+			obj.AddField(temp, func(f *logdb.FieldWriter) { f.WriteFloat64(21.5) })
+			obj.AddString(name, "a")
+			obj.AddField(small, func(f *logdb.FieldWriter) { f.WriteUint16(1) })
+		},
+		func(obj *logdb.Object) {
+			obj.AddUint32(id, 70000)
+			obj.AddString(name, "b")
+			obj.AddString(name, "c")
+			obj.AddField(small, func(f *logdb.FieldWriter) { f.WriteUint16(2) })
+		},
+		func(obj *logdb.Object) {
+			obj.AddInt(id, -3)
+			obj.AddField(temp, func(f *logdb.FieldWriter) { f.WriteFloat64(-1.25) })
+			obj.AddField(small, func(f *logdb.FieldWriter) { f.WriteUint16(3) })
+			obj.AddField(data, func(f *logdb.FieldWriter) { f.WriteBlob([]byte{1, 2}) })
+		},
+	}
+
+	for _, add := range objects {
+		logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+			add(obj)
+			return nil
+		}))
+	}
+	logdbtest.AssertNil(t, db.Flush())
+
+	return db
+}
+
+func export(t *testing.T, db *logdb.DB, opts Options) string {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := Export(db, &buf, opts)
+	logdbtest.AssertNil(t, err)
+	return buf.String()
+}
+
+func TestExportCSV(t *testing.T) {
+	db := openTestDB(t)
+
+	expected := "id,temp,name,small,data\n" +
+		"5,21.5,a,1,\n" +
+		"70000,,b,2,\n" +
+		"-3,-1.25,,3,AQI=\n"
+	if out := export(t, db, Options{Format: CSV}); out != expected {
+		t.Fatalf("unexpected csv\n%s", out)
+	}
+
+	expected = "small,missing,temp\n" +
+		"1,,21.5\n" +
+		"3,,-1.25\n"
+	out := export(t, db, Options{Format: CSV, Names: []string{"small", "missing", "temp"}, Routines: 2,
+		Filter: func(obj *logdb.Object) bool {
+			hasTemp := false
+			obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+				hasTemp = hasTemp || db.NameByIndex(int(name)) == "temp"
+			})
+			return hasTemp
+		},
+	})
+	if out != expected {
+		t.Fatalf("unexpected projected csv\n%s", out)
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	db := openTestDB(t)
+
+	second, err := db.SeekOrdinal(1)
+	logdbtest.AssertNil(t, err)
+
+	expected := `{"id":5,"temp":21.5,"name":"a","small":1}` + "\n" +
+		`{"id":70000,"name":["b","c"],"small":2}` + "\n" +
+		`{"id":-3,"temp":-1.25,"small":3,"data":"AQI="}` + "\n"
+	if out := export(t, db, Options{Format: NDJSON}); out != expected {
+		t.Fatalf("unexpected ndjson\n%s", out)
+	}
+
+	expected = fmt.Sprintf(`{"_id":%d,"name":["b","c"]}`+"\n", second)
+	out := export(t, db, Options{Format: NDJSON, Names: []string{"name"}, IncludeID: true, FromOrdinal: 1,
+		ToOrdinal: 2})
+	if out != expected {
+		t.Fatalf("unexpected ndjson range\n%s", out)
+	}
+
+	if out := export(t, db, Options{Format: NDJSON, FromID: second + 1}); strings.Count(out, "\n") != 1 {
+		t.Fatalf("unexpected ndjson id range\n%s", out)
+	}
+
+	if out := export(t, db, Options{Format: NDJSON, FromOrdinal: 3}); out != "" {
+		t.Fatalf("expected no objects but got\n%s", out)
+	}
+}
+
+func TestExportNestedNDJSON(t *testing.T) {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	blade, temp, counts := db.PutName("blade"), db.PutName("temp"), db.PutName("counts")
+	logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+		obj.AddObject(blade, func(f *logdb.FieldWriter) {
+			f.WriteName(temp)
+			f.WriteFloat32Array([]float32{1.1, float32(math.NaN())})
+			f.WriteName(counts)
+			f.WriteInt64Array([]int64{-1})
+			f.WriteName(counts)
+			f.WriteUint64Array([]uint64{math.MaxUint64})
+		})
+		return nil
+	}))
+	logdbtest.AssertNil(t, db.Flush())
+
+	expected := `{"blade":{"temp":[1.1,null],"counts":[[-1],[18446744073709551615]]}}` + "\n"
+	if out := export(t, db, Options{Format: NDJSON}); out != expected {
+		t.Fatalf("unexpected nested ndjson\n%s", out)
+	}
+}
+
+func TestExportScalars(t *testing.T) {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	names := []uint16{db.PutName("null"), db.PutName("ok"), db.PutName("at"), db.PutName("uuid"), db.PutName("price")}
+	logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+		obj.AddNull(names[0])
+		obj.AddBool(names[1], true)
+		obj.AddTimestamp(names[2], time.Date(2150, 1, 2, 3, 4, 5, 6, time.UTC))
+		obj.AddUUID(names[3], logdb.UUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40})
+		obj.AddDecimal(names[4], logdb.Decimal{Unscaled: -5, Scale: 2})
+		return nil
+	}))
+	logdbtest.AssertNil(t, db.Flush())
+
+	expected := `{"null":null,"ok":true,"at":"2150-01-02T03:04:05.000000006Z",` +
+		`"uuid":"123e4567-e89b-12d3-a456-426614174000","price":-0.05}` + "\n"
+	if out := export(t, db, Options{Format: NDJSON}); out != expected {
+		t.Fatalf("unexpected ndjson\n%s", out)
+	}
+
+	// explicit nulls are missing values and the other types become numbers or text
+	expected = "null,ok,at,uuid,price\n,1,5680379045000000006,123e4567-e89b-12d3-a456-426614174000,-0.05\n"
+	if out := export(t, db, Options{Format: CSV}); out != expected {
+		t.Fatalf("unexpected csv\n%s", out)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	db := openTestDB(t)
+
+	var buf bytes.Buffer
+	n, err := Export(db, &buf, Options{Format: Parquet, RowGroupSize: 2})
+	logdbtest.AssertNil(t, err)
+	if n != 3 {
+		t.Fatalf("expected 3 objects but got %d", n)
+	}
+
+	file := buf.Bytes()
+	if !bytes.HasPrefix(file, parquetMagic) || !bytes.HasSuffix(file, parquetMagic) {
+		t.Fatal("missing magic")
+	}
+
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := readThrift(t, file[len(file)-8-footerLen:len(file)-8])
+	if meta.i(3) != 3 {
+		t.Fatalf("expected 3 rows but got %d", meta.i(3))
+	}
+
+	// name, physical type and converted type of each column
+	var schema []string
+	for _, e := range meta.list(2)[1:] {
+		element := e.(thriftFields)
+		converted := int64(parquetNoType)
+		if v, ok := element[6]; ok {
+			converted = v.(int64)
+		}
+		schema = append(schema, fmt.Sprintf("%s:%d:%d", element[4], element.i(1), converted))
+	}
+
+	expected := "[id:2:18 temp:5:-1 name:6:0 small:1:12 data:6:-1]"
+	if fmt.Sprint(schema) != expected {
+		t.Fatalf("expected schema %s but got %v", expected, schema)
+	}
+
+	groups := meta.list(4)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 row groups but got %d", len(groups))
+	}
+
+	// the values of all columns, with nil for nulls
+	columns := make([][]interface{}, 5)
+	for _, g := range groups {
+		for i, c := range g.(thriftFields).list(1) {
+			meta := c.(thriftFields)[3].(thriftFields)
+			columns[i] = append(columns[i], readPage(t, file, meta)...)
+		}
+	}
+
+	expected = "[[5 70000 -3] [21.5 <nil> -1.25] [a b <nil>] [1 2 3] [<nil> <nil> [1 2]]]"
+	if fmt.Sprint(columns) != expected {
+		t.Fatalf("expected values %s but got %v", expected, columns)
+	}
+}
+
+// readPage decodes the single data page of a column chunk.
+func readPage(t *testing.T, file []byte, meta thriftFields) []interface{} {
+	r := &thriftReader{buf: file[meta.i(9):]}
+	header := r.readStruct()
+	page := r.buf[r.pos : r.pos+int(header.i(3))]
+	rows := int(header[5].(thriftFields).i(1))
+
+	levelLen := int(binary.LittleEndian.Uint32(page))
+	levels := &thriftReader{buf: page[4 : 4+levelLen]}
+	values := page[4+levelLen:]
+
+	var defined []bool
+	for len(defined) < rows {
+		run := levels.varint()
+		if run&1 == 0 {
+			set := levels.buf[levels.pos] == 1
+			levels.pos++
+			for i := 0; i < int(run>>1); i++ {
+				defined = append(defined, set)
+			}
+			continue
+		}
+
+		for i := 0; i < int(run>>1)*8; i++ {
+			defined = append(defined, levels.buf[levels.pos+i/8]&(1<<(i%8)) != 0)
+		}
+		levels.pos += int(run >> 1)
+	}
+
+	var result []interface{}
+	for _, d := range defined[:rows] {
+		if !d {
+			result = append(result, nil)
+			continue
+		}
+
+		switch meta.i(1) {
+		case parquetInt32:
+			result = append(result, int32(binary.LittleEndian.Uint32(values)))
+			values = values[4:]
+		case parquetInt64:
+			result = append(result, int64(binary.LittleEndian.Uint64(values)))
+			values = values[8:]
+		case parquetDouble:
+			result = append(result, math.Float64frombits(binary.LittleEndian.Uint64(values)))
+			values = values[8:]
+		case parquetByteArray:
+			n := int(binary.LittleEndian.Uint32(values))
+			v := values[4 : 4+n]
+			if meta.list(3)[0] == "data" {
+				result = append(result, v)
+			} else {
+				result = append(result, string(v))
+			}
+			values = values[4+n:]
+		default:
+			t.Fatalf("unexpected type %d", meta.i(1))
+		}
+	}
+
+	return result
+}
+
+// thriftFields contains the fields of a decoded struct by their ids.
+type thriftFields map[int16]interface{}
+
+func (s thriftFields) i(id int16) int64 {
+	return s[id].(int64)
+}
+
+func (s thriftFields) list(id int16) []interface{} {
+	return s[id].([]interface{})
+}
+
+// thriftReader decodes the subset of the thrift compact protocol which thriftWriter writes.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func readThrift(t *testing.T, buf []byte) thriftFields {
+	r := &thriftReader{buf: buf}
+	s := r.readStruct()
+	if r.pos != len(buf) {
+		t.Fatalf("expected %d bytes but read %d", len(buf), r.pos)
+	}
+	return s
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() thriftFields {
+	s := thriftFields{}
+	id := int16(0)
+	for {
+		b := r.buf[r.pos]
+		r.pos++
+		if b == 0 {
+			return s
+		}
+
+		if delta := int16(b >> 4); delta > 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.readValue(b & 0x0f)
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		r.pos += n
+		return string(r.buf[r.pos-n : r.pos])
+	case thriftList:
+		b := r.buf[r.pos]
+		r.pos++
+		n := int(b >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.readValue(b & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	default:
+		panic(fmt.Sprintf("unsupported type %d", typ))
+	}
+}
@@ -0,0 +1,200 @@
+package exporter
+
+import (
+	"encoding/json"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"math"
+	"strconv"
+	"time"
+)
+
+// JSONEncoder formats objects as single lines of JSON like the NDJSON format. The fields keep their encoded
+// order, names which occur multiple times become arrays and names which have not been selected are
+// omitted. An encoder must only be used by a single go routine, see Clone.
+type JSONEncoder struct {
+	names     []string
+	selected  []bool // by name index or nil for all names
+	includeID bool
+	fields    []jsonField
+	values    []byte
+}
+
+// jsonField collects the values of a name within an object. Values are ranges into JSONEncoder.values.
+type jsonField struct {
+	name   uint16
+	values []int
+}
+
+// NewJSONEncoder creates an encoder for the given names, or all names if empty, which optionally starts
+// each object with its id as _id.
+func NewJSONEncoder(db *logdb.DB, names []string, includeID bool) *JSONEncoder {
+	e := &JSONEncoder{names: db.Names(), includeID: includeID}
+	if len(names) > 0 {
+		e.selected = make([]bool, len(e.names))
+		for _, name := range names {
+			if idx := db.IndexByName(name); idx >= 0 && idx < len(e.selected) {
+				e.selected[idx] = true
+			}
+		}
+	}
+
+	return e
+}
+
+// Clone returns an encoder with the same configuration for another go routine.
+func (e *JSONEncoder) Clone() *JSONEncoder {
+	return &JSONEncoder{names: e.names, selected: e.selected, includeID: e.includeID}
+}
+
+// Append appends the object as JSON followed by a line break.
+func (e *JSONEncoder) Append(dst []byte, id uint64, obj *logdb.Object) []byte {
+	e.fields, e.values = e.collect(e.fields[:0], e.values[:0], obj, e.selected)
+
+	dst = append(dst, '{')
+	if e.includeID {
+		dst = append(dst, `"`+IDColumn+`":`...)
+		dst = strconv.AppendUint(dst, id, 10)
+	}
+
+	dst = e.appendFields(dst, e.fields, e.values, e.includeID)
+	return append(dst, '}', '\n')
+}
+
+// collect appends the values of the selected fields of the object, or of all fields if selected is nil,
+// and groups them by name.
+func (e *JSONEncoder) collect(fields []jsonField, values []byte, obj *logdb.Object, selected []bool) ([]jsonField, []byte) {
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+		if selected != nil && (int(name) >= len(selected) || !selected[name]) {
+			return
+		}
+
+		start := len(values)
+		values = e.appendValue(values, kind, f)
+		for i := range fields {
+			if fields[i].name == name {
+				fields[i].values = append(fields[i].values, start, len(values))
+				return
+			}
+		}
+		fields = append(fields, jsonField{name: name, values: []int{start, len(values)}})
+	})
+
+	return fields, values
+}
+
+// appendFields appends the collected fields as JSON members, the first one with a leading comma if comma
+// is true.
+func (e *JSONEncoder) appendFields(dst []byte, fields []jsonField, values []byte, comma bool) []byte {
+	for i, field := range fields {
+		if i > 0 || comma {
+			dst = append(dst, ',')
+		}
+
+		name := "#" + strconv.Itoa(int(field.name))
+		if int(field.name) < len(e.names) {
+			name = e.names[field.name]
+		}
+		dst = appendJSONString(dst, name)
+		dst = append(dst, ':')
+
+		if len(field.values) == 2 {
+			dst = append(dst, values[field.values[0]:field.values[1]]...)
+			continue
+		}
+
+		dst = append(dst, '[')
+		for v := 0; v < len(field.values); v += 2 {
+			if v > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, values[field.values[v]:field.values[v+1]]...)
+		}
+		dst = append(dst, ']')
+	}
+
+	return dst
+}
+
+// appendValue appends a field value. Strings are quoted, blobs are base64 encoded, embedded objects become
+// JSON objects and arrays JSON arrays. Timestamps become RFC 3339 strings in UTC, UUIDs strings in their
+// canonical form and decimals exact numbers. Values which cannot be represented in JSON, like NaN or complex
+// numbers, become null.
+func (e *JSONEncoder) appendValue(dst []byte, kind ioutil.Type, f *logdb.FieldReader) []byte {
+	switch kind {
+	case logdb.TNull:
+		return append(dst, "null"...)
+	case logdb.TBool:
+		return strconv.AppendBool(dst, f.ReadBool())
+	case logdb.TTimestamp:
+		return appendJSONString(dst, f.ReadTimestamp().Format(time.RFC3339Nano))
+	case logdb.TUUID:
+		return appendJSONString(dst, f.ReadUUID().String())
+	case logdb.TDecimal:
+		return append(dst, f.ReadDecimal().String()...)
+	case logdb.TObject:
+		fields, values := e.collect(nil, nil, f.ReadObject(), nil)
+		dst = append(dst, '{')
+		dst = e.appendFields(dst, fields, values, false)
+		return append(dst, '}')
+	case logdb.TArray:
+		elem := f.ArrayType()
+		dst = append(dst, '[')
+		if elem == ioutil.TFloat32 || elem == ioutil.TFloat64 {
+			bits := 64
+			if elem == ioutil.TFloat32 {
+				bits = 32
+			}
+			for i, v := range f.ReadFloat64Array(nil) {
+				if i > 0 {
+					dst = append(dst, ',')
+				}
+				dst = appendJSONFloat(dst, v, bits)
+			}
+		} else {
+			unsigned := elem >= ioutil.TUint8 && elem <= ioutil.TUint64
+			for i, v := range f.ReadInt64Array(nil) {
+				if i > 0 {
+					dst = append(dst, ',')
+				}
+				if unsigned {
+					dst = strconv.AppendUint(dst, uint64(v), 10)
+				} else {
+					dst = strconv.AppendInt(dst, v, 10)
+				}
+			}
+		}
+		return append(dst, ']')
+	}
+
+	switch k, _ := kindOf(kind); k {
+	case KindFloat32:
+		return appendJSONFloat(dst, f.ReadFloat(), 32)
+	case KindFloat64:
+		return appendJSONFloat(dst, f.ReadFloat(), 64)
+	case KindUint:
+		return strconv.AppendUint(dst, uint64(f.ReadInt()), 10)
+	case KindInt:
+		return strconv.AppendInt(dst, f.ReadInt(), 10)
+	case KindString:
+		return appendJSONString(dst, string(f.ReadRaw()))
+	case KindBlob:
+		b, _ := json.Marshal(f.ReadRaw())
+		return append(dst, b...)
+	default:
+		return append(dst, "null"...)
+	}
+}
+
+// appendJSONFloat appends a float with the given precision or null, if it is NaN or infinite.
+func appendJSONFloat(dst []byte, v float64, bits int) []byte {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return append(dst, "null"...)
+	}
+	return strconv.AppendFloat(dst, v, 'g', -1, bits)
+}
+
+func appendJSONString(dst []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(dst, b...)
+}
@@ -0,0 +1,328 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+)
+
+// Physical and converted types, encodings and page types of the parquet format, see
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8   = 0
+	parquetUint8  = 11
+	parquetInt8   = 15
+	parquetNoType = -1
+
+	parquetOptional = 1
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetDataPage = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetWriter writes an uncompressed parquet file with a single data page per column chunk. Row groups
+// are encoded by each routine on its own and appended in the order in which they are finished.
+type parquetWriter struct {
+	w            io.Writer
+	columns      []Column
+	rowGroupSize int
+	mutex        sync.Mutex
+	offset       int64
+	rows         int64
+	groups       []parquetRowGroup
+}
+
+// parquetRowGroup is the metadata of a written row group.
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+// parquetChunk is the metadata of a written column chunk.
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+func newParquetWriter(w io.Writer, columns []Column, rowGroupSize int) *parquetWriter {
+	return &parquetWriter{w: w, columns: columns, rowGroupSize: rowGroupSize}
+}
+
+func (p *parquetWriter) begin() error {
+	return p.write(parquetMagic)
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// writeRowGroup encodes the row group and appends it to the file.
+func (p *parquetWriter) writeRowGroup(g *rowGroup) error {
+	group := parquetRowGroup{rows: int64(g.rows), chunks: make([]parquetChunk, len(g.columns))}
+	g.out = g.out[:0]
+	for i, c := range g.columns {
+		start := len(g.out)
+		g.out = c.appendPage(g.out, g.rows)
+		group.chunks[i] = parquetChunk{offset: int64(start), size: int64(len(g.out) - start), values: int64(g.rows)}
+	}
+	group.size = int64(len(g.out))
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i := range group.chunks {
+		group.chunks[i].offset += p.offset
+	}
+
+	if err := p.write(g.out); err != nil {
+		return err
+	}
+
+	p.rows += group.rows
+	p.groups = append(p.groups, group)
+	return nil
+}
+
+// end writes the footer with the file metadata.
+func (p *parquetWriter) end() error {
+	t := &thriftWriter{}
+	t.begin()
+	t.i32(1, 1) // version
+
+	t.list(2, thriftStruct, len(p.columns)+1)
+	t.begin()
+	t.binary(4, []byte("schema"))
+	t.i32(5, int32(len(p.columns)))
+	t.end()
+	for _, c := range p.columns {
+		physical, converted := c.parquetType()
+		t.begin()
+		t.i32(1, physical)
+		t.i32(3, parquetOptional)
+		t.binary(4, []byte(c.Name))
+		if converted != parquetNoType {
+			t.i32(6, converted)
+		}
+		t.end()
+	}
+
+	t.i64(3, p.rows)
+
+	t.list(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.begin()
+		t.list(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			physical, _ := p.columns[i].parquetType()
+			t.begin()
+			t.i64(2, chunk.offset)
+			t.structField(3)
+			t.i32(1, physical)
+			t.list(2, thriftI32, 2)
+			t.zigzag(parquetPlain)
+			t.zigzag(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.bytes([]byte(p.columns[i].Name))
+			t.i32(4, 0) // uncompressed
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.end()
+	}
+
+	t.binary(6, []byte("logdb"))
+	t.end()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(t.buf)))
+	t.buf = append(t.buf, length[:]...)
+	t.buf = append(t.buf, parquetMagic...)
+
+	return p.write(t.buf)
+}
+
+// parquetType returns the physical and converted type of a column.
+func (c Column) parquetType() (int32, int32) {
+	switch c.Kind {
+	case KindInt, KindUint:
+		physical := int32(parquetInt32)
+		if c.Bits > 32 {
+			physical = parquetInt64
+		}
+
+		converted := int32(parquetInt8)
+		if c.Kind == KindUint {
+			converted = parquetUint8
+		}
+
+		// the converted types of the widths 8, 16, 32 and 64 are consecutive
+		for bits := c.Bits; bits > 8; bits /= 2 {
+			converted++
+		}
+
+		return physical, converted
+	case KindFloat32:
+		return parquetFloat, parquetNoType
+	case KindFloat64:
+		return parquetDouble, parquetNoType
+	case KindString:
+		return parquetByteArray, parquetUTF8
+	case KindBlob:
+		return parquetByteArray, parquetNoType
+	default:
+		// parquet has no type for columns without values, so they become integers which are always null
+		return parquetInt32, parquetNoType
+	}
+}
+
+// rowGroup buffers the rows of a single routine.
+type rowGroup struct {
+	columns []*columnBuffer
+	rows    int
+	out     []byte
+}
+
+func newRowGroup(columns []Column) *rowGroup {
+	g := &rowGroup{}
+	for _, c := range columns {
+		g.columns = append(g.columns, &columnBuffer{column: c})
+	}
+
+	return g
+}
+
+func (g *rowGroup) reset() {
+	for _, c := range g.columns {
+		c.defined = c.defined[:0]
+		c.values = c.values[:0]
+		c.nulls = 0
+	}
+	g.rows = 0
+}
+
+// columnBuffer contains the plain encoded values of a column and whether each row has a value.
+type columnBuffer struct {
+	column  Column
+	defined []bool
+	values  []byte
+	nulls   int
+}
+
+// add appends a cell, whose kind has been merged into the column, see Column.widen.
+func (b *columnBuffer) add(c cell) {
+	if !c.set || b.column.Kind == KindNull {
+		b.defined = append(b.defined, false)
+		b.nulls++
+		return
+	}
+
+	b.defined = append(b.defined, true)
+	switch b.column.Kind {
+	case KindInt, KindUint:
+		if b.column.Bits > 32 {
+			b.values = appendUint64(b.values, uint64(c.i))
+		} else {
+			b.values = appendUint32(b.values, uint32(c.i))
+		}
+	case KindFloat32:
+		b.values = appendUint32(b.values, math.Float32bits(float32(c.f)))
+	case KindFloat64:
+		v := c.f
+		switch c.kind {
+		case KindInt:
+			v = float64(c.i)
+		case KindUint:
+			v = float64(uint64(c.i))
+		}
+		b.values = appendUint64(b.values, math.Float64bits(v))
+	default:
+		start := len(b.values)
+		b.values = appendUint32(b.values, 0)
+		if c.kind == KindString || b.column.Kind == KindBlob {
+			b.values = append(b.values, c.b...)
+		} else {
+			b.values = append(b.values, c.String()...)
+		}
+		binary.LittleEndian.PutUint32(b.values[start:], uint32(len(b.values)-start-4))
+	}
+}
+
+// appendPage appends a data page with the definition levels and values of all rows.
+func (b *columnBuffer) appendPage(dst []byte, rows int) []byte {
+	levels := b.appendLevels(nil)
+	size := len(levels) + len(b.values)
+
+	t := &thriftWriter{buf: dst}
+	t.begin()
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.structField(5)
+	t.i32(1, int32(rows))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.end()
+	t.end()
+
+	dst = append(t.buf, levels...)
+	return append(dst, b.values...)
+}
+
+// appendLevels encodes the definition levels with the RLE/bit-packing hybrid and a bit width of 1, prefixed
+// with their length. Columns without nulls become a single run.
+func (b *columnBuffer) appendLevels(dst []byte) []byte {
+	dst = appendUint32(dst, 0)
+	if b.nulls == 0 {
+		dst = appendUvarint(dst, uint64(len(b.defined))<<1)
+		dst = append(dst, 1)
+	} else {
+		groups := (len(b.defined) + 7) / 8
+		dst = appendUvarint(dst, uint64(groups)<<1|1)
+		for i := 0; i < groups; i++ {
+			v := byte(0)
+			for bit := 0; bit < 8 && i*8+bit < len(b.defined); bit++ {
+				if b.defined[i*8+bit] {
+					v |= 1 << bit
+				}
+			}
+			dst = append(dst, v)
+		}
+	}
+
+	binary.LittleEndian.PutUint32(dst, uint32(len(dst)-4))
+	return dst
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return appendUint32(appendUint32(dst, uint32(v)), uint32(v>>32))
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(dst, tmp[:n]...)
+}
@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"strconv"
+)
+
+// Kind is the type of a column in the union schema.
+type Kind uint8
+
+const (
+	// KindNull is the kind of a column without any values.
+	KindNull Kind = iota
+	KindInt
+	KindUint
+	KindFloat32
+	KindFloat64
+	KindString
+	KindBlob
+)
+
+var kindNames = [...]string{"null", "int", "uint", "float32", "float64", "string", "blob"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Column is a name of the union schema with a type which can hold the values of all objects.
+type Column struct {
+	Name string
+	Kind Kind
+	Bits int // width of integers, which is 8, 16, 32 or 64
+}
+
+// kindOf returns the kind and width of a field type. Booleans become unsigned integers, timestamps their
+// nanoseconds and UUIDs and decimals their text. Explicit nulls are missing values, as are complex numbers,
+// embedded objects and arrays, which are not supported by the columnar formats.
+func kindOf(t ioutil.Type) (Kind, int) {
+	switch {
+	case t >= ioutil.TUint8 && t <= ioutil.TUint64:
+		return KindUint, roundBits(int(t-ioutil.TUint8+1) * 8)
+	case t >= ioutil.TInt8 && t <= ioutil.TInt64:
+		return KindInt, roundBits(int(t-ioutil.TInt8+1) * 8)
+	case t == ioutil.TFloat32:
+		return KindFloat32, 0
+	case t == ioutil.TFloat64:
+		return KindFloat64, 0
+	case t >= ioutil.TString8 && t <= ioutil.TString32 || t == logdb.TDictString:
+		return KindString, 0
+	case t >= ioutil.TBlob8 && t <= ioutil.TBlob32:
+		return KindBlob, 0
+	case t == logdb.TBool:
+		return KindUint, 8
+	case t == logdb.TTimestamp:
+		return KindInt, 64
+	case t == logdb.TUUID || t == logdb.TDecimal:
+		return KindString, 0
+	default:
+		return KindNull, 0
+	}
+}
+
+// roundBits rounds odd integer widths like 24 or 40 up to the next width supported by most formats.
+func roundBits(bits int) int {
+	switch {
+	case bits <= 8:
+		return 8
+	case bits <= 16:
+		return 16
+	case bits <= 32:
+		return 32
+	default:
+		return 64
+	}
+}
+
+// widen extends the column, so that it can also hold values of the given field type.
+func (c *Column) widen(t ioutil.Type) {
+	kind, bits := kindOf(t)
+	switch {
+	case kind == KindNull:
+		return
+	case c.Kind == KindNull:
+		c.Kind, c.Bits = kind, bits
+	case c.Kind == kind:
+		if bits > c.Bits {
+			c.Bits = bits
+		}
+	case isInt(c.Kind) && isInt(kind):
+		// a signed integer needs one more bit than the unsigned one
+		signed, unsigned := c.Bits, bits
+		if c.Kind == KindUint {
+			signed, unsigned = bits, c.Bits
+		}
+
+		c.Kind, c.Bits = KindInt, signed
+		if unsigned >= signed {
+			c.Bits = roundBits(unsigned + 1)
+		}
+	case isNumber(c.Kind) && isNumber(kind):
+		c.Kind, c.Bits = KindFloat64, 0
+	default:
+		// strings can hold the text of any number, blobs only blobs
+		c.Kind, c.Bits = KindString, 0
+	}
+}
+
+func isInt(k Kind) bool {
+	return k == KindInt || k == KindUint
+}
+
+func isNumber(k Kind) bool {
+	return isInt(k) || k == KindFloat32 || k == KindFloat64
+}
@@ -0,0 +1,90 @@
+package exporter
+
+// Types of the thrift compact protocol.
+const (
+	thriftBoolTrue = 1
+	thriftI32      = 5
+	thriftI64      = 6
+	thriftBinary   = 8
+	thriftList     = 9
+	thriftStruct   = 12
+)
+
+// thriftWriter encodes structs using the thrift compact protocol, which is used for the parquet metadata.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // id of the last written field of each nested struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	for v >= 0x80 {
+		t.buf = append(t.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	t.buf = append(t.buf, byte(v))
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+// field writes a field header, using the short form for small deltas of the field id.
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, v []byte) {
+	t.field(id, thriftBinary)
+	t.bytes(v)
+}
+
+func (t *thriftWriter) bytes(v []byte) {
+	t.varint(uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
+
+// list writes the header of a list field, whose elements must follow.
+func (t *thriftWriter) list(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+		return
+	}
+
+	t.buf = append(t.buf, 0xf0|elemType)
+	t.varint(uint64(n))
+}
+
+// structField writes the header of a struct field, whose content must follow between begin and end.
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// begin starts a struct, e.g. the top level struct or an element of a list.
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+// end writes the stop field of the current struct.
+func (t *thriftWriter) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
@@ -1,6 +1,11 @@
 package logdb
 
-import "github.com/worldiety/ioutil"
+import (
+	"encoding/binary"
+	"github.com/worldiety/ioutil"
+	"math"
+	"time"
+)
 
 type FieldReader ioutil.TypedLittleEndianBuffer
 
@@ -8,18 +13,78 @@ func (f *FieldReader) ReadBlob(dst []byte) int {
 	return (*ioutil.TypedLittleEndianBuffer)(f).ReadBlob(dst)
 }
 
+// ReadMutableString reads a string or the value of a dictionary string.
 func (f *FieldReader) ReadMutableString(dst []byte) string {
+	if ioutil.Type(f.Bytes[f.Pos]) == TDictString {
+		f.Pos += 5
+	}
+
 	return (*ioutil.TypedLittleEndianBuffer)(f).ReadString(dst)
 }
 
+// ReadDictionaryCode reads a dictionary string as its code without its value, e.g. to group objects by it.
+// The code is stable across all records of the database and maps to the value by DB.DictionaryValue.
+func (f *FieldReader) ReadDictionaryCode() uint32 {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	code := buf.ReadUint32()
+	drain(buf, buf.ReadType())
+	return code
+}
+
+// ReadInt reads any number as an int64. Signed integers with odd widths are sign extended, which ioutil
+// does not do on its own. Booleans are read as 0 or 1, timestamps as nanoseconds and decimals are truncated.
 func (f *FieldReader) ReadInt() int64 {
-	return (*ioutil.TypedLittleEndianBuffer)(f).ReadInt()
+	kind := ioutil.Type(f.Bytes[f.Pos])
+	switch kind {
+	case TBool:
+		if f.ReadBool() {
+			return 1
+		}
+		return 0
+	case TTimestamp:
+		return f.ReadTimestamp().UnixNano()
+	case TDecimal:
+		return int64(f.ReadDecimal().Float64())
+	}
+
+	v := (*ioutil.TypedLittleEndianBuffer)(f).ReadInt()
+	if shift := signShift(kind); shift > 0 {
+		return v << shift >> shift
+	}
+
+	return v
 }
 
+// ReadFloat reads any number as a float64, see also ReadInt.
 func (f *FieldReader) ReadFloat() float64 {
+	switch kind := ioutil.Type(f.Bytes[f.Pos]); {
+	case kind == TDecimal:
+		return f.ReadDecimal().Float64()
+	case kind == TBool || kind == TTimestamp || signShift(kind) > 0:
+		return float64(f.ReadInt())
+	}
+
 	return (*ioutil.TypedLittleEndianBuffer)(f).ReadFloat()
 }
 
+// signShift returns the amount of bits to shift a signed integer with an odd width to the left and back, so
+// that its sign is extended to 64 bit, or 0 for all other types.
+func signShift(kind ioutil.Type) uint {
+	switch kind {
+	case ioutil.TInt24:
+		return 40
+	case ioutil.TInt40:
+		return 24
+	case ioutil.TInt48:
+		return 16
+	case ioutil.TInt56:
+		return 8
+	default:
+		return 0
+	}
+}
+
 func (f *FieldReader) ReadUint8() uint8 {
 	return (*ioutil.TypedLittleEndianBuffer)(f).ReadUint8()
 }
@@ -41,7 +106,7 @@ func (f *FieldReader) ReadUint24() uint32 {
 }
 
 func (f *FieldReader) ReadInt24() int32 {
-	return int32((*ioutil.TypedLittleEndianBuffer)(f).ReadUint24())
+	return int32((*ioutil.TypedLittleEndianBuffer)(f).ReadUint24()<<8) >> 8
 }
 
 func (f *FieldReader) ReadUint32() uint32 {
@@ -83,3 +148,132 @@ func (f *FieldReader) ReadFloat32() float32 {
 func (f *FieldReader) ReadFloat64() float64 {
 	return (*ioutil.TypedLittleEndianBuffer)(f).ReadFloat64()
 }
+
+func (f *FieldReader) ReadBool() bool {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	return buf.ReadUint8() != 0
+}
+
+// ReadTimestamp reads a timestamp as time in UTC, see TTimestamp.
+func (f *FieldReader) ReadTimestamp() time.Time {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	return time.Unix(0, int64(buf.ReadUint64())).UTC()
+}
+
+func (f *FieldReader) ReadUUID() UUID {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	var v UUID
+	buf.Pos += copy(v[:], buf.Bytes[buf.Pos:buf.Pos+len(v)])
+	return v
+}
+
+func (f *FieldReader) ReadDecimal() Decimal {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	v := Decimal{Unscaled: int64(buf.ReadUint64())}
+	v.Scale = buf.ReadUint8()
+	return v
+}
+
+// ReadRaw reads a string, a blob or the value of a dictionary string and returns its bytes without copying.
+// The slice is only valid as long as the object is not modified or reused, e.g. within a WithFields callback.
+func (f *FieldReader) ReadRaw() []byte {
+	return rawBytes((*ioutil.LittleEndianBuffer)(f))
+}
+
+// ReadObject reads an embedded object, whose fields are iterated by its WithFields. It shares the buffer of
+// the outer object, so it is only valid as long as that is, e.g. within a WithFields callback.
+func (f *FieldReader) ReadObject() *Object {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	start := buf.Pos
+	drain(buf, TObject)
+
+	obj := &Object{buf: &ioutil.LittleEndianBuffer{Bytes: buf.Bytes[start:buf.Pos]}}
+	obj.reverseFlush()
+	return obj
+}
+
+// readArray reads an array and returns the type of its elements, their amount and their encoded bytes.
+func (f *FieldReader) readArray() (ioutil.Type, int, []byte) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.Pos++
+	elem := buf.ReadType()
+	n := int(buf.ReadUint32())
+	b := buf.Bytes[buf.Pos : buf.Pos+n*elementSize(elem)]
+	buf.Pos += len(b)
+	return elem, n, b
+}
+
+// ArrayLen returns the amount of elements of the array without reading it.
+func (f *FieldReader) ArrayLen() int {
+	return int(binary.LittleEndian.Uint32(f.Bytes[f.Pos+2:]))
+}
+
+// ArrayType returns the type of the elements of the array without reading it.
+func (f *FieldReader) ArrayType() ioutil.Type {
+	return ioutil.Type(f.Bytes[f.Pos+1])
+}
+
+// ReadFloat32Array appends the elements of an array to dst and returns it. Elements of other types are
+// converted, like by all array accessors.
+func (f *FieldReader) ReadFloat32Array(dst []float32) []float32 {
+	elem, n, b := f.readArray()
+	size := elementSize(elem)
+	for i := 0; i < n; i++ {
+		if elem == ioutil.TFloat32 {
+			dst = append(dst, math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:])))
+		} else {
+			dst = append(dst, float32(elementFloat(elem, b[i*size:(i+1)*size])))
+		}
+	}
+	return dst
+}
+
+func (f *FieldReader) ReadFloat64Array(dst []float64) []float64 {
+	elem, n, b := f.readArray()
+	size := elementSize(elem)
+	for i := 0; i < n; i++ {
+		dst = append(dst, elementFloat(elem, b[i*size:(i+1)*size]))
+	}
+	return dst
+}
+
+func (f *FieldReader) ReadInt32Array(dst []int32) []int32 {
+	elem, n, b := f.readArray()
+	size := elementSize(elem)
+	for i := 0; i < n; i++ {
+		dst = append(dst, int32(elementInt(elem, b[i*size:(i+1)*size])))
+	}
+	return dst
+}
+
+func (f *FieldReader) ReadInt64Array(dst []int64) []int64 {
+	elem, n, b := f.readArray()
+	size := elementSize(elem)
+	for i := 0; i < n; i++ {
+		dst = append(dst, elementInt(elem, b[i*size:(i+1)*size]))
+	}
+	return dst
+}
+
+func (f *FieldReader) ReadUint32Array(dst []uint32) []uint32 {
+	elem, n, b := f.readArray()
+	size := elementSize(elem)
+	for i := 0; i < n; i++ {
+		dst = append(dst, uint32(elementInt(elem, b[i*size:(i+1)*size])))
+	}
+	return dst
+}
+
+func (f *FieldReader) ReadUint64Array(dst []uint64) []uint64 {
+	elem, n, b := f.readArray()
+	size := elementSize(elem)
+	for i := 0; i < n; i++ {
+		dst = append(dst, uint64(elementInt(elem, b[i*size:(i+1)*size])))
+	}
+	return dst
+}
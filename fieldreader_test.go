@@ -0,0 +1,49 @@
+package logdb
+
+import (
+	"github.com/worldiety/ioutil"
+	"testing"
+)
+
+func TestReadIntSignExtension(t *testing.T) {
+	values := []int64{-1, -70000, -1 << 30, -1 << 38, -1 << 46, -1 << 54, 1 << 22, 1<<54 - 1}
+	obj := newObject(1024)
+	for i, v := range values {
+		obj.AddInt(uint16(i), v)
+	}
+	obj.flush()
+
+	var kinds []ioutil.Type
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+		kinds = append(kinds, kind)
+		pos := f.Pos
+		if v := f.ReadInt(); v != values[name] {
+			t.Fatalf("expected %d but got %d for type %d", values[name], v, kind)
+		}
+
+		f.Pos = pos
+		if v := f.ReadFloat(); v != float64(values[name]) {
+			t.Fatalf("expected %d but got %f for type %d", values[name], v, kind)
+		}
+	})
+
+	if kinds[1] != ioutil.TInt24 || kinds[3] != ioutil.TInt40 || kinds[5] != ioutil.TInt56 {
+		t.Fatalf("unexpected types %v", kinds)
+	}
+}
+
+func TestReadInt24SignExtension(t *testing.T) {
+	for _, v := range []int32{-1, -70000, 1<<23 - 1, -1 << 23} {
+		obj := newObject(64)
+		obj.AddField(0, func(f *FieldWriter) {
+			(*ioutil.TypedLittleEndianBuffer)(f).WriteInt24(v)
+		})
+		obj.flush()
+
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			if got := f.ReadInt24(); got != v {
+				t.Fatalf("expected %d but got %d", v, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package logdb
 
-import "github.com/worldiety/ioutil"
+import (
+	"github.com/worldiety/ioutil"
+	"time"
+)
 
 type FieldWriter ioutil.TypedLittleEndianBuffer
 
@@ -63,3 +66,130 @@ func (f *FieldWriter) WriteBlob24(v []byte) {
 func (f *FieldWriter) WriteBlob32(v []byte) {
 	(*ioutil.TypedLittleEndianBuffer)(f).WriteBlob32(v)
 }
+
+// WriteNull writes an explicit null, see TNull.
+func (f *FieldWriter) WriteNull() {
+	(*ioutil.LittleEndianBuffer)(f).WriteType(TNull)
+}
+
+func (f *FieldWriter) WriteBool(v bool) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TBool)
+	if v {
+		buf.WriteUint8(1)
+	} else {
+		buf.WriteUint8(0)
+	}
+}
+
+// WriteTimestamp writes the point in time with nanosecond precision, see TTimestamp.
+func (f *FieldWriter) WriteTimestamp(t time.Time) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TTimestamp)
+	buf.WriteUint64(uint64(t.UnixNano()))
+}
+
+func (f *FieldWriter) WriteUUID(v UUID) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TUUID)
+	buf.WriteSlice(v[:])
+}
+
+func (f *FieldWriter) WriteDecimal(v Decimal) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TDecimal)
+	buf.WriteUint64(uint64(v.Unscaled))
+	buf.WriteUint8(v.Scale)
+}
+
+// WriteDictString writes a string, which is stored as code of the dictionary of the database, if it is the
+// value of a top level field, see Object.AddDictString.
+func (f *FieldWriter) WriteDictString(v string) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TDictString)
+	buf.WriteUint32(NoDictionaryCode)
+	(*ioutil.TypedLittleEndianBuffer)(f).WriteString(v)
+}
+
+// BeginObject starts an embedded object, whose fields are written by WriteName followed by a value, and
+// returns its start, which must be passed to EndObject. Embedded objects may be nested.
+func (f *FieldWriter) BeginObject() int {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TObject)
+	start := buf.Pos
+	buf.WriteUint24(0)
+	buf.WriteUint16(0)
+	return start
+}
+
+// WriteName starts a field of an embedded object.
+func (f *FieldWriter) WriteName(name uint16) {
+	(*ioutil.LittleEndianBuffer)(f).WriteUint16(name)
+}
+
+// EndObject completes the embedded object which has been started at start, see BeginObject.
+func (f *FieldWriter) EndObject(start int) {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	end := buf.Pos
+	count := 0
+	for buf.Pos = start + offsetFieldList; buf.Pos < end; count++ {
+		buf.Pos += 2
+		drain(buf, buf.ReadType())
+	}
+
+	buf.Pos = start
+	buf.WriteUint24(uint32(end - start))
+	buf.WriteUint16(uint16(count))
+	buf.Pos = end
+}
+
+// beginArray writes the header of an array with n elements of the given type.
+func (f *FieldWriter) beginArray(elem ioutil.Type, n int) *ioutil.LittleEndianBuffer {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	buf.WriteType(TArray)
+	buf.WriteType(elem)
+	buf.WriteUint32(uint32(n))
+	return buf
+}
+
+func (f *FieldWriter) WriteFloat32Array(v []float32) {
+	buf := f.beginArray(ioutil.TFloat32, len(v))
+	for _, x := range v {
+		buf.WriteFloat32(x)
+	}
+}
+
+func (f *FieldWriter) WriteFloat64Array(v []float64) {
+	buf := f.beginArray(ioutil.TFloat64, len(v))
+	for _, x := range v {
+		buf.WriteFloat64(x)
+	}
+}
+
+func (f *FieldWriter) WriteInt32Array(v []int32) {
+	buf := f.beginArray(ioutil.TInt32, len(v))
+	for _, x := range v {
+		buf.WriteUint32(uint32(x))
+	}
+}
+
+func (f *FieldWriter) WriteInt64Array(v []int64) {
+	buf := f.beginArray(ioutil.TInt64, len(v))
+	for _, x := range v {
+		buf.WriteUint64(uint64(x))
+	}
+}
+
+func (f *FieldWriter) WriteUint32Array(v []uint32) {
+	buf := f.beginArray(ioutil.TUint32, len(v))
+	for _, x := range v {
+		buf.WriteUint32(x)
+	}
+}
+
+func (f *FieldWriter) WriteUint64Array(v []uint64) {
+	buf := f.beginArray(ioutil.TUint64, len(v))
+	for _, x := range v {
+		buf.WriteUint64(x)
+	}
+}
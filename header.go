@@ -1,6 +1,7 @@
 package logdb
 
 import (
+	"crypto/cipher"
 	"fmt"
 	"github.com/worldiety/ioutil"
 	"sync"
@@ -11,27 +12,54 @@ var headerMagic = [8]byte{'w', 'd', 'y', 'l', 'o', 'g', 'd', 'b'}
 
 const headerVersion = 1
 
+// encryptedHeaderVersion is the version of headers of encrypted databases, which contain the key id, the
+// encrypted data key and the encrypted names instead of the plain names:
+//
+//   - key id              string
+//   - data key length     uint32
+//   - data key            variable, nonce, encrypted key and tag
+//   - names length        uint32
+//   - names               variable, nonce, encrypted names and tag
+const encryptedHeaderVersion = 2
+
+// dictionaryHeaderVersion and encryptedDictionaryHeaderVersion are the versions of headers with a
+// dictionary, see TDictString, which is appended to the layout of version 1 or 2 respectively:
+//
+//   - dictionary count    uint64
+//   - dictionary          the strings like the names, or, if encrypted, their length as uint32 followed by
+//     the nonce, the encrypted strings and the tag
+const (
+	dictionaryHeaderVersion          = 3
+	encryptedDictionaryHeaderVersion = 4
+)
+
 // Header marks the beginning of the database and provides space to organize names and indices.
 type Header struct {
 	buf             *ioutil.LittleEndianBuffer
-	magic           [8]byte        // wdylogdb
-	version         uint32         // currently only 1
-	headerSize      uint32         // the total reserved size of the header. This determines the maximum amount of the string table size
-	objCount        uint64         // the amount of objects
-	txCount         uint64         // the amount of transactions
-	nameCount       uint64         // amount of names
-	lookup          map[string]int // reverse lookup from string to name index
-	names           []string       // lookup index to string
+	magic           [8]byte           // wdylogdb
+	version         uint32            // 1 or 2, if encrypted, which Flush increases for a dictionary
+	headerSize      uint32            // the total reserved size of the header. This determines the maximum amount of the string table size
+	objCount        uint64            // the amount of objects
+	txCount         uint64            // the amount of transactions
+	nameCount       uint64            // amount of names
+	lookup          map[string]int    // reverse lookup from string to name index
+	names           []string          // lookup index to string
+	keyID           string            // id of the key which encrypts the data key, empty if not encrypted
+	wrappedKey      []byte            // the encrypted data key
+	sealedNames     []byte            // the encrypted names, until they are decrypted by openNames
+	aead            cipher.AEAD       // encrypts the names with the data key, nil if not encrypted
+	dictionary      []string          // the values of dictionary strings by their code
+	codes           map[string]uint32 // reverse lookup from value to code
+	sealedDict      []byte            // the encrypted dictionary, until it is decrypted by openNames
 	actualUsedBytes int
+	size            int // the reserved size of the header, the buffer is only allocated if required
 	mutex           sync.RWMutex
 }
 
 func newHeader(size int) *Header {
 	h := &Header{
-		buf: &ioutil.LittleEndianBuffer{
-			Bytes: make([]byte, size),
-			Pos:   0,
-		},
+		buf:             &ioutil.LittleEndianBuffer{},
+		size:            size,
 		magic:           headerMagic,
 		version:         headerVersion,
 		objCount:        0,
@@ -40,11 +68,39 @@ func newHeader(size int) *Header {
 		headerSize:      0,
 		lookup:          make(map[string]int),
 		names:           nil,
+		codes:           make(map[string]uint32),
 		actualUsedBytes: 8 + 4 + 4 + 8 + 8 + 8,
 	}
 	return h
 }
 
+// buffer returns the buffer with the given size for serialization and allocates it, if required.
+func (h *Header) buffer(size int) *ioutil.LittleEndianBuffer {
+	if len(h.buf.Bytes) != size {
+		h.buf.Bytes = make([]byte, size)
+	}
+
+	return h.buf
+}
+
+// release frees the buffer, which would otherwise occupy the entire reserved size per opened database.
+func (h *Header) release() {
+	h.buf.Bytes = nil
+}
+
+// usedBytes returns the serialized size of the header, which grows with each new name and dictionary value.
+func (h *Header) usedBytes() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.actualUsedBytes
+}
+
+// Size returns the reserved size of the header, which is also the offset of the first record.
+func (h *Header) Size() int {
+	return h.size
+}
+
 func (h *Header) ObjectCount() uint64 {
 	return atomic.LoadUint64(&h.objCount)
 }
@@ -97,19 +153,9 @@ func (h *Header) AddName(name string) int {
 	idx, ok := h.lookup[name]
 	if !ok {
 
-		requiredSize := len(name) + 1 // + type
-		if len(name) > int(ioutil.MaxUint8) {
-			requiredSize += 1
-		} else if len(name) > int(ioutil.MaxUint16) {
-			requiredSize += 2
-		} else if len(name) > int(ioutil.MaxUint24) {
-			requiredSize += 3
-		} else {
-			requiredSize += 4
-		}
-
-		if h.actualUsedBytes+requiredSize > len(h.buf.Bytes) {
-			panic(fmt.Sprintf("header overflow: has %d, needs another %d which exceeds %d", h.actualUsedBytes, requiredSize, len(h.buf.Bytes)))
+		requiredSize := nameSize(name)
+		if h.actualUsedBytes+requiredSize > h.size {
+			panic(fmt.Sprintf("header overflow: has %d, needs another %d which exceeds %d", h.actualUsedBytes, requiredSize, h.size))
 		}
 
 		h.actualUsedBytes += requiredSize
@@ -124,6 +170,105 @@ func (h *Header) AddName(name string) int {
 	return idx
 }
 
+// DictionaryCode returns the code of a dictionary string and adds the value to the dictionary, if required.
+// The dictionary only grows while the header is less than half full, so that names can still be added. It
+// returns false, if the value does not fit.
+func (h *Header) DictionaryCode(value []byte) (uint32, bool) {
+	h.mutex.RLock()
+	code, ok := h.codes[string(value)]
+	h.mutex.RUnlock()
+	if ok {
+		return code, true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if code, ok := h.codes[string(value)]; ok {
+		return code, true
+	}
+
+	if h.actualUsedBytes+h.dictionarySize(string(value)) > h.size/2 {
+		return 0, false
+	}
+
+	return h.addDictionaryValue(string(value)), true
+}
+
+// AddDictionaryValue returns the code of the value and adds it to the dictionary, if required. Unlike
+// DictionaryCode, it fills the entire header and panics, if the header overflows, like AddName.
+func (h *Header) AddDictionaryValue(value string) uint32 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if code, ok := h.codes[value]; ok {
+		return code
+	}
+
+	if requiredSize := h.dictionarySize(value); h.actualUsedBytes+requiredSize > h.size {
+		panic(fmt.Sprintf("header overflow: has %d, needs another %d which exceeds %d", h.actualUsedBytes, requiredSize, h.size))
+	}
+
+	return h.addDictionaryValue(value)
+}
+
+// dictionarySize returns the amount of header bytes which another dictionary value needs.
+func (h *Header) dictionarySize(value string) int {
+	size := nameSize(value)
+	if len(h.dictionary) == 0 {
+		// the count and, if encrypted, the length, nonce and tag of the sealed dictionary
+		size += 8
+		if h.aead != nil {
+			size += 4 + sealOverhead(h.aead)
+		}
+	}
+
+	return size
+}
+
+// addDictionaryValue adds a new value to the dictionary, while the lock is held.
+func (h *Header) addDictionaryValue(value string) uint32 {
+	h.actualUsedBytes += h.dictionarySize(value)
+	code := uint32(len(h.dictionary))
+	h.dictionary = append(h.dictionary, value)
+	h.codes[value] = code
+	return code
+}
+
+// DictionaryValue returns the value of a dictionary code and false, if the code is unknown.
+func (h *Header) DictionaryValue(code uint32) (string, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if int64(code) >= int64(len(h.dictionary)) {
+		return "", false
+	}
+
+	return h.dictionary[code], true
+}
+
+// Dictionary returns a copy of the dictionary, whose indexes are the codes.
+func (h *Header) Dictionary() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return append([]string(nil), h.dictionary...)
+}
+
+// nameSize returns the serialized size of a name, which is the type, the length prefix and the string.
+func nameSize(name string) int {
+	switch {
+	case len(name) <= int(ioutil.MaxUint8):
+		return 1 + 1 + len(name)
+	case len(name) <= int(ioutil.MaxUint16):
+		return 1 + 2 + len(name)
+	case len(name) <= int(ioutil.MaxUint24):
+		return 1 + 3 + len(name)
+	default:
+		return 1 + 4 + len(name)
+	}
+}
+
 func (h *Header) reverseFlush() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
@@ -136,6 +281,11 @@ func (h *Header) reverseFlush() {
 	h.txCount = h.buf.ReadUint64()
 	h.nameCount = h.buf.ReadUint64()
 
+	dictionary := h.version >= dictionaryHeaderVersion
+	if dictionary {
+		h.version -= dictionaryHeaderVersion - headerVersion
+	}
+
 	// this is actually an optimized clear-map, see https://github.com/golang/go/issues/20138
 	for k := range h.lookup {
 		delete(h.lookup, k)
@@ -149,29 +299,165 @@ func (h *Header) reverseFlush() {
 		h.names[i] = ""
 	}
 
-	for i := 0; i < int(h.nameCount); i++ {
-		name := (*ioutil.TypedLittleEndianBuffer)(h.buf).ReadString(nil)
+	if h.version == encryptedHeaderVersion {
+		h.keyID = (*ioutil.TypedLittleEndianBuffer)(h.buf).ReadString(nil)
+		h.wrappedKey = h.readBytes()
+		h.sealedNames = h.readBytes()
+	} else {
+		for i := 0; i < int(h.nameCount); i++ {
+			name := (*ioutil.TypedLittleEndianBuffer)(h.buf).ReadString(nil)
+			h.names[i] = name
+			h.lookup[name] = i
+		}
+	}
+
+	for k := range h.codes {
+		delete(h.codes, k)
+	}
+	h.dictionary = h.dictionary[:0]
+
+	if dictionary {
+		h.dictionary = make([]string, h.buf.ReadUint64())
+		if h.version == encryptedHeaderVersion {
+			h.sealedDict = h.readBytes()
+		} else {
+			for i := range h.dictionary {
+				value := (*ioutil.TypedLittleEndianBuffer)(h.buf).ReadString(nil)
+				h.dictionary[i] = value
+				h.codes[value] = uint32(i)
+			}
+		}
+	}
+
+	h.actualUsedBytes = h.buf.Pos
+}
+
+// readBytes reads a length prefixed byte slice, which is empty if the length exceeds the buffer.
+func (h *Header) readBytes() []byte {
+	n := int(h.buf.ReadUint32())
+	if n > len(h.buf.Bytes)-h.buf.Pos {
+		return nil
+	}
+
+	b := make([]byte, n)
+	h.buf.ReadSlice(b)
+	return b
+}
+
+// setEncryption encrypts the names of a new header with the data key from now on.
+func (h *Header) setEncryption(keyID string, wrappedKey []byte, aead cipher.AEAD) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.version = encryptedHeaderVersion
+	h.keyID = keyID
+	h.wrappedKey = wrappedKey
+	h.aead = aead
+	h.actualUsedBytes += nameSize(keyID) + 4 + len(wrappedKey) + 4 + sealOverhead(aead)
+}
+
+// openNames decrypts the names and the dictionary of an encrypted header, which has been read by reverseFlush.
+func (h *Header) openNames(aead cipher.AEAD) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	names, err := unsealNames(aead, h.sealedNames)
+	if err != nil {
+		return err
+	}
+
+	if len(names) != int(h.nameCount) {
+		return fmt.Errorf("expected %d names but got %d", h.nameCount, len(names))
+	}
+
+	for i, name := range names {
 		h.names[i] = name
 		h.lookup[name] = i
 	}
 
-	h.actualUsedBytes = h.buf.Pos
+	if len(h.dictionary) > 0 {
+		values, err := unsealNames(aead, h.sealedDict)
+		if err != nil {
+			return fmt.Errorf("dictionary: %w", err)
+		}
+
+		if len(values) != len(h.dictionary) {
+			return fmt.Errorf("expected %d dictionary values but got %d", len(h.dictionary), len(values))
+		}
+
+		for i, value := range values {
+			h.dictionary[i] = value
+			h.codes[value] = uint32(i)
+		}
+	}
+
+	h.aead = aead
+	h.sealedNames = nil
+	h.sealedDict = nil
+	return nil
 }
 
 func (h *Header) Flush() {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
+	size := 8 + 4 + 4 + 8 + 8 + 8
+	var sealedNames []byte
+	if h.aead != nil {
+		sealedNames = sealNames(h.aead, h.names)
+		size += nameSize(h.keyID) + 4 + len(h.wrappedKey) + 4 + len(sealedNames)
+	} else {
+		for _, name := range h.names {
+			size += nameSize(name)
+		}
+	}
+
+	version := h.version
+	var sealedDict []byte
+	if len(h.dictionary) > 0 {
+		version += dictionaryHeaderVersion - headerVersion
+		size += 8
+		if h.aead != nil {
+			sealedDict = sealNames(h.aead, h.dictionary)
+			size += 4 + len(sealedDict)
+		} else {
+			for _, value := range h.dictionary {
+				size += nameSize(value)
+			}
+		}
+	}
+
+	h.buffer(size)
 	h.buf.Pos = 0
 	h.buf.WriteSlice(h.magic[:])
-	h.buf.WriteUint32(h.version)
+	h.buf.WriteUint32(version)
 	h.buf.WriteUint32(h.headerSize)
 	h.buf.WriteUint64(h.objCount)
 	h.buf.WriteUint64(h.txCount)
 	h.buf.WriteUint64(h.nameCount)
 
-	for _, name := range h.names {
-		(*ioutil.TypedLittleEndianBuffer)(h.buf).WriteString(name)
+	if h.aead != nil {
+		(*ioutil.TypedLittleEndianBuffer)(h.buf).WriteString(h.keyID)
+		h.buf.WriteUint32(uint32(len(h.wrappedKey)))
+		h.buf.WriteSlice(h.wrappedKey)
+		h.buf.WriteUint32(uint32(len(sealedNames)))
+		h.buf.WriteSlice(sealedNames)
+	} else {
+		for _, name := range h.names {
+			(*ioutil.TypedLittleEndianBuffer)(h.buf).WriteString(name)
+		}
+	}
+
+	if len(h.dictionary) > 0 {
+		h.buf.WriteUint64(uint64(len(h.dictionary)))
+		if h.aead != nil {
+			h.buf.WriteUint32(uint32(len(sealedDict)))
+			h.buf.WriteSlice(sealedDict)
+		} else {
+			for _, value := range h.dictionary {
+				(*ioutil.TypedLittleEndianBuffer)(h.buf).WriteString(value)
+			}
+		}
 	}
 
 	h.actualUsedBytes = h.buf.Pos
@@ -0,0 +1,434 @@
+// Package importer streams CSV and newline delimited JSON into a database. Columns and keys become names of
+// the database and each value is stored with the narrowest field type, like the semantic compression
+// described in the README, unless a schema declares otherwise.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is the encoding of the input.
+type Format uint8
+
+const (
+	// CSV expects a header line with the names, followed by one object per line.
+	CSV Format = iota
+
+	// NDJSON expects one JSON object per line. Nested objects are flattened into dotted names and arrays
+	// of scalars become repeated fields with the same name.
+	NDJSON
+)
+
+// ParseFormat returns the format for its name, which is either csv or ndjson.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return CSV, nil
+	case "ndjson", "jsonl", "json":
+		return NDJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown format %s", name)
+	}
+}
+
+// Type declares how the values of a name are stored.
+type Type uint8
+
+const (
+	// Auto infers the narrowest type per value: integers, floats, which are stored as integers if they
+	// have no fraction, and strings otherwise. Bools become the integers 0 and 1.
+	Auto Type = iota
+	Int
+	Float
+	String
+	// Skip ignores the name.
+	Skip
+)
+
+// ParseType returns the type for its name, which is one of auto, int, float, string or skip.
+func ParseType(name string) (Type, error) {
+	for i, n := range typeNames {
+		if strings.EqualFold(n, name) {
+			return Type(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown type %s", name)
+}
+
+var typeNames = [...]string{"auto", "int", "float", "string", "skip"}
+
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return "type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// defaultProgressInterval is the default amount of objects between progress reports.
+const defaultProgressInterval = 100_000
+
+// maxObjectSize is the size limit of a single object in the database, see Object.
+const maxObjectSize = 64 * 1024
+
+// Options configure an import.
+type Options struct {
+	Format Format
+
+	// Schema declares the type of names, all other names are inferred.
+	Schema map[string]Type
+
+	// Comma is the field delimiter of CSV and defaults to ','.
+	Comma rune
+
+	// NullValues are the texts which denote a missing value in CSV, e.g. NULL or NA. Null values are not
+	// added to the object at all. An empty value is always null, unless KeepEmpty is set.
+	NullValues []string
+
+	// KeepEmpty stores empty values as empty strings instead of treating them as null.
+	KeepEmpty bool
+
+	// Progress is called with the amount of imported objects after each ProgressInterval objects and at
+	// the end.
+	Progress func(objects uint64)
+
+	// ProgressInterval defaults to 100,000 objects.
+	ProgressInterval int
+}
+
+// field is a single parsed value of an object.
+type field struct {
+	name uint16
+	kind Type // the resolved type, either Int, Float or String
+	i    int64
+	u    uint64 // for integers which do not fit into an int64
+	big  bool
+	f    float64
+	s    string
+}
+
+// importer converts rows into objects.
+type importer struct {
+	db       *logdb.DB
+	opts     Options
+	names    map[string]uint16
+	fields   []field
+	count    uint64
+	line     int // the current line of the input, counted from 1
+	nulls    map[string]bool
+	interval uint64
+}
+
+// Import reads all objects from the reader, adds them to the database and flushes it. It returns the amount
+// of imported objects, which is also valid if an error occurred.
+func Import(db *logdb.DB, r io.Reader, opts Options) (uint64, error) {
+	imp := &importer{db: db, opts: opts, names: make(map[string]uint16), nulls: make(map[string]bool)}
+	for _, v := range opts.NullValues {
+		imp.nulls[v] = true
+	}
+
+	imp.interval = uint64(opts.ProgressInterval)
+	if imp.interval == 0 {
+		imp.interval = defaultProgressInterval
+	}
+
+	var err error
+	switch opts.Format {
+	case CSV:
+		err = imp.readCSV(r)
+	case NDJSON:
+		err = imp.readNDJSON(r)
+	default:
+		err = fmt.Errorf("unsupported format %d", opts.Format)
+	}
+
+	if err != nil {
+		return imp.count, fmt.Errorf("line %d: %w", imp.line, err)
+	}
+
+	if err := db.Flush(); err != nil {
+		return imp.count, err
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(imp.count)
+	}
+
+	return imp.count, nil
+}
+
+// name returns the database index of a name or false, if it is skipped.
+func (imp *importer) name(name string) (uint16, Type, bool) {
+	t := imp.opts.Schema[name]
+	if t == Skip {
+		return 0, t, false
+	}
+
+	idx, ok := imp.names[name]
+	if !ok {
+		idx = imp.db.PutName(name)
+		imp.names[name] = idx
+	}
+
+	return idx, t, true
+}
+
+// addText parses a textual value according to the type.
+func (imp *importer) addText(name string, text string) error {
+	idx, t, ok := imp.name(name)
+	if !ok {
+		return nil
+	}
+
+	if imp.nulls[text] || text == "" && !imp.opts.KeepEmpty {
+		return nil
+	}
+
+	f := field{name: idx, kind: t}
+	switch t {
+	case Auto:
+		f.kind = String
+		f.s = text
+		if c := text[0]; c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.' {
+			if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+				f.kind, f.i = Int, v
+			} else if v, err := strconv.ParseUint(text, 10, 64); err == nil {
+				f.kind, f.u, f.big = Int, v, true
+			} else if v, err := strconv.ParseFloat(text, 64); err == nil {
+				f.kind, f.f = Float, v
+			}
+		} else if b, err := strconv.ParseBool(text); err == nil && len(text) > 1 {
+			f.kind = Int
+			if b {
+				f.i = 1
+			}
+		}
+	case Int:
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			u, uerr := strconv.ParseUint(text, 10, 64)
+			if uerr != nil {
+				return fmt.Errorf("%s: invalid integer %q", name, text)
+			}
+			f.u, f.big = u, true
+		}
+		f.i = v
+	case Float:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid float %q", name, text)
+		}
+		f.f = v
+	case String:
+		f.s = text
+	}
+
+	imp.fields = append(imp.fields, f)
+	return nil
+}
+
+// add writes the collected fields as a new object.
+func (imp *importer) add() error {
+	size := 5
+	for _, f := range imp.fields {
+		size += 2 + 1 + 8 + len(f.s) + 4
+	}
+
+	if size > maxObjectSize {
+		return fmt.Errorf("object with %d fields exceeds the maximum object size", len(imp.fields))
+	}
+
+	err := imp.db.Add(func(obj *logdb.Object) error {
+		for i := range imp.fields {
+			f := &imp.fields[i]
+			switch f.kind {
+			case Int:
+				if f.big {
+					obj.AddField(f.name, func(w *logdb.FieldWriter) {
+						w.WriteUint64(f.u)
+					})
+				} else {
+					obj.AddInt(f.name, f.i)
+				}
+			case Float:
+				obj.AddFloat(f.name, f.f)
+			default:
+				obj.AddString(f.name, f.s)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	imp.fields = imp.fields[:0]
+	imp.count++
+	if imp.opts.Progress != nil && imp.count%imp.interval == 0 {
+		imp.opts.Progress(imp.count)
+	}
+
+	return nil
+}
+
+func (imp *importer) readCSV(r io.Reader) error {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+	if imp.opts.Comma != 0 {
+		reader.Comma = imp.opts.Comma
+	}
+
+	imp.line = 1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names := append([]string(nil), header...)
+	for {
+		imp.line++
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(row) > len(names) {
+			return fmt.Errorf("expected at most %d values but found %d", len(names), len(row))
+		}
+
+		for i, text := range row {
+			if err := imp.addText(names[i], text); err != nil {
+				return err
+			}
+		}
+
+		if err := imp.add(); err != nil {
+			return err
+		}
+	}
+}
+
+func (imp *importer) readNDJSON(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		imp.line++
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if t != json.Delim('{') {
+			return fmt.Errorf("expected an object")
+		}
+
+		if err := imp.readObject(dec, ""); err != nil {
+			return err
+		}
+
+		if err := imp.add(); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readObject reads the members of an object, whose opening brace has already been consumed.
+func (imp *importer) readObject(dec *json.Decoder, prefix string) error {
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected a key")
+		}
+
+		if err := imp.readValue(dec, prefix+key, true); err != nil {
+			return err
+		}
+	}
+
+	_, err := dec.Token() // closing brace
+	return err
+}
+
+// readValue reads a scalar, a nested object or an array. Arrays are only allowed at the top level or
+// within objects, but not within other arrays.
+func (imp *importer) readValue(dec *json.Decoder, name string, arrays bool) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch v := t.(type) {
+	case json.Delim:
+		switch {
+		case v == '{':
+			return imp.readObject(dec, name+".")
+		case v == '[' && arrays:
+			for dec.More() {
+				if err := imp.readValue(dec, name, false); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token() // closing bracket
+			return err
+		default:
+			return fmt.Errorf("%s: nested arrays are not supported", name)
+		}
+	case nil:
+		return nil
+	case bool:
+		if v {
+			return imp.addText(name, "1")
+		}
+		return imp.addText(name, "0")
+	case json.Number:
+		return imp.addText(name, v.String())
+	case string:
+		idx, t, ok := imp.name(name)
+		if !ok {
+			return nil
+		}
+
+		if t == Auto || t == String {
+			// json strings are never inferred as numbers
+			if v == "" && !imp.opts.KeepEmpty {
+				return nil
+			}
+			imp.fields = append(imp.fields, field{name: idx, kind: String, s: v})
+			return nil
+		}
+
+		return imp.addText(name, v)
+	default:
+		return fmt.Errorf("%s: unexpected token %v", name, t)
+	}
+}
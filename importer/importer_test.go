@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"strings"
+	"testing"
+)
+
+// describe formats all fields of all objects as name:type=value.
+func describe(t *testing.T, db *logdb.DB) []string {
+	var objects []string
+	err := db.ForEach(func(id uint64, obj *logdb.Object) error {
+		var fields []string
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+			var v interface{}
+			switch {
+			case kind == ioutil.TFloat32 || kind == ioutil.TFloat64:
+				v = f.ReadFloat()
+			case kind.IsNumber():
+				v = f.ReadInt()
+			default:
+				v = string(f.ReadRaw())
+			}
+			fields = append(fields, fmt.Sprintf("%s:%d=%v", db.NameByIndex(int(name)), kind, v))
+		})
+		objects = append(objects, strings.Join(fields, " "))
+		return nil
+	})
+	logdbtest.AssertNil(t, err)
+
+	return objects
+}
+
+func TestImportCSV(t *testing.T) {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	input := "Id;Temperature;Name;Zip;Ignored\n" +
+		"1;21.5;first;01234;x\n" +
+		"300;NULL;;00042;x\n" +
+		"-70000;22;\"semi;colon\";1\n"
+
+	var progress []uint64
+	n, err := Import(db, strings.NewReader(input), Options{
+		Format:           CSV,
+		Comma:            ';',
+		NullValues:       []string{"NULL"},
+		Schema:           map[string]Type{"Zip": String, "Ignored": Skip},
+		ProgressInterval: 2,
+		Progress: func(objects uint64) {
+			progress = append(progress, objects)
+		},
+	})
+	logdbtest.AssertNil(t, err)
+
+	if n != 3 || fmt.Sprint(progress) != "[2 3]" {
+		t.Fatalf("unexpected count %d and progress %v", n, progress)
+	}
+
+	expected := []string{
+		fmt.Sprintf("Id:%d=1 Temperature:%d=21.5 Name:%d=first Zip:%d=01234", ioutil.TInt8, ioutil.TFloat32, ioutil.TString8, ioutil.TString8),
+		fmt.Sprintf("Id:%d=300 Zip:%d=00042", ioutil.TInt16, ioutil.TString8),
+		fmt.Sprintf("Id:%d=-70000 Temperature:%d=22 Name:%d=semi;colon Zip:%d=1", ioutil.TInt24, ioutil.TInt8, ioutil.TString8, ioutil.TString8),
+	}
+
+	if objects := describe(t, db); strings.Join(objects, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected objects\n%s", strings.Join(objects, "\n"))
+	}
+
+	_, err = Import(db, strings.NewReader("Id\n1\nabc\n"), Options{Schema: map[string]Type{"Id": Int}})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Fatalf("expected error in line 3 but got %v", err)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	input := `{"Id": 1, "Sensor": {"Name": "a", "Active": true}, "Values": [1, 2.5], "Missing": null}
+
+{"Id": "2", "Empty": ""}
+`
+
+	n, err := Import(db, strings.NewReader(input), Options{Format: NDJSON})
+	logdbtest.AssertNil(t, err)
+
+	if n != 2 {
+		t.Fatalf("expected 2 objects but got %d", n)
+	}
+
+	expected := []string{
+		fmt.Sprintf("Id:%d=1 Sensor.Name:%d=a Sensor.Active:%d=1 Values:%d=1 Values:%d=2.5", ioutil.TInt8, ioutil.TString8, ioutil.TInt8, ioutil.TInt8, ioutil.TFloat32),
+		fmt.Sprintf("Id:%d=2", ioutil.TString8),
+	}
+
+	if objects := describe(t, db); strings.Join(objects, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected objects\n%s", strings.Join(objects, "\n"))
+	}
+
+	_, err = Import(db, strings.NewReader("{\"a\": 1}\n[1]\n"), Options{Format: NDJSON})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expected error in line 2 but got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// ID identifies the client across connections, so that the server recognizes retried batches. It must
+	// be unique among all clients of a server.
+	ID string
+
+	// BatchSize is the amount of objects after which a batch is sent. Defaults to 1000. Batches are also
+	// sent, if their objects exceed 16MiB.
+	BatchSize int
+
+	// Retries is the amount of attempts to send a batch again after a connection failure. Defaults to 3.
+	Retries int
+
+	// RetryDelay is the delay before the first retry, which is doubled for each further retry. Defaults to
+	// 100ms.
+	RetryDelay time.Duration
+
+	// Timeout limits the time to connect, send a batch and receive its answer. Defaults to 30s.
+	Timeout time.Duration
+}
+
+// BatchError is returned, if the server rejected a batch. Unlike connection failures, it is not retried.
+type BatchError struct {
+	Seq    uint64
+	Reason string
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch %d rejected: %s", e.Seq, e.Reason)
+}
+
+// maxBatchSize limits the size of the encoded objects of a batch, to keep it below the maximum frame size and
+// within a single record of the server.
+const maxBatchSize = 16 * 1024 * 1024
+
+// Client sends objects in batches to a Server. It is not safe for concurrent use.
+type Client struct {
+	addr string
+	http bool
+	opts ClientOptions
+
+	names   []string
+	nameIdx map[string]uint16
+	obj     *logdb.Object
+	batch   []byte
+	count   int
+	seq     uint64 // of the pending batch, 0 until the first welcome
+
+	conn      net.Conn
+	rd        *bufio.Reader
+	sentNames int
+	out, in   []byte
+}
+
+// Dial creates a client for the server at addr, which is either host:port of a TCP listener or the URL of
+// an HTTP handler, if it starts with http:// or https://. The connection is established lazily.
+func Dial(addr string, opts ClientOptions) (*Client, error) {
+	if opts.ID == "" {
+		return nil, fmt.Errorf("client id is required")
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 100 * time.Millisecond
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	return &Client{
+		addr:    addr,
+		http:    strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://"),
+		opts:    opts,
+		nameIdx: make(map[string]uint16),
+		obj:     logdb.NewObject(maxObjectSize),
+	}, nil
+}
+
+// PutName returns the client index of a name, which is translated by the server into its own index.
+func (c *Client) PutName(name string) uint16 {
+	if idx, ok := c.nameIdx[name]; ok {
+		return idx
+	}
+
+	idx := uint16(len(c.names))
+	c.names = append(c.names, name)
+	c.nameIdx[name] = idx
+	return idx
+}
+
+// Add encodes an object into the pending batch and sends the batch, if it is full.
+func (c *Client) Add(f func(obj *logdb.Object) error) error {
+	b, err := c.obj.Encode(f)
+	if err != nil {
+		return err
+	}
+
+	// the encoded object stays valid while the full batch is sent
+	if len(c.batch)+len(b) > maxBatchSize {
+		if err := c.Flush(); err != nil {
+			return err
+		}
+	}
+
+	c.batch = append(c.batch, b...)
+	c.count++
+	if c.count >= c.opts.BatchSize {
+		return c.Flush()
+	}
+
+	return nil
+}
+
+// Flush sends the pending batch and waits until the server acknowledged it. Connection failures are retried
+// with the same sequence number. If it fails, the batch is kept and sent again by the next Flush.
+func (c *Client) Flush() error {
+	if c.count == 0 {
+		return nil
+	}
+
+	var err error
+	delay := c.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if err = c.send(); err == nil {
+			break
+		}
+
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			// the batch will never be accepted
+			c.batch, c.count = c.batch[:0], 0
+			c.seq++
+			return err
+		}
+
+		c.disconnect()
+
+		if attempt == c.opts.Retries {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	c.batch, c.count = c.batch[:0], 0
+	c.seq++
+	return nil
+}
+
+// Close sends the pending batch and closes the connection.
+func (c *Client) Close() error {
+	err := c.Flush()
+	c.disconnect()
+	return err
+}
+
+// send sends the pending batch once.
+func (c *Client) send() error {
+	if c.http {
+		return c.sendHTTP()
+	}
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, c.opts.Timeout)
+		if err != nil {
+			return err
+		}
+
+		c.conn, c.rd, c.sentNames = conn, bufio.NewReader(conn), 0
+		_ = c.conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+		if _, err := c.conn.Write(c.appendHello(c.out[:0])); err != nil {
+			return err
+		}
+
+		last, err := c.readWelcome()
+		if err != nil {
+			return err
+		}
+
+		if c.seq == 0 {
+			c.seq = last + 1
+		} else if last >= c.seq {
+			// the batch has been applied, but the ack got lost
+			return nil
+		}
+	}
+
+	_ = c.conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	c.out = c.appendBatch(c.appendNames(c.out[:0]))
+	if _, err := c.conn.Write(c.out); err != nil {
+		return err
+	}
+	c.sentNames = len(c.names)
+
+	return c.readAck()
+}
+
+// sendHTTP posts an entire session with the pending batch. The first post of a client only learns the
+// sequence number.
+func (c *Client) sendHTTP() error {
+	if c.seq == 0 {
+		if err := c.post(c.appendHello(c.out[:0])); err != nil {
+			return err
+		}
+
+		last, err := c.readWelcome()
+		if err != nil {
+			return err
+		}
+		c.seq = last + 1
+	}
+
+	c.sentNames = 0
+	if err := c.post(c.appendBatch(c.appendNames(c.appendHello(c.out[:0])))); err != nil {
+		return err
+	}
+
+	if _, err := c.readWelcome(); err != nil {
+		return err
+	}
+
+	return c.readAck()
+}
+
+// post sends the frames and buffers the answers for reading.
+func (c *Client) post(frames []byte) error {
+	client := &http.Client{Timeout: c.opts.Timeout}
+	resp, err := client.Post(c.addr, "application/octet-stream", bytes.NewReader(frames))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded %s: %s", resp.Status, strings.TrimSpace(body.String()))
+	}
+
+	c.rd = bufio.NewReader(&body)
+	return nil
+}
+
+func (c *Client) disconnect() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn, c.rd = nil, nil
+	}
+}
+
+func (c *Client) appendHello(dst []byte) []byte {
+	dst, start := beginFrame(dst, kindHello)
+	return endFrame(appendString(dst, c.opts.ID), start)
+}
+
+// appendNames appends the names which have not been sent in this session.
+func (c *Client) appendNames(dst []byte) []byte {
+	if c.sentNames == len(c.names) {
+		return dst
+	}
+
+	dst, start := beginFrame(dst, kindNames)
+	dst = appendUint32(dst, uint32(c.sentNames))
+	for _, name := range c.names[c.sentNames:] {
+		dst = appendString(dst, name)
+	}
+	return endFrame(dst, start)
+}
+
+func (c *Client) appendBatch(dst []byte) []byte {
+	dst, start := beginFrame(dst, kindBatch)
+	dst = appendUint32(appendUint64(dst, c.seq), uint32(c.count))
+	return endFrame(append(dst, c.batch...), start)
+}
+
+// readWelcome reads the welcome and returns the last applied sequence number.
+func (c *Client) readWelcome() (uint64, error) {
+	f, err := c.read(kindWelcome)
+	if err != nil {
+		return 0, err
+	}
+
+	p := &payloadReader{buf: f.payload}
+	last := p.uint64()
+	return last, p.end()
+}
+
+// readAck reads the answer to the pending batch.
+func (c *Client) readAck() error {
+	f, err := c.read(kindAck, kindError)
+	if err != nil {
+		return err
+	}
+
+	p := &payloadReader{buf: f.payload}
+	seq := p.uint64()
+	var reason string
+	if f.kind == kindError {
+		reason = p.string()
+	}
+
+	if err := p.end(); err != nil {
+		return err
+	}
+
+	if seq != c.seq {
+		return fmt.Errorf("expected answer to batch %d but got %d", c.seq, seq)
+	}
+
+	if f.kind == kindError {
+		return &BatchError{Seq: seq, Reason: reason}
+	}
+
+	return nil
+}
+
+// read reads the next frame, which must be of one of the given kinds.
+func (c *Client) read(kinds ...byte) (frame, error) {
+	f, buf, err := readFrame(c.rd, c.in)
+	c.in = buf
+	if err != nil {
+		return f, err
+	}
+
+	for _, kind := range kinds {
+		if f.kind == kind {
+			return f, nil
+		}
+	}
+
+	return f, fmt.Errorf("unexpected frame of kind %d", f.kind)
+}
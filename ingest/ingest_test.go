@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *logdb.DB {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	// the server assigns other indexes than the clients
+	db.PutName("unrelated")
+	return db
+}
+
+// addObjects adds count objects, starting at from, to the client.
+func addObjects(t *testing.T, c *Client, from, count int) {
+	t.Helper()
+	sensor, temp := c.PutName("sensor"), c.PutName("temp")
+	for i := from; i < from+count; i++ {
+		logdbtest.AssertNil(t, c.Add(func(obj *logdb.Object) error {
+			obj.AddInt(sensor, int64(i))
+			obj.AddFloat(temp, float64(i)/2)
+			if i%10 == 0 {
+				obj.AddString(c.PutName("note"), fmt.Sprintf("note-%d", i))
+			}
+			return nil
+		}))
+	}
+}
+
+// describeDB formats all fields of all objects as name=value.
+func describeDB(t *testing.T, db *logdb.DB) []string {
+	var objects []string
+	err := db.ForEach(func(id uint64, obj *logdb.Object) error {
+		var fields []string
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+			var v interface{}
+			switch {
+			case kind == ioutil.TFloat32 || kind == ioutil.TFloat64:
+				v = f.ReadFloat()
+			case kind.IsNumber():
+				v = f.ReadInt()
+			default:
+				v = string(f.ReadRaw())
+			}
+			fields = append(fields, fmt.Sprintf("%s=%v", db.NameByIndex(int(name)), v))
+		})
+		objects = append(objects, strings.Join(fields, " "))
+		return nil
+	})
+	logdbtest.AssertNil(t, err)
+	return objects
+}
+
+func checkObjects(t *testing.T, db *logdb.DB, count int) {
+	t.Helper()
+	objects := describeDB(t, db)
+	if len(objects) != count {
+		t.Fatalf("expected %d objects but got %d", count, len(objects))
+	}
+
+	for i, obj := range objects {
+		expected := fmt.Sprintf("sensor=%d temp=%v", i, float64(i)/2)
+		if i%10 == 0 {
+			expected += fmt.Sprintf(" note=note-%d", i)
+		}
+
+		if obj != expected {
+			t.Fatalf("expected %s but got %s", expected, obj)
+		}
+	}
+}
+
+func TestTCP(t *testing.T) {
+	db := openTestDB(t)
+
+	srv, err := New(db, Options{})
+	logdbtest.AssertNil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	logdbtest.AssertNil(t, err)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c, err := Dial(l.Addr().String(), ClientOptions{ID: "gateway", BatchSize: 10})
+	logdbtest.AssertNil(t, err)
+	addObjects(t, c, 0, 25)
+
+	// two full batches are acknowledged after a flush
+	if db.TxCount() != 2 || db.ObjectCount() != 20 {
+		t.Fatalf("expected 2 flushed batches but got %d with %d objects", db.TxCount(), db.ObjectCount())
+	}
+
+	logdbtest.AssertNil(t, c.Close())
+	checkObjects(t, db, 25)
+
+	// a retry of the last batch on a new connection, whose ack got lost, is not applied again
+	c.seq--
+	addObjects(t, c, 20, 5)
+	logdbtest.AssertNil(t, c.Flush())
+	checkObjects(t, db, 25)
+
+	// a new client with the same id continues the sequence
+	c, err = Dial(l.Addr().String(), ClientOptions{ID: "gateway"})
+	logdbtest.AssertNil(t, err)
+	addObjects(t, c, 25, 5)
+	logdbtest.AssertNil(t, c.Close())
+	checkObjects(t, db, 30)
+}
+
+func TestHTTP(t *testing.T) {
+	db := openTestDB(t)
+
+	srv, err := New(db, Options{Durability: DurabilitySync})
+	logdbtest.AssertNil(t, err)
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	c, err := Dial(httpSrv.URL, ClientOptions{ID: "gateway", BatchSize: 7})
+	logdbtest.AssertNil(t, err)
+	addObjects(t, c, 0, 20)
+	logdbtest.AssertNil(t, c.Close())
+	checkObjects(t, db, 20)
+
+	// a duplicated batch is acknowledged without adding the objects again
+	c.seq--
+	addObjects(t, c, 14, 6)
+	logdbtest.AssertNil(t, c.Close())
+	checkObjects(t, db, 20)
+}
+
+func TestRejectedBatch(t *testing.T) {
+	db := openTestDB(t)
+
+	srv, err := New(db, Options{})
+	logdbtest.AssertNil(t, err)
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	c, err := Dial(httpSrv.URL, ClientOptions{ID: "gateway"})
+	logdbtest.AssertNil(t, err)
+
+	// the name of the second object has not been registered, so that the first one is not added either
+	addObjects(t, c, 0, 1)
+	logdbtest.AssertNil(t, c.Add(func(obj *logdb.Object) error {
+		obj.AddInt(42, 1)
+		return nil
+	}))
+
+	var batchErr *BatchError
+	if err := c.Flush(); !errors.As(err, &batchErr) {
+		t.Fatalf("expected a rejected batch but got %v", err)
+	}
+
+	if db.ObjectCount() != 0 {
+		t.Fatalf("expected no objects but got %d", db.ObjectCount())
+	}
+
+	// the client continues with the next batch
+	addObjects(t, c, 0, 3)
+	logdbtest.AssertNil(t, c.Close())
+	checkObjects(t, db, 3)
+}
+
+func TestSequenceFile(t *testing.T) {
+	db := openTestDB(t)
+	seqFile := filepath.Join(logdbtest.TempDir(t), "ingest.seq")
+
+	srv, err := New(db, Options{SequenceFile: seqFile})
+	logdbtest.AssertNil(t, err)
+	httpSrv := httptest.NewServer(srv)
+	c, err := Dial(httpSrv.URL, ClientOptions{ID: "gateway", BatchSize: 10})
+	logdbtest.AssertNil(t, err)
+	addObjects(t, c, 0, 20)
+	logdbtest.AssertNil(t, c.Close())
+	httpSrv.Close()
+
+	// a retry of the last batch after a restart of the server is not applied again
+	srv, err = New(db, Options{SequenceFile: seqFile, Durability: DurabilityAdd})
+	logdbtest.AssertNil(t, err)
+	httpSrv = httptest.NewServer(srv)
+	defer httpSrv.Close()
+	c.addr = httpSrv.URL
+	c.seq--
+	addObjects(t, c, 10, 10)
+	logdbtest.AssertNil(t, c.Flush())
+	checkObjects(t, db, 20)
+
+	// the next batches are only added to the pending record, which is lost, unless it is flushed before the
+	// server restarts
+	for _, flushed := range []bool{true, false} {
+		addObjects(t, c, int(db.ObjectCount()), 10)
+		logdbtest.AssertNil(t, c.Flush())
+		if flushed {
+			logdbtest.AssertNil(t, db.Flush())
+		}
+
+		restarted, err := New(db, Options{SequenceFile: seqFile})
+		logdbtest.AssertNil(t, err)
+		if applied := restarted.sequences["gateway"].applied; applied != 3 {
+			t.Fatalf("expected sequence 3 to be applied but got %d", applied)
+		}
+	}
+}
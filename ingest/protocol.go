@@ -0,0 +1,171 @@
+// Package ingest appends objects which are sent over the network by remote clients, e.g. sensor gateways
+// which cannot write to the database file themselves.
+//
+// The protocol is the same for TCP connections and HTTP POST requests. Both sides exchange frames, which are
+// prefixed with their length:
+//
+//   - length              uint32, of kind and payload, at most 64MiB
+//   - kind                uint8
+//   - payload             variable, depending on kind
+//
+// A client starts a session with a hello frame, which carries its id, and the server answers with a welcome
+// frame, which carries the sequence number of the last batch it applied for that id. Afterwards, the client
+// registers its names with names frames and sends objects in batch frames. The objects are encoded by
+// logdb.Object.Encode, their field names are indexes into the names registered by the client in this
+// session. The server answers each batch with an ack frame, once the batch reached the configured
+// durability, or with an error frame, if the batch has been rejected.
+//
+// Batches carry increasing sequence numbers per client id. The server applies a batch only if its sequence
+// number is larger than the last applied one and otherwise acknowledges it right away, so that a client can
+// retry a batch after a connection failure without duplicating its objects. The objects of a batch are added
+// all at once into the same record. If the server has a sequence file, see Options.SequenceFile, it records
+// each batch before the record is flushed, and after a restart, it treats a batch as applied only if the
+// record has been flushed, so that a retry after a restart does not duplicate a batch either. Otherwise, the
+// sequence numbers are only kept in memory.
+//
+// HTTP requests contain the frames of an entire session, from the hello to the last batch, and the response
+// contains the answers of the server.
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Frame kinds.
+const (
+	// kindHello is sent by the client and contains its id as a string.
+	kindHello = 1
+
+	// kindWelcome is the answer to a hello and contains the last applied sequence number as uint64.
+	kindWelcome = 2
+
+	// kindNames is sent by the client and registers names. It contains the index of the first name as
+	// uint32, followed by the names as strings.
+	kindNames = 3
+
+	// kindBatch is sent by the client and contains the sequence number as uint64, the amount of objects as
+	// uint32 and the encoded objects.
+	kindBatch = 4
+
+	// kindAck is the answer to an applied or duplicated batch and contains its sequence number as uint64.
+	kindAck = 5
+
+	// kindError is the answer to a rejected batch and contains its sequence number as uint64 and the
+	// reason as string.
+	kindError = 6
+)
+
+// maxFrameSize limits the length of a frame.
+const maxFrameSize = 64 * 1024 * 1024
+
+// maxObjectSize is the size limit of a single object in the database, see logdb.Object.
+const maxObjectSize = 64 * 1024
+
+// frame is a decoded frame. The payload is only valid until the next frame is read.
+type frame struct {
+	kind    byte
+	payload []byte
+}
+
+// readFrame reads the next frame into buf, which is grown as required.
+func readFrame(r io.Reader, buf []byte) (frame, []byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return frame{}, buf, err
+	}
+
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length < 1 || length > maxFrameSize {
+		return frame{}, buf, fmt.Errorf("invalid frame length %d", length)
+	}
+
+	if cap(buf) < int(length) {
+		buf = make([]byte, length)
+	}
+	buf = buf[:length]
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return frame{}, buf, err
+	}
+
+	return frame{kind: buf[0], payload: buf[1:]}, buf, nil
+}
+
+// beginFrame appends the prefix and kind of a frame, whose length is set by endFrame.
+func beginFrame(dst []byte, kind byte) ([]byte, int) {
+	return append(dst, 0, 0, 0, 0, kind), len(dst)
+}
+
+// endFrame sets the length of the frame which starts at start.
+func endFrame(dst []byte, start int) []byte {
+	binary.LittleEndian.PutUint32(dst[start:], uint32(len(dst)-start-4))
+	return dst
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return appendUint32(appendUint32(dst, uint32(v)), uint32(v>>32))
+}
+
+// appendString appends the length as uint16 and the bytes of a string.
+func appendString(dst []byte, s string) []byte {
+	return append(append(dst, byte(len(s)), byte(len(s)>>8)), s...)
+}
+
+// payloadReader decodes the payload of a frame and remembers the first error.
+type payloadReader struct {
+	buf []byte
+	err error
+}
+
+func (r *payloadReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.buf) < n {
+		r.err = fmt.Errorf("frame is truncated")
+		return nil
+	}
+
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *payloadReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *payloadReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *payloadReader) string() string {
+	if b := r.next(2); b != nil {
+		return string(r.next(int(binary.LittleEndian.Uint16(b))))
+	}
+	return ""
+}
+
+// end fails, if the payload has not been consumed entirely.
+func (r *payloadReader) end() error {
+	if r.err == nil && len(r.buf) > 0 {
+		r.err = fmt.Errorf("frame has %d trailing bytes", len(r.buf))
+	}
+	return r.err
+}
@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io/ioutil"
+	"os"
+)
+
+// sequenceMagic starts a sequence file.
+var sequenceMagic = [8]byte{'w', 'd', 'y', 'i', 'n', 'g', 's', '1'}
+
+// sequence is the state of a client. The objects of the applied batch are contained in the record at offset,
+// which may not have been flushed yet. If it is missing after a restart, the batch has been lost with the
+// pending record and only prev has been applied. The offset is -1, once the record has been found.
+type sequence struct {
+	applied uint64
+	prev    uint64
+	offset  int64
+}
+
+// sequences are the states of all clients by their id, which the server persists in the sequence file.
+//
+// Format specification of the sequence file, which repeats all but the magic for each client:
+//
+//   - magic               [8]byte, wdyings1
+//   - id                  string, uint16 length and bytes
+//   - applied             uint64
+//   - prev                uint64
+//   - offset              int64
+type sequences map[string]sequence
+
+// advance registers the batch, whose objects have been added to the record at offset. A batch, which shares
+// the pending record with the previous batch of the client, is lost together with it.
+func (s sequences) advance(client string, seq uint64, offset int64) {
+	last := s[client]
+	if last.offset != offset {
+		last.prev = last.applied
+	}
+
+	s[client] = sequence{applied: seq, prev: last.prev, offset: offset}
+}
+
+// readSequences reads the sequence file and resolves the batches, whose records have been lost, because the
+// server has not been stopped regularly. A missing file contains no clients.
+func readSequences(fname string, db *logdb.DB) (sequences, error) {
+	seqs := make(sequences)
+	data, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return seqs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(sequenceMagic) || string(data[:len(sequenceMagic)]) != string(sequenceMagic[:]) {
+		return nil, fmt.Errorf("invalid sequence file '%s'", fname)
+	}
+
+	p := &payloadReader{buf: data[len(sequenceMagic):]}
+	for len(p.buf) > 0 && p.err == nil {
+		client := p.string()
+		seq := sequence{applied: p.uint64(), prev: p.uint64(), offset: int64(p.uint64())}
+		if seq.offset >= 0 {
+			if records := db.RecordsFrom(seq.offset); len(records) == 0 || records[0].Offset != seq.offset {
+				seq.applied = seq.prev
+			}
+			seq.offset = -1
+		}
+
+		seqs[client] = seq
+	}
+
+	if err := p.end(); err != nil {
+		return nil, fmt.Errorf("invalid sequence file '%s': %w", fname, err)
+	}
+
+	return seqs, nil
+}
+
+// write replaces the sequence file and commits it to stable storage, if sync is set.
+func (s sequences) write(fname string, sync bool) error {
+	buf := append([]byte(nil), sequenceMagic[:]...)
+	for client, seq := range s {
+		buf = appendString(buf, client)
+		buf = appendUint64(appendUint64(appendUint64(buf, seq.applied), seq.prev), uint64(seq.offset))
+	}
+
+	tmp := fname + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if sync {
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fname)
+}
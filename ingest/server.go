@@ -0,0 +1,389 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Durability is the point after which the server acknowledges a batch.
+type Durability int
+
+const (
+	// DurabilityFlush acknowledges a batch after its objects have been written to the database file.
+	DurabilityFlush Durability = iota
+
+	// DurabilityAdd acknowledges a batch after its objects have been added to the pending record, which
+	// is lost if the server crashes before the record has been flushed.
+	DurabilityAdd
+
+	// DurabilitySync acknowledges a batch after the database file has been committed to stable storage,
+	// see logdb.DB.Sync.
+	DurabilitySync
+)
+
+// ParseDurability parses the name of a durability, which is add, flush or sync.
+func ParseDurability(s string) (Durability, error) {
+	switch s {
+	case "add":
+		return DurabilityAdd, nil
+	case "flush":
+		return DurabilityFlush, nil
+	case "sync":
+		return DurabilitySync, nil
+	default:
+		return 0, fmt.Errorf("unknown durability %s", s)
+	}
+}
+
+// errClosed ends a session, which tries to add objects after the server has been closed.
+var errClosed = errors.New("server is closed")
+
+// errFailed ends a session, which tries to add objects after the database or the sequence file has failed.
+var errFailed = errors.New("server has failed")
+
+// Options configures a Server.
+type Options struct {
+	// Durability is the point after which batches are acknowledged. Defaults to DurabilityFlush.
+	Durability Durability
+
+	// SequenceFile keeps the last applied sequence number of each client, so that batches which are retried
+	// after a restart of the server are not duplicated, e.g. the database file name with .ingest appended.
+	// Without it, the sequence numbers are only kept in memory.
+	SequenceFile string
+}
+
+// Server appends the objects of remote clients to a database. It must be the only writer of the database.
+type Server struct {
+	db   *logdb.DB
+	opts Options
+
+	mutex     sync.Mutex // guards the database, the sequences and the open listeners and connections
+	sequences sequences
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	failed    error // of the database or the sequence file, after which no batch is acknowledged anymore
+}
+
+// New creates a server for the database and reads the sequence file, if any.
+func New(db *logdb.DB, opts Options) (*Server, error) {
+	seqs := make(sequences)
+	if opts.SequenceFile != "" {
+		var err error
+		if seqs, err = readSequences(opts.SequenceFile, db); err != nil {
+			return nil, err
+		}
+
+		// the resolved offsets must not refer to records of later batches after the next restart
+		if err := seqs.write(opts.SequenceFile, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Server{
+		db:        db,
+		opts:      opts,
+		sequences: seqs,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Serve accepts TCP connections and serves each in its own go routine, until the listener fails or the
+// server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		return errClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn serves a session on a connection and flushes the answers after each frame.
+func (s *Server) serveConn(conn net.Conn) {
+	if !s.track(nil, conn) {
+		_ = conn.Close()
+		return
+	}
+	defer s.untrack(nil, conn)
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	_ = s.serve(bufio.NewReader(conn), w, w.Flush)
+}
+
+// ServeHTTP serves the session which is contained in the body of a POST request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the answers are written after the entire body has been read
+	var answers bytes.Buffer
+	if err := s.serve(bufio.NewReader(r.Body), &answers, nil); err != nil {
+		status := http.StatusBadRequest
+		if err == errClosed || errors.Is(err, errFailed) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(answers.Bytes())
+}
+
+// Close closes all listeners and connections. Afterwards, no further objects are added to the database.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+
+	return nil
+}
+
+// track registers a listener or connection and returns false, if the server is closed.
+func (s *Server) track(l net.Listener, conn net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	} else {
+		s.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, conn net.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.listeners, l)
+	delete(s.conns, conn)
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// serve reads the frames of a session until the end of r and writes the answers to w. The answers are
+// flushed after each frame, if flush is not nil. Malformed frames end the session with an error.
+func (s *Server) serve(r io.Reader, w io.Writer, flush func() error) error {
+	var (
+		buf, out []byte
+		client   string
+		hello    bool
+		names    []uint16 // client name index to database name index
+	)
+
+	for {
+		f, tmp, err := readFrame(r, buf)
+		buf = tmp
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !hello && f.kind != kindHello {
+			return fmt.Errorf("expected a hello frame")
+		}
+
+		p := &payloadReader{buf: f.payload}
+		out = out[:0]
+		switch f.kind {
+		case kindHello:
+			client = p.string()
+			if err := p.end(); err != nil {
+				return err
+			}
+
+			hello = true
+			names = names[:0]
+			out = s.appendWelcome(out, client)
+		case kindNames:
+			first := p.uint32()
+			if p.err == nil && int(first) != len(names) {
+				return fmt.Errorf("expected name %d but got %d", len(names), first)
+			}
+
+			for len(p.buf) > 0 && p.err == nil {
+				name := p.string()
+				if len(names) > 0xFFFF {
+					return fmt.Errorf("too many names")
+				}
+				names = append(names, s.putName(name))
+			}
+
+			if err := p.end(); err != nil {
+				return err
+			}
+		case kindBatch:
+			seq := p.uint64()
+			count := p.uint32()
+			if p.err != nil {
+				return p.err
+			}
+
+			if out, err = s.apply(out, client, seq, count, p.buf, names); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected frame of kind %d", f.kind)
+		}
+
+		if len(out) > 0 {
+			if _, err := w.Write(out); err != nil {
+				return err
+			}
+		}
+
+		if flush != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// appendWelcome appends the welcome frame with the last applied sequence number of the client.
+func (s *Server) appendWelcome(dst []byte, client string) []byte {
+	s.mutex.Lock()
+	seq := s.sequences[client].applied
+	s.mutex.Unlock()
+
+	dst, start := beginFrame(dst, kindWelcome)
+	return endFrame(appendUint64(dst, seq), start)
+}
+
+func (s *Server) putName(name string) uint16 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.db.PutName(name)
+}
+
+// apply adds the objects of a batch, unless it has already been applied, and appends the answer. It fails
+// without an answer, if the server is closed or has failed, so that the client retries the batch later.
+func (s *Server) apply(dst []byte, client string, seq uint64, count uint32, objects []byte, names []uint16) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return dst, errClosed
+	}
+
+	if s.failed != nil {
+		return dst, s.failed
+	}
+
+	if seq > s.sequences[client].applied {
+		batch, err := splitBatch(count, objects, len(names))
+		if err != nil {
+			dst, start := beginFrame(dst, kindError)
+			dst = appendString(appendUint64(dst, seq), err.Error())
+			return endFrame(dst, start), nil
+		}
+
+		if err := s.add(client, seq, batch, names); err != nil {
+			// whether the batch has been added is unknown until the sequence file is read after a restart
+			s.failed = fmt.Errorf("%w: %v", errFailed, err)
+			return dst, s.failed
+		}
+	}
+
+	dst, start := beginFrame(dst, kindAck)
+	return endFrame(appendUint64(dst, seq), start), nil
+}
+
+// splitBatch splits the objects of a batch and validates all of them, before any is added.
+func splitBatch(count uint32, objects []byte, nameCount int) ([][]byte, error) {
+	if len(objects) > maxBatchSize {
+		return nil, fmt.Errorf("batch of %d bytes exceeds %d bytes", len(objects), maxBatchSize)
+	}
+
+	var batch [][]byte
+	for pos := 0; pos < len(objects); {
+		if len(objects)-pos < 3 {
+			return nil, fmt.Errorf("object %d is truncated", len(batch))
+		}
+
+		size := int(objects[pos]) | int(objects[pos+1])<<8 | int(objects[pos+2])<<16
+		// an object consists of at least its size and field count
+		if size < 5 || size > maxObjectSize || size > len(objects)-pos {
+			return nil, fmt.Errorf("object %d has an invalid size of %d bytes", len(batch), size)
+		}
+
+		if err := logdb.VerifyObject(objects[pos:pos+size], nameCount); err != nil {
+			return nil, fmt.Errorf("object %d: %w", len(batch), err)
+		}
+
+		batch = append(batch, objects[pos:pos+size])
+		pos += size
+	}
+
+	if len(batch) != int(count) {
+		return nil, fmt.Errorf("batch contains %d objects instead of %d", len(batch), count)
+	}
+
+	return batch, nil
+}
+
+// add adds all objects of a validated batch at once and waits for the durability. The sequence file is
+// written before the record is flushed, so that it never misses a flushed batch.
+func (s *Server) add(client string, seq uint64, batch [][]byte, names []uint16) error {
+	offset, err := s.db.AddEncodedBatch(batch, names)
+	if err != nil {
+		return err
+	}
+
+	s.sequences.advance(client, seq, offset)
+	if s.opts.SequenceFile != "" {
+		if err := s.sequences.write(s.opts.SequenceFile, s.opts.Durability == DurabilitySync); err != nil {
+			return err
+		}
+	}
+
+	switch s.opts.Durability {
+	case DurabilityFlush:
+		return s.db.Flush()
+	case DurabilitySync:
+		return s.db.Sync()
+	default:
+		return nil
+	}
+}
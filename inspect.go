@@ -0,0 +1,228 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"sort"
+)
+
+// RecordStat describes a flushed record.
+type RecordStat struct {
+	Offset       int64  // file offset of the record
+	Length       uint32 // bytes on disk, including the length prefix of compressed records
+	Size         uint32 // uncompressed size in row layout
+	FirstID      uint64 // id of the first object in the record
+	FirstOrdinal uint64 // ordinal of the first object in the record
+	ObjectCount  uint32
+	Columnar     bool // stored in the columnar layout, see Options.Columnar
+}
+
+// Records returns the statistics of all flushed records in file order.
+func (db *DB) Records() []RecordStat {
+	return recordStats(db.findRecords())
+}
+
+// RecordsFrom returns the statistics of the flushed records which start at or after the file offset.
+func (db *DB) RecordsFrom(offset int64) []RecordStat {
+	records := db.findRecords()
+	i := sort.Search(len(records), func(i int) bool { return records[i].offset >= offset })
+	return recordStats(records[i:])
+}
+
+// recordStats converts the record infos into statistics.
+func recordStats(records []recordInfo) []RecordStat {
+	stats := make([]RecordStat, len(records))
+	for i, info := range records {
+		stats[i] = RecordStat{
+			Offset:       info.offset,
+			Length:       info.length,
+			Size:         info.size,
+			FirstID:      info.base + offsetRecObjList,
+			FirstOrdinal: info.ordinal,
+			ObjectCount:  info.objCount,
+			Columnar:     info.columnar,
+		}
+	}
+
+	return stats
+}
+
+// TxCount returns the amount of flushed transactions.
+func (db *DB) TxCount() uint64 {
+	return db.header.TxCount()
+}
+
+// ForEachFrom walks over all flushed objects whose id is at least the given one, in the order in which they
+// have been added. It is safe to be used concurrently.
+func (db *DB) ForEachFrom(id uint64, f func(id uint64, obj *Object) error) error {
+	reader := newRecordReader(db)
+	obj := newObject(0)
+
+	for _, info := range db.findRecords() {
+		if info.base+uint64(info.size) <= id {
+			continue
+		}
+
+		record, err := reader.readInfo(info)
+		if err != nil {
+			return err
+		}
+
+		err = record.ForEach(obj, func(recOffset int, object *Object) error {
+			if objId := info.base + uint64(recOffset); objId >= id {
+				return f(objId, object)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Verify reads the entire database file sequentially and checks the structure of all records, objects and
+// fields and whether the record index and the object count of the header match the file. It returns the
+// first inconsistency.
+func (db *DB) Verify() error {
+	reader := newRecordReader(db)
+	records := db.findRecords()
+	offset := int64(db.header.Size())
+	objects := uint64(0)
+
+	i := 0
+	for ; offset < db.eof; i++ {
+		record, next, err := reader.read(offset)
+		if err != nil {
+			return err
+		}
+
+		if !validRecordMagic(record.buf.Bytes) {
+			return fmt.Errorf("invalid record magic at offset %d", offset)
+		}
+
+		if err := verifyRecord(record); err != nil {
+			return fmt.Errorf("invalid record at offset %d: %w", offset, err)
+		}
+
+		if i >= len(records) {
+			return fmt.Errorf("record at offset %d is missing in the record index", offset)
+		}
+
+		if info := records[i]; info.offset != offset || info.end() != next || info.size != record.Size() ||
+			info.objCount != record.ObjectCount() || info.columnar != record.columnar {
+			return fmt.Errorf("record index entry %d does not match the record at offset %d", i, offset)
+		}
+
+		objects += uint64(record.ObjectCount())
+		offset = next
+	}
+
+	if offset != db.eof {
+		return fmt.Errorf("last record exceeds the end of file %d", db.eof)
+	}
+
+	if i != len(records) {
+		return fmt.Errorf("record index contains %d records, but the file only %d", len(records), i)
+	}
+
+	if count := db.header.ObjectCount() - uint64(db.pendingWriteRecord.ObjectCount()); count != objects {
+		return fmt.Errorf("header counts %d objects, but the records contain %d", count, objects)
+	}
+
+	return nil
+}
+
+// verifyRecord checks that all objects and their fields lie within the record and that its dictionary codes
+// are known.
+func verifyRecord(record *Record) error {
+	size := int(record.Size())
+	pos := offsetRecObjList
+	for i := 0; i < int(record.ObjectCount()); i++ {
+		if pos+offsetFieldList > size {
+			return fmt.Errorf("object %d exceeds the record", i)
+		}
+
+		objSize := int(record.ReadUint24At(pos))
+		if objSize < offsetFieldList || pos+objSize > size {
+			return fmt.Errorf("object %d has an invalid size of %d bytes", i, objSize)
+		}
+
+		if err := verifyObject(record.buf.Bytes[pos:pos+objSize], -1); err != nil {
+			return fmt.Errorf("object %d %w", i, err)
+		}
+
+		pos += objSize
+	}
+
+	if pos != size {
+		return fmt.Errorf("record has %d bytes, but its objects %d", size, pos)
+	}
+
+	return record.verifyDictionary()
+}
+
+// verifyObject checks that all fields of the encoded object lie within it and, unless nameCount is negative,
+// that their names are below nameCount. The errors are phrased to follow the word object.
+func verifyObject(b []byte, nameCount int) (err error) {
+	defer func() {
+		// malformed fields may read beyond the object, which is sliced to its size
+		if r := recover(); r != nil {
+			err = fmt.Errorf("is malformed: %v", r)
+		}
+	}()
+
+	obj := &Object{buf: &ioutil.LittleEndianBuffer{Bytes: b}}
+	obj.reverseFlush()
+	if int(obj.Size()) != len(b) {
+		return fmt.Errorf("has a size of %d bytes, but %d are given", obj.Size(), len(b))
+	}
+
+	buf := obj.buf
+	buf.Pos = offsetFieldList
+	for f := 0; f < int(obj.FieldCount()); f++ {
+		name := buf.ReadUint16()
+		if nameCount >= 0 && int(name) >= nameCount {
+			return fmt.Errorf("has a field with the unknown name %d", name)
+		}
+
+		kind := buf.ReadType()
+		switch {
+		case kind == TObject || kind == TArray:
+			if err := verifyNested(buf, kind, nameCount); err != nil {
+				return err
+			}
+		case kind == TDictString:
+			if buf.Pos+4 >= len(b) {
+				return fmt.Errorf("has a truncated dictionary string")
+			}
+
+			if t := ioutil.Type(buf.Bytes[buf.Pos+4]); t < ioutil.TString8 || t > ioutil.TString32 {
+				return fmt.Errorf("has a dictionary string of the unsupported type %d", t)
+			}
+		case kind == tXOR:
+			if buf.Pos >= len(b) {
+				return fmt.Errorf("has a truncated encoded float")
+			}
+
+			if h := buf.Bytes[buf.Pos]; int(h&15)+int(h>>4&7) > 8 {
+				return fmt.Errorf("has an encoded float with the invalid header %d", h)
+			}
+		case kind >= TNull && kind <= tXOR:
+		case kind < ioutil.TUint8 || kind > ioutil.TComplex128:
+			return fmt.Errorf("has a field of unknown type %d", kind)
+		}
+
+		if err := skip(buf, kind); err != nil {
+			return err
+		}
+	}
+
+	if buf.Pos != len(b) {
+		return fmt.Errorf("has %d bytes, but its fields %d", len(b), buf.Pos)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package logdb
+
+import (
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "verifyTest")
+	assertNil(t, err)
+
+	for _, compression := range []bool{false, true} {
+		fname := filepath.Join(dir, "verify.bin")
+		_ = removeDB(fname)
+
+		opts := Options{Compression: compression, Quiet: true}
+		db, err := OpenOptions(fname, opts)
+		assertNil(t, err)
+
+		colId := db.PutName("Id")
+		colName := db.PutName("Name")
+		for i := 0; i < 1000; i++ {
+			err := db.Add(func(obj *Object) error {
+				obj.AddUint32(colId, uint32(i))
+				obj.AddString(colName, "verify")
+				return nil
+			})
+			assertNil(t, err)
+
+			if i%100 == 99 {
+				assertNil(t, db.Flush())
+			}
+		}
+		assertNil(t, db.Verify())
+
+		records := db.Records()
+		if len(records) != 10 || records[3].FirstOrdinal != 300 || records[3].ObjectCount != 100 {
+			t.Fatalf("unexpected records %v", records)
+		}
+
+		var ids []uint64
+		err = db.ForEachFrom(records[9].FirstID+1, func(id uint64, obj *Object) error {
+			ids = append(ids, id)
+			return nil
+		})
+		assertNil(t, err)
+
+		if len(ids) != 99 || ids[0] <= records[9].FirstID {
+			t.Fatalf("unexpected objects from id %v", ids)
+		}
+		assertNil(t, db.Close())
+
+		if compression {
+			continue
+		}
+
+		// destroy the type of the first field in the last record
+		file, err := os.OpenFile(fname, os.O_RDWR, 0)
+		assertNil(t, err)
+		_, err = file.WriteAt([]byte{255}, records[9].Offset+offsetRecObjList+offsetFieldList+2)
+		assertNil(t, err)
+		assertNil(t, file.Close())
+
+		db, err = OpenOptions(fname, opts)
+		assertNil(t, err)
+		if err := db.Verify(); err == nil {
+			t.Fatalf("expected verification to fail")
+		}
+		assertNil(t, db.Close())
+	}
+}
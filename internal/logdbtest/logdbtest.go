@@ -0,0 +1,41 @@
+// Package logdbtest contains the fixtures which the tests of the packages around logdb share.
+package logdbtest
+
+import (
+	"github.com/worldiety/logdb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// AssertNil fails the test, if err is not nil.
+func AssertNil(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TempDir creates a temporary directory, which is removed after the test.
+func TempDir(t testing.TB) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "logdbTest")
+	AssertNil(t, err)
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// OpenDB opens a new quiet database in a temporary directory, which is removed after the test together with
+// the database. The database is closed after the test, unless the test has closed it already.
+func OpenDB(t testing.TB, opts logdb.Options) *logdb.DB {
+	t.Helper()
+	opts.Quiet = true
+	db, err := logdb.OpenOptions(filepath.Join(TempDir(t), "test.bin"), opts)
+	AssertNil(t, err)
+
+	// cleanups run in reverse order, so the database is closed before its directory is removed
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
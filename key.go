@@ -0,0 +1,236 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"sync"
+)
+
+// keyIndex maps the primary key of each keyed object to the id of its latest version.
+type keyIndex struct {
+	names  []uint16
+	latest map[string]uint64
+	mutex  sync.RWMutex
+	tmp    []byte
+}
+
+func newKeyIndex(names []uint16) *keyIndex {
+	return &keyIndex{
+		names:  names,
+		latest: make(map[string]uint64),
+	}
+}
+
+// appendKey appends the normalized values of all key names in declaration order. It returns false, if the
+// object does not contain all key names.
+func (k *keyIndex) appendKey(dst []byte, obj *Object) ([]byte, bool) {
+	for _, name := range k.names {
+		found := false
+		obj.WithFields(func(fieldName uint16, kind ioutil.Type, f *FieldReader) {
+			if !found && fieldName == name {
+				dst = appendValue(dst, kind, f)
+				found = true
+			}
+		})
+
+		if !found {
+			return dst, false
+		}
+	}
+
+	return dst, true
+}
+
+// put registers the id as the latest version of the objects key. It returns false, if the object is not keyed.
+// It is not safe to be used concurrently with other puts.
+func (k *keyIndex) put(obj *Object, id uint64) bool {
+	key, ok := k.appendKey(k.tmp[:0], obj)
+	k.tmp = key
+	if !ok {
+		return false
+	}
+
+	k.mutex.Lock()
+	k.latest[string(key)] = id
+	k.mutex.Unlock()
+
+	return true
+}
+
+// isLatest returns true, if the object is not keyed or if the id is the latest version of its key. The buffer
+// is used to build the key and is returned for reuse, so that this is safe to be used concurrently.
+func (k *keyIndex) isLatest(buf []byte, id uint64, obj *Object) ([]byte, bool) {
+	key, ok := k.appendKey(buf[:0], obj)
+	if !ok {
+		return key, true
+	}
+
+	k.mutex.RLock()
+	latest, ok := k.latest[string(key)]
+	k.mutex.RUnlock()
+
+	return key, !ok || latest == id
+}
+
+// buildKeyIndex registers the key names and walks over all objects to find the latest version of each key.
+func (db *DB) buildKeyIndex() error {
+	names := make([]uint16, 0, len(db.opts.Key))
+	for _, name := range db.opts.Key {
+		names = append(names, db.PutName(name))
+	}
+
+	keys := newKeyIndex(names)
+	err := db.ForEach(func(id uint64, obj *Object) error {
+		keys.put(obj, id)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	db.keys = keys
+	return nil
+}
+
+// Put appends a new version of a keyed object. Older versions with the same key are still contained in the
+// file but are superseded and not visited by scans using LatestOnly anymore. Use Compact to remove them.
+// An error is returned, if no key has been declared or if the object misses any of the key names.
+func (db *DB) Put(f func(obj *Object) error) error {
+	if db.keys == nil {
+		return fmt.Errorf("no key has been declared")
+	}
+
+	return db.Add(func(obj *Object) error {
+		if err := f(obj); err != nil {
+			return err
+		}
+
+		obj.flush()
+		if _, ok := db.keys.appendKey(nil, obj); !ok {
+			return fmt.Errorf("object does not contain all key names %v", db.opts.Key)
+		}
+
+		return nil
+	})
+}
+
+// IsLatest returns true, if the object with the given id is not keyed or is the latest version of its key.
+func (db *DB) IsLatest(id uint64, obj *Object) bool {
+	if db.keys == nil {
+		return true
+	}
+
+	_, ok := db.keys.isLatest(nil, id, obj)
+	return ok
+}
+
+// Compact rewrites the database file without superseded versions of keyed objects. Afterwards all ids
+// have changed, so all side files are rebuilt, and a rollup checkpoint of the database is dropped, which
+// fails for an interrupted rollup, see Rollup. Compact must not be called concurrently with any other method.
+// If the file cannot be replaced, the database is opened again. If this fails as well, ErrUnusable is returned
+// and the database must not be used anymore.
+func (db *DB) Compact() error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if err := db.Flush(); err != nil {
+		return err
+	}
+
+	// the rollup checkpoint is dropped below, which only the rows of completed runs can restore
+	if state, err := readRollupState(db.fname + rollupSuffix); err != nil {
+		return err
+	} else if state.target > state.done {
+		return fmt.Errorf("unable to compact, because a rollup into the database has been interrupted")
+	}
+
+	tmpName := db.fname + ".compact"
+	_ = removeDB(tmpName)
+
+	opts := db.opts
+	opts.Mmap = false
+	opts.Key = nil
+	opts.KeyID = db.KeyID()
+	dst, err := OpenOptions(tmpName, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range db.Names() {
+		dst.PutName(name)
+	}
+
+	// the codes of dictionary strings stay the same
+	for _, value := range db.Dictionary() {
+		dst.PutDictionaryValue(value)
+	}
+
+	var key []byte
+	err = db.ForEach(func(id uint64, obj *Object) error {
+		if db.keys != nil {
+			var latest bool
+			key, latest = db.keys.isLatest(key, id, obj)
+			if !latest {
+				return nil
+			}
+		}
+
+		return dst.addObject(obj)
+	})
+
+	if err != nil {
+		_ = dst.Close()
+		_ = removeDB(tmpName)
+		return fmt.Errorf("unable to compact: %w", err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	indexes := db.Indexes()
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	// all ids have changed, so no side file of the old ids must survive, even if the compacted copy has no
+	// replacement for it. The secondary indexes are rebuilt from scratch and Rollup derives its checkpoint
+	// from the rows again.
+	removeSideFiles(db.fname)
+	if err := renameDB(tmpName, db.fname); err != nil {
+		// the original file is still in place, unless only a side file could not be renamed
+		if openErr := db.reopen(); openErr != nil {
+			return fmt.Errorf("%w: unable to compact: %v: %v", ErrUnusable, err, openErr)
+		}
+		return fmt.Errorf("unable to compact: %w", err)
+	}
+
+	if err := db.reopen(); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnusable, err)
+	}
+
+	for _, name := range indexes {
+		if err := db.CreateIndex(name, IndexOptions{Routines: 1}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reopen opens the closed database file again without the state of the previous file.
+func (db *DB) reopen() error {
+	db.keys = nil
+	db.indexes = nil
+	return db.open()
+}
+
+// addObject appends a copy of the given object.
+func (db *DB) addObject(src *Object) error {
+	return db.Add(func(obj *Object) error {
+		obj.copyFrom(src)
+		return nil
+	})
+}
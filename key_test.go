@@ -0,0 +1,126 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPutLatestOnly(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir, err := ioutil2.TempDir("", "keyTest")
+		assertNil(t, err)
+
+		fname := filepath.Join(dir, "keydb.bin")
+		opts := Options{Compression: compress, Key: []string{"SensorId", "Timestamp"}}
+		db, err := OpenOptions(fname, opts)
+		assertNil(t, err)
+
+		colSensorId := db.PutName("SensorId")
+		colTimestamp := db.PutName("Timestamp")
+		colTemperature := db.PutName("Temperature")
+
+		put := func(sensorId uint32, temperature int8) {
+			err := db.Put(func(obj *Object) error {
+				obj.AddUint32(colSensorId, sensorId)
+				obj.AddUint32(colTimestamp, 1594204360)
+				obj.AddInt8(colTemperature, temperature)
+				return nil
+			})
+			assertNil(t, err)
+		}
+
+		put(1, 10)
+		put(2, 20)
+		put(1, 11)
+		assertNil(t, db.Flush())
+		put(1, 12)
+
+		if err := db.Put(func(obj *Object) error {
+			obj.AddUint32(colSensorId, 3)
+			return nil
+		}); err == nil {
+			t.Fatalf("expected error for object without key")
+		}
+
+		assertNil(t, db.Close())
+
+		db, err = OpenOptions(fname, opts)
+		assertNil(t, err)
+
+		temperatures := func() map[uint32]int8 {
+			res := make(map[uint32]int8)
+			mutex := sync.Mutex{}
+			err := db.Scan(ScanOptions{Routines: 2, LatestOnly: true}, func(gid int, id uint64, obj *Object) error {
+				var sensorId uint32
+				var temperature int8
+				obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+					switch name {
+					case colSensorId:
+						sensorId = f.ReadUint32()
+					case colTemperature:
+						temperature = f.ReadInt8()
+					}
+				})
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				if _, ok := res[sensorId]; ok {
+					return fmt.Errorf("sensor %d visited twice", sensorId)
+				}
+				res[sensorId] = temperature
+				return nil
+			})
+			assertNil(t, err)
+			return res
+		}
+
+		res := temperatures()
+		if len(res) != 2 || res[1] != 12 || res[2] != 20 {
+			t.Fatalf("unexpected latest versions %v", res)
+		}
+
+		assertNil(t, db.Compact())
+		if db.ObjectCount() != 2 {
+			t.Fatalf("expected 2 objects after compaction but got %d", db.ObjectCount())
+		}
+
+		res = temperatures()
+		if len(res) != 2 || res[1] != 12 || res[2] != 20 {
+			t.Fatalf("unexpected latest versions after compaction %v", res)
+		}
+
+		assertNil(t, db.Close())
+	}
+}
+
+func TestCompactUnusable(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "keyTest")
+	assertNil(t, err)
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "keydb.bin")
+	db, err := OpenOptions(fname, Options{Quiet: true, ZoneMaps: true, Key: []string{"SensorId"}})
+	assertNil(t, err)
+
+	colSensorId := db.PutName("SensorId")
+	for i := 0; i < 10; i++ {
+		assertNil(t, db.Put(func(obj *Object) error {
+			obj.AddUint32(colSensorId, uint32(i%3))
+			return nil
+		}))
+	}
+
+	// a non-empty directory in place of the zone maps can neither be replaced nor opened
+	assertNil(t, os.Remove(fname+zoneMapSuffix))
+	assertNil(t, os.MkdirAll(filepath.Join(fname+zoneMapSuffix, "blocker"), os.ModePerm))
+
+	if err := db.Compact(); !errors.Is(err, ErrUnusable) {
+		t.Fatalf("expected ErrUnusable but got %v", err)
+	}
+}
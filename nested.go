@@ -0,0 +1,183 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"math"
+)
+
+// Field types beyond those of ioutil, which do not know them. Their values carry their size, so that they
+// are skipped in O(1) like fixed width values.
+const (
+	// TObject is an embedded object with the same framing as Object, i.e. its size as uint24, including the
+	// size itself, followed by its field count as uint16 and its fields.
+	TObject ioutil.Type = 32
+
+	// TArray is a homogeneous array of fixed width numbers, i.e. the element type as uint8, followed by the
+	// amount of elements as uint32 and the elements.
+	TArray ioutil.Type = 33
+)
+
+// drain moves the buffer behind the value of the given type, whose type byte has already been read. Unlike
+// DrainFast of ioutil, which panics for unknown types, embedded objects and arrays are skipped by their
+// size prefix and the scalar types by their fixed width. A malformed value moves the buffer to its end, see
+// skip.
+func drain(buf *ioutil.LittleEndianBuffer, kind ioutil.Type) {
+	if err := skip(buf, kind); err != nil {
+		buf.Pos = len(buf.Bytes)
+	}
+}
+
+// skip is drain for values which may be malformed, because they are read from a file, and returns an error
+// instead of moving the buffer beyond its end. The errors are phrased to follow the word object.
+func skip(buf *ioutil.LittleEndianBuffer, kind ioutil.Type) error {
+	b := buf.Bytes[buf.Pos:]
+	switch kind {
+	case TObject:
+		if len(b) < offsetFieldList {
+			return fmt.Errorf("has a truncated embedded object")
+		}
+
+		size := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		if size < offsetFieldList || size > len(b) {
+			return fmt.Errorf("has an embedded object with an invalid size of %d bytes", size)
+		}
+		buf.Pos += size
+	case TArray:
+		if len(b) < 5 {
+			return fmt.Errorf("has a truncated array")
+		}
+
+		size := elementSize(ioutil.Type(b[0]))
+		if size == 0 {
+			return fmt.Errorf("has an array of the unsupported type %d", b[0])
+		}
+
+		n := uint64(binary.LittleEndian.Uint32(b[1:])) * uint64(size)
+		if n > uint64(len(b)-5) {
+			return fmt.Errorf("has a truncated array")
+		}
+		buf.Pos += 5 + int(n)
+	case TNull:
+	case TBool:
+		buf.Pos++
+	case TTimestamp:
+		buf.Pos += 8
+	case TUUID:
+		buf.Pos += 16
+	case TDecimal:
+		buf.Pos += 9
+	case tTimestampDelta:
+		_, n := binary.Varint(b)
+		if n <= 0 {
+			return fmt.Errorf("has a malformed timestamp delta")
+		}
+		buf.Pos += n
+	case TDictString:
+		if len(b) < 5 {
+			return fmt.Errorf("has a truncated dictionary string")
+		}
+
+		buf.Pos += 4
+		return skip(buf, buf.ReadType())
+	case tDictRef:
+		_, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("has a malformed dictionary code")
+		}
+		buf.Pos += n
+	case tVarint, tDelta:
+		_, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("has a malformed encoded integer")
+		}
+		buf.Pos += n
+	case tXOR:
+		if len(b) == 0 {
+			return fmt.Errorf("has a truncated encoded float")
+		}
+		buf.Pos += xorSize(b)
+	default:
+		if buf.DrainFast(kind) == -1 {
+			buf.Drain(kind)
+		}
+	}
+
+	if buf.Pos > len(buf.Bytes) {
+		return fmt.Errorf("has a truncated field of type %d", kind)
+	}
+
+	return nil
+}
+
+// elementSize returns the width of a fixed width number, which may be an array element, or 0 for all other
+// types.
+func elementSize(kind ioutil.Type) int {
+	switch {
+	case kind >= ioutil.TUint8 && kind <= ioutil.TUint64:
+		return int(kind-ioutil.TUint8) + 1
+	case kind >= ioutil.TInt8 && kind <= ioutil.TInt64:
+		return int(kind-ioutil.TInt8) + 1
+	case kind == ioutil.TFloat32:
+		return 4
+	case kind == ioutil.TFloat64:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// elementInt decodes an array element of any type as int64, see also FieldReader.ReadInt.
+func elementInt(kind ioutil.Type, b []byte) int64 {
+	switch kind {
+	case ioutil.TFloat32:
+		return int64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case ioutil.TFloat64:
+		return int64(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	}
+
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+
+	if kind >= ioutil.TInt8 && kind <= ioutil.TInt64 {
+		shift := uint(64 - 8*len(b))
+		return int64(v<<shift) >> shift
+	}
+
+	return int64(v)
+}
+
+// elementFloat decodes an array element of any type as float64.
+func elementFloat(kind ioutil.Type, b []byte) float64 {
+	switch kind {
+	case ioutil.TFloat32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case ioutil.TFloat64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case ioutil.TUint64:
+		return float64(binary.LittleEndian.Uint64(b))
+	default:
+		return float64(elementInt(kind, b))
+	}
+}
+
+// verifyNested checks an embedded object or an array, which the buffer points to after its type byte, like
+// verifyObject, whose error phrasing it follows.
+func verifyNested(buf *ioutil.LittleEndianBuffer, kind ioutil.Type, nameCount int) error {
+	b := buf.Bytes[buf.Pos:]
+	if err := skip(&ioutil.LittleEndianBuffer{Bytes: b}, kind); err != nil {
+		return err
+	}
+
+	if kind == TObject {
+		size := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		if err := verifyObject(b[:size], nameCount); err != nil {
+			return fmt.Errorf("has an embedded object which %w", err)
+		}
+	}
+
+	return nil
+}
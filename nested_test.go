@@ -0,0 +1,158 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNested(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "nestedTest")
+	assertNil(t, err)
+
+	db, err := OpenOptions(filepath.Join(dir, "db.bin"), Options{Quiet: true})
+	assertNil(t, err)
+	defer db.Close()
+
+	id := db.PutName("id")
+	blades := db.PutName("blades")
+	temps := db.PutName("temps")
+	counts := db.PutName("counts")
+	pos := db.PutName("pos")
+
+	for i := 0; i < 3; i++ {
+		assertNil(t, db.Add(func(obj *Object) error {
+			obj.AddInt(id, int64(i))
+			obj.AddObject(blades, func(f *FieldWriter) {
+				f.WriteName(temps)
+				f.WriteFloat32Array([]float32{1.5, float32(i)})
+				f.WriteName(pos)
+				start := f.BeginObject()
+				f.WriteName(id)
+				f.WriteInt(int64(i * 10))
+				f.EndObject(start)
+			})
+			obj.AddUint32Array(counts, []uint32{uint32(i), 1 << 31})
+			obj.AddInt(id, -1)
+			return nil
+		}))
+	}
+	assertNil(t, db.Flush())
+	assertNil(t, db.Verify())
+
+	var lines []string
+	assertNil(t, db.ForEach(func(_ uint64, obj *Object) error {
+		line := ""
+		var visit func(obj *Object)
+		visit = func(obj *Object) {
+			obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+				line += db.NameByIndex(int(name)) + "="
+				switch kind {
+				case TObject:
+					line += "{"
+					visit(f.ReadObject())
+					line += "}"
+				case TArray:
+					if f.ArrayLen() != 2 {
+						t.Fatalf("unexpected array length %d", f.ArrayLen())
+					}
+					if name == temps {
+						line += fmt.Sprint(f.ReadFloat32Array(nil))
+					} else {
+						line += fmt.Sprint(f.ReadUint32Array(nil))
+					}
+				default:
+					line += fmt.Sprint(f.ReadInt())
+				}
+				line += " "
+			})
+		}
+		visit(obj)
+		lines = append(lines, line)
+		return nil
+	}))
+
+	if expected := "id=2 blades={temps=[1.5 2] pos={id=20 } } counts=[2 2147483648] id=-1 "; lines[2] != expected {
+		t.Fatalf("expected %q but got %q", expected, lines[2])
+	}
+
+	// encoded objects are verified and their nested names are translated
+	obj := NewObject(1024)
+	b, err := obj.Encode(func(obj *Object) error {
+		obj.AddObject(1, func(f *FieldWriter) {
+			f.WriteName(0)
+			f.WriteFloat64Array([]float64{0.5})
+		})
+		return nil
+	})
+	assertNil(t, err)
+	assertNil(t, db.AddEncoded(b, []uint16{temps, blades}))
+	valid := append([]byte(nil), b...)
+
+	if err := VerifyObject(b, 1); err == nil {
+		t.Fatal("expected an error for an unknown nested name")
+	}
+
+	b[offsetFieldList+3+offsetFieldList+3]++
+	if err := VerifyObject(b, 2); err == nil {
+		t.Fatal("expected an error for an unsupported array type")
+	}
+
+	// a batch is added entirely or not at all
+	count := db.ObjectCount()
+	if _, err := db.AddEncodedBatch([][]byte{valid, b}, []uint16{temps, blades}); err == nil || db.ObjectCount() != count {
+		t.Fatalf("expected an error without added objects but got %d objects: %v", db.ObjectCount(), err)
+	}
+
+	assertNil(t, db.Flush())
+	assertNil(t, db.Verify())
+	encodedID, err := db.SeekOrdinal(3)
+	assertNil(t, err)
+	assertNil(t, db.Read(encodedID, func(obj *Object) error {
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			f.ReadObject().WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+				if name != temps || fmt.Sprint(f.ReadFloat64Array(nil)) != "[0.5]" {
+					t.Fatalf("unexpected nested field %d", name)
+				}
+			})
+		})
+		return nil
+	}))
+	offset, err := db.AddEncodedBatch([][]byte{valid, valid}, []uint16{temps, blades})
+	assertNil(t, err)
+	assertNil(t, db.Flush())
+	if stats := db.RecordsFrom(offset); len(stats) != 1 || stats[0].Offset != offset || stats[0].ObjectCount != 2 {
+		t.Fatalf("expected the batch in the record at offset %d but got %+v", offset, stats)
+	}
+}
+
+func TestNestedMalformed(t *testing.T) {
+	// an object with a single field of the given type and value
+	object := func(kind ioutil.Type, value ...byte) []byte {
+		b := append([]byte{0, 0, 0, 1, 0, 0, 0, byte(kind)}, value...)
+		b[0], b[1], b[2] = byte(len(b)), byte(len(b)>>8), byte(len(b)>>16)
+		return b
+	}
+
+	for _, tc := range []struct {
+		obj []byte
+		err string
+	}{
+		{object(tVarint, 0xff, 0xff), "has a malformed encoded integer"},
+		{object(tTimestampDelta, 0x80), "has a malformed timestamp delta"},
+		{object(tDictRef), "has a malformed dictionary code"},
+		{object(TObject, 0xff, 0xff, 0xff, 0, 0), "has an embedded object with an invalid size of 16777215 bytes"},
+		{object(TArray, byte(ioutil.TUint32), 0xff, 0xff, 0, 0), "has a truncated array"},
+	} {
+		if err := verifyObject(tc.obj, -1); err == nil || err.Error() != tc.err {
+			t.Fatalf("expected verification error '%s' but got %v", tc.err, err)
+		}
+
+		var ctx recordContext
+		if _, err := (&Record{}).resolve(tc.obj, &ctx); err == nil || err.Error() != tc.err {
+			t.Fatalf("expected resolve error '%s' but got %v", tc.err, err)
+		}
+	}
+}
@@ -17,7 +17,7 @@ const (
 //     {
 //       - name           uint16, at most 65.536 per object file
 //       - fieldType      uint8
-//       - value          variable, depending on type
+//       - value          variable, depending on type, see also TObject and TArray
 //     }
 type Object struct {
 	buf        *ioutil.LittleEndianBuffer
@@ -134,7 +134,8 @@ func(d *Object) FieldReader()*FieldReader{
 
 
 // WithFields iterates over each available field. This is the fastest
-// thing we can do.
+// thing we can do. Embedded objects are visited as a single field, whose
+// fields are iterated by calling WithFields on FieldReader.ReadObject.
 func (d *Object) WithFields(f func(name uint16, kind ioutil.Type, f *FieldReader)) {
 	count := int(d.FieldCount())
 	d.buf.Pos = offsetFieldList
@@ -147,9 +148,7 @@ func (d *Object) WithFields(f func(name uint16, kind ioutil.Type, f *FieldReader
 
 		// reset the pos to ensure we are correct, independently what f has done
 		d.buf.Pos = myDrainPos
-		if i := d.buf.DrainFast(kind); i == -1 {
-			d.buf.Drain(kind)
-		}
+		drain(d.buf, kind)
 	}
 }
 
@@ -163,6 +162,13 @@ func (d *Object) AddField(name uint16, f func(f *FieldWriter)) {
 	d.setFieldCount(count + 1)
 }
 
+// copyFrom replaces the entire content with a copy of the given object.
+func (d *Object) copyFrom(src *Object) {
+	copy(d.buf.Bytes, src.Bytes())
+	d.setSize(src.Size())
+	d.setFieldCount(src.FieldCount())
+}
+
 // Reset sets the length to the minimum length
 func (d *Object) resetWrite() {
 	d.setSize(offsetFieldList)
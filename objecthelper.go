@@ -1,5 +1,7 @@
 package logdb
 
+import "time"
+
 func (d *Object) AddFloat(name uint16, v float64) {
 	count := d.FieldCount()
 	d.buf.Pos = int(d.Size())
@@ -44,3 +46,79 @@ func (d *Object) AddString(name uint16, v string) {
 	d.setSize(uint32(d.buf.Pos))
 	d.setFieldCount(count + 1)
 }
+
+// AddNull appends an explicit null, which unlike a missing field states that the value is unknown.
+func (d *Object) AddNull(name uint16) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteNull()
+	})
+}
+
+func (d *Object) AddBool(name uint16, v bool) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteBool(v)
+	})
+}
+
+// AddTimestamp appends the point in time with nanosecond precision. Times before 1678 or after 2262 cannot be
+// represented, see TTimestamp.
+func (d *Object) AddTimestamp(name uint16, t time.Time) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteTimestamp(t)
+	})
+}
+
+func (d *Object) AddUUID(name uint16, v UUID) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteUUID(v)
+	})
+}
+
+func (d *Object) AddDecimal(name uint16, v Decimal) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteDecimal(v)
+	})
+}
+
+// AddDictString appends a string, which is stored as code of the dictionary of the database, when the object
+// is added, so that repeated values like status codes only occupy a few bytes. Readers get the value by
+// FieldReader.ReadMutableString and the code by FieldReader.ReadDictionaryCode. If the dictionary is full, the
+// value is stored as plain string.
+func (d *Object) AddDictString(name uint16, v string) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteDictString(v)
+	})
+}
+
+// AddObject appends an embedded object, whose fields are written by f, see FieldWriter.WriteName.
+func (d *Object) AddObject(name uint16, f func(f *FieldWriter)) {
+	d.AddField(name, func(w *FieldWriter) {
+		start := w.BeginObject()
+		f(w)
+		w.EndObject(start)
+	})
+}
+
+func (d *Object) AddFloat32Array(name uint16, v []float32) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteFloat32Array(v)
+	})
+}
+
+func (d *Object) AddFloat64Array(name uint16, v []float64) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteFloat64Array(v)
+	})
+}
+
+func (d *Object) AddInt64Array(name uint16, v []int64) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteInt64Array(v)
+	})
+}
+
+func (d *Object) AddUint32Array(name uint16, v []uint32) {
+	d.AddField(name, func(f *FieldWriter) {
+		f.WriteUint32Array(v)
+	})
+}
@@ -0,0 +1,65 @@
+package logdb
+
+// Options configures a database when opening it with OpenOptions.
+type Options struct {
+	// Mmap maps the entire file into memory for reading instead of using pread.
+	Mmap bool
+
+	// Compression compresses each record using lz4. The flag is not persisted and must always match the
+	// way the file has been written.
+	Compression bool
+
+	// Key declares the names whose values together form the primary key of an object. If set, a key index
+	// is built while opening and kept up to date by Add and Put, so that scans can yield the latest version
+	// of each key only. Objects which miss any of the key names are not keyed and are always visited.
+	Key []string
+
+	// ZoneMaps collects the minimum, maximum and null count of each numeric field per record and persists
+	// them in a side file. Scans with ranges use them to skip entire records without reading them. An existing
+	// side file is used and kept up to date, even if ZoneMaps is not set.
+	ZoneMaps bool
+
+	// BloomFilters declares the names for which a bloom filter is built per record and persisted in a side
+	// file. Scans with equality predicates use them to skip records which definitely do not contain a value.
+	// If no names are declared, the names of an existing side file are used.
+	BloomFilters []string
+
+	// TimestampDeltas stores the top level timestamps of each record, except the first one, as varint delta
+	// to the first one, which needs 1 to 7 instead of 8 bytes for timestamps which are close together.
+	// Reading resolves them transparently, but reading a single object with deltas costs a scan of its
+	// record. Records with and without deltas can be mixed, so the flag needs not to match the file.
+	TimestampDeltas bool
+
+	// Encodings declares the names whose top level numbers are stored relative to their record, e.g. integers
+	// which increase by small steps as EncodingDelta. Like TimestampDeltas, encoded values are only used if
+	// they are shorter, reading resolves them transparently into their original types and the encodings
+	// need not to match the file.
+	Encodings map[string]Encoding
+
+	// Columnar stores each flushed record in the columnar layout, i.e. with a chunk per name, so that scans
+	// with ScanOptions.Columns only decode the chunks they need. Records are still read, decrypted and
+	// decompressed as a whole. A record keeps the row layout, if it contains
+	// relative values, if the fields of its objects have no common order, or if it would grow. Reading
+	// transposes columnar records back into objects transparently, but once a file contains a columnar
+	// record, Read needs to look up the record of each id. Both layouts can be mixed in a file, so the flag
+	// needs not to match the file.
+	Columnar bool
+
+	// Keys enables the encryption of records and names with AES-256-GCM. A new database is encrypted with the
+	// key KeyID and an existing one with the key whose id is stored in its header. Unlike compression, the
+	// encryption is persisted, but side files like zone maps, bloom filters and secondary indexes are not
+	// encrypted.
+	Keys KeyProvider
+
+	// KeyID is the id of the key, which encrypts a new database.
+	KeyID string
+
+	// ReadOnly opens an existing database file only for reading, e.g. next to a process which writes into it.
+	// Neither the file nor its side files are written, so records which are missing in the record index are
+	// only indexed in memory, while missing zone maps, bloom filters and outdated secondary indexes are not
+	// used. Adding objects and other modifications fail with ErrReadOnly.
+	ReadOnly bool
+
+	// Quiet suppresses the diagnostic output on stdout, e.g. while opening or scanning.
+	Quiet bool
+}
@@ -0,0 +1,338 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Expr is a typed expression which is evaluated per object. Field references are bound to the names of a
+// database, when a query is executed.
+type Expr interface {
+	// Eval computes the value for the decoded fields of the current object.
+	Eval(row *Row) Value
+
+	// String returns the expression in a SQL like notation.
+	String() string
+
+	// bind returns a copy of the expression whose field references have been resolved.
+	bind(b *binder) Expr
+}
+
+// Row contains the decoded values of all fields which are referenced by a query. The values are only valid
+// within the callback of a single object.
+type Row struct {
+	values []Value
+}
+
+// binder assigns a slot within the row to each distinct field name.
+type binder struct {
+	slots map[string]int
+	names []string
+}
+
+func (b *binder) slot(name string) int {
+	if slot, ok := b.slots[name]; ok {
+		return slot
+	}
+
+	slot := len(b.names)
+	b.slots[name] = slot
+	b.names = append(b.names, name)
+	return slot
+}
+
+// Op is the operator of a comparison, logical or arithmetic expression.
+type Op uint8
+
+const (
+	OpEq Op = iota
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	OpAnd
+	OpOr
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+)
+
+var opNames = [...]string{"=", "!=", "<", "<=", ">", ">=", "AND", "OR", "+", "-", "*", "/"}
+
+func (o Op) String() string {
+	if int(o) < len(opNames) {
+		return opNames[o]
+	}
+	return "op(" + strconv.Itoa(int(o)) + ")"
+}
+
+// FieldRef evaluates to the first value of the field with the given name or null, if the object does not
+// contain it. Numbers of any width become ints or floats and strings and blobs become strings.
+type FieldRef struct {
+	Name string
+	slot int
+}
+
+// Field references a field by name.
+func Field(name string) *FieldRef {
+	return &FieldRef{Name: name}
+}
+
+func (f *FieldRef) Eval(row *Row) Value {
+	return row.values[f.slot]
+}
+
+func (f *FieldRef) String() string {
+	return f.Name
+}
+
+func (f *FieldRef) bind(b *binder) Expr {
+	return &FieldRef{Name: f.Name, slot: b.slot(f.Name)}
+}
+
+// Const evaluates to a constant value.
+type Const struct {
+	Value Value
+}
+
+// Int returns an int constant.
+func Int(v int64) *Const {
+	return &Const{Value: IntValue(v)}
+}
+
+// Float returns a float constant.
+func Float(v float64) *Const {
+	return &Const{Value: FloatValue(v)}
+}
+
+// String returns a string constant.
+func String(v string) *Const {
+	return &Const{Value: StringValue(v)}
+}
+
+// Bool returns a bool constant.
+func Bool(v bool) *Const {
+	return &Const{Value: BoolValue(v)}
+}
+
+func (c *Const) Eval(row *Row) Value {
+	return c.Value
+}
+
+func (c *Const) String() string {
+	if c.Value.Kind == KindString {
+		return "'" + strings.Replace(string(c.Value.B), "'", "''", -1) + "'"
+	}
+	return c.Value.String()
+}
+
+func (c *Const) bind(b *binder) Expr {
+	return c
+}
+
+// Comparison compares two values, see Compare. It evaluates to null, if any side is null or if a number is
+// compared with a value which is not a number.
+type Comparison struct {
+	Op    Op
+	Left  Expr
+	Right Expr
+}
+
+func Eq(l, r Expr) *Comparison { return &Comparison{Op: OpEq, Left: l, Right: r} }
+func Ne(l, r Expr) *Comparison { return &Comparison{Op: OpNe, Left: l, Right: r} }
+func Lt(l, r Expr) *Comparison { return &Comparison{Op: OpLt, Left: l, Right: r} }
+func Le(l, r Expr) *Comparison { return &Comparison{Op: OpLe, Left: l, Right: r} }
+func Gt(l, r Expr) *Comparison { return &Comparison{Op: OpGt, Left: l, Right: r} }
+func Ge(l, r Expr) *Comparison { return &Comparison{Op: OpGe, Left: l, Right: r} }
+
+// Between is true, if lo <= e <= hi.
+func Between(e, lo, hi Expr) *Logical {
+	return And(Ge(e, lo), Le(e, hi))
+}
+
+func (c *Comparison) Eval(row *Row) Value {
+	l, r := c.Left.Eval(row), c.Right.Eval(row)
+	if l.Kind == KindNull || r.Kind == KindNull || l.IsNumber() != r.IsNumber() {
+		return Value{}
+	}
+
+	cmp := Compare(l, r)
+	switch c.Op {
+	case OpEq:
+		return BoolValue(cmp == 0)
+	case OpNe:
+		return BoolValue(cmp != 0)
+	case OpLt:
+		return BoolValue(cmp < 0)
+	case OpLe:
+		return BoolValue(cmp <= 0)
+	case OpGt:
+		return BoolValue(cmp > 0)
+	case OpGe:
+		return BoolValue(cmp >= 0)
+	default:
+		return Value{}
+	}
+}
+
+func (c *Comparison) String() string {
+	return "(" + c.Left.String() + " " + c.Op.String() + " " + c.Right.String() + ")"
+}
+
+func (c *Comparison) bind(b *binder) Expr {
+	return &Comparison{Op: c.Op, Left: c.Left.bind(b), Right: c.Right.bind(b)}
+}
+
+// Logical combines bools using three valued logic: AND is false if any argument is false, OR is true if any
+// argument is true and otherwise the result is null, if any argument is null or not a bool.
+type Logical struct {
+	Op   Op
+	Args []Expr
+}
+
+func And(args ...Expr) *Logical { return &Logical{Op: OpAnd, Args: args} }
+func Or(args ...Expr) *Logical  { return &Logical{Op: OpOr, Args: args} }
+
+func (l *Logical) Eval(row *Row) Value {
+	decisive := l.Op == OpOr // the value which decides the result on its own
+	unknown := false
+	for _, arg := range l.Args {
+		v := arg.Eval(row)
+		if v.Kind != KindBool {
+			unknown = true
+			continue
+		}
+
+		if (v.I != 0) == decisive {
+			return BoolValue(decisive)
+		}
+	}
+
+	if unknown {
+		return Value{}
+	}
+
+	return BoolValue(!decisive)
+}
+
+func (l *Logical) String() string {
+	args := make([]string, len(l.Args))
+	for i, arg := range l.Args {
+		args[i] = arg.String()
+	}
+	return "(" + strings.Join(args, " "+l.Op.String()+" ") + ")"
+}
+
+func (l *Logical) bind(b *binder) Expr {
+	args := make([]Expr, len(l.Args))
+	for i, arg := range l.Args {
+		args[i] = arg.bind(b)
+	}
+	return &Logical{Op: l.Op, Args: args}
+}
+
+// Negation inverts a bool and evaluates to null otherwise.
+type Negation struct {
+	Arg Expr
+}
+
+func Not(e Expr) *Negation { return &Negation{Arg: e} }
+
+func (n *Negation) Eval(row *Row) Value {
+	v := n.Arg.Eval(row)
+	if v.Kind != KindBool {
+		return Value{}
+	}
+	return BoolValue(v.I == 0)
+}
+
+func (n *Negation) String() string {
+	return "(NOT " + n.Arg.String() + ")"
+}
+
+func (n *Negation) bind(b *binder) Expr {
+	return &Negation{Arg: n.Arg.bind(b)}
+}
+
+// Arithmetic computes with numbers. Ints stay ints, except for divisions, which always result in floats.
+// It evaluates to null, if any side is null or not a number or for a division by zero.
+type Arithmetic struct {
+	Op    Op
+	Left  Expr
+	Right Expr
+}
+
+func Add(l, r Expr) *Arithmetic { return &Arithmetic{Op: OpAdd, Left: l, Right: r} }
+func Sub(l, r Expr) *Arithmetic { return &Arithmetic{Op: OpSub, Left: l, Right: r} }
+func Mul(l, r Expr) *Arithmetic { return &Arithmetic{Op: OpMul, Left: l, Right: r} }
+func Div(l, r Expr) *Arithmetic { return &Arithmetic{Op: OpDiv, Left: l, Right: r} }
+
+func (a *Arithmetic) Eval(row *Row) Value {
+	l, r := a.Left.Eval(row), a.Right.Eval(row)
+	if !l.IsNumber() || !r.IsNumber() {
+		return Value{}
+	}
+
+	if l.Kind == KindInt && r.Kind == KindInt && a.Op != OpDiv {
+		switch a.Op {
+		case OpAdd:
+			return IntValue(l.I + r.I)
+		case OpSub:
+			return IntValue(l.I - r.I)
+		case OpMul:
+			return IntValue(l.I * r.I)
+		}
+	}
+
+	x, _ := l.Float()
+	y, _ := r.Float()
+	switch a.Op {
+	case OpAdd:
+		return FloatValue(x + y)
+	case OpSub:
+		return FloatValue(x - y)
+	case OpMul:
+		return FloatValue(x * y)
+	case OpDiv:
+		if y == 0 {
+			return Value{}
+		}
+		return FloatValue(x / y)
+	default:
+		return Value{}
+	}
+}
+
+func (a *Arithmetic) String() string {
+	return "(" + a.Left.String() + " " + a.Op.String() + " " + a.Right.String() + ")"
+}
+
+func (a *Arithmetic) bind(b *binder) Expr {
+	return &Arithmetic{Op: a.Op, Left: a.Left.bind(b), Right: a.Right.bind(b)}
+}
+
+// NullCheck is true, if the argument is null, or with Not set, if it is not null.
+type NullCheck struct {
+	Arg Expr
+	Not bool
+}
+
+func IsNull(e Expr) *NullCheck    { return &NullCheck{Arg: e} }
+func IsNotNull(e Expr) *NullCheck { return &NullCheck{Arg: e, Not: true} }
+
+func (n *NullCheck) Eval(row *Row) Value {
+	return BoolValue(n.Arg.Eval(row).IsNull() != n.Not)
+}
+
+func (n *NullCheck) String() string {
+	if n.Not {
+		return "(" + n.Arg.String() + " IS NOT NULL)"
+	}
+	return "(" + n.Arg.String() + " IS NULL)"
+}
+
+func (n *NullCheck) bind(b *binder) Expr {
+	return &NullCheck{Arg: n.Arg.bind(b), Not: n.Not}
+}
@@ -0,0 +1,430 @@
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"io"
+	ioutil2 "io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// spillPartitions is the amount of files per routine into which groups are spilled by the hash of their key.
+// Each partition is aggregated on its own afterwards, so that only a fraction of all groups is in memory.
+const spillPartitions = 16
+
+const defaultMaxGroups = 1 << 20
+
+// aggState is the state of a single aggregated column within a group.
+type aggState struct {
+	count int64
+	isum  int64
+	fsum  float64
+	float bool // at least one float has been summed up
+	min   Value
+	max   Value
+}
+
+func (s *aggState) update(f Func, v Value, countAll bool) {
+	if countAll {
+		s.count++
+		return
+	}
+
+	if v.Kind == KindNull {
+		return
+	}
+
+	switch f {
+	case FuncCount:
+		s.count++
+	case FuncSum, FuncAvg:
+		switch v.Kind {
+		case KindInt:
+			s.isum += v.I
+		case KindFloat:
+			s.fsum += v.F
+			s.float = true
+		default:
+			return
+		}
+		s.count++
+	case FuncMin:
+		if s.count == 0 || Compare(v, s.min) < 0 {
+			s.min = Value{Kind: v.Kind, I: v.I, F: v.F, B: append(s.min.B[:0], v.B...)}
+		}
+		s.count++
+	case FuncMax:
+		if s.count == 0 || Compare(v, s.max) > 0 {
+			s.max = Value{Kind: v.Kind, I: v.I, F: v.F, B: append(s.max.B[:0], v.B...)}
+		}
+		s.count++
+	}
+}
+
+func (s *aggState) merge(o *aggState) {
+	if o.count == 0 {
+		return
+	}
+
+	if s.count == 0 || Compare(o.min, s.min) < 0 {
+		s.min = o.min.Clone()
+	}
+	if s.count == 0 || Compare(o.max, s.max) > 0 {
+		s.max = o.max.Clone()
+	}
+	s.count += o.count
+	s.isum += o.isum
+	s.fsum += o.fsum
+	s.float = s.float || o.float
+}
+
+func (s *aggState) result(f Func) Value {
+	if f == FuncCount {
+		return IntValue(s.count)
+	}
+
+	if s.count == 0 {
+		return Value{}
+	}
+
+	switch f {
+	case FuncSum:
+		if s.float {
+			return FloatValue(s.fsum + float64(s.isum))
+		}
+		return IntValue(s.isum)
+	case FuncAvg:
+		return FloatValue((s.fsum + float64(s.isum)) / float64(s.count))
+	case FuncMin:
+		return s.min
+	case FuncMax:
+		return s.max
+	default:
+		return Value{}
+	}
+}
+
+// appendState appends the serialized state for spill files.
+//
+// Format specification:
+//  - count               int64
+//  - isum                int64
+//  - fsum                float64
+//  - float               uint8
+//  - min                 value, see appendValue
+//  - max                 value, see appendValue
+func appendState(dst []byte, s *aggState) []byte {
+	dst = appendUint64(dst, uint64(s.count))
+	dst = appendUint64(dst, uint64(s.isum))
+	dst = appendUint64(dst, math.Float64bits(s.fsum))
+	if s.float {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	dst = appendValue(dst, s.min)
+	return appendValue(dst, s.max)
+}
+
+func readState(b []byte, s *aggState) ([]byte, error) {
+	if len(b) < 25 {
+		return nil, fmt.Errorf("unexpected end of state")
+	}
+
+	s.count = int64(readUint64(b))
+	s.isum = int64(readUint64(b[8:]))
+	s.fsum = math.Float64frombits(readUint64(b[16:]))
+	s.float = b[24] != 0
+
+	var err error
+	if s.min, b, err = readValue(b[25:]); err != nil {
+		return nil, err
+	}
+
+	s.max, b, err = readValue(b)
+	return b, err
+}
+
+// group contains the states of all aggregated columns for a single key.
+type group struct {
+	key    string // encoded values of the group by expressions, see appendValue
+	states []aggState
+}
+
+// groupTable is the hash table of a single routine, which is spilled into partition files when it grows
+// beyond the maximum amount of groups.
+type groupTable struct {
+	groups  map[string]*group
+	key     []byte
+	row     Row
+	spills  []*os.File
+	writers []*bufio.Writer
+	buf     []byte
+}
+
+func (t *groupTable) spill(p *plan, dir string, gid int) error {
+	if t.spills == nil {
+		for i := 0; i < spillPartitions; i++ {
+			file, err := os.Create(filepath.Join(dir, strconv.Itoa(gid)+"-"+strconv.Itoa(i)+".spill"))
+			if err != nil {
+				return err
+			}
+			t.spills = append(t.spills, file)
+			t.writers = append(t.writers, bufio.NewWriter(file))
+		}
+	}
+
+	for key, g := range t.groups {
+		t.buf = t.buf[:0]
+		t.buf = append(t.buf, byte(len(key)), byte(len(key)>>8), byte(len(key)>>16), byte(len(key)>>24))
+		t.buf = append(t.buf, key...)
+		for i := range g.states {
+			t.buf = appendState(t.buf, &g.states[i])
+		}
+
+		n := uint32(len(t.buf))
+		w := t.writers[partition(key)]
+		if _, err := w.Write([]byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}); err != nil {
+			return err
+		}
+		if _, err := w.Write(t.buf); err != nil {
+			return err
+		}
+	}
+
+	t.groups = make(map[string]*group)
+	return nil
+}
+
+// partition returns the spill partition of a key using FNV-1a.
+func partition(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % spillPartitions)
+}
+
+func (t *groupTable) close() {
+	for _, file := range t.spills {
+		_ = file.Close()
+	}
+}
+
+// readSpill merges all groups of a spill file into the table.
+func readSpill(p *plan, file *os.File, groups map[string]*group) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(file)
+	var lenBuf [4]byte
+	var buf []byte
+	var state aggState
+	for {
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		n := int(lenBuf[0]) | int(lenBuf[1])<<8 | int(lenBuf[2])<<16 | int(lenBuf[3])<<24
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+
+		keyLen := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16 | int(buf[3])<<24
+		key := buf[4 : 4+keyLen]
+		g, ok := groups[string(key)]
+		if !ok {
+			g = &group{key: string(key), states: make([]aggState, len(p.aggs))}
+			groups[g.key] = g
+		}
+
+		rest := buf[4+keyLen:]
+		for i := range g.states {
+			var err error
+			if rest, err = readState(rest, &state); err != nil {
+				return err
+			}
+			g.states[i].merge(&state)
+		}
+	}
+}
+
+// executeGrouped aggregates the matching objects per group in a hash table per routine. Tables which grow
+// too large are spilled to disk and merged partition by partition afterwards.
+func (p *plan) executeGrouped(opts logdb.ScanOptions, routines int) ([][]Value, error) {
+	maxGroups := p.q.MaxGroups
+	if maxGroups <= 0 {
+		maxGroups = defaultMaxGroups
+	}
+
+	dir, err := ioutil2.TempDir(p.q.TempDir, "logdb-query")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tables := make([]*groupTable, routines)
+	for i := range tables {
+		tables[i] = &groupTable{groups: make(map[string]*group), row: Row{values: make([]Value, p.nslots)}}
+	}
+
+	defer func() {
+		for _, t := range tables {
+			t.close()
+		}
+	}()
+
+	err = p.scan(opts, func(gid int, id uint64, obj *logdb.Object) error {
+		t := tables[gid]
+		if !p.filter(obj, &t.row) {
+			return nil
+		}
+
+		t.key = t.key[:0]
+		for _, e := range p.groupBy {
+			t.key = appendValue(t.key, e.Eval(&t.row))
+		}
+
+		g, ok := t.groups[string(t.key)]
+		if !ok {
+			g = &group{key: string(t.key), states: make([]aggState, len(p.aggs))}
+			t.groups[g.key] = g
+		}
+
+		for i, col := range p.aggs {
+			e := p.columns[col]
+			if e == nil {
+				g.states[i].update(FuncCount, Value{}, true)
+				continue
+			}
+			g.states[i].update(p.q.Select[col].Func, e.Eval(&t.row), false)
+		}
+
+		if len(t.groups) > maxGroups {
+			return t.spill(p, dir, gid)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	spilled := false
+	for _, t := range tables {
+		spilled = spilled || t.spills != nil
+	}
+
+	var rows [][]Value
+	if !spilled {
+		groups := tables[0].groups
+		for _, t := range tables[1:] {
+			mergeGroups(groups, t.groups)
+		}
+
+		if len(groups) == 0 && len(p.groupBy) == 0 {
+			// aggregates without group by always have a single row
+			groups[""] = &group{states: make([]aggState, len(p.aggs))}
+		}
+
+		if rows, err = p.appendRows(rows, groups); err != nil {
+			return nil, err
+		}
+
+		return p.sortAndLimit(rows), nil
+	}
+
+	for gid, t := range tables {
+		if err := t.spill(p, dir, gid); err != nil {
+			return nil, err
+		}
+
+		for _, w := range t.writers {
+			if err := w.Flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for part := 0; part < spillPartitions; part++ {
+		groups := make(map[string]*group)
+		for _, t := range tables {
+			if t.spills == nil {
+				continue
+			}
+
+			if err := readSpill(p, t.spills[part], groups); err != nil {
+				return nil, fmt.Errorf("unable to read spill file: %w", err)
+			}
+		}
+
+		if rows, err = p.appendRows(rows, groups); err != nil {
+			return nil, err
+		}
+
+		// keep only the best rows, so that the memory is bounded by the limit
+		if p.q.Limit > 0 && len(p.orderBy) > 0 {
+			rows = p.sortAndLimit(rows)
+		}
+	}
+
+	return p.sortAndLimit(rows), nil
+}
+
+func mergeGroups(dst, src map[string]*group) {
+	for key, g := range src {
+		existing, ok := dst[key]
+		if !ok {
+			dst[key] = g
+			continue
+		}
+
+		for i := range existing.states {
+			existing.states[i].merge(&g.states[i])
+		}
+	}
+}
+
+// appendRows appends a result row for each group.
+func (p *plan) appendRows(rows [][]Value, groups map[string]*group) ([][]Value, error) {
+	keys := make([]Value, len(p.groupBy))
+	for _, g := range groups {
+		rest := []byte(g.key)
+		for i := range keys {
+			var err error
+			if keys[i], rest, err = readValue(rest); err != nil {
+				return nil, err
+			}
+		}
+
+		row := make([]Value, len(p.columns))
+		agg := 0
+		for i, keyCol := range p.keyCols {
+			if keyCol >= 0 {
+				row[i] = keys[keyCol].Clone()
+				continue
+			}
+
+			row[i] = g.states[agg].result(p.q.Select[i].Func).Clone()
+			agg++
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,425 @@
+// Package query executes ad-hoc queries with projection, filter, group-by and order-by over the objects of
+// a database.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"sort"
+	"sync/atomic"
+)
+
+// Func is the aggregate function of a column.
+type Func uint8
+
+const (
+	FuncNone Func = iota
+	FuncCount
+	FuncSum
+	FuncMin
+	FuncMax
+	FuncAvg
+)
+
+var funcNames = [...]string{"", "count", "sum", "min", "max", "avg"}
+
+func (f Func) String() string {
+	if int(f) < len(funcNames) {
+		return funcNames[f]
+	}
+	return fmt.Sprintf("func(%d)", f)
+}
+
+// Column is a projected expression or an aggregate. For aggregates, a nil expression counts all objects.
+type Column struct {
+	Name string
+	Expr Expr
+	Func Func
+}
+
+// As projects an expression with the given name.
+func As(e Expr, name string) Column {
+	return Column{Name: name, Expr: e}
+}
+
+// Col projects an expression, which is named after itself.
+func Col(e Expr) Column {
+	return Column{Expr: e}
+}
+
+// Count counts the objects for which the expression is not null or all objects, if it is nil.
+func Count(e Expr) Column { return Column{Expr: e, Func: FuncCount} }
+
+// Sum adds up all numbers. The result is an int, as long as all numbers are ints.
+func Sum(e Expr) Column { return Column{Expr: e, Func: FuncSum} }
+
+// Min returns the smallest value, see Compare.
+func Min(e Expr) Column { return Column{Expr: e, Func: FuncMin} }
+
+// Max returns the largest value, see Compare.
+func Max(e Expr) Column { return Column{Expr: e, Func: FuncMax} }
+
+// Avg returns the mean of all numbers as a float.
+func Avg(e Expr) Column { return Column{Expr: e, Func: FuncAvg} }
+
+// Label returns the name of the column or derives one from the expression.
+func (c Column) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	arg := "*"
+	if c.Expr != nil {
+		arg = c.Expr.String()
+	}
+
+	if c.Func == FuncNone {
+		return arg
+	}
+
+	return c.Func.String() + "(" + arg + ")"
+}
+
+// Order sorts the result by the column with the given name.
+type Order struct {
+	Column string
+	Desc   bool
+}
+
+// Query describes what to select from a database.
+type Query struct {
+	// Select are the columns of the result. If any column is an aggregate, all other columns must be
+	// contained in GroupBy.
+	Select []Column
+
+	// Where filters the objects before grouping. Only objects for which it evaluates to true are selected.
+	Where Expr
+
+	// GroupBy groups the objects by the values of the expressions.
+	GroupBy []Expr
+
+	// OrderBy sorts the result by columns of Select.
+	OrderBy []Order
+
+	// Limit is the maximum amount of rows in the result or 0 for all.
+	Limit int
+
+	// Routines is the amount of go routines which scan the database.
+	Routines int
+
+	// Scan options are passed to the database to skip records using zone maps or bloom filters. They must
+	// not exclude any object which matches Where.
+	Scan logdb.ScanOptions
+
+	// MaxGroups is the amount of groups each routine keeps in memory, before it spills them to disk.
+	// Defaults to 1 million.
+	MaxGroups int
+
+	// TempDir is the directory for spill files and defaults to the system temp directory.
+	TempDir string
+
+	// Context cancels the scan, e.g. when the client of a request disconnects. Defaults to
+	// context.Background.
+	Context context.Context
+}
+
+// Result contains the selected rows.
+type Result struct {
+	Columns []string
+	Rows    [][]Value
+}
+
+// errLimitReached stops scanning, as soon as enough rows have been selected.
+var errLimitReached = errors.New("limit reached")
+
+// plan is a query whose expressions have been bound to the names of a database.
+type plan struct {
+	db      *logdb.DB
+	q       *Query
+	slots   []int32 // name index within the database to slot or -1
+	nslots  int
+	where   Expr
+	groupBy []Expr
+	columns []Expr // bound column expressions, nil for count(*)
+	aggs    []int  // indices of the aggregated columns
+	keyCols []int  // for grouped queries, the group-by index of each column or -1 for aggregates
+	orderBy []int
+	grouped bool
+}
+
+func newPlan(db *logdb.DB, q *Query) (*plan, error) {
+	if len(q.Select) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+
+	b := &binder{slots: make(map[string]int)}
+	p := &plan{db: db, q: q, grouped: len(q.GroupBy) > 0}
+
+	if q.Where != nil {
+		p.where = q.Where.bind(b)
+	}
+
+	for _, e := range q.GroupBy {
+		p.groupBy = append(p.groupBy, e.bind(b))
+	}
+
+	for i, c := range q.Select {
+		var e Expr
+		if c.Expr != nil {
+			e = c.Expr.bind(b)
+		} else if c.Func != FuncCount {
+			return nil, fmt.Errorf("column %s has no expression", c.Label())
+		}
+
+		p.columns = append(p.columns, e)
+		if c.Func != FuncNone {
+			p.aggs = append(p.aggs, i)
+			p.grouped = true
+		}
+	}
+
+	if p.grouped {
+		for _, c := range q.Select {
+			idx := -1
+			if c.Func == FuncNone {
+				for j, g := range q.GroupBy {
+					if g.String() == c.Expr.String() {
+						idx = j
+					}
+				}
+
+				if idx < 0 {
+					return nil, fmt.Errorf("column %s must be grouped or aggregated", c.Label())
+				}
+			}
+			p.keyCols = append(p.keyCols, idx)
+		}
+	}
+
+	for _, o := range q.OrderBy {
+		idx := -1
+		for i, c := range q.Select {
+			if c.Label() == o.Column {
+				idx = i
+			}
+		}
+
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown order by column %s", o.Column)
+		}
+		p.orderBy = append(p.orderBy, idx)
+	}
+
+	p.bindSlots(b)
+	return p, nil
+}
+
+// bindSlots maps the name indices of the database to the slots of the bound names.
+func (p *plan) bindSlots(b *binder) {
+	p.nslots = len(b.names)
+	p.slots = make([]int32, int(ioutil.MaxUint16)+1)
+	for i := range p.slots {
+		p.slots[i] = -1
+	}
+
+	for slot, name := range b.names {
+		if idx := p.db.IndexByName(name); idx >= 0 {
+			p.slots[idx] = int32(slot)
+		}
+	}
+}
+
+// scan walks over the objects like DB.Scan and stops with the error of the context, once it is done.
+func (p *plan) scan(opts logdb.ScanOptions, f func(gid int, id uint64, obj *logdb.Object) error) error {
+	if p.q.Context == nil {
+		return p.db.Scan(opts, f)
+	}
+
+	done := p.q.Context.Done()
+	return p.db.Scan(opts, func(gid int, id uint64, obj *logdb.Object) error {
+		select {
+		case <-done:
+			return p.q.Context.Err()
+		default:
+			return f(gid, id, obj)
+		}
+	})
+}
+
+// decode fills the row with the values of all referenced fields of the object.
+func (p *plan) decode(obj *logdb.Object, row *Row) {
+	for i := range row.values {
+		row.values[i] = Value{}
+	}
+
+	slots := p.slots
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+		slot := slots[name]
+		if slot < 0 || row.values[slot].Kind != KindNull {
+			return
+		}
+
+		switch {
+		case kind == ioutil.TFloat32 || kind == ioutil.TFloat64:
+			row.values[slot] = Value{Kind: KindFloat, F: f.ReadFloat()}
+		case kind >= ioutil.TBlob8 && kind <= ioutil.TString32 || kind == logdb.TDictString:
+			row.values[slot] = Value{Kind: KindString, B: f.ReadRaw()}
+		case kind.IsNumber():
+			row.values[slot] = Value{Kind: KindInt, I: f.ReadInt()}
+		}
+	})
+}
+
+// filter decodes the object and returns true, if it matches the where clause. It does not allocate.
+func (p *plan) filter(obj *logdb.Object, row *Row) bool {
+	p.decode(obj, row)
+	return p.where == nil || p.where.Eval(row).IsTrue()
+}
+
+// Filter matches objects against an expression, e.g. to stream the matching objects instead of collecting
+// them like Execute. A filter must only be used by a single go routine.
+type Filter struct {
+	plan *plan
+	row  Row
+}
+
+// NewFilter binds the expression to the names of the database.
+func NewFilter(db *logdb.DB, where Expr) *Filter {
+	b := &binder{slots: make(map[string]int)}
+	p := &plan{db: db, where: where.bind(b)}
+	p.bindSlots(b)
+
+	return &Filter{plan: p, row: Row{values: make([]Value, p.nslots)}}
+}
+
+// Clone returns a filter with the same expression for another go routine.
+func (f *Filter) Clone() *Filter {
+	return &Filter{plan: f.plan, row: Row{values: make([]Value, f.plan.nslots)}}
+}
+
+// Match returns true, if the expression evaluates to true for the object.
+func (f *Filter) Match(obj *logdb.Object) bool {
+	return f.plan.filter(obj, &f.row)
+}
+
+// less compares two result rows by the order by columns.
+func (p *plan) less(a, b []Value) bool {
+	for i, idx := range p.orderBy {
+		c := Compare(a[idx], b[idx])
+		if c == 0 {
+			continue
+		}
+
+		if p.q.OrderBy[i].Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	return false
+}
+
+// sortAndLimit sorts the rows, if required, and truncates them to the limit.
+func (p *plan) sortAndLimit(rows [][]Value) [][]Value {
+	if len(p.orderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return p.less(rows[i], rows[j])
+		})
+	}
+
+	if p.q.Limit > 0 && len(rows) > p.q.Limit {
+		rows = rows[:p.q.Limit]
+	}
+
+	return rows
+}
+
+// Execute runs the query in parallel and returns all selected rows.
+func Execute(db *logdb.DB, q Query) (*Result, error) {
+	p, err := newPlan(db, &q)
+	if err != nil {
+		return nil, err
+	}
+
+	routines := q.Routines
+	if routines < 1 {
+		routines = 1
+	}
+
+	opts := q.Scan
+	opts.Routines = routines
+
+	res := &Result{}
+	for _, c := range q.Select {
+		res.Columns = append(res.Columns, c.Label())
+	}
+
+	if p.grouped {
+		res.Rows, err = p.executeGrouped(opts, routines)
+	} else {
+		res.Rows, err = p.executeProjection(opts, routines)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// executeProjection evaluates the columns of each matching object.
+func (p *plan) executeProjection(opts logdb.ScanOptions, routines int) ([][]Value, error) {
+	type worker struct {
+		row  Row
+		rows [][]Value
+	}
+
+	workers := make([]worker, routines)
+	for i := range workers {
+		workers[i].row.values = make([]Value, p.nslots)
+	}
+
+	limit := p.q.Limit
+	ordered := len(p.orderBy) > 0
+	var selected int64
+
+	err := p.scan(opts, func(gid int, id uint64, obj *logdb.Object) error {
+		w := &workers[gid]
+		if !p.filter(obj, &w.row) {
+			return nil
+		}
+
+		out := make([]Value, len(p.columns))
+		for i, e := range p.columns {
+			out[i] = e.Eval(&w.row).Clone()
+		}
+		w.rows = append(w.rows, out)
+
+		if limit > 0 {
+			if ordered {
+				// keep only the best rows, so that the memory is bounded by the limit
+				if len(w.rows) >= 2*limit {
+					w.rows = p.sortAndLimit(w.rows)
+				}
+			} else if atomic.AddInt64(&selected, 1) >= int64(limit) {
+				return errLimitReached
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errLimitReached {
+		return nil, err
+	}
+
+	var rows [][]Value
+	for _, w := range workers {
+		rows = append(rows, w.rows...)
+	}
+
+	return p.sortAndLimit(rows), nil
+}
@@ -0,0 +1,162 @@
+package query
+
+import (
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"testing"
+)
+
+const (
+	testCount = 10_000
+	testStart = 1594166400
+)
+
+func openTestDB(t *testing.T) *logdb.DB {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	colSensorId := db.PutName("SensorId")
+	colTimestamp := db.PutName("Timestamp")
+	colTemperature := db.PutName("Temperature")
+	colName := db.PutName("Name")
+
+	for i := 0; i < testCount; i++ {
+		err := db.Add(func(obj *logdb.Object) error {
+			obj.AddUint32(colSensorId, uint32(i%10))
+			obj.AddUint32(colTimestamp, uint32(testStart+i*60))
+			obj.AddInt8(colTemperature, int8(i%50))
+			obj.AddString(colName, fmt.Sprintf("sensor-%d", i%10))
+			return nil
+		})
+		logdbtest.AssertNil(t, err)
+
+		if i%1000 == 999 {
+			logdbtest.AssertNil(t, db.Flush())
+		}
+	}
+
+	return db
+}
+
+func TestGroupBy(t *testing.T) {
+	db := openTestDB(t)
+
+	// expected average per sensor within the first 5000 minutes
+	sums := make(map[int64]int64)
+	counts := make(map[int64]int64)
+	for i := 0; i < 5000; i++ {
+		sums[int64(i%10)] += int64(i % 50)
+		counts[int64(i%10)]++
+	}
+
+	_, err := Execute(db, Query{
+		Select:  []Column{Col(Field("Temperature")), Count(nil)},
+		GroupBy: []Expr{Field("SensorId")},
+	})
+
+	if err == nil {
+		t.Fatalf("expected error for column which is neither grouped nor aggregated")
+	}
+
+	for _, maxGroups := range []int{0, 2} {
+		res, err := Execute(db, Query{
+			Select:    []Column{Col(Field("SensorId")), Avg(Field("Temperature")), Count(nil), Max(Field("Name"))},
+			Where:     Between(Field("Timestamp"), Int(testStart), Int(testStart+4999*60)),
+			GroupBy:   []Expr{Field("SensorId")},
+			OrderBy:   []Order{{Column: "SensorId"}},
+			Routines:  3,
+			MaxGroups: maxGroups,
+		})
+		logdbtest.AssertNil(t, err)
+
+		if len(res.Rows) != 10 || res.Columns[1] != "avg(Temperature)" || res.Columns[2] != "count(*)" {
+			t.Fatalf("unexpected result %v %v", res.Columns, res.Rows)
+		}
+
+		for i, row := range res.Rows {
+			sensorId := int64(i)
+			if row[0].I != sensorId || row[2].I != counts[sensorId] {
+				t.Fatalf("unexpected row %v", row)
+			}
+
+			if avg := float64(sums[sensorId]) / float64(counts[sensorId]); row[1].F != avg {
+				t.Fatalf("expected avg %f but got %v", avg, row[1])
+			}
+
+			if name := fmt.Sprintf("sensor-%d", i); row[3].String() != name {
+				t.Fatalf("expected max name %s but got %v", name, row[3])
+			}
+		}
+	}
+
+	res, err := Execute(db, Query{Select: []Column{Count(nil), Sum(Field("Temperature"))}, Routines: 2})
+	logdbtest.AssertNil(t, err)
+	if len(res.Rows) != 1 || res.Rows[0][0].I != testCount || res.Rows[0][1].I != 200*1225 {
+		t.Fatalf("unexpected totals %v", res.Rows)
+	}
+}
+
+func TestProjection(t *testing.T) {
+	db := openTestDB(t)
+
+	res, err := Execute(db, Query{
+		Select: []Column{
+			Col(Field("Timestamp")),
+			As(Add(Mul(Field("Temperature"), Float(1.8)), Int(32)), "Fahrenheit"),
+		},
+		Where:    And(Eq(Field("Name"), String("sensor-3")), Ge(Field("Temperature"), Int(40))),
+		OrderBy:  []Order{{Column: "Fahrenheit", Desc: true}, {Column: "Timestamp"}},
+		Limit:    3,
+		Routines: 3,
+	})
+	logdbtest.AssertNil(t, err)
+
+	if len(res.Rows) != 3 {
+		t.Fatalf("expected 3 rows but got %d", len(res.Rows))
+	}
+
+	// temperature 43 is the largest one of sensor 3, which first occurs at minute 43
+	if row := res.Rows[0]; row[0].I != testStart+43*60 || row[1].F != 43*1.8+32 {
+		t.Fatalf("unexpected first row %v", row)
+	}
+
+	if row := res.Rows[1]; row[0].I != testStart+93*60 {
+		t.Fatalf("unexpected second row %v", row)
+	}
+
+	res, err = Execute(db, Query{Select: []Column{Col(Field("Name"))}, Limit: 5, Routines: 2})
+	logdbtest.AssertNil(t, err)
+	if len(res.Rows) != 5 {
+		t.Fatalf("expected 5 rows but got %d", len(res.Rows))
+	}
+}
+
+func TestFilterAllocations(t *testing.T) {
+	db := openTestDB(t)
+
+	p, err := newPlan(db, &Query{
+		Select: []Column{Col(Field("SensorId"))},
+		Where: Or(
+			And(Eq(Field("Name"), String("sensor-3")), Gt(Div(Field("Temperature"), Int(2)), Float(10))),
+			Not(Lt(Field("Timestamp"), Int(testStart))),
+		),
+	})
+	logdbtest.AssertNil(t, err)
+
+	row := &Row{values: make([]Value, p.nslots)}
+	err = db.ReadOrdinal(3, func(id uint64, obj *logdb.Object) error {
+		if !p.filter(obj, row) {
+			t.Fatalf("expected object to match")
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			p.filter(obj, row)
+		})
+
+		if allocs != 0 {
+			t.Fatalf("expected no allocations but got %f", allocs)
+		}
+		return nil
+	})
+	logdbtest.AssertNil(t, err)
+}
@@ -0,0 +1,251 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Kind is the type of a Value.
+type Kind uint8
+
+const (
+	KindNull Kind = iota
+	KindBool
+	KindInt
+	KindFloat
+	KindString
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "null"
+	case KindBool:
+		return "bool"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindString:
+		return "string"
+	default:
+		return "kind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Value is a typed scalar. Bools and ints are kept in I, floats in F and strings in B. Values which have
+// been read from an object may slice into its buffer, see Clone.
+type Value struct {
+	Kind Kind
+	I    int64
+	F    float64
+	B    []byte
+}
+
+// NullValue returns the null value.
+func NullValue() Value {
+	return Value{}
+}
+
+// BoolValue returns a bool value.
+func BoolValue(v bool) Value {
+	if v {
+		return Value{Kind: KindBool, I: 1}
+	}
+	return Value{Kind: KindBool}
+}
+
+// IntValue returns an int value.
+func IntValue(v int64) Value {
+	return Value{Kind: KindInt, I: v}
+}
+
+// FloatValue returns a float value.
+func FloatValue(v float64) Value {
+	return Value{Kind: KindFloat, F: v}
+}
+
+// StringValue returns a string value.
+func StringValue(v string) Value {
+	return Value{Kind: KindString, B: []byte(v)}
+}
+
+// IsNull returns true, if the value is null.
+func (v Value) IsNull() bool {
+	return v.Kind == KindNull
+}
+
+// IsTrue returns true, if the value is the bool true.
+func (v Value) IsTrue() bool {
+	return v.Kind == KindBool && v.I != 0
+}
+
+// IsNumber returns true for ints and floats.
+func (v Value) IsNumber() bool {
+	return v.Kind == KindInt || v.Kind == KindFloat
+}
+
+// Float returns ints and floats as float64.
+func (v Value) Float() (float64, bool) {
+	switch v.Kind {
+	case KindInt:
+		return float64(v.I), true
+	case KindFloat:
+		return v.F, true
+	default:
+		return 0, false
+	}
+}
+
+// Clone returns a value which does not share any memory.
+func (v Value) Clone() Value {
+	if v.B != nil {
+		v.B = append([]byte(nil), v.B...)
+	}
+	return v
+}
+
+// Interface returns nil, bool, int64, float64 or string.
+func (v Value) Interface() interface{} {
+	switch v.Kind {
+	case KindBool:
+		return v.I != 0
+	case KindInt:
+		return v.I
+	case KindFloat:
+		return v.F
+	case KindString:
+		return string(v.B)
+	default:
+		return nil
+	}
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case KindBool:
+		return strconv.FormatBool(v.I != 0)
+	case KindInt:
+		return strconv.FormatInt(v.I, 10)
+	case KindFloat:
+		return strconv.FormatFloat(v.F, 'g', -1, 64)
+	case KindString:
+		return string(v.B)
+	default:
+		return "null"
+	}
+}
+
+// Compare orders values. Null is less than everything else, numbers are compared by value independent of
+// being ints or floats and strings are compared bytewise. Values of different kinds are ordered by kind.
+func Compare(a, b Value) int {
+	if a.IsNumber() && b.IsNumber() {
+		if a.Kind == KindInt && b.Kind == KindInt {
+			switch {
+			case a.I < b.I:
+				return -1
+			case a.I > b.I:
+				return 1
+			default:
+				return 0
+			}
+		}
+
+		x, _ := a.Float()
+		y, _ := b.Float()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	if a.Kind != b.Kind {
+		if a.Kind < b.Kind {
+			return -1
+		}
+		return 1
+	}
+
+	switch a.Kind {
+	case KindBool:
+		return int(a.I - b.I)
+	case KindString:
+		return bytes.Compare(a.B, b.B)
+	default:
+		return 0
+	}
+}
+
+// appendValue appends a self describing encoding of the value, which is used for group keys and spill files.
+//
+// Format specification:
+//  - kind                uint8
+//  - payload             nothing for null, int64 for bool and int, float64 bits for float and
+//                        uint32 length followed by the bytes for string
+func appendValue(dst []byte, v Value) []byte {
+	dst = append(dst, byte(v.Kind))
+	switch v.Kind {
+	case KindBool, KindInt:
+		return appendUint64(dst, uint64(v.I))
+	case KindFloat:
+		return appendUint64(dst, math.Float64bits(v.F))
+	case KindString:
+		n := uint32(len(v.B))
+		dst = append(dst, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+		return append(dst, v.B...)
+	default:
+		return dst
+	}
+}
+
+// readValue decodes a value written by appendValue and returns the remaining bytes. Strings slice into b.
+func readValue(b []byte) (Value, []byte, error) {
+	if len(b) == 0 {
+		return Value{}, nil, fmt.Errorf("unexpected end of value")
+	}
+
+	v := Value{Kind: Kind(b[0])}
+	b = b[1:]
+	switch v.Kind {
+	case KindNull:
+		return v, b, nil
+	case KindBool, KindInt, KindFloat:
+		if len(b) < 8 {
+			return v, nil, fmt.Errorf("unexpected end of value")
+		}
+		u := readUint64(b)
+		if v.Kind == KindFloat {
+			v.F = math.Float64frombits(u)
+		} else {
+			v.I = int64(u)
+		}
+		return v, b[8:], nil
+	case KindString:
+		if len(b) < 4 {
+			return v, nil, fmt.Errorf("unexpected end of value")
+		}
+		n := int(b[0]) | int(b[1])<<8 | int(b[2])<<16 | int(b[3])<<24
+		if len(b) < 4+n {
+			return v, nil, fmt.Errorf("unexpected end of value")
+		}
+		v.B = b[4 : 4+n]
+		return v, b[4+n:], nil
+	default:
+		return v, nil, fmt.Errorf("invalid value kind %d", v.Kind)
+	}
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+func readUint64(b []byte) uint64 {
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
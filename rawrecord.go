@@ -0,0 +1,140 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pierrec/lz4"
+	"github.com/worldiety/ioutil"
+)
+
+// ReadRawRecord reads a flushed record as it is stored in the file, i.e. including the length prefix of a
+// compressed or encrypted record, into dst, which is grown as required. It is safe to be used concurrently.
+func (db *DB) ReadRawRecord(stat RecordStat, dst []byte) ([]byte, error) {
+	if cap(dst) < int(stat.Length) {
+		dst = make([]byte, stat.Length)
+	}
+	dst = dst[:stat.Length]
+
+	if _, err := db.file.ReadAt(dst, stat.Offset); err != nil {
+		return dst, fmt.Errorf("unable to read the record at offset %d: %w", stat.Offset, err)
+	}
+
+	return dst, nil
+}
+
+// AppendRawRecord appends a record as it is stored in the file of another database, see ReadRawRecord, e.g.
+// to replicate it. Both databases must use the same compression and the same data key, if they are encrypted,
+// e.g. by restoring a snapshot of the other database first. The record must start at the current end of
+// the file, so that both files stay byte-identical, and there must not be any pending objects. The record is
+// verified before it is written and its objects are added to the key index, the secondary indexes, zone maps
+// and bloom filters like those of a flushed record.
+func (db *DB) AppendRawRecord(offset int64, raw []byte) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if db.pendingWriteRecord.ObjectCount() > 0 {
+		return fmt.Errorf("unable to append a raw record with pending objects")
+	}
+
+	if offset != db.eof {
+		return fmt.Errorf("raw record at offset %d does not start at the end of file %d", offset, db.eof)
+	}
+
+	record, err := db.decodeRawRecord(offset, raw)
+	if err != nil {
+		return fmt.Errorf("invalid raw record at offset %d: %w", offset, err)
+	}
+
+	n, err := db.file.WriteAt(raw, offset)
+	if err != nil {
+		return err
+	}
+
+	if n != len(raw) {
+		return fmt.Errorf("file did not accept full buffer")
+	}
+
+	db.eof += int64(len(raw))
+
+	base := db.pendingID() - offsetRecObjList
+	obj := newObject(0)
+	_ = record.ForEach(obj, func(recOffset int, object *Object) error {
+		db.indexObject(base+uint64(recOffset), object)
+		return nil
+	})
+
+	if err := db.commitRecord(offset, record); err != nil {
+		return err
+	}
+
+	db.header.AddObjectCount(uint64(record.ObjectCount()))
+	db.header.AddTxCount(1)
+	return nil
+}
+
+// decodeRawRecord decrypts, decompresses and transposes the raw record at the file offset back into rows, if
+// required, and verifies its structure.
+func (db *DB) decodeRawRecord(offset int64, raw []byte) (*Record, error) {
+	if db.prefixed() {
+		if len(raw) < 4 || int(binary.LittleEndian.Uint32(raw)) != len(raw)-4 {
+			return nil, fmt.Errorf("invalid length prefix")
+		}
+		raw = raw[4:]
+	}
+
+	if db.aead != nil {
+		plain, err := openRecord(db.aead, nil, offset, raw)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt: %w", err)
+		}
+		raw = plain
+	}
+
+	record := &Record{buf: &ioutil.LittleEndianBuffer{Bytes: raw}}
+	if db.compress {
+		record = newRecord(db.maxRecSize)
+		n, err := lz4.UncompressBlock(raw, record.buf.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress: %w", err)
+		}
+		record.buf.Bytes = record.buf.Bytes[:n]
+	}
+
+	record.header = db.header
+	if len(record.buf.Bytes) < offsetRecObjList {
+		return nil, fmt.Errorf("record is truncated")
+	}
+
+	if !validRecordMagic(record.buf.Bytes) {
+		return nil, fmt.Errorf("invalid record magic")
+	}
+
+	record.reverseFlush()
+	if int(record.Size()) != len(record.buf.Bytes) {
+		return nil, fmt.Errorf("record has %d bytes, but %d are given", record.Size(), len(record.buf.Bytes))
+	}
+
+	if record.magic == columnarRecordMagic {
+		var cols columnar
+		if err := cols.parse(record.Bytes()); err != nil {
+			return nil, err
+		}
+
+		if cols.rowSize > db.maxRecSize {
+			return nil, fmt.Errorf("columnar record has an invalid row size of %d", cols.rowSize)
+		}
+
+		record = newRecord(cols.rowSize)
+		record.header = db.header
+		if err := cols.untranspose(record); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := verifyRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
@@ -0,0 +1,67 @@
+package logdb
+
+import (
+	ioutil2 "io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendRawRecord(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "rawRecordTest")
+	assertNil(t, err)
+
+	src, err := OpenOptions(filepath.Join(dir, "src.bin"), Options{Quiet: true})
+	assertNil(t, err)
+	defer src.Close()
+
+	dst, err := OpenOptions(filepath.Join(dir, "dst.bin"), Options{Quiet: true, Key: []string{"key"}})
+	assertNil(t, err)
+	defer dst.Close()
+
+	key := src.PutName("key")
+	dst.PutName("key")
+	for r := 0; r < 3; r++ {
+		for i := 0; i < 5; i++ {
+			assertNil(t, src.Add(func(obj *Object) error {
+				obj.AddInt(key, int64(i))
+				return nil
+			}))
+		}
+		assertNil(t, src.Flush())
+	}
+
+	var raw []byte
+	for _, stat := range src.Records() {
+		raw, err = src.ReadRawRecord(stat, raw)
+		assertNil(t, err)
+
+		// a corrupted record is rejected without being written
+		raw[offsetRecSize]++
+		if err := dst.AppendRawRecord(stat.Offset, raw); err == nil {
+			t.Fatal("expected a corrupted record to be rejected")
+		}
+		raw[offsetRecSize]--
+
+		if err := dst.AppendRawRecord(stat.Offset+1, raw); err == nil {
+			t.Fatal("expected a record at a wrong offset to be rejected")
+		}
+
+		assertNil(t, dst.AppendRawRecord(stat.Offset, raw))
+	}
+
+	assertNil(t, dst.Verify())
+	if dst.ObjectCount() != 15 || dst.TxCount() != 3 {
+		t.Fatalf("expected 15 objects in 3 records but got %d in %d", dst.ObjectCount(), dst.TxCount())
+	}
+
+	// the key index covers the appended objects
+	latest := 0
+	err = dst.Scan(ScanOptions{LatestOnly: true}, func(gid int, id uint64, obj *Object) error {
+		latest++
+		return nil
+	})
+	assertNil(t, err)
+	if latest != 5 {
+		t.Fatalf("expected 5 latest objects but got %d", latest)
+	}
+}
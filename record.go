@@ -1,6 +1,7 @@
 package logdb
 
 import (
+	"bytes"
 	"github.com/worldiety/ioutil"
 )
 
@@ -13,11 +14,26 @@ const (
 
 var recordMagic = [8]byte{'w', 'd', 'y', 'r', 'e', 'c', '0', '1'}
 
+// relativeRecordMagic marks records with values which are relative to preceding values of the record, like
+// timestamp deltas, so that they are only resolved for records which contain them.
+var relativeRecordMagic = [8]byte{'w', 'd', 'y', 'r', 'e', 'c', '0', '2'}
+
+// validRecordMagic returns true, if the record starts with one of the record magics.
+func validRecordMagic(b []byte) bool {
+	return bytes.Equal(b[offsetRecMagic:offsetRecMagic+len(recordMagic)], recordMagic[:]) ||
+		bytes.Equal(b[offsetRecMagic:offsetRecMagic+len(relativeRecordMagic)], relativeRecordMagic[:]) ||
+		bytes.Equal(b[offsetRecMagic:offsetRecMagic+len(columnarRecordMagic)], columnarRecordMagic[:])
+}
+
 type Record struct {
 	magic    [8]byte
 	buf      *ioutil.LittleEndianBuffer
 	size     uint32
 	objCount uint32
+	ctx      recordContext // of the objects added so far, see addRelative
+	resolved []byte        // the last object with resolved relative values
+	header   *Header       // resolves dictionary codes of a read record
+	columnar bool          // stored in the columnar layout, see transpose
 }
 
 func newRecord(maxSize int) *Record {
@@ -55,6 +71,9 @@ func (d *Record) Bytes() []byte {
 func (d *Record) Reset() {
 	d.setSize(offsetRecObjList)
 	d.setObjectCount(0)
+	d.magic = recordMagic
+	d.ctx = recordContext{}
+	d.columnar = false
 }
 
 func (d *Record) Add(obj *Object) {
@@ -68,6 +87,10 @@ func (d *Record) Add(obj *Object) {
 }
 
 func (d *Record) At(offset int, tmp *Object, f func(offset int, object *Object) error) error {
+	if d.magic == relativeRecordMagic {
+		return d.atRelative(offset, tmp, f)
+	}
+
 	size := d.ReadUint24At(offset)
 	objBuf := d.buf.Bytes[offset : offset+int(size)]
 	tmp.buf.Bytes = objBuf
@@ -99,6 +122,16 @@ func (d *Record) ObjOffsets(dst []int) int {
 	return count
 }
 
+// objOffset returns the offset of the i-th object within the record.
+func (d *Record) objOffset(i int) int {
+	pos := offsetRecObjList
+	for ; i > 0; i-- {
+		pos += int(d.ReadUint24At(pos))
+	}
+
+	return pos
+}
+
 func (d *Record) ForEach(tmp *Object, f func(offset int, object *Object) error) error {
 	// we don't want another memcpy, so we slice into
 	/*tmpBuf := tmp.buf.Bytes
@@ -106,6 +139,10 @@ func (d *Record) ForEach(tmp *Object, f func(offset int, object *Object) error)
 		tmp.buf.Bytes = tmpBuf
 	}()*/
 
+	if d.magic == relativeRecordMagic {
+		return d.forEachRelative(tmp, f)
+	}
+
 	count := int(d.ObjectCount())
 	d.buf.Pos = offsetRecObjList
 	_ = offsetSize // for documentation only
@@ -146,6 +183,8 @@ func (d *Record) flush() {
 }
 
 func (d *Record) reverseFlush() {
+	copy(d.magic[:], d.buf.Bytes[offsetRecMagic:])
+
 	d.buf.Pos = offsetRecSize
 	d.size = d.buf.ReadUint32()
 
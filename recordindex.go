@@ -0,0 +1,314 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// recordIndexSuffix is appended to the database file name to get the name of the record index file.
+const recordIndexSuffix = ".ridx"
+
+// recordIndexEntrySize is the fixed size of a single persisted entry.
+//
+// Format specification:
+//  - offset              uint64, file offset of the record
+//  - ordinal             uint64, ordinal of the first object in the record
+//  - length              uint32, bytes on disk, including the length prefix of compressed records
+//  - size                uint32, uncompressed size of the record
+//  - objCount            uint32, amount of objects in the record
+//  - flags               uint32, bit 0 is set for records in the columnar layout
+const recordIndexEntrySize = 32
+
+// recordInfo describes where a record is located and which objects it contains.
+type recordInfo struct {
+	offset   int64
+	length   uint32
+	size     uint32
+	ordinal  uint64
+	objCount uint32
+	base     uint64 // the logical offset of the uncompressed record, which is used as the base for object ids
+	columnar bool   // stored in the columnar layout, whose objects are not at their ids within the file
+}
+
+// end returns the file offset of the next record.
+func (r recordInfo) end() int64 {
+	return r.offset + int64(r.length)
+}
+
+// recordIndex keeps all record locations in memory and appends each flushed record to the index file, so that
+// the database file does not need to be parsed on each scan. The index is append-only like the database
+// itself and is repaired while opening, if it is behind the database file, e.g. after a crash.
+type recordIndex struct {
+	file     *os.File // nil for a read-only database without index file
+	readOnly bool     // the file is never written, missing entries are only kept in memory
+	records  []recordInfo
+	columnar bool // any record is stored in the columnar layout
+	mutex    sync.RWMutex
+	buf      *ioutil.LittleEndianBuffer
+}
+
+func openRecordIndex(fname string, readOnly bool) (*recordIndex, error) {
+	file, err := openSideFile(fname, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordIndex{
+		file:     file,
+		readOnly: readOnly,
+		buf:      &ioutil.LittleEndianBuffer{Bytes: make([]byte, recordIndexEntrySize)},
+	}, nil
+}
+
+// load reads all persisted entries and drops those which do not fit to the database file.
+func (x *recordIndex) load(headerSize int64, eof int64) error {
+	x.records = x.records[:0]
+	if x.file == nil {
+		return nil
+	}
+
+	stat, err := x.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, stat.Size()-stat.Size()%recordIndexEntrySize)}
+	if _, err := x.file.ReadAt(buf.Bytes, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	offset, base, ordinal := headerSize, uint64(headerSize), uint64(0)
+	for buf.Pos < len(buf.Bytes) {
+		info := recordInfo{}
+		info.offset = int64(buf.ReadUint64())
+		info.ordinal = buf.ReadUint64()
+		info.length = buf.ReadUint32()
+		info.size = buf.ReadUint32()
+		info.objCount = buf.ReadUint32()
+		info.columnar = buf.ReadUint32()&1 != 0
+		info.base = base
+
+		if info.offset != offset || info.ordinal != ordinal || info.end() > eof {
+			break
+		}
+
+		x.records = append(x.records, info)
+		x.columnar = x.columnar || info.columnar
+		offset = info.end()
+		base += uint64(info.size)
+		ordinal += uint64(info.objCount)
+	}
+
+	if x.readOnly {
+		return nil
+	}
+
+	return x.file.Truncate(int64(len(x.records)) * recordIndexEntrySize)
+}
+
+// add appends a new record to the index file and to the in-memory table. The base and ordinal are
+// calculated from the last record.
+func (x *recordIndex) add(offset int64, length, size, objCount uint32, columnar bool) error {
+	info := recordInfo{offset: offset, length: length, size: size, objCount: objCount, columnar: columnar}
+	if last, ok := x.last(); ok {
+		info.base = last.base + uint64(last.size)
+		info.ordinal = last.ordinal + uint64(last.objCount)
+	} else {
+		info.base = uint64(offset)
+	}
+
+	buf := x.buf
+	buf.Pos = 0
+	buf.WriteUint64(uint64(info.offset))
+	buf.WriteUint64(info.ordinal)
+	buf.WriteUint32(info.length)
+	buf.WriteUint32(info.size)
+	buf.WriteUint32(info.objCount)
+	if columnar {
+		buf.WriteUint32(1)
+	} else {
+		buf.WriteUint32(0)
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if !x.readOnly {
+		if _, err := x.file.WriteAt(buf.Bytes, int64(len(x.records))*recordIndexEntrySize); err != nil {
+			return fmt.Errorf("unable to write record index: %w", err)
+		}
+	}
+
+	x.records = append(x.records, info)
+	x.columnar = x.columnar || columnar
+	return nil
+}
+
+// hasColumnar returns true, if any record is stored in the columnar layout.
+func (x *recordIndex) hasColumnar() bool {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	return x.columnar
+}
+
+func (x *recordIndex) last() (recordInfo, bool) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	if len(x.records) == 0 {
+		return recordInfo{}, false
+	}
+
+	return x.records[len(x.records)-1], true
+}
+
+// snapshot returns the currently known records. Entries are never modified, so the result can be used
+// without any further locking.
+func (x *recordIndex) snapshot() []recordInfo {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	return x.records[:len(x.records):len(x.records)]
+}
+
+// byOrdinal returns the record which contains the object with the given ordinal.
+func (x *recordIndex) byOrdinal(n uint64) (recordInfo, bool) {
+	records := x.snapshot()
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].ordinal+uint64(records[i].objCount) > n
+	})
+
+	if i == len(records) {
+		return recordInfo{}, false
+	}
+
+	return records[i], true
+}
+
+// byID returns the record which contains the object with the given id.
+func (x *recordIndex) byID(id uint64) (recordInfo, bool) {
+	records := x.snapshot()
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].base+uint64(records[i].size) > id
+	})
+
+	if i == len(records) || records[i].base > id {
+		return recordInfo{}, false
+	}
+
+	return records[i], true
+}
+
+func (x *recordIndex) Close() error {
+	if x.file == nil {
+		return nil
+	}
+
+	return x.file.Close()
+}
+
+// openRecordIndex loads the record index and appends all records which are missing in the index.
+func (db *DB) openRecordIndex() error {
+	index, err := openRecordIndex(db.fname+recordIndexSuffix, db.opts.ReadOnly)
+	if err != nil {
+		return err
+	}
+
+	headerSize := int64(db.header.Size())
+	if err := index.load(headerSize, db.eof); err != nil {
+		_ = index.Close()
+		return err
+	}
+
+	db.records = index
+
+	offset := headerSize
+	if last, ok := index.last(); ok {
+		offset = last.end()
+	}
+
+	if offset < db.eof {
+		db.logf("rebuilding record index from offset %d\n", offset)
+	}
+
+	reader := newRecordReader(db)
+	for offset < db.eof {
+		record, next, err := reader.read(offset)
+		if err != nil {
+			return err
+		}
+
+		if err := index.add(offset, uint32(next-offset), record.Size(), record.ObjectCount(), record.columnar); err != nil {
+			return err
+		}
+
+		offset = next
+	}
+
+	return nil
+}
+
+// SeekOrdinal returns the id of the n-th object, counted from 0 in the order in which the objects have been
+// added. Only flushed objects can be found. It is safe to be used concurrently.
+func (db *DB) SeekOrdinal(n uint64) (uint64, error) {
+	info, ok := db.records.byOrdinal(n)
+	if !ok {
+		return 0, fmt.Errorf("ordinal %d is out of range", n)
+	}
+
+	reader := db.readerPool.Get().(*recordReader)
+	defer db.readerPool.Put(reader)
+
+	record, err := reader.readInfo(info)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.base + uint64(record.objOffset(int(n-info.ordinal))), nil
+}
+
+// ValidID returns true, if the id is the id of a flushed object, i.e. if it points to the start of an object
+// within a record. Unlike Read, which trusts the id, it reads the record of the id to walk its objects. It is
+// safe to be used concurrently.
+func (db *DB) ValidID(id uint64) (bool, error) {
+	info, ok := db.records.byID(id)
+	if !ok {
+		return false, nil
+	}
+
+	reader := db.readerPool.Get().(*recordReader)
+	defer db.readerPool.Put(reader)
+
+	record, err := reader.readInfo(info)
+	if err != nil {
+		return false, err
+	}
+
+	offset := int(id - info.base)
+	pos := offsetRecObjList
+	for i := 0; i < int(record.ObjectCount()) && pos <= offset; i++ {
+		if pos == offset {
+			return true, nil
+		}
+		pos += int(record.ReadUint24At(pos))
+	}
+
+	return false, nil
+}
+
+// ReadOrdinal reads the n-th object, see also SeekOrdinal and Read.
+func (db *DB) ReadOrdinal(n uint64, f func(id uint64, obj *Object) error) error {
+	id, err := db.SeekOrdinal(n)
+	if err != nil {
+		return err
+	}
+
+	return db.Read(id, func(obj *Object) error {
+		return f(id, obj)
+	})
+}
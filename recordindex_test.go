@@ -0,0 +1,74 @@
+package logdb
+
+import (
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOrdinal(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir, err := ioutil2.TempDir("", "ordinalTest")
+		assertNil(t, err)
+
+		fname := filepath.Join(dir, "ordinaldb.bin")
+		opts := Options{Compression: compress}
+		db, err := OpenOptions(fname, opts)
+		assertNil(t, err)
+
+		const count = 10_000
+		for i := 0; i < count; i++ {
+			err := db.Add(func(obj *Object) error {
+				obj.AddInt(1, int64(i))
+				return nil
+			})
+			assertNil(t, err)
+
+			// produce a bunch of records
+			if i%999 == 0 {
+				assertNil(t, db.Flush())
+			}
+		}
+
+		assertNil(t, db.Close())
+
+		// a missing index must be rebuilt
+		assertNil(t, os.Remove(fname+recordIndexSuffix))
+
+		for reopen := 0; reopen < 2; reopen++ {
+			db, err = OpenOptions(fname, opts)
+			assertNil(t, err)
+
+			for _, n := range []uint64{0, 1, 998, 999, 1000, 5000, count - 1} {
+				err := db.ReadOrdinal(n, func(id uint64, obj *Object) error {
+					obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+						if v := f.ReadInt(); v != int64(n) {
+							t.Fatalf("expected ordinal %d but got %d", n, v)
+						}
+					})
+					return nil
+				})
+				assertNil(t, err)
+			}
+
+			if _, err := db.SeekOrdinal(count); err == nil {
+				t.Fatalf("expected out of range error")
+			}
+
+			id, err := db.SeekOrdinal(5000)
+			assertNil(t, err)
+			for _, c := range []struct {
+				id    uint64
+				valid bool
+			}{{id, true}, {id + 1, false}, {id - 1, false}, {0, false}, {1 << 40, false}} {
+				if valid, err := db.ValidID(c.id); err != nil || valid != c.valid {
+					t.Fatalf("expected id %d to be valid=%v: %v", c.id, c.valid, err)
+				}
+			}
+
+			assertNil(t, db.Close())
+		}
+	}
+}
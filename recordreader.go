@@ -0,0 +1,249 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/pierrec/lz4"
+	"io"
+)
+
+// recordReader loads records from the database file, either by pread or by slicing into the mmap area, and
+// decrypts and decompresses them if required. It is not safe to be used concurrently, so each go routine needs its own.
+type recordReader struct {
+	db         *DB
+	record     *Record // owns the buffer to read into, grows on demand
+	view       *Record // slices into the mmap area, never written into
+	rows       *Record // owns the buffer into which columnar records are transposed back, allocated lazily
+	cols       columnar
+	compressed []byte
+	decrypted  []byte
+	lenBuf     []byte
+	hdrBuf     []byte
+	cached     int64 // file offset of the decoded record which is still in the owned buffer or -1
+	rowsCached int64 // file offset of the columnar record which is still transposed in rows or -1
+}
+
+func newRecordReader(db *DB) *recordReader {
+	r := &recordReader{
+		db:         db,
+		record:     newRecord(0),
+		view:       newRecord(0),
+		lenBuf:     make([]byte, 4),
+		hdrBuf:     make([]byte, offsetRecObjList),
+		cached:     -1,
+		rowsCached: -1,
+	}
+	r.record.header, r.view.header = db.header, db.header
+
+	return r
+}
+
+// reset switches the reader to another database, whose records refer to its own header, e.g. to the
+// dictionary of its file. Cached records are dropped, because records of different databases may have the
+// same offset.
+func (r *recordReader) reset(db *DB) {
+	r.db = db
+	r.cached, r.rowsCached = -1, -1
+	r.record.header, r.view.header = db.header, db.header
+	if r.rows != nil {
+		r.rows.header = db.header
+	}
+}
+
+// buffer returns the owned record with a buffer of at least the given size. Buffers are allocated lazily,
+// because most records are much smaller than the maximum record size.
+func (r *recordReader) buffer(size int) *Record {
+	if len(r.record.buf.Bytes) < size {
+		r.record = newRecord(size)
+		r.record.header = r.db.header
+	}
+
+	return r.record
+}
+
+// readInfo loads the given record. A compressed, encrypted or columnar record is only decoded once, if it is
+// read repeatedly. The returned record is only valid until the next call.
+func (r *recordReader) readInfo(info recordInfo) (*Record, error) {
+	if r.rowsCached == info.offset {
+		return r.rows, nil
+	}
+
+	rec, err := r.loadInfo(info)
+	if err != nil || rec.magic != columnarRecordMagic {
+		return rec, err
+	}
+
+	return r.untranspose(info.offset, rec)
+}
+
+// readColumns loads the given record like readInfo, but returns a columnar record as parsed columns
+// instead of transposing it back. Exactly one of the results is not nil, unless an error occurred.
+func (r *recordReader) readColumns(info recordInfo) (*Record, *columnar, error) {
+	rec, err := r.loadInfo(info)
+	if err != nil || rec.magic != columnarRecordMagic {
+		return rec, nil, err
+	}
+
+	if err := r.cols.parse(rec.Bytes()); err != nil {
+		return nil, nil, fmt.Errorf("invalid columnar record at offset %d: %w", info.offset, err)
+	}
+
+	return nil, &r.cols, nil
+}
+
+// loadInfo loads the given record like readInfo, but returns a columnar record as it is.
+func (r *recordReader) loadInfo(info recordInfo) (*Record, error) {
+	if r.db.prefixed() && r.cached == info.offset {
+		return r.record, nil
+	}
+
+	rec, _, err := r.load(info.offset)
+	return rec, err
+}
+
+// read loads the record at the given file offset and transposes a columnar record back into rows. It returns
+// the record and the file offset of the next record. The returned record is only valid until the next call.
+func (r *recordReader) read(offset int64) (*Record, int64, error) {
+	rec, next, err := r.load(offset)
+	if err != nil || rec.magic != columnarRecordMagic {
+		return rec, next, err
+	}
+
+	rec, err = r.untranspose(offset, rec)
+	return rec, next, err
+}
+
+// untranspose transposes the columnar record at the file offset back into rows.
+func (r *recordReader) untranspose(offset int64, rec *Record) (*Record, error) {
+	r.rowsCached = -1
+	if err := r.cols.parse(rec.Bytes()); err != nil {
+		return nil, fmt.Errorf("invalid columnar record at offset %d: %w", offset, err)
+	}
+
+	if r.cols.rowSize > r.db.maxRecSize {
+		return nil, fmt.Errorf("invalid columnar record at offset %d with a row size of %d", offset, r.cols.rowSize)
+	}
+
+	if r.rows == nil || len(r.rows.buf.Bytes) < r.cols.rowSize {
+		r.rows = newRecord(r.cols.rowSize)
+		r.rows.header = r.db.header
+	}
+
+	if err := r.cols.untranspose(r.rows); err != nil {
+		return nil, fmt.Errorf("invalid columnar record at offset %d: %w", offset, err)
+	}
+
+	r.rowsCached = offset
+	return r.rows, nil
+}
+
+// load loads the record at the given file offset as it is stored. It returns the record and the file offset
+// of the next record. The returned record is only valid until the next call.
+func (r *recordReader) load(offset int64) (rec *Record, next int64, err error) {
+	if r.db.prefixed() {
+		return r.readCompressed(offset)
+	}
+
+	if r.inMmap(offset, offsetRecObjList) {
+		max := int64(r.db.maxRecSize)
+		if avail := int64(len(r.db.mmapFile)) - offset; max > avail {
+			max = avail
+		}
+
+		r.view.buf.Bytes = r.db.mmapFile[offset : offset+max]
+		rec = r.view
+	} else {
+		if _, err := r.db.file.ReadAt(r.hdrBuf, offset); err != nil {
+			return nil, 0, fmt.Errorf("unable to read a record at offset %d: %w", offset, err)
+		}
+
+		size := int(r.hdrBuf[offsetRecSize]) | int(r.hdrBuf[offsetRecSize+1])<<8 | int(r.hdrBuf[offsetRecSize+2])<<16 | int(r.hdrBuf[offsetRecSize+3])<<24
+		if size < offsetRecObjList || size > r.db.maxRecSize {
+			return nil, 0, fmt.Errorf("invalid record size %d at offset %d", size, offset)
+		}
+
+		rec = r.buffer(size)
+		if _, err := r.db.file.ReadAt(rec.buf.Bytes[:size], offset); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+	}
+
+	rec.reverseFlush()
+	if rec.Size() < offsetRecObjList || int64(rec.Size()) > int64(len(rec.buf.Bytes)) {
+		return nil, 0, fmt.Errorf("invalid record size %d at offset %d", rec.Size(), offset)
+	}
+
+	return rec, offset + int64(rec.Size()), nil
+}
+
+// readCompressed loads a record with a length prefix, decrypts it with its nonce and offset, if the
+// database is encrypted, and decompresses it, if the database is compressed.
+func (r *recordReader) readCompressed(offset int64) (rec *Record, next int64, err error) {
+	r.cached = -1
+
+	var clen int64
+	if r.inMmap(offset, 4) {
+		clen = int64(r.db.mmapFile[offset]) | int64(r.db.mmapFile[offset+1])<<8 | int64(r.db.mmapFile[offset+2])<<16 | int64(r.db.mmapFile[offset+3])<<24
+	} else {
+		if _, err := r.db.file.ReadAt(r.lenBuf, offset); err != nil {
+			return nil, 0, fmt.Errorf("unable to read record length at offset %d: %w", offset, err)
+		}
+		clen = int64(r.lenBuf[0]) | int64(r.lenBuf[1])<<8 | int64(r.lenBuf[2])<<16 | int64(r.lenBuf[3])<<24
+	}
+
+	maxLen := int64(r.db.maxRecSize)
+	if r.db.aead != nil {
+		maxLen += int64(sealOverhead(r.db.aead))
+	}
+
+	if clen > maxLen {
+		return nil, 0, fmt.Errorf("invalid compressed record length %d at offset %d", clen, offset)
+	}
+
+	var buf []byte
+	if r.inMmap(offset+4, int(clen)) {
+		buf = r.db.mmapFile[offset+4 : offset+4+clen]
+	} else {
+		if int64(len(r.compressed)) < clen {
+			r.compressed = make([]byte, clen)
+		}
+		buf = r.compressed[:clen]
+		if _, err := r.db.file.ReadAt(buf, offset+4); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+	}
+
+	var n int
+	switch {
+	case r.db.aead != nil && !r.db.compress:
+		plain, err := openRecord(r.db.aead, r.buffer(int(clen)).buf.Bytes[:0], offset, buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to decrypt record at offset %d: %w", offset, err)
+		}
+		n = len(plain)
+	case r.db.aead != nil:
+		r.decrypted, err = openRecord(r.db.aead, r.decrypted[:0], offset, buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to decrypt record at offset %d: %w", offset, err)
+		}
+		buf = r.decrypted
+		fallthrough
+	default:
+		n, err = lz4.UncompressBlock(buf, r.buffer(r.db.maxRecSize).buf.Bytes)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to decompress record at offset %d: %w", offset, err)
+		}
+	}
+
+	if n < offsetRecObjList {
+		return nil, 0, fmt.Errorf("unable to read a record at offset %d", offset)
+	}
+
+	r.record.reverseFlush()
+	r.cached = offset
+	return r.record, offset + 4 + clen, nil
+}
+
+// inMmap returns true, if the requested range is available in the mapped area.
+func (r *recordReader) inMmap(offset int64, length int) bool {
+	return r.db.useMmap && offset+int64(length) <= int64(len(r.db.mmapFile))
+}
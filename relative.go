@@ -0,0 +1,281 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+)
+
+// recordContext is the state of a record which relative values refer to. The writer and the reader build it
+// alike from the absolute values of the objects in their order within the record.
+type recordContext struct {
+	timeBase    int64 // the first timestamp of the record
+	hasTimeBase bool
+	ints        map[uint16]int64   // the previous integer of each name, see EncodingDelta
+	floats      map[uint16]float64 // the previous float of each name, see EncodingXOR
+}
+
+// observe updates the context with an absolute value of the given name and type.
+func (c *recordContext) observe(name uint16, kind ioutil.Type, b []byte) {
+	switch {
+	case kind == TTimestamp && !c.hasTimeBase:
+		c.timeBase = int64(binary.LittleEndian.Uint64(b))
+		c.hasTimeBase = true
+	case isInteger(kind):
+		if c.ints == nil {
+			c.ints = make(map[uint16]int64)
+		}
+		c.ints[name] = elementInt(kind, b[:elementSize(kind)])
+	case isFloat(kind):
+		if c.floats == nil {
+			c.floats = make(map[uint16]float64)
+		}
+		c.floats[name] = elementFloat(kind, b[:elementSize(kind)])
+	}
+}
+
+// addRelative adds an object like Add, but stores its top level dictionary strings as codes of the
+// dictionary of the header, its top level numbers according to the encodings of their names and, if deltas
+// is set, its top level timestamps, except the first one of the record, as delta to the first one, see
+// Options.TimestampDeltas. An encoded value is only used, if it is shorter than the value itself, and a
+// dictionary string, which does not fit into the dictionary, is stored as plain string, so that the object
+// never grows. Records which contain relative values get the magic relativeRecordMagic.
+func (d *Record) addRelative(obj *Object, h *Header, deltas bool, encodings map[uint16]Encoding) {
+	src := obj.Bytes()
+	start := int(d.Size())
+	dst := d.buf.Bytes
+	pos := start + copy(dst[start:], src[:offsetFieldList])
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: src, Pos: offsetFieldList}
+	var delta [binary.MaxVarintLen64]byte
+	for i := 0; i < int(obj.FieldCount()); i++ {
+		field := buf.Pos
+		name := buf.ReadUint16()
+		kind := buf.ReadType()
+		if kind == TDictString {
+			buf.Pos += 4
+			value := buf.Pos
+			code, ok := h.DictionaryCode(rawBytes(buf))
+			pos += copy(dst[pos:], src[field:field+2])
+			if ok {
+				dst[pos] = byte(tDictRef)
+				pos += 1 + binary.PutUvarint(dst[pos+1:], uint64(code))
+				d.magic = relativeRecordMagic
+			} else {
+				pos += copy(dst[pos:], src[value:buf.Pos])
+			}
+			continue
+		}
+
+		if kind == TTimestamp && deltas && d.ctx.hasTimeBase {
+			v := int64(binary.LittleEndian.Uint64(src[buf.Pos:]))
+			if n := binary.PutVarint(delta[:], v-d.ctx.timeBase); n < 8 {
+				pos += copy(dst[pos:], src[field:field+2])
+				dst[pos] = byte(tTimestampDelta)
+				pos += 1 + copy(dst[pos+1:], delta[:n])
+				buf.Pos += 8
+				d.magic = relativeRecordMagic
+				continue
+			}
+		}
+
+		if encoding := encodings[name]; encoding != EncodingNone {
+			if encoded, ok := d.ctx.encode(dst[pos:pos+2], name, encoding, kind, src[buf.Pos:]); ok {
+				copy(dst[pos:], src[field:field+2])
+				pos += len(encoded)
+				d.ctx.observe(name, kind, src[buf.Pos:])
+				drain(buf, kind)
+				d.magic = relativeRecordMagic
+				continue
+			}
+		}
+
+		d.ctx.observe(name, kind, src[buf.Pos:])
+		drain(buf, kind)
+		pos += copy(dst[pos:], src[field:buf.Pos])
+	}
+
+	size := pos - start
+	dst[start], dst[start+1], dst[start+2] = byte(size), byte(size>>8), byte(size>>16)
+	d.setSize(uint32(pos))
+	d.setObjectCount(d.ObjectCount() + 1)
+}
+
+// encode appends the type and the value of a number of the given name, which b points to, in the encoding to
+// dst and returns false, if the encoding does not apply to the type or if the encoded value would not be
+// shorter. The context is not updated.
+func (c *recordContext) encode(dst []byte, name uint16, encoding Encoding, kind ioutil.Type, b []byte) ([]byte, bool) {
+	switch {
+	case encoding == EncodingVarint && isInteger(kind):
+		return appendEncodedInt(dst, kind, tVarint, elementInt(kind, b[:elementSize(kind)]))
+	case encoding == EncodingDelta && isInteger(kind):
+		return appendEncodedInt(dst, kind, tDelta, elementInt(kind, b[:elementSize(kind)])-c.ints[name])
+	case encoding == EncodingXOR && isFloat(kind):
+		return appendXOR(dst, kind, b, c.floats[name])
+	default:
+		return dst, false
+	}
+}
+
+// resolve expands the relative values of an encoded object into absolute ones in their original types and
+// updates the context with its values. Unknown dictionary codes, which Verify reports, become empty strings.
+// The returned copy is only valid until the next call. Malformed values, see skip, are returned as error.
+func (d *Record) resolve(src []byte, ctx *recordContext) ([]byte, error) {
+	if len(src) < offsetFieldList {
+		return nil, fmt.Errorf("has an invalid size of %d bytes", len(src))
+	}
+
+	dst := append(d.resolved[:0], src[:offsetFieldList]...)
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: src, Pos: offsetFieldList}
+	count := int(binary.LittleEndian.Uint16(src[offsetFieldCount:]))
+	for i := 0; i < count; i++ {
+		if buf.Pos+3 > len(src) {
+			return nil, fmt.Errorf("has a truncated field")
+		}
+
+		field := buf.Pos
+		name := buf.ReadUint16()
+		kind := buf.ReadType()
+		switch kind {
+		case tTimestampDelta, tDictRef, tVarint, tDelta, tXOR:
+			// the value is decoded below, after skip has checked that it is well-formed
+			if err := skip(&ioutil.LittleEndianBuffer{Bytes: src, Pos: buf.Pos}, kind); err != nil {
+				return nil, err
+			}
+		}
+
+		switch kind {
+		case tTimestampDelta:
+			delta, n := binary.Varint(src[buf.Pos:])
+			buf.Pos += n
+			dst = append(dst, src[field], src[field+1], byte(TTimestamp))
+			dst = appendUint64(dst, uint64(ctx.timeBase+delta))
+			continue
+		case tDictRef:
+			code, n := binary.Uvarint(src[buf.Pos:])
+			buf.Pos += n
+			value, _ := d.header.DictionaryValue(uint32(code))
+			dst = append(dst, src[field], src[field+1], byte(TDictString))
+			dst = append(dst, byte(code), byte(code>>8), byte(code>>16), byte(code>>24))
+			dst = appendTypedString(dst, value)
+			continue
+		case tVarint, tDelta, tXOR:
+			dst = append(dst, src[field], src[field+1])
+			value := len(dst) + 1
+			if kind == tXOR {
+				dst = appendDecodedFloat(dst, src[buf.Pos:], ctx.floats[name])
+				buf.Pos += xorSize(src[buf.Pos:])
+			} else {
+				u, n := binary.Uvarint(src[buf.Pos:])
+				buf.Pos += n
+				base := int64(0)
+				if kind == tDelta {
+					base = ctx.ints[name]
+				}
+				dst = appendDecodedInt(dst, u, base)
+			}
+			ctx.observe(name, ioutil.Type(dst[value-1]), dst[value:])
+			continue
+		}
+
+		value := buf.Pos
+		if err := skip(buf, kind); err != nil {
+			return nil, err
+		}
+		ctx.observe(name, kind, src[value:])
+		dst = append(dst, src[field:buf.Pos]...)
+	}
+
+	size := len(dst)
+	dst[0], dst[1], dst[2] = byte(size), byte(size>>8), byte(size>>16)
+	d.resolved = dst
+	return dst, nil
+}
+
+// relativeObject returns the encoded object at the offset of a record with relative values.
+func (d *Record) relativeObject(offset int) ([]byte, error) {
+	if offset+offsetFieldList > int(d.Size()) {
+		return nil, fmt.Errorf("exceeds the record")
+	}
+
+	size := int(d.ReadUint24At(offset))
+	if size < offsetFieldList || offset+size > int(d.Size()) {
+		return nil, fmt.Errorf("has an invalid size of %d bytes", size)
+	}
+
+	return d.buf.Bytes[offset : offset+size], nil
+}
+
+// forEachRelative is ForEach for records with relative values, which passes copies of the objects with
+// resolved values.
+func (d *Record) forEachRelative(tmp *Object, f func(offset int, object *Object) error) error {
+	var ctx recordContext
+	pos := offsetRecObjList
+	for i := 0; i < int(d.ObjectCount()); i++ {
+		src, err := d.relativeObject(pos)
+		if err == nil {
+			tmp.buf.Bytes, err = d.resolve(src, &ctx)
+		}
+
+		if err != nil {
+			return fmt.Errorf("object %d %w", i, err)
+		}
+
+		tmp.reverseFlush()
+		if err := f(pos, tmp); err != nil {
+			return err
+		}
+
+		pos += len(src)
+	}
+
+	return nil
+}
+
+// atRelative is At for records with relative values. It resolves all preceding objects of the record to
+// build the context, so it costs a scan of the record up to the object.
+func (d *Record) atRelative(offset int, tmp *Object, f func(offset int, object *Object) error) error {
+	var ctx recordContext
+	for pos := offsetRecObjList; pos <= offset; {
+		src, err := d.relativeObject(pos)
+		if err == nil {
+			tmp.buf.Bytes, err = d.resolve(src, &ctx)
+		}
+
+		if err != nil {
+			return fmt.Errorf("object at %d %w", pos, err)
+		}
+
+		if pos == offset {
+			tmp.reverseFlush()
+			return f(offset, tmp)
+		}
+		pos += len(src)
+	}
+
+	return fmt.Errorf("record has no object at %d", offset)
+}
+
+// hasRelativeFields returns true, if the encoded object contains values which are relative to its record.
+func hasRelativeFields(b []byte) bool {
+	return hasFieldType(b, func(kind ioutil.Type) bool {
+		return kind == tTimestampDelta || kind == tDictRef || kind >= tVarint && kind <= tXOR
+	})
+}
+
+// hasFieldType returns true, if the encoded object has a top level field whose type matches.
+func hasFieldType(b []byte, match func(kind ioutil.Type) bool) bool {
+	buf := &ioutil.LittleEndianBuffer{Bytes: b, Pos: offsetFieldList}
+	count := int(binary.LittleEndian.Uint16(b[offsetFieldCount:]))
+	for i := 0; i < count; i++ {
+		buf.Pos += 2
+		kind := buf.ReadType()
+		if match(kind) {
+			return true
+		}
+		drain(buf, kind)
+	}
+
+	return false
+}
@@ -0,0 +1,275 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"net"
+	"sync"
+	"time"
+)
+
+// FollowerOptions configures a Follower.
+type FollowerOptions struct {
+	// Timeout is the maximum time to connect and to wait for the next frame of the leader. Defaults to 10s.
+	Timeout time.Duration
+
+	// Retries is the amount of reconnects after a connection failure without any replicated record in
+	// between. Defaults to 3.
+	Retries int
+
+	// RetryDelay is the delay before a reconnect. Defaults to 1s.
+	RetryDelay time.Duration
+}
+
+// RefusedError is returned, if the leader refused to replicate, e.g. because the follower diverged.
+type RefusedError struct {
+	Reason string
+}
+
+func (e *RefusedError) Error() string {
+	return "leader refused replication: " + e.Reason
+}
+
+// Follower appends the records of a leader to a database, which must not be written otherwise until it is
+// promoted. It may be read concurrently.
+type Follower struct {
+	db   *logdb.DB
+	addr string
+	opts FollowerOptions
+
+	mutex   sync.Mutex // guards the database and the connection
+	conn    net.Conn
+	dirty   bool // whether anything has been applied since the last commit
+	stopped bool
+	stop    chan struct{}
+}
+
+// NewFollower creates a follower, which replicates the leader at the TCP address into the database.
+func NewFollower(db *logdb.DB, addr string, opts FollowerOptions) *Follower {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	return &Follower{db: db, addr: addr, opts: opts, stop: make(chan struct{})}
+}
+
+// Run replicates and reconnects after connection failures. It returns nil after Stop or Promote, a
+// RefusedError if the leader refused to replicate, or the last error if the retries are exhausted.
+func (f *Follower) Run() error {
+	failures := 0
+	for {
+		progressed, err := f.replicate()
+		if f.isStopped() {
+			return nil
+		}
+
+		if _, ok := err.(*RefusedError); ok {
+			return err
+		}
+
+		if progressed {
+			failures = 0
+		}
+
+		failures++
+		if failures > f.opts.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(f.opts.RetryDelay):
+		case <-f.stop:
+			return nil
+		}
+	}
+}
+
+// Stop ends the replication and closes the connection to the leader.
+func (f *Follower) Stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stopped {
+		return
+	}
+
+	f.stopped = true
+	close(f.stop)
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+}
+
+// Promote stops the replication and commits the database, which can be written afterwards, e.g. after the
+// leader failed.
+func (f *Follower) Promote() (*logdb.DB, error) {
+	f.Stop()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.db.Sync(); err != nil {
+		return nil, err
+	}
+
+	return f.db, nil
+}
+
+func (f *Follower) isStopped() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.stopped
+}
+
+// replicate connects once and applies the frames of the leader until the connection fails. It returns
+// whether any record has been appended.
+func (f *Follower) replicate() (bool, error) {
+	conn, err := net.DialTimeout("tcp", f.addr, f.opts.Timeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	f.mutex.Lock()
+	if f.stopped {
+		f.mutex.Unlock()
+		return false, nil
+	}
+	f.conn = conn
+	hello, err := f.hello()
+	f.mutex.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(f.opts.Timeout))
+	if err := writeFrame(conn, kindHello, hello); err != nil {
+		return false, err
+	}
+
+	r := bufio.NewReaderSize(conn, 1024*1024)
+	var buf []byte
+	progressed := false
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(f.opts.Timeout))
+		var kind byte
+		var payload []byte
+		kind, payload, buf, err = readFrame(r, buf)
+		if err != nil {
+			return progressed, err
+		}
+
+		if kind == kindError {
+			return progressed, &RefusedError{Reason: string(payload)}
+		}
+
+		f.mutex.Lock()
+		if f.stopped {
+			f.mutex.Unlock()
+			return progressed, nil
+		}
+		err = f.apply(kind, payload)
+		f.mutex.Unlock()
+
+		if err != nil {
+			return progressed, err
+		}
+
+		if kind == kindRecord {
+			progressed = true
+		}
+	}
+}
+
+// hello encodes the position of the follower.
+func (f *Follower) hello() ([]byte, error) {
+	hello := make([]byte, helloSize)
+	if records := f.db.Records(); len(records) > 0 {
+		last := records[len(records)-1]
+		raw, err := f.db.ReadRawRecord(last, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		binary.LittleEndian.PutUint64(hello, uint64(last.Offset+int64(last.Length)))
+		binary.LittleEndian.PutUint32(hello[8:], checksum(raw))
+	}
+
+	binary.LittleEndian.PutUint32(hello[12:], uint32(len(f.db.Names())))
+	if f.db.Options().Compression {
+		hello[16] = 1
+	}
+	binary.LittleEndian.PutUint32(hello[17:], uint32(len(f.db.Dictionary())))
+
+	return hello, nil
+}
+
+// apply applies a frame of the leader to the database.
+func (f *Follower) apply(kind byte, payload []byte) error {
+	switch kind {
+	case kindNames:
+		first, names, err := decodeNames(payload)
+		if err != nil {
+			return err
+		}
+
+		if first != len(f.db.Names()) {
+			return fmt.Errorf("expected name %d but got %d", len(f.db.Names()), first)
+		}
+
+		for i, name := range names {
+			if idx := int(f.db.PutName(name)); idx != first+i {
+				return fmt.Errorf("name %s has index %d instead of %d", name, idx, first+i)
+			}
+		}
+		f.dirty = true
+	case kindDictionary:
+		first, values, err := decodeNames(payload)
+		if err != nil {
+			return err
+		}
+
+		if first != len(f.db.Dictionary()) {
+			return fmt.Errorf("expected dictionary value %d but got %d", len(f.db.Dictionary()), first)
+		}
+
+		for i, value := range values {
+			if code := int(f.db.PutDictionaryValue(value)); code != first+i {
+				return fmt.Errorf("dictionary value %s has code %d instead of %d", value, code, first+i)
+			}
+		}
+		f.dirty = true
+	case kindRecord:
+		if len(payload) < 12 {
+			return fmt.Errorf("record frame is truncated")
+		}
+
+		offset := int64(binary.LittleEndian.Uint64(payload))
+		raw := payload[12:]
+		if sum := binary.LittleEndian.Uint32(payload[8:]); checksum(raw) != sum {
+			return fmt.Errorf("checksum mismatch of the record at offset %d", offset)
+		}
+
+		f.dirty = true
+		return f.db.AppendRawRecord(offset, raw)
+	case kindCommit:
+		if f.dirty {
+			f.dirty = false
+			return f.db.Sync()
+		}
+	default:
+		return fmt.Errorf("unexpected frame of kind %d", kind)
+	}
+
+	return nil
+}
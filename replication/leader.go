@@ -0,0 +1,260 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LeaderOptions configures a Leader.
+type LeaderOptions struct {
+	// PollInterval is the delay between two checks for newly flushed records. Defaults to 100ms.
+	PollInterval time.Duration
+
+	// HeartbeatInterval is the maximum delay between two frames, so that followers detect a dead leader.
+	// Defaults to 1s.
+	HeartbeatInterval time.Duration
+}
+
+// Leader streams the flushed records of a database to followers.
+type Leader struct {
+	db   *logdb.DB
+	opts LeaderOptions
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	done      chan struct{}
+}
+
+// NewLeader creates a leader for the database, which may be written concurrently.
+func NewLeader(db *logdb.DB, opts LeaderOptions) *Leader {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = time.Second
+	}
+
+	return &Leader{
+		db:        db,
+		opts:      opts,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Serve accepts followers and serves each in its own go routine, until the listener fails or the leader is
+// closed.
+func (l *Leader) Serve(ln net.Listener) error {
+	if !l.track(ln, nil) {
+		return errClosed
+	}
+	defer l.untrack(ln, nil)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if l.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			if !l.track(nil, conn) {
+				_ = conn.Close()
+				return
+			}
+			defer l.untrack(nil, conn)
+			defer conn.Close()
+
+			_ = l.serve(conn)
+		}()
+	}
+}
+
+// Close closes all listeners and connections.
+func (l *Leader) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+
+	for ln := range l.listeners {
+		_ = ln.Close()
+	}
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+
+	return nil
+}
+
+var errClosed = errors.New("leader is closed")
+
+// track registers a listener or connection and returns false, if the leader is closed.
+func (l *Leader) track(ln net.Listener, conn net.Conn) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return false
+	}
+
+	if ln != nil {
+		l.listeners[ln] = struct{}{}
+	} else {
+		l.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (l *Leader) untrack(ln net.Listener, conn net.Conn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.listeners, ln)
+	delete(l.conns, conn)
+}
+
+func (l *Leader) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+// serve checks the hello of a follower and streams the records after its end of file.
+func (l *Leader) serve(conn net.Conn) error {
+	w := bufio.NewWriterSize(conn, 1024*1024)
+
+	_ = conn.SetReadDeadline(time.Now().Add(l.opts.HeartbeatInterval * 10))
+	kind, payload, _, err := readFrame(conn, nil)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindHello || len(payload) != helloSize {
+		return fmt.Errorf("expected a hello frame")
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(payload))
+	sum := binary.LittleEndian.Uint32(payload[8:])
+	names := int(binary.LittleEndian.Uint32(payload[12:]))
+	values := int(binary.LittleEndian.Uint32(payload[17:]))
+
+	if err := l.check(offset, sum, names, values, payload[16] == 1); err != nil {
+		_ = writeFrame(w, kindError, []byte(err.Error()))
+		_ = w.Flush()
+		return err
+	}
+
+	var raw []byte
+	var hdr [12]byte
+	lastFrame := time.Now()
+	ticker := time.NewTicker(l.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		_ = conn.SetWriteDeadline(time.Now().Add(l.opts.HeartbeatInterval * 10))
+
+		// names and dictionary values are added before the records which use them, so they must be looked up
+		// afterwards
+		records := l.db.RecordsFrom(offset)
+		if all := l.db.Names(); len(all) > names {
+			if err := writeFrame(w, kindNames, encodeNames(names, all[names:])); err != nil {
+				return err
+			}
+			names = len(all)
+		}
+
+		if all := l.db.Dictionary(); len(all) > values {
+			if err := writeFrame(w, kindDictionary, encodeNames(values, all[values:])); err != nil {
+				return err
+			}
+			values = len(all)
+		}
+
+		for _, stat := range records {
+			if raw, err = l.db.ReadRawRecord(stat, raw); err != nil {
+				return err
+			}
+
+			binary.LittleEndian.PutUint64(hdr[:], uint64(stat.Offset))
+			binary.LittleEndian.PutUint32(hdr[8:], checksum(raw))
+			if err := writeFrame(w, kindRecord, hdr[:], raw); err != nil {
+				return err
+			}
+			offset = stat.Offset + int64(stat.Length)
+		}
+
+		if len(records) > 0 || time.Since(lastFrame) >= l.opts.HeartbeatInterval {
+			if err := writeFrame(w, kindCommit); err != nil {
+				return err
+			}
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			lastFrame = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-l.done:
+			return errClosed
+		}
+	}
+}
+
+// check verifies that the file of the follower is a prefix of the file of the leader.
+func (l *Leader) check(offset int64, sum uint32, names, values int, compressed bool) error {
+	if compressed != l.db.Options().Compression {
+		return fmt.Errorf("follower compression %v does not match the leader", compressed)
+	}
+
+	if names > len(l.db.Names()) {
+		return fmt.Errorf("follower has %d names, but the leader only %d", names, len(l.db.Names()))
+	}
+
+	if values > len(l.db.Dictionary()) {
+		return fmt.Errorf("follower has %d dictionary values, but the leader only %d", values, len(l.db.Dictionary()))
+	}
+
+	// a follower without records starts at the beginning
+	if offset == 0 {
+		return nil
+	}
+
+	records := l.db.Records()
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Offset+int64(records[i].Length) >= offset
+	})
+
+	if i == len(records) || records[i].Offset+int64(records[i].Length) != offset {
+		return fmt.Errorf("follower end of file %d is no record boundary of the leader", offset)
+	}
+
+	raw, err := l.db.ReadRawRecord(records[i], nil)
+	if err != nil {
+		return err
+	}
+
+	if checksum(raw) != sum {
+		return fmt.Errorf("follower record at offset %d differs from the leader", records[i].Offset)
+	}
+
+	return nil
+}
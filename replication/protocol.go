@@ -0,0 +1,142 @@
+// Package replication ships the committed records of a leader database to follower databases, which append
+// them byte-identically to their own files.
+//
+// A follower connects to the leader and sends a hello frame with the end of its last record, or 0 without
+// any, the checksum of its last record, its amount of names, whether it is compressed and its amount of
+// dictionary values. The leader checks that the follower is a prefix of itself and then streams names and
+// dictionary frames, which carry the names and dictionary values added since then, record frames, which carry
+// the file offset, the CRC-32 checksum and the raw bytes of a record, and commit frames. A follower verifies
+// each record before appending it and commits its header and file to stable storage with each commit frame,
+// which the leader also sends as a heartbeat. After a connection failure, a follower resumes from the end of
+// its file. Each frame is prefixed with its length:
+//
+//   - length              uint32, of kind and payload
+//   - kind                uint8
+//   - payload             variable, depending on kind
+//
+// A follower is a regular database, which can be promoted to accept writes after its leader failed.
+package replication
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Frame kinds.
+const (
+	// kindHello is sent by the follower and contains the end of its last record or 0 as uint64, the
+	// checksum of its last record as uint32, the amount of its names as uint32, whether it is compressed as
+	// uint8 and the amount of its dictionary values as uint32.
+	kindHello = 1
+
+	// kindNames contains the index of the first name as uint32, followed by the names as strings.
+	kindNames = 2
+
+	// kindRecord contains the file offset as uint64, the checksum as uint32 and the raw record.
+	kindRecord = 3
+
+	// kindCommit asks the follower to commit the appended records and is also sent as heartbeat.
+	kindCommit = 4
+
+	// kindError contains the reason why the leader refuses to replicate as string.
+	kindError = 5
+
+	// kindDictionary contains the code of the first dictionary value as uint32, followed by the values like
+	// the names of a names frame.
+	kindDictionary = 6
+)
+
+// helloSize is the size of the payload of a hello frame.
+const helloSize = 21
+
+// maxFrameSize limits the length of a frame, which is dominated by the maximum record size.
+const maxFrameSize = 128 * 1024 * 1024
+
+// checksum returns the CRC-32 checksum of a raw record.
+func checksum(raw []byte) uint32 {
+	return crc32.ChecksumIEEE(raw)
+}
+
+// readFrame reads the next frame into buf, which is grown as required, and returns its kind and payload.
+func readFrame(r io.Reader, buf []byte) (byte, []byte, []byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return 0, nil, buf, err
+	}
+
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length < 1 || length > maxFrameSize {
+		return 0, nil, buf, fmt.Errorf("invalid frame length %d", length)
+	}
+
+	if cap(buf) < int(length) {
+		buf = make([]byte, length)
+	}
+	buf = buf[:length]
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, buf, err
+	}
+
+	return buf[0], buf[1:], buf, nil
+}
+
+// writeFrame writes a frame with the concatenated payloads.
+func writeFrame(w io.Writer, kind byte, payload ...[]byte) error {
+	length := 1
+	for _, p := range payload {
+		length += len(p)
+	}
+
+	var prefix [5]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(length))
+	prefix[4] = kind
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+
+	for _, p := range payload {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeNames encodes the names starting at index first.
+func encodeNames(first int, names []string) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(first))
+	for _, name := range names {
+		b = append(b, byte(len(name)), byte(len(name)>>8))
+		b = append(b, name...)
+	}
+	return b
+}
+
+// decodeNames decodes a names frame.
+func decodeNames(payload []byte) (int, []string, error) {
+	if len(payload) < 4 {
+		return 0, nil, fmt.Errorf("names frame is truncated")
+	}
+
+	first := int(binary.LittleEndian.Uint32(payload))
+	var names []string
+	for p := payload[4:]; len(p) > 0; {
+		if len(p) < 2 || len(p) < 2+int(binary.LittleEndian.Uint16(p)) {
+			return 0, nil, fmt.Errorf("names frame is truncated")
+		}
+
+		n := int(binary.LittleEndian.Uint16(p))
+		names = append(names, string(p[2:2+n]))
+		p = p[2+n:]
+	}
+
+	return first, names, nil
+}
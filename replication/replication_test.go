@@ -0,0 +1,144 @@
+package replication
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	ioutil2 "io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T, dir, name string, compression bool) *logdb.DB {
+	db, err := logdb.OpenOptions(filepath.Join(dir, name), logdb.Options{Compression: compression, Quiet: true, ZoneMaps: true})
+	logdbtest.AssertNil(t, err)
+	return db
+}
+
+// addRecords adds records with ten objects each, whose names and dictionary values grow with each record.
+func addRecords(t *testing.T, db *logdb.DB, from, count int) {
+	status := db.PutName("status")
+	for r := from; r < from+count; r++ {
+		name := db.PutName(fmt.Sprintf("value-%d", r))
+		for i := 0; i < 10; i++ {
+			logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+				obj.AddInt(name, int64(r*10+i))
+				obj.AddDictString(status, fmt.Sprintf("status-%d", r+i%2))
+				return nil
+			}))
+		}
+		logdbtest.AssertNil(t, db.Flush())
+	}
+}
+
+// waitFor waits until the follower has the given amount of objects.
+func waitFor(t *testing.T, db *logdb.DB, objects uint64) {
+	t.Helper()
+	for start := time.Now(); db.ObjectCount() != objects; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected %d replicated objects but got %d", objects, db.ObjectCount())
+		}
+	}
+}
+
+func startLeader(t *testing.T, db *logdb.DB) (*Leader, string) {
+	leader := NewLeader(db, LeaderOptions{PollInterval: 10 * time.Millisecond, HeartbeatInterval: 50 * time.Millisecond})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	logdbtest.AssertNil(t, err)
+	go leader.Serve(ln)
+	return leader, ln.Addr().String()
+}
+
+func TestReplication(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		dir := logdbtest.TempDir(t)
+
+		db := openTestDB(t, dir, "leader.bin", compression)
+		addRecords(t, db, 0, 3)
+		leader, addr := startLeader(t, db)
+
+		follower := openTestDB(t, dir, "follower.bin", compression)
+		f := NewFollower(follower, addr, FollowerOptions{RetryDelay: 10 * time.Millisecond})
+		done := make(chan error)
+		go func() { done <- f.Run() }()
+
+		waitFor(t, follower, 30)
+		addRecords(t, db, 3, 2)
+		waitFor(t, follower, 50)
+
+		// the follower resumes after it has been stopped and reopened
+		f.Stop()
+		logdbtest.AssertNil(t, <-done)
+		logdbtest.AssertNil(t, follower.Close())
+
+		addRecords(t, db, 5, 2)
+		follower = openTestDB(t, dir, "follower.bin", compression)
+		f = NewFollower(follower, addr, FollowerOptions{RetryDelay: 10 * time.Millisecond})
+		go func() { done <- f.Run() }()
+		waitFor(t, follower, 70)
+
+		// ranges use the zone maps which the follower has built for the appended records
+		name := uint16(follower.IndexByName("value-6"))
+		var values []int64
+		err := follower.Scan(logdb.ScanOptions{Ranges: []logdb.Range{{Name: name, Min: 65, Max: 100}}}, func(gid int, id uint64, obj *logdb.Object) error {
+			obj.WithFields(func(n uint16, _ ioutil.Type, r *logdb.FieldReader) {
+				if n == name {
+					values = append(values, r.ReadInt())
+				}
+			})
+			return nil
+		})
+		logdbtest.AssertNil(t, err)
+		if fmt.Sprint(values) != "[65 66 67 68 69]" {
+			t.Fatalf("unexpected values %v", values)
+		}
+
+		if fmt.Sprint(follower.Dictionary()) != fmt.Sprint(db.Dictionary()) || len(db.Dictionary()) != 8 {
+			t.Fatalf("unexpected dictionary %v", follower.Dictionary())
+		}
+
+		promoted, err := f.Promote()
+		logdbtest.AssertNil(t, err)
+		logdbtest.AssertNil(t, <-done)
+		leader.Close()
+		logdbtest.AssertNil(t, db.Close())
+		logdbtest.AssertNil(t, promoted.Close())
+
+		leaderFile, err := ioutil2.ReadFile(filepath.Join(dir, "leader.bin"))
+		logdbtest.AssertNil(t, err)
+		followerFile, err := ioutil2.ReadFile(filepath.Join(dir, "follower.bin"))
+		logdbtest.AssertNil(t, err)
+		if !bytes.Equal(leaderFile, followerFile) {
+			t.Fatalf("follower file with %d bytes differs from the leader file with %d bytes", len(followerFile), len(leaderFile))
+		}
+
+		// the promoted follower accepts writes
+		promoted = openTestDB(t, dir, "follower.bin", compression)
+		addRecords(t, promoted, 7, 1)
+		logdbtest.AssertNil(t, promoted.Verify())
+		logdbtest.AssertNil(t, promoted.Close())
+	}
+}
+
+func TestDivergedFollower(t *testing.T) {
+	dir := logdbtest.TempDir(t)
+
+	db := openTestDB(t, dir, "leader.bin", false)
+	defer db.Close()
+	addRecords(t, db, 0, 2)
+	leader, addr := startLeader(t, db)
+	defer leader.Close()
+
+	follower := openTestDB(t, dir, "follower.bin", false)
+	defer follower.Close()
+	addRecords(t, follower, 1, 1)
+
+	err := NewFollower(follower, addr, FollowerOptions{}).Run()
+	if _, ok := err.(*RefusedError); !ok {
+		t.Fatalf("expected a refused replication but got %v", err)
+	}
+}
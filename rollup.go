@@ -0,0 +1,501 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// rollupSuffix is appended to the destination database file name to get the name of the checkpoint file.
+const rollupSuffix = ".rollup"
+
+var rollupMagic = [8]byte{'w', 'd', 'y', 'r', 'l', 'u', 'p', '1'}
+
+// Names of the fields which are written by Rollup in addition to the key field. The statistics of each
+// aggregated field are named by appending the suffixes .count, .min, .max, .sum and .avg to its name,
+// e.g. Temperature.avg.
+const (
+	RollupBucket     = "Bucket"     // int64, unix timestamp in seconds of the bucket start
+	RollupCount      = "Count"      // int64, amount of source objects in the group
+	RollupCheckpoint = "Checkpoint" // int64, the source checkpoint of the run which has written the row
+)
+
+var rollupStatSuffixes = [...]string{".count", ".min", ".max", ".sum", ".avg"}
+
+// RollupOptions configures a rollup job.
+type RollupOptions struct {
+	// KeyField is the name of the field to group by, e.g. a sensor id. It must contain a number or a string.
+	KeyField string
+
+	// TimeField is the name of a numeric field containing a unix timestamp in seconds.
+	TimeField string
+
+	// BucketDuration is the time span of a single group, e.g. an hour.
+	BucketDuration time.Duration
+
+	// Fields are the names of the numeric fields to aggregate.
+	Fields []string
+
+	// Routines is the amount of go routines to scan the source with.
+	Routines int
+}
+
+// rollupStats are the statistics of a single field within a group.
+type rollupStats struct {
+	count int64
+	min   float64
+	max   float64
+	sum   float64
+}
+
+func (s *rollupStats) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+}
+
+func (s *rollupStats) merge(o rollupStats) {
+	if o.count == 0 {
+		return
+	}
+
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+}
+
+// rollupGroup contains the statistics of a key within a bucket.
+type rollupGroup struct {
+	key    string // the normalized key value and bucket, which is also the primary key in the destination
+	bucket int64
+	count  int64
+	fields []rollupStats
+}
+
+func (g *rollupGroup) merge(o *rollupGroup) {
+	g.count += o.count
+	for i := range g.fields {
+		g.fields[i].merge(o.fields[i])
+	}
+}
+
+// rollupState is the persisted checkpoint of the destination. All source objects with an id below done are
+// contained in the destination. If target is greater than done, a run has been started but it is unknown
+// whether its rows have been written completely.
+//
+// Format specification:
+//  - magic               [8]byte, wdyrlup1
+//  - done                uint64
+//  - target              uint64
+type rollupState struct {
+	done   uint64
+	target uint64
+}
+
+func readRollupState(fname string) (rollupState, error) {
+	data, err := ioutil2.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return rollupState{}, nil
+	}
+
+	if err != nil {
+		return rollupState{}, err
+	}
+
+	if len(data) != 24 || string(data[:8]) != string(rollupMagic[:]) {
+		return rollupState{}, fmt.Errorf("invalid rollup checkpoint '%s'", fname)
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: data, Pos: 8}
+	return rollupState{done: buf.ReadUint64(), target: buf.ReadUint64()}, nil
+}
+
+func writeRollupState(fname string, state rollupState) error {
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 24)}
+	buf.WriteSlice(rollupMagic[:])
+	buf.WriteUint64(state.done)
+	buf.WriteUint64(state.target)
+
+	tmp := fname + ".tmp"
+	if err := ioutil2.WriteFile(tmp, buf.Bytes, os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fname)
+}
+
+// deriveRollupState returns the state of the last completed run, whose checkpoint is the highest one of the
+// destination rows, e.g. after Compact has dropped the checkpoint file.
+func deriveRollupState(dst *DB, checkpoint uint16) (rollupState, error) {
+	var done int64
+	err := dst.Scan(ScanOptions{Columns: []uint16{checkpoint}}, func(gid int, id uint64, obj *Object) error {
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			if name != checkpoint || !kind.IsNumber() {
+				return
+			}
+
+			if v := f.ReadInt(); v > done {
+				done = v
+			}
+		})
+		return nil
+	})
+
+	return rollupState{done: uint64(done), target: uint64(done)}, err
+}
+
+// rollupNames are the name indices of a rollup job in the source and destination.
+type rollupNames struct {
+	srcKey    uint16
+	srcTime   uint16
+	srcFields []uint16
+
+	key        uint16
+	bucket     uint16
+	count      uint16
+	checkpoint uint16
+	stats      [][len(rollupStatSuffixes)]uint16
+}
+
+// Rollup groups the objects of src by the key field and the time bucket and appends a row with the
+// statistics of each group into dst, see RollupCount and RollupBucket. The destination must be opened with
+// the primary key KeyField and RollupBucket. Only flushed source objects are considered.
+//
+// Each call continues at the checkpoint of the previous call, which is persisted next to the destination.
+// A missing checkpoint, e.g. after DB.Compact, is derived from the checkpoints of the rows.
+// A group which already has a row is merged with it and a new version is put. If a call has been
+// interrupted, e.g. by a crash, the next call repeats the interrupted run first and skips the rows which
+// have already been written. It returns the new checkpoint, which is the id after the last rolled up
+// source object. Rollup must not be called concurrently for the same destination.
+func Rollup(src, dst *DB, opts RollupOptions) (uint64, error) {
+	if dst.keys == nil || len(dst.opts.Key) != 2 || dst.opts.Key[0] != opts.KeyField || dst.opts.Key[1] != RollupBucket {
+		return 0, fmt.Errorf("destination must declare the key [%s %s]", opts.KeyField, RollupBucket)
+	}
+
+	if dst.opts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if opts.BucketDuration < time.Second {
+		return 0, fmt.Errorf("bucket duration must be at least a second")
+	}
+
+	fname := dst.fname + rollupSuffix
+	state, err := readRollupState(fname)
+	if err != nil {
+		return 0, err
+	}
+
+	names := rollupNames{
+		key:        dst.PutName(opts.KeyField),
+		bucket:     dst.PutName(RollupBucket),
+		count:      dst.PutName(RollupCount),
+		checkpoint: dst.PutName(RollupCheckpoint),
+	}
+
+	for _, field := range opts.Fields {
+		var stats [len(rollupStatSuffixes)]uint16
+		for i, suffix := range rollupStatSuffixes {
+			stats[i] = dst.PutName(field + suffix)
+		}
+		names.stats = append(names.stats, stats)
+	}
+
+	if state == (rollupState{}) {
+		if state, err = deriveRollupState(dst, names.checkpoint); err != nil {
+			return 0, err
+		}
+	}
+
+	// repeat an interrupted run, before starting a new one
+	if state.target > state.done {
+		if err := rollupRun(src, dst, opts, &names, state.done, state.target); err != nil {
+			return state.done, err
+		}
+
+		state.done = state.target
+		if err := writeRollupState(fname, state); err != nil {
+			return state.done, err
+		}
+	}
+
+	target := state.done
+	if last, ok := src.records.last(); ok && last.base+uint64(last.size) > target {
+		target = last.base + uint64(last.size)
+	}
+
+	if target == state.done {
+		return state.done, nil
+	}
+
+	state.target = target
+	if err := writeRollupState(fname, state); err != nil {
+		return state.done, err
+	}
+
+	if err := rollupRun(src, dst, opts, &names, state.done, state.target); err != nil {
+		return state.done, err
+	}
+
+	state.done = state.target
+	if err := writeRollupState(fname, state); err != nil {
+		return state.done, err
+	}
+
+	return state.done, nil
+}
+
+// rollupRun aggregates all source records whose ids are within [from, to) and puts the merged rows. Rows
+// which have already been written by this run are skipped.
+func rollupRun(src, dst *DB, opts RollupOptions, names *rollupNames, from, to uint64) error {
+	var records []recordInfo
+	for _, info := range src.findRecords() {
+		if info.base >= from && info.base+uint64(info.size) <= to {
+			records = append(records, info)
+		}
+	}
+
+	groups, err := rollupAggregate(src, records, opts, names)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]*rollupGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].bucket != sorted[j].bucket {
+			return sorted[i].bucket < sorted[j].bucket
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	for _, g := range sorted {
+		dst.keys.mutex.RLock()
+		id, exists := dst.keys.latest[g.key]
+		dst.keys.mutex.RUnlock()
+
+		if exists {
+			applied := false
+			err := dst.Read(id, func(obj *Object) error {
+				existing, checkpoint := readRollupRow(obj, names)
+				applied = checkpoint >= int64(to)
+				g.merge(existing)
+				return nil
+			})
+
+			if err != nil {
+				return fmt.Errorf("unable to read rollup row: %w", err)
+			}
+
+			if applied {
+				continue
+			}
+		}
+
+		if err := dst.Put(func(obj *Object) error {
+			return writeRollupRow(obj, g, names, to)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return dst.Flush()
+}
+
+// rollupAggregate scans the records in parallel and returns the merged groups by their key.
+func rollupAggregate(src *DB, records []recordInfo, opts RollupOptions, names *rollupNames) (map[string]*rollupGroup, error) {
+	keyIdx := src.IndexByName(opts.KeyField)
+	timeIdx := src.IndexByName(opts.TimeField)
+	if keyIdx < 0 || timeIdx < 0 || len(records) == 0 {
+		return nil, nil
+	}
+
+	names.srcKey, names.srcTime = uint16(keyIdx), uint16(timeIdx)
+	names.srcFields = names.srcFields[:0]
+	for _, field := range opts.Fields {
+		idx := src.IndexByName(field)
+		if idx < 0 {
+			idx = int(ioutil.MaxUint16) // never matches a field, because the name table cannot be that large
+		}
+		names.srcFields = append(names.srcFields, uint16(idx))
+	}
+
+	routines := opts.Routines
+	if routines < 1 {
+		routines = 1
+	}
+
+	type scratch struct {
+		groups  map[string]*rollupGroup
+		key     []byte
+		values  []float64
+		present []bool
+	}
+
+	perRoutine := make([]scratch, routines)
+	for i := range perRoutine {
+		perRoutine[i] = scratch{
+			groups:  make(map[string]*rollupGroup),
+			values:  make([]float64, len(opts.Fields)),
+			present: make([]bool, len(opts.Fields)),
+		}
+	}
+
+	err := src.forEachRecordP(routines, records, nil, func(gid int, id uint64, obj *Object) error {
+		s := &perRoutine[gid]
+		s.key = s.key[:0]
+		hasKey, hasTime := false, false
+		var unix int64
+		for i := range s.present {
+			s.present[i] = false
+		}
+
+		var err error
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			switch {
+			case name == names.srcKey && !hasKey:
+				s.key = appendValue(s.key, kind, f)
+				hasKey = true
+				if k := s.key[0]; k != 'i' && k != 'f' && k != 's' {
+					err = fmt.Errorf("unsupported key type %d of object %d", kind, id)
+				}
+			case name == names.srcTime && !hasTime && kind.IsNumber():
+				unix = f.ReadInt()
+				hasTime = true
+			case kind.IsNumber():
+				for i, fieldName := range names.srcFields {
+					if fieldName == name && !s.present[i] {
+						s.values[i] = f.ReadFloat()
+						s.present[i] = !math.IsNaN(s.values[i])
+						break
+					}
+				}
+			}
+		})
+
+		if err != nil || !hasKey || !hasTime {
+			return err
+		}
+
+		bucket := bucketOf(unix, opts.BucketDuration)
+		s.key = appendUint64(append(s.key, 'i'), uint64(bucket))
+		g, ok := s.groups[string(s.key)]
+		if !ok {
+			g = &rollupGroup{key: string(s.key), bucket: bucket, fields: make([]rollupStats, len(opts.Fields))}
+			s.groups[g.key] = g
+		}
+
+		g.count++
+		for i, present := range s.present {
+			if present {
+				g.fields[i].add(s.values[i])
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	groups := perRoutine[0].groups
+	for _, s := range perRoutine[1:] {
+		for key, g := range s.groups {
+			if existing, ok := groups[key]; ok {
+				existing.merge(g)
+			} else {
+				groups[key] = g
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// readRollupRow parses the statistics and the checkpoint of a destination row.
+func readRollupRow(obj *Object, names *rollupNames) (*rollupGroup, int64) {
+	g := &rollupGroup{fields: make([]rollupStats, len(names.stats))}
+	var checkpoint int64
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+		if !kind.IsNumber() {
+			return
+		}
+
+		switch name {
+		case names.count:
+			g.count = f.ReadInt()
+			return
+		case names.checkpoint:
+			checkpoint = f.ReadInt()
+			return
+		}
+
+		for i, stats := range names.stats {
+			switch name {
+			case stats[0]:
+				g.fields[i].count = f.ReadInt()
+			case stats[1]:
+				g.fields[i].min = f.ReadFloat()
+			case stats[2]:
+				g.fields[i].max = f.ReadFloat()
+			case stats[3]:
+				g.fields[i].sum = f.ReadFloat()
+			}
+		}
+	})
+
+	return g, checkpoint
+}
+
+// writeRollupRow adds the key, the bucket, the checkpoint and the statistics of all fields, which have at
+// least a single value.
+func writeRollupRow(obj *Object, g *rollupGroup, names *rollupNames, checkpoint uint64) error {
+	// the key is the normalized key value followed by the normalized bucket, see appendValue
+	key := g.key[:len(g.key)-9]
+	switch key[0] {
+	case 'i':
+		obj.AddInt(names.key, int64(binary.LittleEndian.Uint64([]byte(key[1:]))))
+	case 'f':
+		obj.AddFloat(names.key, math.Float64frombits(binary.LittleEndian.Uint64([]byte(key[1:]))))
+	case 's':
+		obj.AddString(names.key, key[5:])
+	default:
+		return fmt.Errorf("unsupported key type '%c'", key[0])
+	}
+
+	obj.AddInt(names.bucket, g.bucket)
+	obj.AddInt(names.count, g.count)
+	obj.AddInt(names.checkpoint, int64(checkpoint))
+	for i, stats := range g.fields {
+		if stats.count == 0 {
+			continue
+		}
+
+		obj.AddInt(names.stats[i][0], stats.count)
+		obj.AddFloat(names.stats[i][1], stats.min)
+		obj.AddFloat(names.stats[i][2], stats.max)
+		obj.AddFloat(names.stats[i][3], stats.sum)
+		obj.AddFloat(names.stats[i][4], stats.sum/float64(stats.count))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package logdb
+
+import (
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollup(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "rollupTest")
+	assertNil(t, err)
+
+	src, err := OpenOptions(filepath.Join(dir, "src.bin"), Options{})
+	assertNil(t, err)
+	defer src.Close()
+
+	dst, err := OpenOptions(filepath.Join(dir, "dst.bin"), Options{Key: []string{"SensorId", RollupBucket}})
+	assertNil(t, err)
+	defer dst.Close()
+
+	colSensorId := src.PutName("SensorId")
+	colTimestamp := src.PutName("Timestamp")
+	colTemperature := src.PutName("Temperature")
+
+	start := time.Date(2020, 7, 8, 0, 0, 0, 0, time.UTC).Unix()
+
+	// one value per minute for 2 sensors, the temperature is the minute of the hour
+	add := func(fromMinute, toMinute int) {
+		for i := fromMinute; i < toMinute; i++ {
+			for sensorId := uint32(1); sensorId <= 2; sensorId++ {
+				err := src.Add(func(obj *Object) error {
+					obj.AddUint32(colSensorId, sensorId)
+					obj.AddUint32(colTimestamp, uint32(start)+uint32(i)*60)
+					obj.AddInt8(colTemperature, int8(i%60))
+					return nil
+				})
+				assertNil(t, err)
+			}
+
+			if i%25 == 24 {
+				assertNil(t, src.Flush())
+			}
+		}
+		assertNil(t, src.Flush())
+	}
+
+	opts := RollupOptions{
+		KeyField:       "SensorId",
+		TimeField:      "Timestamp",
+		BucketDuration: time.Hour,
+		Fields:         []string{"Temperature"},
+		Routines:       3,
+	}
+
+	type row struct {
+		count    int64
+		min, max float64
+		avg      float64
+	}
+
+	rows := func() map[[2]int64]row {
+		colBucket := uint16(dst.IndexByName(RollupBucket))
+		colCount := uint16(dst.IndexByName(RollupCount))
+		colMin := uint16(dst.IndexByName("Temperature.min"))
+		colMax := uint16(dst.IndexByName("Temperature.max"))
+		colAvg := uint16(dst.IndexByName("Temperature.avg"))
+		colKey := uint16(dst.IndexByName("SensorId"))
+
+		res := make(map[[2]int64]row)
+		err := dst.Scan(ScanOptions{LatestOnly: true}, func(gid int, id uint64, obj *Object) error {
+			var key [2]int64
+			var r row
+			obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+				switch name {
+				case colKey:
+					key[0] = f.ReadInt()
+				case colBucket:
+					key[1] = f.ReadInt()
+				case colCount:
+					r.count = f.ReadInt()
+				case colMin:
+					r.min = f.ReadFloat()
+				case colMax:
+					r.max = f.ReadFloat()
+				case colAvg:
+					r.avg = f.ReadFloat()
+				}
+			})
+			res[key] = r
+			return nil
+		})
+		assertNil(t, err)
+
+		return res
+	}
+
+	// the first hour and a half
+	add(0, 90)
+	checkpoint, err := Rollup(src, dst, opts)
+	assertNil(t, err)
+
+	res := rows()
+	if len(res) != 4 {
+		t.Fatalf("expected 4 groups but got %d", len(res))
+	}
+
+	if r := res[[2]int64{1, start}]; r.count != 60 || r.min != 0 || r.max != 59 || r.avg != 29.5 {
+		t.Fatalf("unexpected first hour %+v", r)
+	}
+
+	if r := res[[2]int64{2, start + 3600}]; r.count != 30 || r.min != 0 || r.max != 29 {
+		t.Fatalf("unexpected partial second hour %+v", r)
+	}
+
+	// nothing new to roll up
+	again, err := Rollup(src, dst, opts)
+	assertNil(t, err)
+	if again != checkpoint {
+		t.Fatalf("expected checkpoint %d but got %d", checkpoint, again)
+	}
+
+	// complete the second hour, which is merged with the existing rows
+	add(90, 120)
+	next, err := Rollup(src, dst, opts)
+	assertNil(t, err)
+	if next <= checkpoint {
+		t.Fatalf("checkpoint has not been advanced")
+	}
+
+	expected := map[[2]int64]row{
+		{1, start}: {60, 0, 59, 29.5}, {1, start + 3600}: {60, 0, 59, 29.5},
+		{2, start}: {60, 0, 59, 29.5}, {2, start + 3600}: {60, 0, 59, 29.5},
+	}
+
+	res = rows()
+	for key, r := range expected {
+		if res[key] != r {
+			t.Fatalf("expected %+v for %v but got %+v", r, key, res[key])
+		}
+	}
+
+	// simulate a crash after all rows of the last run have been written but before it has been marked as done
+	assertNil(t, writeRollupState(dst.fname+rollupSuffix, rollupState{done: checkpoint, target: next}))
+	resumed, err := Rollup(src, dst, opts)
+	assertNil(t, err)
+	if resumed != next {
+		t.Fatalf("expected checkpoint %d but got %d", next, resumed)
+	}
+
+	res = rows()
+	for key, r := range expected {
+		if res[key] != r {
+			t.Fatalf("expected %+v for %v after resume but got %+v", r, key, res[key])
+		}
+	}
+
+	// a compaction drops the checkpoint, which is derived from the rows again
+	assertNil(t, dst.Compact())
+	if _, err := os.Stat(dst.fname + rollupSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint to be dropped by the compaction: %v", err)
+	}
+
+	add(120, 150)
+	derived, err := Rollup(src, dst, opts)
+	assertNil(t, err)
+	if derived <= next {
+		t.Fatalf("checkpoint has not been advanced after the compaction")
+	}
+
+	expected[[2]int64{1, start + 7200}] = row{30, 0, 29, 14.5}
+	expected[[2]int64{2, start + 7200}] = row{30, 0, 29, 14.5}
+	res = rows()
+	for key, r := range expected {
+		if res[key] != r {
+			t.Fatalf("expected %+v for %v after compaction but got %+v", r, key, res[key])
+		}
+	}
+
+	// an interrupted run cannot be derived from the rows
+	assertNil(t, writeRollupState(dst.fname+rollupSuffix, rollupState{done: next, target: derived}))
+	if err := dst.Compact(); err == nil {
+		t.Fatalf("expected error for compaction after an interrupted rollup")
+	}
+
+	if _, err := Rollup(src, src, opts); err == nil {
+		t.Fatalf("expected error for destination without key")
+	}
+}
@@ -0,0 +1,84 @@
+package logdb
+
+import (
+	"encoding/hex"
+	"github.com/worldiety/ioutil"
+	"math"
+	"strconv"
+)
+
+// Scalar field types beyond those of ioutil. Unlike the integers of ioutil, which have been used for booleans
+// and seconds before, they keep the meaning of their values, so that tools like the exporter can render them.
+const (
+	// TNull is an explicit null, which has no value at all.
+	TNull ioutil.Type = 34
+
+	// TBool is a boolean as a single byte, which is 0 for false and 1 for true.
+	TBool ioutil.Type = 35
+
+	// TTimestamp is a point in time as int64 nanoseconds since the unix epoch in UTC, which covers the years
+	// 1678 to 2262.
+	TTimestamp ioutil.Type = 36
+
+	// TUUID is a universally unique identifier with 16 bytes in network byte order.
+	TUUID ioutil.Type = 37
+
+	// TDecimal is a fixed-point number, i.e. its unscaled value as int64, followed by its scale as uint8.
+	TDecimal ioutil.Type = 38
+
+	// tTimestampDelta is a timestamp which is stored as zigzag varint delta to the first timestamp of its
+	// record, see Options.TimestampDeltas. It only exists within records and is resolved into a TTimestamp
+	// while reading them.
+	tTimestampDelta ioutil.Type = 39
+)
+
+// UUID is a universally unique identifier, see TUUID.
+type UUID [16]byte
+
+// String returns the canonical form of the identifier, e.g. 123e4567-e89b-12d3-a456-426614174000.
+func (u UUID) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// Decimal is a fixed-point number, whose value is Unscaled * 10^-Scale, see TDecimal. Unlike a float, it
+// represents decimal fractions like prices exactly.
+type Decimal struct {
+	Unscaled int64
+	Scale    uint8
+}
+
+// String returns the exact decimal representation of the number, e.g. -12.50 for -1250 with a scale of 2.
+func (d Decimal) String() string {
+	digits := strconv.FormatUint(uint64(d.Unscaled), 10)
+	sign := ""
+	if d.Unscaled < 0 {
+		digits = strconv.FormatUint(uint64(-d.Unscaled), 10)
+		sign = "-"
+	}
+
+	scale := int(d.Scale)
+	if scale == 0 {
+		return sign + digits
+	}
+
+	for len(digits) <= scale {
+		digits = "0" + digits
+	}
+
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// Float64 returns the nearest float of the number.
+func (d Decimal) Float64() float64 {
+	return float64(d.Unscaled) / math.Pow10(int(d.Scale))
+}
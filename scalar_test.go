@@ -0,0 +1,132 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScalars(t *testing.T) {
+	base := time.Date(2150, 1, 2, 3, 4, 5, 0, time.UTC)
+	sizes := map[bool]int64{}
+
+	for _, deltas := range []bool{false, true} {
+		for _, compression := range []bool{false, true} {
+			dir, err := ioutil2.TempDir("", "scalarTest")
+			assertNil(t, err)
+
+			fname := filepath.Join(dir, "db.bin")
+			db, err := OpenOptions(fname, Options{Quiet: true, Compression: compression, TimestampDeltas: deltas})
+			assertNil(t, err)
+
+			at, ok, id, price, null, nested := db.PutName("at"), db.PutName("ok"), db.PutName("id"), db.PutName("price"),
+				db.PutName("null"), db.PutName("nested")
+			expected := func(i int) string {
+				if i%10 == 0 {
+					// the first object of each record has no timestamp and the sixth one a far one
+					return fmt.Sprintf("ok=%v null", i%2 == 0)
+				}
+
+				ts := base.Add(time.Duration(i) * time.Millisecond)
+				if i%10 == 5 {
+					ts = time.Date(1700, 1, 1, 0, 0, 0, 0, time.UTC)
+				}
+
+				return fmt.Sprintf("at=%s id=%02x-%d price=%d.%02d ok=%v nested={at=%s}", ts.Format(time.RFC3339Nano), i, i,
+					i, i, i%2 == 0, ts.Format(time.RFC3339Nano))
+			}
+
+			for r := 0; r < 3; r++ {
+				for i := r * 10; i < r*10+10; i++ {
+					assertNil(t, db.Add(func(obj *Object) error {
+						if i%10 == 0 {
+							obj.AddBool(ok, i%2 == 0)
+							obj.AddNull(null)
+							return nil
+						}
+
+						ts := base.Add(time.Duration(i) * time.Millisecond)
+						if i%10 == 5 {
+							ts = time.Date(1700, 1, 1, 0, 0, 0, 0, time.UTC)
+						}
+
+						obj.AddTimestamp(at, ts)
+						obj.AddUUID(id, UUID{15: byte(i)})
+						obj.AddDecimal(price, Decimal{Unscaled: int64(i*100 + i), Scale: 2})
+						obj.AddBool(ok, i%2 == 0)
+						obj.AddObject(nested, func(f *FieldWriter) {
+							f.WriteName(at)
+							f.WriteTimestamp(ts)
+						})
+						return nil
+					}))
+				}
+				assertNil(t, db.Flush())
+			}
+			assertNil(t, db.Verify())
+
+			var visit func(obj *Object) string
+			visit = func(obj *Object) string {
+				s := ""
+				obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+					if s != "" {
+						s += " "
+					}
+
+					switch kind {
+					case TNull:
+						s += "null"
+					case TBool:
+						s += fmt.Sprintf("ok=%v", f.ReadBool())
+					case TTimestamp:
+						s += "at=" + f.ReadTimestamp().Format(time.RFC3339Nano)
+					case TUUID:
+						v := f.ReadUUID()
+						s += fmt.Sprintf("id=%02x-%d", v[15], v[15])
+					case TDecimal:
+						s += "price=" + f.ReadDecimal().String()
+					case TObject:
+						s += "nested={" + visit(f.ReadObject()) + "}"
+					default:
+						t.Fatalf("unexpected type %d", kind)
+					}
+				})
+				return s
+			}
+
+			var ids []uint64
+			assertNil(t, db.ForEach(func(oid uint64, obj *Object) error {
+				if s := visit(obj); s != expected(len(ids)) {
+					t.Fatalf("expected %q but got %q", expected(len(ids)), s)
+				}
+				ids = append(ids, oid)
+				return nil
+			}))
+
+			for i, oid := range ids {
+				assertNil(t, db.Read(oid, func(obj *Object) error {
+					if s := visit(obj); s != expected(i) {
+						t.Fatalf("expected %q but got %q", expected(i), s)
+					}
+					return nil
+				}))
+			}
+
+			for _, stat := range db.Records() {
+				sizes[deltas] += int64(stat.Size)
+			}
+			assertNil(t, db.Close())
+		}
+	}
+
+	if sizes[true] >= sizes[false] {
+		t.Fatalf("expected timestamp deltas to shrink the records, but got %v", sizes)
+	}
+
+	if s := (Decimal{Unscaled: -5, Scale: 3}).String(); s != "-0.005" {
+		t.Fatalf("unexpected decimal %s", s)
+	}
+}
@@ -0,0 +1,96 @@
+package logdb
+
+import "fmt"
+
+// ScanOptions configures a Scan.
+type ScanOptions struct {
+	// Routines is the amount of go routines to walk over the records in parallel. Values smaller than 1
+	// are treated as 1.
+	Routines int
+
+	// LatestOnly skips all objects which have been superseded by a newer version of the same key. This
+	// requires a declared key, see Options.Key.
+	LatestOnly bool
+
+	// Ranges only visits objects which match all ranges. Records which cannot contain any matching
+	// object are skipped without reading them, if zone maps are available, see Options.ZoneMaps.
+	Ranges []Range
+
+	// Equals only visits objects which match all equality predicates. Records which definitely do not
+	// contain a value are skipped without reading them, if bloom filters are available, see
+	// Options.BloomFilters.
+	Equals []Equal
+
+	// Columns restricts the objects of columnar records to the fields of these names, so that only their
+	// chunks are decoded, see Options.Columnar. Objects of row records still contain all fields. The names of
+	// Ranges and Equals and the key names for LatestOnly are added implicitly. Nil visits all fields.
+	Columns []uint16
+}
+
+// Scan walks in parallel over all objects, like ForEachP, but visits only those objects which satisfy
+// the given options.
+func (db *DB) Scan(opts ScanOptions, f func(gid int, id uint64, obj *Object) error) error {
+	if opts.LatestOnly && db.keys == nil {
+		return fmt.Errorf("LatestOnly requires a declared key")
+	}
+
+	records := db.findRecords()
+	if db.zones != nil && len(opts.Ranges) > 0 {
+		records = db.zones.filter(records, opts.Ranges)
+	}
+
+	if db.blooms != nil && len(opts.Equals) > 0 {
+		var err error
+		if records, err = db.blooms.filter(records, opts.Equals); err != nil {
+			return err
+		}
+	}
+
+	routines := opts.Routines
+	if routines < 1 {
+		routines = 1
+	}
+
+	columns := opts.Columns
+	if columns != nil {
+		columns = append([]uint16(nil), columns...)
+		for _, r := range opts.Ranges {
+			columns = append(columns, r.Name)
+		}
+		for _, e := range opts.Equals {
+			columns = append(columns, e.Name)
+		}
+		if opts.LatestOnly {
+			columns = append(columns, db.keys.names...)
+		}
+	}
+
+	if !opts.LatestOnly && len(opts.Ranges) == 0 && len(opts.Equals) == 0 {
+		return db.forEachRecordP(routines, records, columns, f)
+	}
+
+	keyBufs := make([][]byte, routines)
+	return db.forEachRecordP(routines, records, columns, func(gid int, id uint64, obj *Object) error {
+		for _, r := range opts.Ranges {
+			if !r.Match(obj) {
+				return nil
+			}
+		}
+
+		for _, e := range opts.Equals {
+			if !e.Match(obj) {
+				return nil
+			}
+		}
+
+		if opts.LatestOnly {
+			var latest bool
+			keyBufs[gid], latest = db.keys.isLatest(keyBufs[gid], id, obj)
+			if !latest {
+				return nil
+			}
+		}
+
+		return f(gid, id, obj)
+	})
+}
@@ -0,0 +1,667 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+	ioutil2 "io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secondaryIndexSuffix is appended to the database file name, followed by the indexed name, to get the name of
+// the manifest file of a secondary index. The run files append another dot and their sequence number.
+const secondaryIndexSuffix = ".sidx."
+
+const (
+	indexEntrySize      = 16
+	defaultIndexRunSize = 1 << 22 // 4 million entries or 64MiB per sorted run
+	maxIndexRunRatio    = 4       // merge runs, as soon as a newer run is at least 1/4 of its predecessor
+)
+
+var indexMagic = [8]byte{'w', 'd', 'y', 's', 'i', 'd', 'x', '1'}
+
+// IndexOptions configures the creation of a secondary index.
+type IndexOptions struct {
+	// Routines is the amount of go routines to scan the existing records.
+	Routines int
+
+	// RunSize is the maximum amount of entries which are sorted in memory, before they are written into a
+	// sorted run. Zero means 4 million entries.
+	RunSize int
+}
+
+// indexEntry maps an order preserving key to an object id.
+type indexEntry struct {
+	key uint64
+	id  uint64
+}
+
+// indexKey converts a float into an unsigned integer with the same order.
+func indexKey(v float64) uint64 {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+
+	return bits | 1<<63
+}
+
+// indexValue reverses indexKey.
+func indexValue(key uint64) float64 {
+	if key&(1<<63) != 0 {
+		return math.Float64frombits(key &^ (1 << 63))
+	}
+
+	return math.Float64frombits(^key)
+}
+
+// indexRun is a file of entries, sorted by key and id.
+type indexRun struct {
+	seq   uint64
+	count uint64
+}
+
+// secondaryIndex keeps sorted runs of a single name. Each flush adds a small run and runs of similar size
+// are merged, so that the amount of runs grows only logarithmically.
+//
+// Format specification of the manifest:
+//  - magic               [8]byte, wdysidx1
+//  - name                uint16
+//  - coveredRecords      uint64, the amount of records from the record index which are contained
+//  - lastRecordOffset    int64, the offset of the last covered record or -1
+//  - nextSeq             uint64, the next sequence number for a run file
+//  - runCount            uint32
+//  - []                  runCount times
+//     {
+//       - seq            uint64
+//       - count          uint64
+//     }
+//
+// Each run file is a sequence of entries, each consisting of an uint64 order preserving key, see indexKey, and
+// the uint64 object id.
+type secondaryIndex struct {
+	fname            string
+	name             uint16
+	coveredRecords   uint64
+	lastRecordOffset int64
+	nextSeq          uint64
+	runs             []indexRun
+	pending          []indexEntry
+	mutex            sync.RWMutex
+}
+
+func (x *secondaryIndex) runName(seq uint64) string {
+	return x.fname + "." + strconv.FormatUint(seq, 10)
+}
+
+func (x *secondaryIndex) writeManifest() error {
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 38+len(x.runs)*16)}
+	buf.WriteSlice(indexMagic[:])
+	buf.WriteUint16(x.name)
+	buf.WriteUint64(x.coveredRecords)
+	buf.WriteUint64(uint64(x.lastRecordOffset))
+	buf.WriteUint64(x.nextSeq)
+	buf.WriteUint32(uint32(len(x.runs)))
+	for _, run := range x.runs {
+		buf.WriteUint64(run.seq)
+		buf.WriteUint64(run.count)
+	}
+
+	tmp := x.fname + ".tmp"
+	if err := ioutil2.WriteFile(tmp, buf.Bytes, os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, x.fname)
+}
+
+func readIndexManifest(fname string) (*secondaryIndex, error) {
+	data, err := ioutil2.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 38 || string(data[:8]) != string(indexMagic[:]) {
+		return nil, fmt.Errorf("invalid index manifest '%s'", fname)
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: data, Pos: 8}
+	x := &secondaryIndex{fname: fname}
+	x.name = buf.ReadUint16()
+	x.coveredRecords = buf.ReadUint64()
+	x.lastRecordOffset = int64(buf.ReadUint64())
+	x.nextSeq = buf.ReadUint64()
+	count := int(buf.ReadUint32())
+	if len(data) != 38+count*16 {
+		return nil, fmt.Errorf("invalid index manifest '%s'", fname)
+	}
+
+	for i := 0; i < count; i++ {
+		x.runs = append(x.runs, indexRun{seq: buf.ReadUint64(), count: buf.ReadUint64()})
+	}
+
+	return x, nil
+}
+
+// writeRun sorts the entries and writes them into a new run file.
+func (x *secondaryIndex) writeRun(entries []indexEntry) (indexRun, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key == entries[j].key {
+			return entries[i].id < entries[j].id
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	run := indexRun{seq: x.nextSeq, count: uint64(len(entries))}
+	x.nextSeq++
+
+	w, err := newRunWriter(x.runName(run.seq))
+	if err != nil {
+		return run, err
+	}
+
+	for _, e := range entries {
+		if err := w.write(e); err != nil {
+			_ = w.file.Close()
+			return run, err
+		}
+	}
+
+	return run, w.Close()
+}
+
+// mergeRuns merges the given runs into a new run. The caller is responsible to remove the merged run files.
+func (x *secondaryIndex) mergeRuns(runs []indexRun) (indexRun, error) {
+	merged := indexRun{seq: x.nextSeq}
+	x.nextSeq++
+
+	w, err := newRunWriter(x.runName(merged.seq))
+	if err != nil {
+		return merged, err
+	}
+
+	err = x.merge(runs, 0, math.MaxUint64, func(e indexEntry) error {
+		merged.count++
+		return w.write(e)
+	})
+
+	if err != nil {
+		_ = w.file.Close()
+		return merged, err
+	}
+
+	if err := w.Close(); err != nil {
+		return merged, err
+	}
+
+	return merged, nil
+}
+
+// compact merges the newest runs as long as they have a similar size.
+func (x *secondaryIndex) compact() error {
+	for len(x.runs) >= 2 {
+		a, b := x.runs[len(x.runs)-2], x.runs[len(x.runs)-1]
+		if b.count*maxIndexRunRatio < a.count {
+			return nil
+		}
+
+		merged, err := x.mergeRuns([]indexRun{a, b})
+		if err != nil {
+			return err
+		}
+
+		x.runs = append(x.runs[:len(x.runs)-2], merged)
+		if err := x.writeManifest(); err != nil {
+			return err
+		}
+
+		_ = os.Remove(x.runName(a.seq))
+		_ = os.Remove(x.runName(b.seq))
+	}
+
+	return nil
+}
+
+// addRun appends the entries as a new run and marks the given records as covered.
+func (x *secondaryIndex) addRun(entries []indexEntry, covered []recordInfo) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if len(entries) > 0 {
+		run, err := x.writeRun(entries)
+		if err != nil {
+			return err
+		}
+		x.runs = append(x.runs, run)
+	}
+
+	if len(covered) > 0 {
+		x.coveredRecords += uint64(len(covered))
+		x.lastRecordOffset = covered[len(covered)-1].offset
+	}
+
+	if err := x.writeManifest(); err != nil {
+		return err
+	}
+
+	return x.compact()
+}
+
+// merge visits all entries of the runs whose keys are within [lo, hi] in key order.
+func (x *secondaryIndex) merge(runs []indexRun, lo, hi uint64, f func(e indexEntry) error) error {
+	readers := make([]*runReader, 0, len(runs))
+	defer func() {
+		for _, r := range readers {
+			_ = r.file.Close()
+		}
+	}()
+
+	heads := make([]indexEntry, 0, len(runs))
+	for _, run := range runs {
+		r, err := openRunReader(x.runName(run.seq), run.count)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, r)
+
+		if err := r.seek(lo); err != nil {
+			return err
+		}
+
+		e, ok, err := r.next()
+		if err != nil {
+			return err
+		}
+
+		if !ok || e.key > hi {
+			readers = readers[:len(readers)-1]
+			_ = r.file.Close()
+			continue
+		}
+
+		heads = append(heads, e)
+	}
+
+	for len(heads) > 0 {
+		min := 0
+		for i := 1; i < len(heads); i++ {
+			if heads[i].key < heads[min].key || heads[i].key == heads[min].key && heads[i].id < heads[min].id {
+				min = i
+			}
+		}
+
+		if err := f(heads[min]); err != nil {
+			return err
+		}
+
+		e, ok, err := readers[min].next()
+		if err != nil {
+			return err
+		}
+
+		if ok && e.key <= hi {
+			heads[min] = e
+			continue
+		}
+
+		_ = readers[min].file.Close()
+		readers = append(readers[:min], readers[min+1:]...)
+		heads = append(heads[:min], heads[min+1:]...)
+	}
+
+	return nil
+}
+
+// runWriter writes buffered entries into a run file.
+type runWriter struct {
+	file *os.File
+	buf  *ioutil.LittleEndianBuffer
+	pos  int64
+}
+
+func newRunWriter(fname string) (*runWriter, error) {
+	file, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runWriter{file: file, buf: &ioutil.LittleEndianBuffer{Bytes: make([]byte, 4096*indexEntrySize)}}, nil
+}
+
+func (w *runWriter) write(e indexEntry) error {
+	if w.buf.Pos == len(w.buf.Bytes) {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	w.buf.WriteUint64(e.key)
+	w.buf.WriteUint64(e.id)
+	return nil
+}
+
+func (w *runWriter) flush() error {
+	n, err := w.file.WriteAt(w.buf.Bytes[:w.buf.Pos], w.pos)
+	w.pos += int64(n)
+	w.buf.Pos = 0
+	return err
+}
+
+func (w *runWriter) Close() error {
+	if err := w.flush(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// runReader reads buffered entries from a run file.
+type runReader struct {
+	file   *os.File
+	count  int64
+	cursor int64 // index of the next entry to read
+	buf    *ioutil.LittleEndianBuffer
+	start  int64 // index of the first entry in the buffer
+	limit  int64 // index after the last entry in the buffer
+}
+
+func openRunReader(fname string, count uint64) (*runReader, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runReader{file: file, count: int64(count), buf: &ioutil.LittleEndianBuffer{Bytes: make([]byte, 4096*indexEntrySize)}}, nil
+}
+
+// seek moves to the first entry whose key is at least the given key, using a binary search.
+func (r *runReader) seek(key uint64) error {
+	tmp := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 8)}
+	var err error
+	r.cursor = int64(sort.Search(int(r.count), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		tmp.Pos = 0
+		if _, e := r.file.ReadAt(tmp.Bytes, int64(i)*indexEntrySize); e != nil {
+			err = e
+			return true
+		}
+		return tmp.ReadUint64() >= key
+	}))
+	r.start, r.limit = 0, 0
+
+	return err
+}
+
+func (r *runReader) next() (indexEntry, bool, error) {
+	if r.cursor >= r.count {
+		return indexEntry{}, false, nil
+	}
+
+	if r.cursor < r.start || r.cursor >= r.limit {
+		n, err := r.file.ReadAt(r.buf.Bytes, r.cursor*indexEntrySize)
+		if err != nil && err != io.EOF {
+			return indexEntry{}, false, err
+		}
+
+		r.start, r.limit = r.cursor, r.cursor+int64(n/indexEntrySize)
+		if r.limit <= r.start {
+			return indexEntry{}, false, fmt.Errorf("truncated index run")
+		}
+	}
+
+	r.buf.Pos = int(r.cursor-r.start) * indexEntrySize
+	e := indexEntry{key: r.buf.ReadUint64(), id: r.buf.ReadUint64()}
+	r.cursor++
+
+	return e, true, nil
+}
+
+// collectIndexEntry appends the entry of the first numeric field with the given name.
+func collectIndexEntry(dst []indexEntry, name uint16, id uint64, obj *Object) []indexEntry {
+	found := false
+	obj.WithFields(func(fieldName uint16, kind ioutil.Type, f *FieldReader) {
+		if !found && fieldName == name && kind.IsNumber() {
+			dst = append(dst, indexEntry{key: indexKey(f.ReadFloat()), id: id})
+			found = true
+		}
+	})
+
+	return dst
+}
+
+// CreateIndex builds a secondary index for the numeric values of the given name from all existing objects.
+// Afterwards the index is updated on each Flush and is available after reopening. Objects without a numeric
+// value for the name are not indexed. Integers beyond 2^53 lose precision, because all values are
+// indexed as float64. CreateIndex must not be called concurrently with Add or Flush.
+func (db *DB) CreateIndex(name uint16, opts IndexOptions) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if _, ok := db.indexes[name]; ok {
+		return fmt.Errorf("index for name %d already exists", name)
+	}
+
+	if err := db.Flush(); err != nil {
+		return err
+	}
+
+	x := &secondaryIndex{
+		fname:            db.fname + secondaryIndexSuffix + strconv.Itoa(int(name)),
+		name:             name,
+		lastRecordOffset: -1,
+	}
+
+	if err := db.fillIndex(x, db.findRecords(), opts); err != nil {
+		x.remove()
+		return fmt.Errorf("unable to create index: %w", err)
+	}
+
+	if db.indexes == nil {
+		db.indexes = make(map[uint16]*secondaryIndex)
+	}
+	db.indexes[name] = x
+
+	return nil
+}
+
+// fillIndex scans the given records in parallel, writes sorted runs of at most RunSize entries and merges them
+// into a single run.
+func (db *DB) fillIndex(x *secondaryIndex, records []recordInfo, opts IndexOptions) error {
+	runSize := opts.RunSize
+	if runSize <= 0 {
+		runSize = defaultIndexRunSize
+	}
+
+	routines := opts.Routines
+	if routines < 1 {
+		routines = 1
+	}
+
+	runMutex := sync.Mutex{}
+	var runs []indexRun
+	writeRun := func(entries []indexEntry) error {
+		runMutex.Lock()
+		defer runMutex.Unlock()
+
+		run, err := x.writeRun(entries)
+		runs = append(runs, run)
+		return err
+	}
+
+	buffers := make([][]indexEntry, routines)
+	err := db.forEachRecordP(routines, records, []uint16{x.name}, func(gid int, id uint64, obj *Object) error {
+		buffers[gid] = collectIndexEntry(buffers[gid], x.name, id, obj)
+		if len(buffers[gid]) >= runSize {
+			if err := writeRun(buffers[gid]); err != nil {
+				return err
+			}
+			buffers[gid] = buffers[gid][:0]
+		}
+		return nil
+	})
+
+	if err == nil {
+		for _, entries := range buffers {
+			if len(entries) > 0 {
+				if err = writeRun(entries); err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		x.runs = runs
+		return err
+	}
+
+	if len(runs) > 1 {
+		merged, err := x.mergeRuns(runs)
+		for _, run := range runs {
+			_ = os.Remove(x.runName(run.seq))
+		}
+		if err != nil {
+			return err
+		}
+		runs = []indexRun{merged}
+	}
+
+	x.runs = append(x.runs, runs...)
+	if len(records) > 0 {
+		x.coveredRecords += uint64(len(records))
+		x.lastRecordOffset = records[len(records)-1].offset
+	}
+
+	if err := x.writeManifest(); err != nil {
+		return err
+	}
+
+	return x.compact()
+}
+
+// remove deletes the manifest and all run files.
+func (x *secondaryIndex) remove() {
+	for _, run := range x.runs {
+		_ = os.Remove(x.runName(run.seq))
+	}
+	_ = os.Remove(x.fname)
+}
+
+// DropIndex removes the secondary index of the given name.
+func (db *DB) DropIndex(name uint16) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	x, ok := db.indexes[name]
+	if !ok {
+		return fmt.Errorf("no index for name %d", name)
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	delete(db.indexes, name)
+	x.remove()
+	return nil
+}
+
+// Indexes returns the names which have a secondary index.
+func (db *DB) Indexes() []uint16 {
+	res := make([]uint16, 0, len(db.indexes))
+	for name := range db.indexes {
+		res = append(res, name)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+
+	return res
+}
+
+// IndexRange visits the ids of all flushed objects whose indexed value is within [lo, hi], ordered by value
+// and id. Use lo == hi for point lookups. It is safe to be used concurrently.
+func (db *DB) IndexRange(name uint16, lo, hi float64, f func(value float64, id uint64) error) error {
+	x, ok := db.indexes[name]
+	if !ok {
+		return fmt.Errorf("no index for name %d", name)
+	}
+
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	return x.merge(x.runs, indexKey(lo), indexKey(hi), func(e indexEntry) error {
+		return f(indexValue(e.key), e.id)
+	})
+}
+
+// openIndexes loads all existing secondary indexes and adds runs for records which are not yet covered.
+// Indexes which do not fit to the record index at all are rebuilt.
+func (db *DB) openIndexes() error {
+	manifests, err := filepath.Glob(db.fname + secondaryIndexSuffix + "*")
+	if err != nil {
+		return err
+	}
+
+	records := db.findRecords()
+	for _, manifest := range manifests {
+		suffix := strings.TrimPrefix(manifest, db.fname+secondaryIndexSuffix)
+		if strings.Contains(suffix, ".") {
+			continue // a run file
+		}
+
+		x, err := readIndexManifest(manifest)
+		if err != nil {
+			return err
+		}
+
+		if db.indexes == nil {
+			db.indexes = make(map[uint16]*secondaryIndex)
+		}
+
+		covered := int(x.coveredRecords)
+		if db.opts.ReadOnly && covered != len(records) {
+			db.logf("ignoring outdated index for name %d\n", x.name)
+			continue
+		}
+
+		if covered > len(records) || covered > 0 && records[covered-1].offset != x.lastRecordOffset {
+			db.logf("rebuilding index for name %d\n", x.name)
+			x.remove()
+			delete(db.indexes, x.name)
+			if err := db.CreateIndex(x.name, IndexOptions{Routines: 1}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if covered < len(records) {
+			if err := db.fillIndex(x, records[covered:], IndexOptions{Routines: 1}); err != nil {
+				return err
+			}
+		}
+
+		db.indexes[x.name] = x
+	}
+
+	return nil
+}
+
+// removeIndexFiles deletes all manifests and run files of the given database file.
+func removeIndexFiles(fname string) {
+	files, _ := filepath.Glob(fname + secondaryIndexSuffix + "*")
+	for _, file := range files {
+		_ = os.Remove(file)
+	}
+}
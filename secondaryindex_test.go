@@ -0,0 +1,86 @@
+package logdb
+
+import (
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecondaryIndex(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "indexTest")
+	assertNil(t, err)
+
+	fname := filepath.Join(dir, "indexdb.bin")
+	db, err := OpenOptions(fname, Options{Compression: true})
+	assertNil(t, err)
+
+	colSensorId := db.PutName("SensorId")
+
+	add := func(from, to int) {
+		for i := from; i < to; i++ {
+			err := db.Add(func(obj *Object) error {
+				obj.AddUint32(colSensorId, uint32(i%1000))
+				return nil
+			})
+			assertNil(t, err)
+		}
+	}
+
+	// expects exactly count matches for each value within [lo, hi] and ids which resolve to the value
+	check := func(lo, hi float64, countPerValue int) {
+		t.Helper()
+		matches := 0
+		last := lo
+		err := db.IndexRange(colSensorId, lo, hi, func(value float64, id uint64) error {
+			if value < last || value > hi {
+				t.Fatalf("unexpected value order %v after %v", value, last)
+			}
+			last = value
+			matches++
+
+			return db.Read(id, func(obj *Object) error {
+				obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+					if v := f.ReadFloat(); v != value {
+						t.Fatalf("expected value %v but got %v", value, v)
+					}
+				})
+				return nil
+			})
+		})
+		assertNil(t, err)
+
+		if expected := int(hi-lo+1) * countPerValue; matches != expected {
+			t.Fatalf("expected %d matches but got %d", expected, matches)
+		}
+	}
+
+	add(0, 5000)
+	assertNil(t, db.Flush())
+	assertNil(t, db.CreateIndex(colSensorId, IndexOptions{Routines: 2, RunSize: 1000}))
+	check(10, 19, 5)
+	check(42, 42, 5)
+
+	for i := 5; i < 10; i++ {
+		add(i*1000, (i+1)*1000)
+		assertNil(t, db.Flush())
+	}
+	check(990, 999, 10)
+
+	assertNil(t, db.Close())
+
+	db, err = OpenOptions(fname, Options{Compression: true})
+	assertNil(t, err)
+	defer db.Close()
+
+	check(0, 999, 10)
+
+	if indexes := db.Indexes(); len(indexes) != 1 || indexes[0] != colSensorId {
+		t.Fatalf("unexpected indexes %v", indexes)
+	}
+
+	assertNil(t, db.DropIndex(colSensorId))
+	if err := db.IndexRange(colSensorId, 0, 1, func(value float64, id uint64) error { return nil }); err == nil {
+		t.Fatalf("expected error for dropped index")
+	}
+}
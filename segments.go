@@ -0,0 +1,571 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	segmentPrefix    = "seg-"
+	segmentExtension = ".logdb"
+	storeNamesFile   = "names"
+)
+
+// StoreOptions configures a Store.
+type StoreOptions struct {
+	// Options are used to open each segment.
+	Options
+
+	// MaxSegmentSize rolls over to a new segment, as soon as the current segment has at least the given size
+	// in bytes. Zero means unlimited.
+	MaxSegmentSize int64
+
+	// TimeField is the name of a numeric field containing a unix timestamp in seconds. If set together with
+	// BucketDuration, each segment contains only objects of the same time bucket and each object must
+	// contain the field.
+	TimeField string
+
+	// BucketDuration is the time span of a single segment, e.g. 24 hours.
+	BucketDuration time.Duration
+
+	// Retention is the period after which segments are dropped by DropExpired. Zero means forever.
+	Retention time.Duration
+}
+
+// SegmentInfo describes a single segment of a Store.
+type SegmentInfo struct {
+	Seq      uint64    // Seq is the unique and increasing sequence number of the segment.
+	Bucket   time.Time // Bucket is the start of the time bucket, or the zero time if not partitioned by time.
+	FileName string
+	Size     int64
+	Objects  uint64
+}
+
+// segment is a single database file of a Store.
+type segment struct {
+	seq    uint64
+	bucket int64 // unix seconds or -1
+	fname  string
+	db     *DB
+}
+
+// A Store partitions objects across multiple database files, called segments. Segments are rolled by size
+// or by the time bucket of a timestamp field and share a single name table, so that name indices are the
+// same in each segment. Whole segments can be dropped after a retention period, which is much cheaper
+// than rewriting a single large file.
+type Store struct {
+	dir       string
+	opts      StoreOptions
+	names     []string
+	lookup    map[string]int
+	segments  []*segment // ordered by seq
+	timeField uint16
+	tmpObj    *Object
+	namesErr  error // namesErr is the last failure to write the name table
+	mutex     sync.RWMutex
+}
+
+// OpenStore opens or creates a store in the given directory.
+func OpenStore(dir string, opts StoreOptions) (*Store, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, opts: opts, lookup: make(map[string]int)}
+	if err := s.loadNames(); err != nil {
+		return nil, fmt.Errorf("unable to load names: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fname := range files {
+		seg := &segment{fname: fname}
+		if _, err := fmt.Sscanf(filepath.Base(fname), segmentPrefix+"%d_%d"+segmentExtension, &seg.seq, &seg.bucket); err != nil {
+			return nil, fmt.Errorf("invalid segment file name '%s': %w", fname, err)
+		}
+
+		if err := s.openSegment(seg); err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+
+	if s.partitioned() {
+		s.timeField = s.PutName(opts.TimeField)
+		if s.namesErr != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("unable to write name table: %w", s.namesErr)
+		}
+	}
+
+	s.tmpObj = newObject(1024 * 64)
+
+	return s, nil
+}
+
+func (s *Store) partitioned() bool {
+	return s.opts.TimeField != "" && s.opts.BucketDuration > 0
+}
+
+// openSegment opens the database and registers all names of the store in the same order.
+func (s *Store) openSegment(seg *segment) error {
+	db, err := OpenOptions(seg.fname, s.opts.Options)
+	if err != nil {
+		return fmt.Errorf("unable to open segment '%s': %w", seg.fname, err)
+	}
+
+	for i, name := range s.names {
+		if idx := db.PutName(name); int(idx) != i {
+			_ = db.Close()
+			return fmt.Errorf("segment '%s' has a different name table", seg.fname)
+		}
+	}
+
+	seg.db = db
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+// loadNames reads the shared name table.
+//
+// Format specification:
+//  - count               uint32
+//  - []                  count times a typed string, see ioutil.TypedLittleEndianBuffer.WriteString
+func (s *Store) loadNames() error {
+	data, err := ioutil2.ReadFile(filepath.Join(s.dir, storeNamesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	buf := &ioutil.TypedLittleEndianBuffer{Bytes: data}
+	count := int((*ioutil.LittleEndianBuffer)(buf).ReadUint32())
+	scratch := make([]byte, len(data))
+	for i := 0; i < count; i++ {
+		// ReadString returns a string backed by the scratch buffer, so that each name needs its own copy
+		name := string(scratch[:len(buf.ReadString(scratch))])
+		s.lookup[name] = len(s.names)
+		s.names = append(s.names, name)
+	}
+
+	return nil
+}
+
+// writeNames replaces the name table file.
+func (s *Store) writeNames() error {
+	size := 4
+	for _, name := range s.names {
+		size += len(name) + 5
+	}
+
+	buf := &ioutil.TypedLittleEndianBuffer{Bytes: make([]byte, size)}
+	(*ioutil.LittleEndianBuffer)(buf).WriteUint32(uint32(len(s.names)))
+	for _, name := range s.names {
+		buf.WriteString(name)
+	}
+
+	fname := filepath.Join(s.dir, storeNamesFile)
+	if err := ioutil2.WriteFile(fname+".tmp", buf.Bytes[:buf.Pos], os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(fname+".tmp", fname)
+}
+
+// PutName registers the name in all segments and returns its index, which is the same in each segment. If the
+// name table cannot be written, the failure is reported by the next Add or Flush and Add writes it again.
+func (s *Store) PutName(name string) uint16 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if idx, ok := s.lookup[name]; ok {
+		return uint16(idx)
+	}
+
+	idx := len(s.names)
+	s.names = append(s.names, name)
+	s.lookup[name] = idx
+	s.namesErr = s.writeNames()
+
+	for _, seg := range s.segments {
+		seg.db.PutName(name)
+	}
+
+	return uint16(idx)
+}
+
+// IndexByName returns the index of the name or -1.
+func (s *Store) IndexByName(name string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	idx, ok := s.lookup[name]
+	if !ok {
+		return -1
+	}
+
+	return idx
+}
+
+// NameByIndex returns the name of the index.
+func (s *Store) NameByIndex(idx int) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.names[idx]
+}
+
+// Names returns a copy of the shared name table.
+func (s *Store) Names() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]string(nil), s.names...)
+}
+
+// Add appends an object into the segment of its time bucket or into the newest segment. A new segment is
+// created if required.
+func (s *Store) Add(f func(obj *Object) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.namesErr != nil {
+		if s.namesErr = s.writeNames(); s.namesErr != nil {
+			return fmt.Errorf("unable to write name table: %w", s.namesErr)
+		}
+	}
+
+	bucket := int64(-1)
+	if s.partitioned() {
+		obj := s.tmpObj
+		obj.resetWrite()
+		if err := f(obj); err != nil {
+			return err
+		}
+		obj.flush()
+
+		found := false
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			if !found && name == s.timeField && kind.IsNumber() {
+				bucket = bucketOf(f.ReadInt(), s.opts.BucketDuration)
+				found = true
+			}
+		})
+
+		if !found {
+			return fmt.Errorf("object has no timestamp '%s'", s.opts.TimeField)
+		}
+
+		seg, err := s.writableSegment(bucket)
+		if err != nil {
+			return err
+		}
+
+		return seg.db.addObject(obj)
+	}
+
+	seg, err := s.writableSegment(bucket)
+	if err != nil {
+		return err
+	}
+
+	return seg.db.Add(f)
+}
+
+func bucketOf(unix int64, d time.Duration) int64 {
+	secs := int64(d / time.Second)
+	if secs <= 0 {
+		secs = 1
+	}
+
+	b := unix - unix%secs
+	if unix < 0 && unix%secs != 0 {
+		b -= secs
+	}
+
+	return b
+}
+
+// writableSegment returns the newest segment of the bucket which has not yet reached the maximum size.
+func (s *Store) writableSegment(bucket int64) (*segment, error) {
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		seg := s.segments[i]
+		if seg.bucket != bucket {
+			continue
+		}
+
+		if s.opts.MaxSegmentSize > 0 && seg.db.Size() >= s.opts.MaxSegmentSize {
+			if err := seg.db.Flush(); err != nil {
+				return nil, err
+			}
+			seg.db.releaseWriteBuffer()
+			break
+		}
+
+		return seg, nil
+	}
+
+	seq := uint64(1)
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+
+	seg := &segment{
+		seq:    seq,
+		bucket: bucket,
+		fname:  filepath.Join(s.dir, fmt.Sprintf("%s%08d_%d%s", segmentPrefix, seq, bucket, segmentExtension)),
+	}
+
+	if err := s.openSegment(seg); err != nil {
+		return nil, err
+	}
+
+	return seg, nil
+}
+
+// Flush writes the pending records of all segments. The write buffers of all but the newest segment are
+// released, because older segments are rarely written again.
+func (s *Store) Flush() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.namesErr != nil {
+		return fmt.Errorf("unable to write name table: %w", s.namesErr)
+	}
+
+	for i, seg := range s.segments {
+		if err := seg.db.Flush(); err != nil {
+			return err
+		}
+
+		if i < len(s.segments)-1 {
+			seg.db.releaseWriteBuffer()
+		}
+	}
+
+	return nil
+}
+
+// Close closes all segments.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var firstErr error
+	for _, seg := range s.segments {
+		if err := seg.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	s.segments = nil
+	return firstErr
+}
+
+// Segments returns a description of all segments, ordered by their sequence number.
+func (s *Store) Segments() []SegmentInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]SegmentInfo, 0, len(s.segments))
+	for _, seg := range s.segments {
+		info := SegmentInfo{Seq: seg.seq, FileName: seg.fname, Size: seg.db.Size(), Objects: seg.db.ObjectCount()}
+		if seg.bucket >= 0 {
+			info.Bucket = time.Unix(seg.bucket, 0)
+		}
+		res = append(res, info)
+	}
+
+	return res
+}
+
+// Segment returns the database of the segment with the given sequence number or nil.
+func (s *Store) Segment(seq uint64) *DB {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, seg := range s.segments {
+		if seg.seq == seq {
+			return seg.db
+		}
+	}
+
+	return nil
+}
+
+// Read reads the object with the id from the segment with the given sequence number.
+func (s *Store) Read(seq uint64, id uint64, f func(obj *Object) error) error {
+	db := s.Segment(seq)
+	if db == nil {
+		return fmt.Errorf("no such segment %d", seq)
+	}
+
+	return db.Read(id, f)
+}
+
+// DropExpired closes and removes all segments which are older than the retention period. Segments
+// partitioned by time expire at the end of their bucket, other segments at their last modification
+// time, however the newest segment is never dropped. DropExpired must not be called concurrently with
+// any scan. It returns the amount of dropped segments.
+func (s *Store) DropExpired(now time.Time) (int, error) {
+	if s.opts.Retention <= 0 {
+		return 0, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deadline := now.Add(-s.opts.Retention)
+	kept := s.segments[:0]
+	dropped := 0
+	for i, seg := range s.segments {
+		var expired bool
+		if seg.bucket >= 0 {
+			expired = time.Unix(seg.bucket, 0).Add(s.opts.BucketDuration).Before(deadline)
+		} else if i < len(s.segments)-1 {
+			stat, err := os.Stat(seg.fname)
+			expired = err == nil && stat.ModTime().Before(deadline)
+		}
+
+		if !expired {
+			kept = append(kept, seg)
+			continue
+		}
+
+		if err := seg.db.Close(); err != nil {
+			return dropped, err
+		}
+
+		if err := removeDB(seg.fname); err != nil {
+			return dropped, err
+		}
+
+		dropped++
+	}
+
+	for i := len(kept); i < len(s.segments); i++ {
+		s.segments[i] = nil
+	}
+	s.segments = kept
+
+	return dropped, nil
+}
+
+// ForEachP walks in parallel over the flushed records of all segments, which are distributed equally across
+// the amount of routines. The callback gets the sequence number of the segment and the id within it. The first
+// error stops all routines.
+func (s *Store) ForEachP(routines int, f func(gid int, seq uint64, id uint64, obj *Object) error) (e error) {
+	type task struct {
+		seg  *segment
+		info recordInfo
+	}
+
+	s.mutex.RLock()
+	var tasks []task
+	for _, seg := range s.segments {
+		for _, info := range seg.db.findRecords() {
+			tasks = append(tasks, task{seg: seg, info: info})
+		}
+	}
+	s.mutex.RUnlock()
+
+	if routines < 1 {
+		routines = 1
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(routines)
+	errMutex := sync.Mutex{}
+	var stopped int32 // set by the first error, so that all routines stop with the next object
+
+	batchSize := len(tasks) / routines
+	for i := 0; i < routines; i++ {
+		from, to := i*batchSize, (i+1)*batchSize
+		if i == routines-1 {
+			to = len(tasks)
+		}
+
+		go func(gid, from, to int) {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+
+			defer wg.Done()
+
+			var reader *recordReader
+			var obj *Object
+			for _, t := range tasks[from:to] {
+				if reader == nil {
+					reader = newRecordReader(t.seg.db)
+					obj = newObject(t.seg.db.maxObjSize)
+				} else if reader.db != t.seg.db {
+					reader.reset(t.seg.db)
+				}
+
+				record, err := reader.readInfo(t.info)
+				if err == nil {
+					err = record.ForEach(obj, func(recOffset int, object *Object) error {
+						if atomic.LoadInt32(&stopped) != 0 {
+							return errStopped
+						}
+						return f(gid, t.seg.seq, t.info.base+uint64(recOffset), object)
+					})
+				}
+
+				if err != nil {
+					atomic.StoreInt32(&stopped, 1)
+					if err == errStopped {
+						return
+					}
+
+					errMutex.Lock()
+					if e == nil {
+						e = err
+					}
+					errMutex.Unlock()
+					return
+				}
+			}
+		}(i, from, to)
+	}
+
+	wg.Wait()
+
+	return
+}
+
+// Scan applies the scan options to each segment, one after another, see DB.Scan.
+func (s *Store) Scan(opts ScanOptions, f func(gid int, seq uint64, id uint64, obj *Object) error) error {
+	s.mutex.RLock()
+	segments := append([]*segment(nil), s.segments...)
+	s.mutex.RUnlock()
+
+	for _, seg := range segments {
+		err := seg.db.Scan(opts, func(gid int, id uint64, obj *Object) error {
+			return f(gid, seg.seq, id, obj)
+		})
+
+		if err != nil {
+			return fmt.Errorf("unable to scan segment '%s': %w", strings.TrimPrefix(seg.fname, s.dir), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStoreSegments(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "storeTest")
+	assertNil(t, err)
+
+	opts := StoreOptions{
+		TimeField:      "Timestamp",
+		BucketDuration: time.Hour,
+		MaxSegmentSize: 1024,
+		Retention:      24 * time.Hour,
+	}
+
+	store, err := OpenStore(dir, opts)
+	assertNil(t, err)
+
+	colSensorId := store.PutName("SensorId")
+	colTimestamp := store.PutName("Timestamp")
+
+	start := time.Date(2020, 7, 8, 0, 0, 0, 0, time.UTC)
+	const count = 10_000
+	for i := 0; i < count; i++ {
+		err := store.Add(func(obj *Object) error {
+			obj.AddUint32(colSensorId, uint32(i))
+			obj.AddUint32(colTimestamp, uint32(start.Unix())+uint32(i)*30)
+			return nil
+		})
+		assertNil(t, err)
+
+		if i%100 == 99 {
+			assertNil(t, store.Flush())
+		}
+	}
+
+	if err := store.Add(func(obj *Object) error {
+		obj.AddUint32(colSensorId, 1)
+		return nil
+	}); err == nil {
+		t.Fatalf("expected error for object without timestamp")
+	}
+
+	assertNil(t, store.Close())
+
+	store, err = OpenStore(dir, opts)
+	assertNil(t, err)
+	defer store.Close()
+
+	if store.IndexByName("Timestamp") != int(colTimestamp) {
+		t.Fatalf("name table has been lost")
+	}
+
+	segments := store.Segments()
+	buckets := make(map[time.Time]bool)
+	for _, seg := range segments {
+		buckets[seg.Bucket] = true
+	}
+
+	// 10.000 objects * 30s are about 84 hours
+	if len(buckets) != 84 || len(segments) <= len(buckets) {
+		t.Fatalf("unexpected amount of segments %d for %d buckets", len(segments), len(buckets))
+	}
+
+	var visited uint64
+	err = store.ForEachP(3, func(gid int, seq uint64, id uint64, obj *Object) error {
+		atomic.AddUint64(&visited, 1)
+		return nil
+	})
+	assertNil(t, err)
+
+	if visited != count {
+		t.Fatalf("expected %d objects but got %d", count, visited)
+	}
+
+	// keep the last day, which are the buckets of the hours 59 to 83, because the bucket 59 ends exactly at the deadline
+	dropped, err := store.DropExpired(start.Add(60*time.Hour + 24*time.Hour))
+	assertNil(t, err)
+
+	if dropped == 0 || len(store.Segments()) != len(segments)-dropped {
+		t.Fatalf("unexpected amount of dropped segments %d", dropped)
+	}
+
+	visited = 0
+	err = store.Scan(ScanOptions{Routines: 2}, func(gid int, seq uint64, id uint64, obj *Object) error {
+		atomic.AddUint64(&visited, 1)
+		return nil
+	})
+	assertNil(t, err)
+
+	if visited != 24*120+40 {
+		t.Fatalf("unexpected amount of remaining objects %d", visited)
+	}
+}
+
+func TestStoreSegmentsDictionary(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "storeTest")
+	assertNil(t, err)
+
+	store, err := OpenStore(dir, StoreOptions{Options: Options{Quiet: true}, TimeField: "Timestamp", BucketDuration: time.Hour})
+	assertNil(t, err)
+	defer store.Close()
+
+	timestamp, status := store.PutName("Timestamp"), store.PutName("Status")
+
+	// each segment has its own dictionary, so that the same codes mean different strings
+	for seg := 0; seg < 4; seg++ {
+		for i := 0; i < 10; i++ {
+			assertNil(t, store.Add(func(obj *Object) error {
+				obj.AddUint32(timestamp, uint32(seg*3600+i))
+				obj.AddDictString(status, fmt.Sprintf("seg%d-%d", seg, i%3))
+				return nil
+			}))
+		}
+		assertNil(t, store.Flush())
+	}
+
+	if len(store.Segments()) != 4 {
+		t.Fatalf("unexpected amount of segments %d", len(store.Segments()))
+	}
+
+	visited := 0
+	assertNil(t, store.ForEachP(1, func(gid int, seq uint64, id uint64, obj *Object) error {
+		var ts uint32
+		var s string
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+			switch name {
+			case timestamp:
+				ts = uint32(f.ReadInt())
+			case status:
+				s = f.ReadMutableString(make([]byte, 64))
+			}
+		})
+
+		if expected := fmt.Sprintf("seg%d-%d", ts/3600, ts%3600%3); s != expected {
+			t.Fatalf("expected %q in segment %d but got %q", expected, seq, s)
+		}
+		visited++
+		return nil
+	}))
+
+	if visited != 40 {
+		t.Fatalf("expected 40 objects but got %d", visited)
+	}
+}
+
+func TestStoreNamesWriteError(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "storeTest")
+	assertNil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := OpenStore(dir, StoreOptions{Options: Options{Quiet: true}})
+	assertNil(t, err)
+
+	// a directory in place of the temporary name table makes writing it fail
+	blocker := filepath.Join(dir, storeNamesFile+".tmp")
+	assertNil(t, os.Mkdir(blocker, os.ModePerm))
+
+	col := store.PutName("SensorId")
+	add := func() error {
+		return store.Add(func(obj *Object) error {
+			obj.AddUint32(col, 42)
+			return nil
+		})
+	}
+
+	if err := store.Flush(); err == nil {
+		t.Fatal("expected the failure to write the name table")
+	}
+
+	if err := add(); err == nil {
+		t.Fatal("expected the failure to write the name table")
+	}
+
+	assertNil(t, os.Remove(blocker))
+	assertNil(t, add())
+	assertNil(t, store.Flush())
+	assertNil(t, store.Close())
+
+	store, err = OpenStore(dir, StoreOptions{Options: Options{Quiet: true}})
+	assertNil(t, err)
+	defer store.Close()
+
+	if idx := store.IndexByName("SensorId"); idx != int(col) {
+		t.Fatalf("unexpected index %d", idx)
+	}
+}
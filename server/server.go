@@ -0,0 +1,419 @@
+// Package server exposes a database read-only over HTTP, so that it can be queried remotely:
+//
+//	GET  /info          counts and sizes of the database
+//	GET  /names         all names
+//	GET  /objects/{id}  a single object as JSON
+//	POST /scan          matching objects as newline delimited JSON, see ScanRequest
+//	POST /aggregate     the rows of a query as JSON, see AggregateRequest
+//
+// Errors are returned as {"error": "..."} with a 4xx or 5xx status. A scan which fails after it has
+// started to stream objects ends with such an error line instead, because its status has already been sent.
+// Scans and aggregations stop as soon as the client disconnects.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/exporter"
+	"github.com/worldiety/logdb/query"
+	"github.com/worldiety/logdb/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// flushSize is the amount of bytes which each routine of a scan buffers, before it writes them.
+const flushSize = 64 * 1024
+
+// errLimitReached stops a scan, as soon as enough objects have been written.
+var errLimitReached = errors.New("limit reached")
+
+// Options configure a server.
+type Options struct {
+	// MaxConcurrent is the amount of scans and aggregations which run at the same time. Further requests
+	// wait until a running one completes or their client disconnects. Defaults to 4.
+	MaxConcurrent int
+
+	// Routines is the amount of go routines of each scan or aggregation. Defaults to 1.
+	Routines int
+}
+
+// Server handles the HTTP requests for a database.
+type Server struct {
+	db    *logdb.DB
+	opts  Options
+	slots chan struct{}
+}
+
+// New creates a server for the database, which must stay open as long as the server is used.
+func New(db *logdb.DB, opts Options) *Server {
+	if opts.MaxConcurrent < 1 {
+		opts.MaxConcurrent = 4
+	}
+
+	if opts.Routines < 1 {
+		opts.Routines = 1
+	}
+
+	return &Server{db: db, opts: opts, slots: make(chan struct{}, opts.MaxConcurrent)}
+}
+
+// ServeHTTP routes the request to its endpoint.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/info":
+		s.get(w, r, s.info)
+	case path == "/names":
+		s.get(w, r, s.names)
+	case strings.HasPrefix(path, "/objects/"):
+		s.get(w, r, func(w http.ResponseWriter, r *http.Request) {
+			s.object(w, strings.TrimPrefix(path, "/objects/"))
+		})
+	case path == "/scan":
+		s.post(w, r, s.scan)
+	case path == "/aggregate":
+		s.post(w, r, s.aggregate)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, f http.HandlerFunc) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("expected GET but got %s", r.Method))
+		return
+	}
+	f(w, r)
+}
+
+// post runs the handler once a slot is free, because scans and aggregations read the entire database.
+func (s *Server) post(w http.ResponseWriter, r *http.Request, f http.HandlerFunc) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("expected POST but got %s", r.Method))
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-r.Context().Done():
+		writeError(w, http.StatusServiceUnavailable, r.Context().Err())
+		return
+	}
+
+	f(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Info is the response of /info.
+type Info struct {
+	Objects          uint64 `json:"objects"`
+	Transactions     uint64 `json:"transactions"`
+	Records          int    `json:"records"`
+	StoredSize       uint64 `json:"storedSize"`
+	UncompressedSize uint64 `json:"uncompressedSize"`
+	Compression      bool   `json:"compression"`
+	Names            int    `json:"names"`
+}
+
+func (s *Server) info(w http.ResponseWriter, r *http.Request) {
+	info := Info{
+		Objects:      s.db.ObjectCount(),
+		Transactions: s.db.TxCount(),
+		Compression:  s.db.Options().Compression,
+		Names:        len(s.db.Names()),
+	}
+
+	records := s.db.Records()
+	info.Records = len(records)
+	for _, rec := range records {
+		info.StoredSize += uint64(rec.Length)
+		info.UncompressedSize += uint64(rec.Size)
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) names(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.db.Names())
+}
+
+// object writes the object with the given id. The id is checked against the objects of its record, because
+// Read trusts its ids.
+func (s *Server) object(w http.ResponseWriter, text string) {
+	id, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid id %s", text))
+		return
+	}
+
+	valid, err := s.db.ValidID(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !valid {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no object at id %d", id))
+		return
+	}
+
+	var line []byte
+	err = s.db.Read(id, func(obj *logdb.Object) error {
+		line = exporter.NewJSONEncoder(s.db, nil, true).Append(nil, id, obj)
+		return nil
+	})
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(line)
+}
+
+// ScanRequest is the body of /scan.
+type ScanRequest struct {
+	// Names are the names to return, all by default. Each object starts with its id as _id.
+	Names []string `json:"names"`
+
+	// Where is an optional filter in SQL syntax, e.g. "temp > 20 AND name = 'a'".
+	Where string `json:"where"`
+
+	// Limit is the maximum amount of objects or 0 for all.
+	Limit int64 `json:"limit"`
+}
+
+func (s *Server) scan(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	opts := logdb.ScanOptions{}
+	filters := make([]*query.Filter, s.opts.Routines)
+	if req.Where != "" {
+		where, err := sql.ParseExpr(req.Where)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		opts = sql.ScanOptions(s.db, where)
+		filters[0] = query.NewFilter(s.db, where)
+		for i := 1; i < len(filters); i++ {
+			filters[i] = filters[0].Clone()
+		}
+	}
+	opts.Routines = s.opts.Routines
+
+	encoders := make([]*exporter.JSONEncoder, s.opts.Routines)
+	encoders[0] = exporter.NewJSONEncoder(s.db, req.Names, true)
+	for i := 1; i < len(encoders); i++ {
+		encoders[i] = encoders[0].Clone()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	out := &streamWriter{w: w}
+	bufs := make([][]byte, s.opts.Routines)
+	done := r.Context().Done()
+	var count int64
+
+	err := s.db.Scan(opts, func(gid int, id uint64, obj *logdb.Object) error {
+		select {
+		case <-done:
+			return r.Context().Err()
+		default:
+		}
+
+		if f := filters[gid]; f != nil && !f.Match(obj) {
+			return nil
+		}
+
+		if req.Limit > 0 && atomic.AddInt64(&count, 1) > req.Limit {
+			return errLimitReached
+		}
+
+		bufs[gid] = encoders[gid].Append(bufs[gid], id, obj)
+		if len(bufs[gid]) >= flushSize {
+			err := out.write(bufs[gid])
+			bufs[gid] = bufs[gid][:0]
+			return err
+		}
+		return nil
+	})
+
+	for _, buf := range bufs {
+		if err == nil || err == errLimitReached {
+			err = out.write(buf)
+		}
+	}
+
+	if err == nil || err == errLimitReached {
+		return
+	}
+
+	if !out.started {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the status has already been sent, so the error is appended as last line
+	line, _ := json.Marshal(map[string]string{"error": err.Error()})
+	_ = out.write(append(line, '\n'))
+}
+
+// streamWriter writes the buffers of concurrent routines and flushes them to the client.
+type streamWriter struct {
+	mutex   sync.Mutex
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *streamWriter) write(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.started = true
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// AggregateRequest is the body of /aggregate. Its parts form a SQL statement, e.g.
+//
+//	{"select": ["name", "avg(temp) AS avg"], "groupBy": ["name"], "orderBy": ["avg DESC"], "limit": 3}
+type AggregateRequest struct {
+	Select  []string `json:"select"`
+	Where   string   `json:"where"`
+	GroupBy []string `json:"groupBy"`
+	OrderBy []string `json:"orderBy"`
+	Limit   int      `json:"limit"`
+}
+
+// AggregateResponse contains the rows of an aggregation.
+type AggregateResponse struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// statement returns the request as a SQL statement.
+func (a *AggregateRequest) statement() string {
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(a.Select, ", "))
+	if a.Where != "" {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(a.Where)
+	}
+
+	if len(a.GroupBy) > 0 {
+		sb.WriteString(" GROUP BY ")
+		sb.WriteString(strings.Join(a.GroupBy, ", "))
+	}
+
+	if len(a.OrderBy) > 0 {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(a.OrderBy, ", "))
+	}
+
+	if a.Limit > 0 {
+		sb.WriteString(" LIMIT ")
+		sb.WriteString(strconv.Itoa(a.Limit))
+	}
+
+	return sb.String()
+}
+
+func (s *Server) aggregate(w http.ResponseWriter, r *http.Request) {
+	var req AggregateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(req.Select) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no columns selected"))
+		return
+	}
+
+	stmt, err := sql.Parse(req.statement())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q, err := stmt.Query(s.db)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q.Routines = s.opts.Routines
+	q.Context = r.Context()
+	res, err := query.Execute(s.db, q)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.Canceled) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	resp := AggregateResponse{Columns: res.Columns, Rows: make([][]interface{}, len(res.Rows))}
+	for i, row := range res.Rows {
+		resp.Rows[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			resp.Rows[i][j] = jsonValue(v)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// jsonValue converts a value for the JSON encoder. Floats which cannot be represented in JSON become null.
+func jsonValue(v query.Value) interface{} {
+	switch v.Kind {
+	case query.KindBool:
+		return v.I != 0
+	case query.KindInt:
+		return v.I
+	case query.KindFloat:
+		if math.IsNaN(v.F) || math.IsInf(v.F, 0) {
+			return nil
+		}
+		return v.F
+	case query.KindString:
+		return string(v.B)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"io"
+	ioutil2 "io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB creates a database with 100 objects of 10 sensors.
+func openTestDB(t *testing.T) *logdb.DB {
+	db := logdbtest.OpenDB(t, logdb.Options{})
+
+	sensor, temp, name := db.PutName("sensor"), db.PutName("temp"), db.PutName("name")
+	for i := 0; i < 100; i++ {
+		logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+			obj.AddInt(sensor, int64(i%10))
+			obj.AddFloat(temp, float64(i)/2)
+			obj.AddString(name, fmt.Sprintf("sensor-%d", i%10))
+			return nil
+		}))
+	}
+	logdbtest.AssertNil(t, db.Flush())
+
+	return db
+}
+
+// request sends a request and returns the status and body.
+func request(t *testing.T, srv *httptest.Server, method, path string, body string) (int, string) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, srv.URL+path, r)
+	logdbtest.AssertNil(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	logdbtest.AssertNil(t, err)
+	defer resp.Body.Close()
+
+	b, err := ioutil2.ReadAll(resp.Body)
+	logdbtest.AssertNil(t, err)
+	return resp.StatusCode, string(b)
+}
+
+func TestServer(t *testing.T) {
+	db := openTestDB(t)
+
+	srv := httptest.NewServer(New(db, Options{Routines: 2}))
+	defer srv.Close()
+
+	status, body := request(t, srv, http.MethodGet, "/names", "")
+	if status != http.StatusOK || body != `["sensor","temp","name"]`+"\n" {
+		t.Fatalf("unexpected names %d %s", status, body)
+	}
+
+	var info Info
+	_, body = request(t, srv, http.MethodGet, "/info", "")
+	logdbtest.AssertNil(t, json.Unmarshal([]byte(body), &info))
+	if info.Objects != 100 || info.Records != 1 || info.Names != 3 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	id, err := db.SeekOrdinal(3)
+	logdbtest.AssertNil(t, err)
+	status, body = request(t, srv, http.MethodGet, fmt.Sprintf("/objects/%d", id), "")
+	if expected := fmt.Sprintf(`{"_id":%d,"sensor":3,"temp":1.5,"name":"sensor-3"}`+"\n", id); body != expected {
+		t.Fatalf("unexpected object %d %s", status, body)
+	}
+
+	// ids within a record, but not at the start of an object, are not found
+	for _, invalid := range []uint64{1, id + 1} {
+		if status, _ := request(t, srv, http.MethodGet, fmt.Sprintf("/objects/%d", invalid), ""); status != http.StatusNotFound {
+			t.Fatalf("expected not found for %d but got %d", invalid, status)
+		}
+	}
+
+	status, body = request(t, srv, http.MethodPost, "/scan", `{"names":["temp"],"where":"sensor = 3 AND temp > 10"}`)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if status != http.StatusOK || len(lines) != 8 {
+		t.Fatalf("unexpected scan %d %s", status, body)
+	}
+
+	for _, line := range lines {
+		var obj map[string]float64
+		logdbtest.AssertNil(t, json.Unmarshal([]byte(line), &obj))
+		if len(obj) != 2 || obj["temp"] <= 10 {
+			t.Fatalf("unexpected object %s", line)
+		}
+	}
+
+	_, body = request(t, srv, http.MethodPost, "/scan", `{"limit":5}`)
+	if n := strings.Count(body, "\n"); n != 5 {
+		t.Fatalf("expected 5 objects but got %d", n)
+	}
+
+	status, body = request(t, srv, http.MethodPost, "/aggregate",
+		`{"select":["name","count(*)","max(temp) AS max"],"where":"sensor < 3","groupBy":["name"],"orderBy":["max DESC"],"limit":2}`)
+	expected := `{"columns":["name","count(*)","max"],"rows":[["sensor-2",10,46],["sensor-1",10,45.5]]}` + "\n"
+	if status != http.StatusOK || body != expected {
+		t.Fatalf("unexpected aggregate %d %s", status, body)
+	}
+
+	for _, invalid := range []struct{ method, path, body string }{
+		{http.MethodPost, "/scan", `{"where":"sensor = "}`},
+		{http.MethodPost, "/aggregate", `{"select":["count(*) +"]}`},
+		{http.MethodPost, "/aggregate", `{}`},
+		{http.MethodGet, "/scan", ""},
+		{http.MethodGet, "/objects/x", ""},
+		{http.MethodGet, "/unknown", ""},
+	} {
+		status, body := request(t, srv, invalid.method, invalid.path, invalid.body)
+		if status < 400 || !bytes.Contains([]byte(body), []byte(`"error"`)) {
+			t.Fatalf("%s %s: expected an error but got %d %s", invalid.method, invalid.path, status, body)
+		}
+	}
+}
+
+func TestScanError(t *testing.T) {
+	fname := filepath.Join(logdbtest.TempDir(t), "server.bin")
+	db, err := logdb.OpenOptions(fname, logdb.Options{Quiet: true})
+	logdbtest.AssertNil(t, err)
+	defer db.Close()
+
+	// the first record exceeds the buffer of a routine, so that it is streamed before the second one fails
+	sensor := db.PutName("sensor")
+	for r := 0; r < 2; r++ {
+		for i := 0; i < 5000; i++ {
+			logdbtest.AssertNil(t, db.Add(func(obj *logdb.Object) error {
+				obj.AddInt(sensor, int64(i))
+				return nil
+			}))
+		}
+		logdbtest.AssertNil(t, db.Flush())
+	}
+
+	file, err := os.OpenFile(fname, os.O_RDWR, 0)
+	logdbtest.AssertNil(t, err)
+	_, err = file.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, db.Records()[1].Offset+8)
+	logdbtest.AssertNil(t, err)
+	logdbtest.AssertNil(t, file.Close())
+
+	srv := httptest.NewServer(New(db, Options{}))
+	defer srv.Close()
+
+	status, body := request(t, srv, http.MethodPost, "/scan", `{}`)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if status != http.StatusOK || len(lines) < 2 || !strings.HasPrefix(lines[len(lines)-1], `{"error":`) {
+		t.Fatalf("expected a trailing error line but got %d with %d lines", status, len(lines))
+	}
+}
@@ -0,0 +1,95 @@
+package logdb
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// sideFileSuffixes enumerates the files which belong to a database file and are named after it.
+var sideFileSuffixes = []string{recordIndexSuffix, zoneMapSuffix, bloomSuffix, rollupSuffix}
+
+// openSideFile opens a side file for reading and writing or creates it. If readOnly is set, the file is only
+// opened for reading and a missing file is returned as nil without an error.
+func openSideFile(fname string, readOnly bool) (*os.File, error) {
+	if !readOnly {
+		return os.OpenFile(fname, os.O_RDWR|os.O_CREATE, os.ModePerm)
+	}
+
+	file, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return file, err
+}
+
+// detectSideFiles enables the zone maps and bloom filters, whose side files exist although they are not
+// configured, so that they are neither ignored by scans nor become stale by later writes.
+func (db *DB) detectSideFiles() error {
+	if !db.opts.ZoneMaps {
+		if _, err := os.Stat(db.fname + zoneMapSuffix); err == nil {
+			db.opts.ZoneMaps = true
+		}
+	}
+
+	if len(db.opts.BloomFilters) == 0 {
+		names, err := readBloomNames(db.fname + bloomSuffix)
+		if err != nil {
+			return err
+		}
+
+		known := db.header.Names()
+		for _, name := range names {
+			if int(name) < len(known) {
+				db.opts.BloomFilters = append(db.opts.BloomFilters, known[name])
+			}
+		}
+	}
+
+	return nil
+}
+
+// renameDB renames the database file and all its side files.
+func renameDB(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+
+	for _, suffix := range sideFileSuffixes {
+		if err := os.Rename(from+suffix, to+suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeDB removes the database file and all its side files.
+func removeDB(fname string) error {
+	err := os.Remove(fname)
+	removeSideFiles(fname)
+
+	return err
+}
+
+// removeSideFiles removes all side files of the database file, but not the file itself.
+func removeSideFiles(fname string) {
+	for _, suffix := range sideFileSuffixes {
+		_ = os.Remove(fname + suffix)
+	}
+	removeIndexFiles(fname)
+}
+
+// SideFiles returns the names of all existing files which belong to the database file, like the record
+// index, zone maps, bloom filters and secondary indexes.
+func (db *DB) SideFiles() []string {
+	var files []string
+	for _, suffix := range sideFileSuffixes {
+		if _, err := os.Stat(db.fname + suffix); err == nil {
+			files = append(files, db.fname+suffix)
+		}
+	}
+
+	indexes, _ := filepath.Glob(db.fname + secondaryIndexSuffix + "*")
+	return append(files, indexes...)
+}
@@ -0,0 +1,248 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// snapshotVersion is the version of the manifest format.
+const snapshotVersion = 1
+
+// A Manifest describes a snapshot, which contains the records of a database between two file offsets. The
+// header state at the end of the snapshot is part of the manifest, so that Restore can write it without
+// reading any other snapshot. The names and the dictionary of an encrypted database are encrypted, like the
+// records.
+type Manifest struct {
+	Version      int       `json:"version"`
+	Created      time.Time `json:"created"`
+	Compression  bool      `json:"compression"`
+	HeaderSize   int64     `json:"headerSize"`
+	From         int64     `json:"from"`    // file offset of the first record, the header size for full snapshots
+	EOF          int64     `json:"eof"`     // file offset after the last record
+	Records      int       `json:"records"` // amount of records in the snapshot
+	Objects      uint64    `json:"objects"` // amount of objects in the database up to EOF
+	Transactions uint64    `json:"transactions"`
+	Names        []string  `json:"names"`
+	KeyID        string    `json:"keyId,omitempty"`            // id of the key which encrypts the data key, if encrypted
+	DataKey      []byte    `json:"dataKey,omitempty"`          // the encrypted data key
+	SealedNames  []byte    `json:"sealedNames,omitempty"`      // the names encrypted by the data key instead of Names
+	Dictionary   []string  `json:"dictionary,omitempty"`       // the values of dictionary strings by their code
+	SealedDict   []byte    `json:"sealedDictionary,omitempty"` // the dictionary encrypted by the data key
+	Checksum     uint32    `json:"checksum"`                   // CRC-32 of the snapshot data
+}
+
+// Full returns true, if the snapshot starts at the first record.
+func (m *Manifest) Full() bool {
+	return m.From == m.HeaderSize
+}
+
+// Snapshot writes all flushed records to w and returns the manifest of this consistent point. Concurrent
+// adds and flushes are not blocked, but records which are flushed afterwards are not part of the snapshot.
+func (db *DB) Snapshot(w io.Writer) (*Manifest, error) {
+	return db.SnapshotFrom(w, 0)
+}
+
+// SnapshotFrom is like Snapshot, but writes only the records starting at the file offset, which is usually
+// the EOF of a previous snapshot. An offset of 0 writes a full snapshot.
+func (db *DB) SnapshotFrom(w io.Writer, from int64) (*Manifest, error) {
+	headerSize := int64(db.header.Size())
+	if from == 0 {
+		from = headerSize
+	}
+
+	records := db.findRecords()
+
+	// the names and dictionary values are added before the records which use them, so they must be looked up
+	// afterwards
+	m := &Manifest{
+		Version:     snapshotVersion,
+		Created:     time.Now(),
+		Compression: db.compress,
+		HeaderSize:  headerSize,
+		From:        from,
+		EOF:         headerSize,
+		Names:       db.Names(),
+		Dictionary:  db.Dictionary(),
+	}
+
+	first := len(records)
+	for i, info := range records {
+		if info.offset == from {
+			first = i
+		}
+	}
+
+	if db.aead != nil {
+		m.KeyID = db.header.keyID
+		m.DataKey = db.header.wrappedKey
+		m.SealedNames = sealNames(db.aead, m.Names)
+		m.Names = nil
+		if len(m.Dictionary) > 0 {
+			m.SealedDict = sealNames(db.aead, m.Dictionary)
+			m.Dictionary = nil
+		}
+	}
+
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		m.EOF = last.end()
+		m.Objects = last.ordinal + uint64(last.objCount)
+		m.Transactions = uint64(len(records))
+	}
+
+	if first == len(records) && from != m.EOF {
+		return nil, fmt.Errorf("offset %d is no record boundary", from)
+	}
+
+	m.Records = len(records) - first
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(w, hash), io.NewSectionReader(db.file, from, m.EOF-from)); err != nil {
+		return nil, err
+	}
+
+	m.Checksum = hash.Sum32()
+	return m, nil
+}
+
+// Restore creates a new database file from a full snapshot and any amount of incremental snapshots, which
+// must continue each other. The data of the i-th snapshot is read from the reader returned by data. The
+// checksums of the snapshots and the structure of the restored file are verified, and the file is removed,
+// if it is invalid.
+func Restore(fname string, snapshots []*Manifest, data func(i int) (io.ReadCloser, error)) error {
+	return RestoreEncrypted(fname, snapshots, data, nil)
+}
+
+// RestoreEncrypted is like Restore, but the snapshots may belong to an encrypted database, whose key is
+// supplied by the keys. The restored file keeps the data key, so that it can follow the original database.
+func RestoreEncrypted(fname string, snapshots []*Manifest, data func(i int) (io.ReadCloser, error), keys KeyProvider) (err error) {
+	if len(snapshots) == 0 || !snapshots[0].Full() {
+		return fmt.Errorf("a full snapshot is required")
+	}
+
+	for i, m := range snapshots {
+		if m.Version != snapshotVersion {
+			return fmt.Errorf("snapshot %d has the unsupported version %d", i, m.Version)
+		}
+
+		if m.From > m.EOF || m.HeaderSize != snapshots[0].HeaderSize || m.Compression != snapshots[0].Compression ||
+			m.KeyID != snapshots[0].KeyID || !bytes.Equal(m.DataKey, snapshots[0].DataKey) {
+			return fmt.Errorf("snapshot %d does not belong to the same database", i)
+		}
+
+		if i > 0 && m.From != snapshots[i-1].EOF {
+			return fmt.Errorf("snapshot %d starts at %d instead of %d", i, m.From, snapshots[i-1].EOF)
+		}
+	}
+
+	file, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_EXCL, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = removeDB(fname)
+		}
+	}()
+
+	last := snapshots[len(snapshots)-1]
+	header := newHeader(int(last.HeaderSize))
+	names, dictionary := last.Names, last.Dictionary
+	if last.KeyID != "" {
+		aead, err := openDataKey(keys, last.KeyID, last.DataKey)
+		if err != nil {
+			return err
+		}
+
+		if names, err = unsealNames(aead, last.SealedNames); err != nil {
+			return err
+		}
+
+		if len(last.SealedDict) > 0 {
+			if dictionary, err = unsealNames(aead, last.SealedDict); err != nil {
+				return err
+			}
+		}
+
+		header.setEncryption(last.KeyID, last.DataKey, aead)
+	}
+
+	for _, name := range names {
+		header.AddName(name)
+	}
+	for _, value := range dictionary {
+		header.AddDictionaryValue(value)
+	}
+	header.AddObjectCount(last.Objects)
+	header.AddTxCount(last.Transactions)
+	header.Flush()
+
+	if _, err := file.WriteAt(header.buf.Bytes, 0); err != nil {
+		return err
+	}
+
+	if err := file.Truncate(last.HeaderSize); err != nil {
+		return err
+	}
+
+	for i, m := range snapshots {
+		if err := restoreSnapshot(file, m, data, i); err != nil {
+			return fmt.Errorf("snapshot %d: %w", i, err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	opts := Options{Compression: last.Compression, Quiet: true}
+	if last.KeyID != "" {
+		opts.Keys = keys
+	}
+
+	db, err := OpenOptions(fname, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Verify(); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	return db.Close()
+}
+
+// restoreSnapshot appends the data of a snapshot and verifies its length and checksum.
+func restoreSnapshot(file *os.File, m *Manifest, data func(i int) (io.ReadCloser, error), i int) error {
+	r, err := data(i)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if _, err := file.Seek(m.From, io.SeekStart); err != nil {
+		return err
+	}
+
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(r, m.EOF-m.From+1))
+	if err != nil {
+		return err
+	}
+
+	if n != m.EOF-m.From {
+		return fmt.Errorf("expected %d bytes but got %d", m.EOF-m.From, n)
+	}
+
+	if hash.Sum32() != m.Checksum {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	return nil
+}
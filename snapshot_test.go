@@ -0,0 +1,99 @@
+package logdb
+
+import (
+	"bytes"
+	"io"
+	ioutil2 "io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		dir, err := ioutil2.TempDir("", "snapshotTest")
+		assertNil(t, err)
+
+		fname := filepath.Join(dir, "src.bin")
+		db, err := OpenOptions(fname, Options{Compression: compression, Quiet: true})
+		assertNil(t, err)
+
+		addRecords := func(name string, count int) {
+			idx := db.PutName(name)
+			for r := 0; r < count; r++ {
+				for i := 0; i < 100; i++ {
+					assertNil(t, db.Add(func(obj *Object) error {
+						obj.AddInt(idx, int64(r*100+i))
+						return nil
+					}))
+				}
+				assertNil(t, db.Flush())
+			}
+		}
+
+		addRecords("a", 3)
+
+		// pending objects are not part of the snapshot
+		assertNil(t, db.Add(func(obj *Object) error {
+			obj.AddInt(0, -1)
+			return nil
+		}))
+
+		var full, incremental bytes.Buffer
+		m1, err := db.Snapshot(&full)
+		assertNil(t, err)
+		if !m1.Full() || m1.Records != 3 || m1.Objects != 300 || m1.Transactions != 3 {
+			t.Fatalf("unexpected manifest %+v", m1)
+		}
+
+		addRecords("b", 2)
+		m2, err := db.SnapshotFrom(&incremental, m1.EOF)
+		assertNil(t, err)
+		if m2.Full() || m2.Records != 2 || m2.Objects != 501 || len(m2.Names) != 2 {
+			t.Fatalf("unexpected manifest %+v", m2)
+		}
+
+		if _, err := db.SnapshotFrom(ioutil2.Discard, m1.EOF+1); err == nil {
+			t.Fatal("expected an error for an offset within a record")
+		}
+
+		assertNil(t, db.Close())
+
+		data := func(buffers ...*bytes.Buffer) func(i int) (io.ReadCloser, error) {
+			return func(i int) (io.ReadCloser, error) {
+				return ioutil2.NopCloser(bytes.NewReader(buffers[i].Bytes())), nil
+			}
+		}
+
+		restored := filepath.Join(dir, "restored.bin")
+		assertNil(t, Restore(restored, []*Manifest{m1, m2}, data(&full, &incremental)))
+
+		src, err := ioutil2.ReadFile(fname)
+		assertNil(t, err)
+		dst, err := ioutil2.ReadFile(restored)
+		assertNil(t, err)
+		if !bytes.Equal(src, dst) {
+			t.Fatalf("restored file with %d bytes differs from the source with %d bytes", len(dst), len(src))
+		}
+
+		// incomplete chains and corrupted data are rejected without leaving a file behind
+		for _, invalid := range []struct {
+			snapshots []*Manifest
+			data      []*bytes.Buffer
+		}{
+			{[]*Manifest{m2}, []*bytes.Buffer{&incremental}},
+			{[]*Manifest{m1, m2, m2}, []*bytes.Buffer{&full, &incremental, &incremental}},
+			{[]*Manifest{m1}, []*bytes.Buffer{bytes.NewBuffer(full.Bytes()[:full.Len()-1])}},
+			{[]*Manifest{m1}, []*bytes.Buffer{bytes.NewBuffer(append([]byte{1}, full.Bytes()[1:]...))}},
+		} {
+			fname := filepath.Join(dir, "invalid.bin")
+			if err := Restore(fname, invalid.snapshots, data(invalid.data...)); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if _, err := os.Stat(fname); !os.IsNotExist(err) {
+				t.Fatalf("expected the invalid file to be removed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,403 @@
+// Package driver registers a read-only database/sql driver named "logdb". The data source name is the path
+// of a database file, optionally followed by the parameters lz4, mmap and routines, e.g.
+//
+//	db, err := sql.Open("logdb", "/data/sensor.logdb?lz4=true&routines=4")
+//
+// A database is a single table whose columns are the names of its header. Statements are parsed by the
+// logdb sql package and missing fields are NULL.
+package driver
+
+import (
+	"context"
+	dbsql "database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/query"
+	"github.com/worldiety/logdb/sql"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrReadOnly is returned for transactions and statements which modify the database.
+var ErrReadOnly = errors.New("logdb driver is read-only")
+
+func init() {
+	dbsql.Register("logdb", &Driver{})
+}
+
+// Driver opens connections to database files. Connections to the same data source share a single
+// logdb.DB, which is closed with the last connection.
+type Driver struct {
+	mutex sync.Mutex
+	open  map[string]*sharedDB
+}
+
+// sharedDB is a database with the amount of connections which use it.
+type sharedDB struct {
+	db       *logdb.DB
+	refs     int
+	routines int
+	types    map[string]ioutil.Type // lazily determined type of each name, see fieldTypes
+	mutex    sync.Mutex
+}
+
+// Open parses the data source name and connects to the database, which is opened read-only, so that neither
+// the file nor its side files are written, even next to a process which writes into it.
+func (d *Driver) Open(dsn string) (sqldriver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if shared, ok := d.open[dsn]; ok {
+		shared.refs++
+		return &conn{driver: d, dsn: dsn, shared: shared}, nil
+	}
+
+	fname, opts, routines, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := logdb.OpenOptions(fname, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.open == nil {
+		d.open = make(map[string]*sharedDB)
+	}
+
+	shared := &sharedDB{db: db, refs: 1, routines: routines}
+	d.open[dsn] = shared
+	return &conn{driver: d, dsn: dsn, shared: shared}, nil
+}
+
+// parseDSN splits the data source name into the file name and the options.
+func parseDSN(dsn string) (string, logdb.Options, int, error) {
+	opts := logdb.Options{Quiet: true, ReadOnly: true}
+	routines := 1
+
+	i := strings.LastIndexByte(dsn, '?')
+	if i < 0 {
+		return dsn, opts, routines, nil
+	}
+
+	params, err := url.ParseQuery(dsn[i+1:])
+	if err != nil {
+		return "", opts, 0, fmt.Errorf("invalid data source name: %w", err)
+	}
+
+	for key, values := range params {
+		value := values[len(values)-1]
+		switch key {
+		case "lz4":
+			opts.Compression, err = strconv.ParseBool(value)
+		case "mmap":
+			opts.Mmap, err = strconv.ParseBool(value)
+		case "routines":
+			routines, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown parameter %s", key)
+		}
+
+		if err != nil {
+			return "", opts, 0, fmt.Errorf("invalid data source name: %w", err)
+		}
+	}
+
+	return dsn[:i], opts, routines, nil
+}
+
+func (d *Driver) release(dsn string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	shared := d.open[dsn]
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+
+	delete(d.open, dsn)
+	return shared.db.Close()
+}
+
+// errFound stops the scan for field types.
+var errFound = errors.New("found")
+
+// fieldTypesPrefix is the maximum amount of objects, which are scanned for field types.
+const fieldTypesPrefix = 10_000
+
+// fieldTypes returns the type of the first occurrence of each name within the first objects, see
+// fieldTypesPrefix. Names which do not occur in these objects are missing. The result is cached, unless the
+// context is done before.
+func (s *sharedDB) fieldTypes(ctx context.Context) (map[string]ioutil.Type, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.types != nil {
+		return s.types, nil
+	}
+
+	names := s.db.Names()
+	types := make(map[string]ioutil.Type)
+	done := ctx.Done()
+	visited := 0
+	err := s.db.ForEach(func(id uint64, obj *logdb.Object) error {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+
+		obj.WithFields(func(name uint16, kind ioutil.Type, f *logdb.FieldReader) {
+			if int(name) < len(names) {
+				if _, ok := types[names[name]]; !ok {
+					types[names[name]] = kind
+				}
+			}
+		})
+
+		visited++
+		if len(types) == len(names) || visited >= fieldTypesPrefix {
+			return errFound
+		}
+		return nil
+	})
+
+	if err != nil && err != errFound {
+		return nil, err
+	}
+
+	s.types = types
+	return types, nil
+}
+
+// conn is a connection to a shared database.
+type conn struct {
+	driver *Driver
+	dsn    string
+	shared *sharedDB
+	closed bool
+}
+
+func (c *conn) Prepare(query string) (sqldriver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.driver.release(c.dsn)
+}
+
+func (c *conn) Begin() (sqldriver.Tx, error) {
+	return nil, ErrReadOnly
+}
+
+// QueryContext executes statements without preparing them first.
+func (c *conn) QueryContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	values := make([]sqldriver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named arguments are not supported")
+		}
+		values[i] = arg.Value
+	}
+
+	return c.query(ctx, query, values)
+}
+
+// query executes the statement and stops scanning, once the context is done.
+func (c *conn) query(ctx context.Context, stmt string, args []sqldriver.Value) (sqldriver.Rows, error) {
+	params := make([]interface{}, len(args))
+	for i, arg := range args {
+		params[i] = arg
+	}
+
+	parsed, err := sql.Parse(stmt, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	db := c.shared.db
+	q, err := parsed.Query(db)
+	if err != nil {
+		return nil, err
+	}
+	q.Routines = c.shared.routines
+	q.Context = ctx
+
+	res, err := query.Execute(db, q)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := c.shared.fieldTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rows{columns: res.Columns, values: res.Rows, types: make([]ioutil.Type, len(q.Select))}
+	for i, col := range q.Select {
+		r.types[i] = columnType(col, types)
+	}
+
+	return r, nil
+}
+
+// stmt is a prepared statement, which is parsed on each execution, because the placeholders are replaced
+// while parsing.
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1, because the arguments are checked while parsing.
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *stmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
+	return s.conn.query(context.Background(), s.query, args)
+}
+
+// Types which are not defined by ioutil and describe computed columns.
+const (
+	typeUnknown ioutil.Type = 0
+	typeBool    ioutil.Type = 255
+)
+
+// columnType derives the type of a column from the types of the fields. Counts are int64, averages
+// float64 and the type of other computed columns is unknown.
+func columnType(c query.Column, fields map[string]ioutil.Type) ioutil.Type {
+	switch c.Func {
+	case query.FuncCount:
+		return ioutil.TInt64
+	case query.FuncAvg:
+		return ioutil.TFloat64
+	}
+
+	var t ioutil.Type
+	switch e := c.Expr.(type) {
+	case *query.FieldRef:
+		t = fields[e.Name]
+	case *query.Comparison, *query.Logical, *query.Negation, *query.NullCheck:
+		return typeBool
+	default:
+		return typeUnknown
+	}
+
+	if c.Func != query.FuncSum {
+		return t
+	}
+
+	switch {
+	case t == ioutil.TFloat32 || t == ioutil.TFloat64:
+		return ioutil.TFloat64
+	case t.IsNumber():
+		return ioutil.TInt64
+	default:
+		return typeUnknown
+	}
+}
+
+// rows converts the result of a query into driver values.
+type rows struct {
+	columns []string
+	values  [][]query.Value
+	types   []ioutil.Type
+	pos     int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	r.values = nil
+	return nil
+}
+
+func (r *rows) Next(dest []sqldriver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+
+	for i, v := range r.values[r.pos] {
+		switch v.Kind {
+		case query.KindNull:
+			dest[i] = nil
+		case query.KindBool:
+			dest[i] = v.I != 0
+		case query.KindInt:
+			dest[i] = v.I
+		case query.KindFloat:
+			dest[i] = v.F
+		case query.KindString:
+			if t := r.types[i]; t >= ioutil.TBlob8 && t <= ioutil.TBlob32 {
+				dest[i] = v.B
+			} else {
+				dest[i] = string(v.B)
+			}
+		}
+	}
+
+	r.pos++
+	return nil
+}
+
+var typeNames = map[ioutil.Type]string{
+	ioutil.TUint8: "UINT8", ioutil.TUint16: "UINT16", ioutil.TUint24: "UINT24", ioutil.TUint32: "UINT32",
+	ioutil.TUint40: "UINT40", ioutil.TUint48: "UINT48", ioutil.TUint56: "UINT56", ioutil.TUint64: "UINT64",
+	ioutil.TInt8: "INT8", ioutil.TInt16: "INT16", ioutil.TInt24: "INT24", ioutil.TInt32: "INT32",
+	ioutil.TInt40: "INT40", ioutil.TInt48: "INT48", ioutil.TInt56: "INT56", ioutil.TInt64: "INT64",
+	ioutil.TBlob8: "BLOB", ioutil.TBlob16: "BLOB", ioutil.TBlob24: "BLOB", ioutil.TBlob32: "BLOB",
+	ioutil.TString8: "STRING", ioutil.TString16: "STRING", ioutil.TString24: "STRING", ioutil.TString32: "STRING",
+	ioutil.TFloat32: "FLOAT32", ioutil.TFloat64: "FLOAT64", typeBool: "BOOL", logdb.TDictString: "STRING",
+}
+
+// ColumnTypeDatabaseTypeName returns the name of the ioutil type of a field, e.g. UINT32, FLOAT64, STRING
+// or BLOB, or an empty string, if the type is unknown.
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return typeNames[r.types[index]]
+}
+
+// ColumnTypeScanType returns the go type of the driver values of a column.
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch t := r.types[index]; {
+	case t == typeBool:
+		return reflect.TypeOf(false)
+	case t == ioutil.TFloat32 || t == ioutil.TFloat64:
+		return reflect.TypeOf(float64(0))
+	case t >= ioutil.TBlob8 && t <= ioutil.TBlob32:
+		return reflect.TypeOf([]byte(nil))
+	case t >= ioutil.TString8 && t <= ioutil.TString32 || t == logdb.TDictString:
+		return reflect.TypeOf("")
+	case t.IsNumber():
+		return reflect.TypeOf(int64(0))
+	default:
+		return reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+}
+
+// ColumnTypeNullable returns true for all columns, because any object may miss a field.
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return true, true
+}
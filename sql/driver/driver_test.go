@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"context"
+	dbsql "database/sql"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testStart = 1594166400
+
+func createTestDB(t *testing.T) string {
+	fname := filepath.Join(logdbtest.TempDir(t), "driver.bin")
+	db, err := logdb.OpenOptions(fname, logdb.Options{Quiet: true})
+	logdbtest.AssertNil(t, err)
+
+	colSensorId := db.PutName("SensorId")
+	colTimestamp := db.PutName("Timestamp")
+	colTemperature := db.PutName("Temperature")
+	colName := db.PutName("Name")
+
+	for i := 0; i < 1000; i++ {
+		err := db.Add(func(obj *logdb.Object) error {
+			obj.AddUint32(colSensorId, uint32(i%10))
+			obj.AddUint32(colTimestamp, uint32(testStart+i*60))
+			obj.AddField(colTemperature, func(f *logdb.FieldWriter) {
+				f.WriteFloat64(float64(i%50) + 0.1)
+			})
+			if i%2 == 0 {
+				obj.AddString(colName, fmt.Sprintf("sensor-%d", i%10))
+			}
+			return nil
+		})
+		logdbtest.AssertNil(t, err)
+	}
+
+	logdbtest.AssertNil(t, db.Close())
+	return fname
+}
+
+func TestDriver(t *testing.T) {
+	fname := createTestDB(t)
+
+	// the database is opened read-only, so that the missing record index is only rebuilt in memory
+	logdbtest.AssertNil(t, os.Remove(fname+".ridx"))
+
+	db, err := dbsql.Open("logdb", fname+"?routines=2")
+	logdbtest.AssertNil(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM sensors WHERE Timestamp < ? AND SensorId = ? ORDER BY Timestamp",
+		time.Unix(testStart+20*60, 0), 1)
+	logdbtest.AssertNil(t, err)
+
+	types, err := rows.ColumnTypes()
+	logdbtest.AssertNil(t, err)
+
+	var typeNames []string
+	for _, t := range types {
+		typeNames = append(typeNames, t.DatabaseTypeName())
+	}
+
+	if fmt.Sprint(typeNames) != "[UINT32 UINT32 FLOAT64 STRING]" {
+		t.Fatalf("unexpected column types %v", typeNames)
+	}
+
+	var n int
+	for rows.Next() {
+		var sensorId, timestamp int64
+		var temperature float64
+		var name dbsql.NullString
+		logdbtest.AssertNil(t, rows.Scan(&sensorId, &timestamp, &temperature, &name))
+
+		// odd sensors have no name
+		if sensorId != 1 || timestamp != testStart+int64(n*10+1)*60 || temperature != float64(n*10+1)+0.1 || name.Valid {
+			t.Fatalf("unexpected row %d %d %f %v", sensorId, timestamp, temperature, name)
+		}
+		n++
+	}
+	logdbtest.AssertNil(t, rows.Err())
+
+	if n != 2 {
+		t.Fatalf("expected 2 rows but got %d", n)
+	}
+
+	var count int64
+	var avg float64
+	err = db.QueryRow("select count(*), avg(Temperature) where Name = 'sensor-4'").Scan(&count, &avg)
+	logdbtest.AssertNil(t, err)
+
+	if count != 100 || math.Abs(avg-24.1) > 1e-9 {
+		t.Fatalf("unexpected count %d and average %f", count, avg)
+	}
+
+	if _, err := db.Exec("select 1"); err == nil {
+		t.Fatalf("expected read-only error")
+	}
+
+	if _, err := db.Query("select * where SensorId = ?"); err == nil {
+		t.Fatalf("expected error for missing argument")
+	}
+
+	logdbtest.AssertNil(t, db.Close())
+	if _, err := os.Stat(fname + ".ridx"); !os.IsNotExist(err) {
+		t.Fatalf("expected no record index to be written: %v", err)
+	}
+}
+
+func TestDriverContext(t *testing.T) {
+	fname := createTestDB(t)
+
+	c, err := (&Driver{}).Open(fname)
+	logdbtest.AssertNil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.(*conn).QueryContext(ctx, "SELECT count(*) FROM sensors", nil); err != context.Canceled {
+		t.Fatalf("expected the query to be cancelled but got %v", err)
+	}
+
+	if _, err := c.(*conn).shared.fieldTypes(ctx); err != context.Canceled {
+		t.Fatalf("expected the field types to be cancelled but got %v", err)
+	}
+
+	// a cancelled scan for field types is not cached
+	types, err := c.(*conn).shared.fieldTypes(context.Background())
+	logdbtest.AssertNil(t, err)
+	if len(types) != 4 {
+		t.Fatalf("unexpected field types %v", types)
+	}
+}
@@ -0,0 +1,124 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokInt
+	tokFloat
+	tokString
+	tokSymbol
+)
+
+// token is a lexical unit of a statement. Keywords are upper case, quoted identifiers keep their case.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of statement"
+	}
+	return fmt.Sprintf("'%s' at %d", t.text, t.pos)
+}
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "BY": true, "ORDER": true, "ASC": true,
+	"DESC": true, "LIMIT": true, "AND": true, "OR": true, "NOT": true, "AS": true, "BETWEEN": true,
+	"IS": true, "NULL": true, "TRUE": true, "FALSE": true,
+}
+
+// lex splits the statement into tokens.
+func lex(stmt string) ([]token, error) {
+	var tokens []token
+	runes := []rune(stmt)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			if upper := strings.ToUpper(text); keywords[upper] {
+				tokens = append(tokens, token{kind: tokKeyword, text: upper, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokIdent, text: text, pos: start})
+			}
+			continue
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			kind := tokInt
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				(runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e' || runes[i-1] == 'E')) {
+				if !unicode.IsDigit(runes[i]) {
+					kind = tokFloat
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[start:i]), pos: start})
+			continue
+		case r == '\'' || r == '"':
+			// strings and quoted identifiers escape the quote by doubling it
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated quote at %d", start)
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						sb.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+
+			kind := tokString
+			if r == '"' {
+				kind = tokIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: sb.String(), pos: start})
+			continue
+		}
+
+		for _, sym := range []string{"<=", ">=", "!=", "<>", "=", "<", ">", "(", ")", ",", "*", "+", "-", "/", ";", "?"} {
+			if strings.HasPrefix(string(runes[i:min(i+2, len(runes))]), sym) {
+				tokens = append(tokens, token{kind: tokSymbol, text: sym, pos: start})
+				i += len(sym)
+				break
+			}
+		}
+
+		if i == start {
+			return nil, fmt.Errorf("unexpected character '%c' at %d", r, start)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
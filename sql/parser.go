@@ -0,0 +1,575 @@
+package sql
+
+import (
+	"fmt"
+	"github.com/worldiety/logdb/query"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statement is a parsed SELECT statement.
+type Statement struct {
+	// Columns are the selected columns. A column without expression and function stands for *, which
+	// selects all names of the database.
+	Columns []query.Column
+
+	// From is the optional table name, which is ignored, because a database is a single table.
+	From string
+
+	Where   query.Expr
+	GroupBy []query.Expr
+	OrderBy []OrderItem
+
+	// Limit is the maximum amount of rows or 0 for all.
+	Limit int
+}
+
+// OrderItem sorts by a selected column, which is either given by its 1 based position or by an expression
+// which equals the column or its alias.
+type OrderItem struct {
+	Column  query.Column
+	Ordinal int
+	Desc    bool
+}
+
+var aggregates = map[string]query.Func{
+	"COUNT": query.FuncCount,
+	"SUM":   query.FuncSum,
+	"MIN":   query.FuncMin,
+	"MAX":   query.FuncMax,
+	"AVG":   query.FuncAvg,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	args   []interface{}
+	arg    int // index of the next placeholder
+}
+
+// Parse parses a statement of the form
+//
+//	SELECT * | column [AS alias], ...
+//	[FROM table]
+//	[WHERE condition]
+//	[GROUP BY expression, ...]
+//	[ORDER BY column [ASC | DESC], ...]
+//	[LIMIT count]
+//
+// Columns are expressions or the aggregates COUNT(*), COUNT, SUM, MIN, MAX and AVG of an expression.
+// Expressions consist of field names, which may be double quoted, numbers, single quoted strings, TRUE,
+// FALSE, NULL, the arithmetic operators + - * /, the comparisons = != <> < <= > >=, BETWEEN, IS [NOT] NULL
+// and the logical operators NOT, AND and OR.
+//
+// Each ? is a placeholder for the next argument, which must be nil, a bool, an integer, a float, a string,
+// a byte slice or a time.Time, which becomes its unix time in seconds.
+func Parse(stmt string, args ...interface{}) (*Statement, error) {
+	tokens, err := lex(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, args: args}
+	s, err := p.statement()
+	if err != nil {
+		return nil, fmt.Errorf("invalid statement: %w", err)
+	}
+
+	if p.arg != len(args) {
+		return nil, fmt.Errorf("expected %d arguments but got %d", p.arg, len(args))
+	}
+
+	return s, nil
+}
+
+// ParseExpr parses a single expression like the where clause of a statement, e.g. to filter objects
+// without a query.
+func ParseExpr(expr string, args ...interface{}) (query.Expr, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, args: args}
+	e, err := p.expr()
+	if err == nil {
+		if t := p.peek(); t.kind != tokEOF {
+			err = fmt.Errorf("unexpected %v", t)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+
+	if p.arg != len(args) {
+		return nil, fmt.Errorf("expected %d arguments but got %d", p.arg, len(args))
+	}
+
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token, if it is the given keyword or symbol.
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokKeyword || t.kind == tokSymbol) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %s but found %v", text, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) statement() (*Statement, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+
+	s := &Statement{}
+	for {
+		if p.accept("*") {
+			s.Columns = append(s.Columns, query.Column{})
+		} else {
+			c, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			s.Columns = append(s.Columns, c)
+		}
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if p.accept("FROM") {
+		t := p.next()
+		if t.kind != tokIdent {
+			return nil, fmt.Errorf("expected table name but found %v", t)
+		}
+		s.From = t.text
+	}
+
+	if p.accept("WHERE") {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		s.Where = e
+	}
+
+	if p.accept("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			s.GroupBy = append(s.GroupBy, e)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+
+		for {
+			var item OrderItem
+			if t := p.peek(); t.kind == tokInt {
+				p.next()
+				n, err := strconv.Atoi(t.text)
+				if err != nil || n < 1 || n > len(s.Columns) {
+					return nil, fmt.Errorf("invalid column position %v", t)
+				}
+				item.Ordinal = n
+			} else {
+				c, err := p.aggregateOrExpr()
+				if err != nil {
+					return nil, err
+				}
+				item.Column = c
+			}
+
+			if p.accept("DESC") {
+				item.Desc = true
+			} else {
+				p.accept("ASC")
+			}
+			s.OrderBy = append(s.OrderBy, item)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokInt || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit %v", t)
+		}
+		s.Limit = n
+	}
+
+	p.accept(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %v", t)
+	}
+
+	return s, nil
+}
+
+// column parses an aggregate or an expression with an optional alias.
+func (p *parser) column() (query.Column, error) {
+	c, err := p.aggregateOrExpr()
+	if err != nil {
+		return c, err
+	}
+
+	if p.accept("AS") {
+		t := p.next()
+		if t.kind != tokIdent {
+			return c, fmt.Errorf("expected alias but found %v", t)
+		}
+		c.Name = t.text
+	}
+
+	return c, nil
+}
+
+func (p *parser) aggregateOrExpr() (query.Column, error) {
+	t := p.peek()
+	if f, ok := aggregates[strings.ToUpper(t.text)]; ok && t.kind == tokIdent && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		c := query.Column{Func: f}
+		if f != query.FuncCount || !p.accept("*") {
+			e, err := p.expr()
+			if err != nil {
+				return c, err
+			}
+			c.Expr = e
+		}
+
+		if err := p.expect(")"); err != nil {
+			return c, err
+		}
+
+		if t := p.peek(); t.kind == tokSymbol && t.text != "," && t.text != ";" && t.text != ")" {
+			return c, fmt.Errorf("aggregates cannot be part of an expression at %d", t.pos)
+		}
+
+		return c, nil
+	}
+
+	e, err := p.expr()
+	return query.Column{Expr: e}, err
+}
+
+func (p *parser) expr() (query.Expr, error) {
+	return p.or()
+}
+
+func (p *parser) or() (query.Expr, error) {
+	e, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []query.Expr{e}
+	for p.accept("OR") {
+		if e, err = p.and(); err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return query.Or(args...), nil
+}
+
+func (p *parser) and() (query.Expr, error) {
+	e, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []query.Expr{e}
+	for p.accept("AND") {
+		if e, err = p.not(); err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return query.And(args...), nil
+}
+
+func (p *parser) not() (query.Expr, error) {
+	if p.accept("NOT") {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return query.Not(e), nil
+	}
+
+	return p.comparison()
+}
+
+var comparisons = map[string]func(l, r query.Expr) *query.Comparison{
+	"=": query.Eq, "!=": query.Ne, "<>": query.Ne, "<": query.Lt, "<=": query.Le, ">": query.Gt, ">=": query.Ge,
+}
+
+func (p *parser) comparison() (query.Expr, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if cmp, ok := comparisons[t.text]; ok && t.kind == tokSymbol {
+		p.next()
+		right, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return cmp(left, right), nil
+	}
+
+	switch {
+	case p.accept("BETWEEN"):
+		lo, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+
+		hi, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return query.Between(left, lo, hi), nil
+	case p.accept("IS"):
+		not := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+
+		if not {
+			return query.IsNotNull(left), nil
+		}
+		return query.IsNull(left), nil
+	}
+
+	return left, nil
+}
+
+func (p *parser) additive() (query.Expr, error) {
+	e, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op func(l, r query.Expr) *query.Arithmetic
+		switch {
+		case p.accept("+"):
+			op = query.Add
+		case p.accept("-"):
+			op = query.Sub
+		default:
+			return e, nil
+		}
+
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		e = op(e, r)
+	}
+}
+
+func (p *parser) multiplicative() (query.Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op func(l, r query.Expr) *query.Arithmetic
+		switch {
+		case p.accept("*"):
+			op = query.Mul
+		case p.accept("/"):
+			op = query.Div
+		default:
+			return e, nil
+		}
+
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		e = op(e, r)
+	}
+}
+
+func (p *parser) unary() (query.Expr, error) {
+	if p.accept("-") {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		// fold negative literals, so that they stay constants
+		if c, ok := e.(*query.Const); ok {
+			switch c.Value.Kind {
+			case query.KindInt:
+				return query.Int(-c.Value.I), nil
+			case query.KindFloat:
+				return query.Float(-c.Value.F), nil
+			}
+		}
+		return query.Sub(query.Int(0), e), nil
+	}
+
+	p.accept("+")
+	return p.primary()
+}
+
+func (p *parser) primary() (query.Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v", t)
+		}
+		return query.Int(v), nil
+	case tokFloat:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v", t)
+		}
+		return query.Float(v), nil
+	case tokString:
+		return query.String(t.text), nil
+	case tokIdent:
+		if p.peek().text == "(" && p.peek().kind == tokSymbol {
+			if _, ok := aggregates[strings.ToUpper(t.text)]; ok {
+				return nil, fmt.Errorf("aggregates cannot be part of an expression at %d", t.pos)
+			}
+			return nil, fmt.Errorf("unknown function %v", t)
+		}
+		return query.Field(t.text), nil
+	case tokKeyword:
+		switch t.text {
+		case "TRUE":
+			return query.Bool(true), nil
+		case "FALSE":
+			return query.Bool(false), nil
+		case "NULL":
+			return &query.Const{}, nil
+		}
+	case tokSymbol:
+		if t.text == "?" {
+			return p.placeholder(t)
+		}
+
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %v", t)
+}
+
+// placeholder returns the next argument as a constant.
+func (p *parser) placeholder(t token) (query.Expr, error) {
+	if p.arg >= len(p.args) {
+		return nil, fmt.Errorf("missing argument for placeholder at %d", t.pos)
+	}
+
+	arg := p.args[p.arg]
+	p.arg++
+
+	switch v := arg.(type) {
+	case nil:
+		return &query.Const{}, nil
+	case bool:
+		return query.Bool(v), nil
+	case int:
+		return query.Int(int64(v)), nil
+	case int8:
+		return query.Int(int64(v)), nil
+	case int16:
+		return query.Int(int64(v)), nil
+	case int32:
+		return query.Int(int64(v)), nil
+	case int64:
+		return query.Int(v), nil
+	case uint8:
+		return query.Int(int64(v)), nil
+	case uint16:
+		return query.Int(int64(v)), nil
+	case uint32:
+		return query.Int(int64(v)), nil
+	case uint64:
+		return query.Int(int64(v)), nil
+	case float32:
+		return query.Float(float64(v)), nil
+	case float64:
+		return query.Float(v), nil
+	case string:
+		return query.String(v), nil
+	case []byte:
+		return query.String(string(v)), nil
+	case time.Time:
+		return query.Int(v.Unix()), nil
+	default:
+		return nil, fmt.Errorf("unsupported argument type %T at %d", arg, t.pos)
+	}
+}
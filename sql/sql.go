@@ -0,0 +1,225 @@
+// Package sql executes a subset of SQL SELECT statements against a database. The database is a single
+// table whose columns are the names of its header, see logdb.DB.Names.
+package sql
+
+import (
+	"context"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/query"
+	"math"
+)
+
+// Options configure the execution of a statement.
+type Options struct {
+	// Routines is the amount of go routines which scan the database. Values smaller than 1 are treated
+	// as 1.
+	Routines int
+
+	// TempDir is the directory for spill files of large group by queries.
+	TempDir string
+
+	// Context cancels the execution.
+	Context context.Context
+}
+
+// Query parses and executes the statement and returns the selected rows. The arguments replace the
+// placeholders of the statement, see Parse.
+func Query(db *logdb.DB, stmt string, opts Options, args ...interface{}) (*Rows, error) {
+	s, err := Parse(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := s.Query(db)
+	if err != nil {
+		return nil, err
+	}
+
+	q.Routines = opts.Routines
+	q.TempDir = opts.TempDir
+	q.Context = opts.Context
+
+	res, err := query.Execute(db, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rows{columns: res.Columns, rows: res.Rows, pos: -1}, nil
+}
+
+// Query translates the statement into a query for the given database. It expands *, resolves the order
+// by columns and derives ranges and equality predicates from the where clause, so that records can be
+// skipped using zone maps and bloom filters.
+func (s *Statement) Query(db *logdb.DB) (query.Query, error) {
+	q := query.Query{Where: s.Where, GroupBy: s.GroupBy, Limit: s.Limit}
+	for _, c := range s.Columns {
+		if c.Expr == nil && c.Func == query.FuncNone {
+			for _, name := range db.Names() {
+				q.Select = append(q.Select, query.Col(query.Field(name)))
+			}
+			continue
+		}
+		q.Select = append(q.Select, c)
+	}
+
+	for _, item := range s.OrderBy {
+		order := query.Order{Desc: item.Desc}
+		if item.Ordinal > 0 {
+			if item.Ordinal > len(q.Select) {
+				return q, fmt.Errorf("order by position %d is out of range", item.Ordinal)
+			}
+			order.Column = q.Select[item.Ordinal-1].Label()
+		} else {
+			order.Column = item.Column.Label()
+		}
+		q.OrderBy = append(q.OrderBy, order)
+	}
+
+	if s.Where != nil {
+		q.Scan = ScanOptions(db, s.Where)
+	}
+
+	return q, nil
+}
+
+// ScanOptions extracts predicates on numeric constants from the top level conjunction of the where clause.
+// The predicates may match more objects than the where clause, but never less.
+func ScanOptions(db *logdb.DB, where query.Expr) logdb.ScanOptions {
+	var opts logdb.ScanOptions
+	dbOpts := db.Options()
+	if !dbOpts.ZoneMaps && len(dbOpts.BloomFilters) == 0 {
+		return opts
+	}
+
+	blooms := make(map[string]bool)
+	for _, name := range dbOpts.BloomFilters {
+		blooms[name] = true
+	}
+
+	ranges := make(map[uint16]int) // name to index in opts.Ranges
+	for _, e := range conjuncts(where, nil) {
+		c, ok := e.(*query.Comparison)
+		if !ok {
+			continue
+		}
+
+		field, fok := c.Left.(*query.FieldRef)
+		value, vok := c.Right.(*query.Const)
+		op := c.Op
+		if !fok || !vok {
+			// normalize constant op field into field op constant
+			field, fok = c.Right.(*query.FieldRef)
+			value, vok = c.Left.(*query.Const)
+			op = mirror(op)
+		}
+
+		if !fok || !vok {
+			continue
+		}
+
+		v, ok := value.Value.Float()
+		idx := db.IndexByName(field.Name)
+		if !ok || math.IsNaN(v) || idx < 0 {
+			continue
+		}
+		name := uint16(idx)
+
+		if op == query.OpEq && blooms[field.Name] {
+			if value.Value.Kind == query.KindInt {
+				opts.Equals = append(opts.Equals, logdb.EqualInt(name, value.Value.I))
+			} else {
+				opts.Equals = append(opts.Equals, logdb.EqualFloat(name, v))
+			}
+		}
+
+		if !dbOpts.ZoneMaps {
+			continue
+		}
+
+		r := logdb.Range{Name: name, Min: math.Inf(-1), Max: math.Inf(1)}
+		switch op {
+		case query.OpEq:
+			r.Min, r.Max = v, v
+		case query.OpLt, query.OpLe:
+			r.Max = v
+		case query.OpGt, query.OpGe:
+			r.Min = v
+		default:
+			continue
+		}
+
+		if i, ok := ranges[name]; ok {
+			// intersect multiple predicates on the same field
+			opts.Ranges[i].Min = math.Max(opts.Ranges[i].Min, r.Min)
+			opts.Ranges[i].Max = math.Min(opts.Ranges[i].Max, r.Max)
+			continue
+		}
+
+		ranges[name] = len(opts.Ranges)
+		opts.Ranges = append(opts.Ranges, r)
+	}
+
+	return opts
+}
+
+// conjuncts appends the arguments of nested ANDs.
+func conjuncts(e query.Expr, dst []query.Expr) []query.Expr {
+	if l, ok := e.(*query.Logical); ok && l.Op == query.OpAnd {
+		for _, arg := range l.Args {
+			dst = conjuncts(arg, dst)
+		}
+		return dst
+	}
+	return append(dst, e)
+}
+
+// mirror returns the operator with swapped sides.
+func mirror(op query.Op) query.Op {
+	switch op {
+	case query.OpLt:
+		return query.OpGt
+	case query.OpLe:
+		return query.OpGe
+	case query.OpGt:
+		return query.OpLt
+	case query.OpGe:
+		return query.OpLe
+	default:
+		return op
+	}
+}
+
+// Rows iterates over the result of a statement.
+type Rows struct {
+	columns []string
+	rows    [][]query.Value
+	pos     int
+}
+
+// Columns returns the names of the selected columns.
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Next advances to the next row and returns false, if there are no more rows.
+func (r *Rows) Next() bool {
+	if r.pos+1 >= len(r.rows) {
+		r.pos = len(r.rows)
+		return false
+	}
+	r.pos++
+	return true
+}
+
+// Values returns the values of the current row.
+func (r *Rows) Values() []query.Value {
+	return r.rows[r.pos]
+}
+
+// Close releases the rows. It is safe to call it multiple times.
+func (r *Rows) Close() error {
+	r.rows = nil
+	r.pos = 0
+	return nil
+}
@@ -0,0 +1,177 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/worldiety/logdb"
+	"github.com/worldiety/logdb/internal/logdbtest"
+	"strings"
+	"testing"
+)
+
+const (
+	testCount = 10_000
+	testStart = 1594166400
+)
+
+func openTestDB(t *testing.T) *logdb.DB {
+	db := logdbtest.OpenDB(t, logdb.Options{
+		ZoneMaps:     true,
+		BloomFilters: []string{"SensorId"},
+	})
+
+	colSensorId := db.PutName("SensorId")
+	colTimestamp := db.PutName("Timestamp")
+	colTemperature := db.PutName("Temperature")
+	colName := db.PutName("Name")
+
+	for i := 0; i < testCount; i++ {
+		err := db.Add(func(obj *logdb.Object) error {
+			obj.AddUint32(colSensorId, uint32(i%10))
+			obj.AddUint32(colTimestamp, uint32(testStart+i*60))
+			obj.AddInt8(colTemperature, int8(i%50))
+			obj.AddString(colName, fmt.Sprintf("sensor-%d", i%10))
+			return nil
+		})
+		logdbtest.AssertNil(t, err)
+
+		if i%1000 == 999 {
+			logdbtest.AssertNil(t, db.Flush())
+		}
+	}
+
+	return db
+}
+
+func TestParse(t *testing.T) {
+	valid := map[string]string{
+		"select * from t": "",
+		"SELECT a, b AS \"x y\" FROM t WHERE a >= -1.5e2":                                                "(a >= -150)",
+		"select count(*), avg(a) where not a < 3 or b = 'it''s' group by c order by 2 desc, c limit 10;": "((NOT (a < 3)) OR (b = 'it''s'))",
+		"select a where a between 1 and 2 and b is not null":                                             "(((a >= 1) AND (a <= 2)) AND (b IS NOT NULL))",
+		"select a where a + 2 * b <> 3":                                                                  "((a + (2 * b)) != 3)",
+	}
+
+	for stmt, where := range valid {
+		s, err := Parse(stmt)
+		logdbtest.AssertNil(t, err)
+
+		if s.Where == nil && where != "" || s.Where != nil && s.Where.String() != where {
+			t.Fatalf("%s: unexpected where clause %v", stmt, s.Where)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"select",
+		"select a from",
+		"select a where",
+		"select sum(a) + 1",
+		"select a where sum(a) > 1",
+		"select a order by 2",
+		"select a limit -1",
+		"select 'a",
+		"select a b",
+		"select foo(a)",
+	}
+
+	for _, stmt := range invalid {
+		if _, err := Parse(stmt); err == nil {
+			t.Fatalf("%s: expected error", stmt)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := openTestDB(t)
+
+	rows, err := Query(db, `SELECT SensorId, avg(Temperature) AS avg, count(*)
+		FROM sensors
+		WHERE Timestamp BETWEEN 1594166400 AND 1594166400 + 4999 * 60 AND Name != 'sensor-1'
+		GROUP BY SensorId
+		ORDER BY avg DESC, 1
+		LIMIT 3`, Options{Routines: 3})
+	logdbtest.AssertNil(t, err)
+
+	if cols := rows.Columns(); len(cols) != 3 || cols[1] != "avg" || cols[2] != "count(*)" {
+		t.Fatalf("unexpected columns %v", cols)
+	}
+
+	// the average temperature grows with the sensor id
+	var sensors []int64
+	for rows.Next() {
+		row := rows.Values()
+		sensors = append(sensors, row[0].I)
+		if row[2].I != 500 {
+			t.Fatalf("unexpected row %v", row)
+		}
+	}
+	logdbtest.AssertNil(t, rows.Close())
+
+	if fmt.Sprint(sensors) != "[9 8 7]" {
+		t.Fatalf("unexpected sensors %v", sensors)
+	}
+
+	rows, err = Query(db, "select * where SensorId = 3 and Temperature > 40 order by Timestamp limit 2", Options{})
+	logdbtest.AssertNil(t, err)
+
+	var n int
+	for rows.Next() {
+		row := rows.Values()
+		if len(row) != 4 || row[0].I != 3 || row[2].I <= 40 || row[3].String() != "sensor-3" {
+			t.Fatalf("unexpected row %v", row)
+		}
+		n++
+	}
+
+	if n != 2 {
+		t.Fatalf("expected 2 rows but got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Query(db, "select count(*)", Options{Context: ctx}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled query but got %v", err)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	for _, expr := range []string{"SensorId = 3 AND Temperature > 40", "Name != 'sensor-1' OR NOT Temperature < 3", "SensorId BETWEEN ? AND ?"} {
+		args := []interface{}{1, 2}
+		if _, err := ParseExpr(expr, args[:strings.Count(expr, "?")]...); err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+	}
+
+	for _, expr := range []string{"", "SensorId =", "SensorId = 3 LIMIT 1", "SensorId = ?"} {
+		if _, err := ParseExpr(expr); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestScanOptions(t *testing.T) {
+	db := openTestDB(t)
+
+	s, err := Parse("select * where Timestamp >= 1594166400 + 60 and 1594170000 > Timestamp and SensorId = 3 and Temperature < 3 or Temperature > 40")
+	logdbtest.AssertNil(t, err)
+	q, err := s.Query(db)
+	logdbtest.AssertNil(t, err)
+	if len(q.Scan.Ranges) != 0 || len(q.Scan.Equals) != 0 {
+		t.Fatalf("expected no scan options for a disjunction but got %v", q.Scan)
+	}
+
+	s, err = Parse("select * where Timestamp >= 1594166400 and 1594170000 > Timestamp and SensorId = 3 and Temperature < 3")
+	logdbtest.AssertNil(t, err)
+	q, err = s.Query(db)
+	logdbtest.AssertNil(t, err)
+
+	if len(q.Scan.Equals) != 1 || len(q.Scan.Ranges) != 3 {
+		t.Fatalf("unexpected scan options %v", q.Scan)
+	}
+
+	if r := q.Scan.Ranges[0]; r.Min != testStart || r.Max != 1594170000 {
+		t.Fatalf("unexpected timestamp range %v", r)
+	}
+}
@@ -1,17 +1,21 @@
 package logdb
 
 import (
+	"crypto/cipher"
+	"errors"
 	"fmt"
 	"github.com/pierrec/lz4"
 	"github.com/worldiety/ioutil"
-	"io"
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
 type DB struct {
+	fname              string
+	opts               Options
 	file               *os.File
 	eof                int64
 	tmpWriteObj        *Object
@@ -22,48 +26,92 @@ type DB struct {
 	objPool            sync.Pool
 	recPool            sync.Pool
 	header             *Header
+	headerUsed         int // the used bytes of the header, when it has been written last, see Header.usedBytes
 	mmapFile           []byte
 	useMmap            bool
 	compress           bool
 	compressHashtable  []int
+	aead               cipher.AEAD // encrypts the records with the data key, nil if not encrypted
+	keys               *keyIndex
+	records            *recordIndex
+	zones              *zoneMapIndex
+	blooms             *bloomIndex
+	indexes            map[uint16]*secondaryIndex
+	encodings          map[uint16]Encoding // of the names, see Options.Encodings
+	readerPool         sync.Pool
 }
 
+// ErrReadOnly is returned by all modifications of a database, which has been opened read-only, see
+// Options.ReadOnly.
+var ErrReadOnly = errors.New("database is opened read-only")
+
+// errStopped ends the routines of a parallel walk, after another routine has failed or stopped the walk.
+var errStopped = errors.New("stopped")
+
+// ErrUnusable is returned, if a database has been closed internally and could not be opened again, see Compact.
+// The database must not be used anymore.
+var ErrUnusable = errors.New("database is unusable")
+
+// Open is a shortcut for OpenOptions without any further options.
 func Open(fname string, useMmap bool, compression bool) (*DB, error) {
-	file, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
+	return OpenOptions(fname, Options{Mmap: useMmap, Compression: compression})
+}
+
+// OpenOptions opens or creates the database file using the given options.
+func OpenOptions(fname string, opts Options) (*DB, error) {
+	db := &DB{fname: fname, opts: opts}
+	if err := db.open(); err != nil {
 		return nil, err
 	}
 
+	return db, nil
+}
+
+func (db *DB) open() error {
+	useMmap, compression := db.opts.Mmap, db.opts.Compression
+
+	flag := os.O_RDWR | os.O_CREATE
+	if db.opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	file, err := os.OpenFile(db.fname, flag, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	fmt.Printf("mmap=%v compression=%v\n", useMmap, compression)
+	db.logf("mmap=%v compression=%v\n", useMmap, compression)
 
-	fmt.Printf("db size is %d (%d MiB)\n", stat.Size(), stat.Size()/1024/1024)
+	db.logf("db size is %d (%d MiB)\n", stat.Size(), stat.Size()/1024/1024)
 
-	db := &DB{file: file, eof: stat.Size()}
+	db.file = file
+	db.eof = stat.Size()
 	db.maxObjSize = 1024 * 64                                           // 64k max object size
 	db.maxRecSize = db.maxObjSize * 1000                                // 64MB max batch size
 	db.header = newHeader(int(ioutil.MaxUint8) * int(ioutil.MaxUint16)) // 16mb
-	db.pendingWriteRecord = newRecord(db.maxRecSize)
+	db.pendingWriteRecord = newRecord(offsetRecObjList)                 // allocated with the first Add
 	db.tmpWriteObj = newObject(db.maxObjSize)
 	db.reader, err = newConcurrentCachedReader(db.file, db.maxRecSize)
 	db.useMmap = useMmap
 	db.compressHashtable = make([]int, 1<<16)
 	db.compress = compression
+	db.mmapFile = nil
 
-	if useMmap {
+	if useMmap && stat.Size() > 0 {
 		data, err := syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE)
 		if err != nil {
-			return nil, fmt.Errorf("error mmap: %w", err)
+			return fmt.Errorf("error mmap: %w", err)
 		}
 		db.mmapFile = data
 	}
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 	db.objPool = sync.Pool{
 		New: func() interface{} { return newObject(db.maxObjSize) },
@@ -73,40 +121,119 @@ func Open(fname string, useMmap bool, compression bool) (*DB, error) {
 		New: func() interface{} { return newRecord(db.maxRecSize) },
 	}
 
+	db.readerPool = sync.Pool{
+		New: func() interface{} { return newRecordReader(db) },
+	}
+
+	if db.eof == 0 && db.opts.ReadOnly {
+		_ = db.file.Close()
+		return fmt.Errorf("unable to open the empty database file read-only")
+	}
+
 	if db.eof == 0 {
+		if err := db.openEncryption(true); err != nil {
+			_ = db.file.Close()
+			return err
+		}
+
 		db.header.Flush()
 		_, err := db.file.Write(db.header.buf.Bytes)
+		db.header.release()
+		if err == nil {
+			err = db.file.Truncate(int64(db.header.Size())) // reserve the remaining header space
+		}
 		if err != nil {
 			_ = db.file.Close()
-			return nil, fmt.Errorf("unable to create db header: %w", err)
+			return fmt.Errorf("unable to create db header: %w", err)
 		}
-		db.eof = int64(len(db.header.buf.Bytes))
+		db.eof = int64(db.header.Size())
 
 	} else {
-		if db.eof < int64(len(db.header.buf.Bytes)) {
+		if db.eof < int64(db.header.Size()) {
 			_ = db.file.Close()
-			return nil, fmt.Errorf("truncated database file, header to short: %w", err)
+			return fmt.Errorf("truncated database file, header to short: %w", err)
 		} else {
-			_, err := db.file.Read(db.header.buf.Bytes)
+			_, err := db.file.Read(db.header.buffer(db.header.Size()).Bytes)
 			if err != nil {
 				_ = db.file.Close()
-				return nil, fmt.Errorf("unable to read header: %w", err)
+				return fmt.Errorf("unable to read header: %w", err)
 			}
 			db.header.reverseFlush()
+			db.header.release()
+			if err := db.openEncryption(false); err != nil {
+				_ = db.file.Close()
+				return err
+			}
 		}
 	}
 
-	fmt.Printf("names: %d\n", db.header.nameCount)
-	fmt.Printf("objects: %d\n", db.header.ObjectCount())
-	fmt.Printf("last transaction: %d\n", db.header.TxCount())
+	db.logf("names: %d\n", db.header.nameCount)
+	db.logf("objects: %d\n", db.header.ObjectCount())
+	db.logf("last transaction: %d\n", db.header.TxCount())
 
-	return db, nil
+	db.openEncodings()
+
+	if err := db.openRecordIndex(); err != nil {
+		_ = db.file.Close()
+		return fmt.Errorf("unable to open record index: %w", err)
+	}
+
+	if err := db.detectSideFiles(); err != nil {
+		_ = db.file.Close()
+		return fmt.Errorf("unable to detect side files: %w", err)
+	}
+
+	if db.opts.ZoneMaps {
+		if err := db.openZoneMaps(); err != nil {
+			_ = db.file.Close()
+			return fmt.Errorf("unable to open zone maps: %w", err)
+		}
+	}
+
+	if len(db.opts.BloomFilters) > 0 {
+		if err := db.openBloomFilters(); err != nil {
+			_ = db.file.Close()
+			return fmt.Errorf("unable to open bloom filters: %w", err)
+		}
+	}
+
+	if err := db.openIndexes(); err != nil {
+		_ = db.file.Close()
+		return fmt.Errorf("unable to open secondary indexes: %w", err)
+	}
+
+	if len(db.opts.Key) > 0 {
+		if err := db.buildKeyIndex(); err != nil {
+			_ = db.file.Close()
+			return fmt.Errorf("unable to build key index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// logf prints diagnostic output, unless the database has been opened quietly.
+func (db *DB) logf(format string, args ...interface{}) {
+	if !db.opts.Quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Options returns the options which have been used to open the database.
+func (db *DB) Options() Options {
+	return db.opts
 }
 
 func (db *DB) ObjectCount() uint64 {
 	return db.header.ObjectCount()
 }
 
+// Size returns the size of all records including the pending record, which is not yet written. The reserved
+// header is not included.
+func (db *DB) Size() int64 {
+	return db.eof - int64(db.header.Size()) + int64(db.pendingWriteRecord.Size()) - offsetRecObjList
+}
+
 func (db *DB) NameByIndex(idx int) string {
 	return db.header.NameByIndex(idx)
 }
@@ -124,13 +251,15 @@ func (db *DB) Names() []string {
 }
 
 func (db *DB) Add(f func(obj *Object) error) error {
-	record := db.pendingWriteRecord
-	if record.MaxSize()-int(record.Size()) < db.maxObjSize {
-		if err := db.Flush(); err != nil {
-			return err
-		}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
 	}
 
+	if err := db.reserve(db.maxObjSize); err != nil {
+		return err
+	}
+
+	record := db.pendingWriteRecord
 	obj := db.tmpWriteObj
 	obj.resetWrite()
 	err := f(obj)
@@ -139,11 +268,65 @@ func (db *DB) Add(f func(obj *Object) error) error {
 	}
 
 	obj.flush()
-	record.Add(obj)
+	db.indexObject(db.pendingID(), obj)
+	if db.opts.TimestampDeltas || len(db.encodings) > 0 || hasFieldType(obj.Bytes(), isDictString) {
+		record.addRelative(obj, db.header, db.opts.TimestampDeltas, db.encodings)
+	} else {
+		record.Add(obj)
+	}
 	db.header.AddObjectCount(1)
 	return nil
 }
 
+// reserve flushes the pending record, if it has less than size bytes left.
+func (db *DB) reserve(size int) error {
+	if db.pendingWriteRecord.MaxSize() < db.maxRecSize {
+		db.pendingWriteRecord = newRecord(db.maxRecSize)
+	}
+
+	record := db.pendingWriteRecord
+	if record.MaxSize()-int(record.Size()) < size {
+		return db.Flush()
+	}
+
+	return nil
+}
+
+// indexObject collects the object for the key index, the secondary indexes, zone maps and bloom filters,
+// which are completed by commitRecord.
+func (db *DB) indexObject(id uint64, obj *Object) {
+	if db.keys != nil {
+		db.keys.put(obj, id)
+	}
+	for _, x := range db.indexes {
+		x.pending = collectIndexEntry(x.pending, x.name, id, obj)
+	}
+	if db.zones != nil {
+		db.zones.builder.add(obj)
+	}
+	if db.blooms != nil {
+		db.blooms.builder.add(obj)
+	}
+}
+
+// releaseWriteBuffer frees the buffer of the pending record, if it is empty. It is allocated again with the
+// next Add.
+func (db *DB) releaseWriteBuffer() {
+	if db.pendingWriteRecord.Size() == offsetRecObjList {
+		db.pendingWriteRecord = newRecord(offsetRecObjList)
+	}
+}
+
+// pendingID returns the id which the next object added to the pending record will get.
+func (db *DB) pendingID() uint64 {
+	base := uint64(db.header.Size())
+	if last, ok := db.records.last(); ok {
+		base = last.base + uint64(last.size)
+	}
+
+	return base + uint64(db.pendingWriteRecord.Size())
+}
+
 func (db *DB) Flush() error {
 	record := db.pendingWriteRecord
 	record.flush()
@@ -153,74 +336,165 @@ func (db *DB) Flush() error {
 		return nil
 	}
 
+	// the record may refer to new names and dictionary values, which must not get lost, if the process
+	// crashes before the header is written by Close or Sync
+	if db.header.usedBytes() != db.headerUsed {
+		if err := db.flushHeader(); err != nil {
+			return err
+		}
+	}
+
+	offset := db.eof
+	data := tmp
+
+	if db.opts.Columnar {
+		columnarRec := db.recPool.Get().(*Record)
+		defer db.recPool.Put(columnarRec)
+
+		if record.transpose(columnarRec) {
+			data = columnarRec.Bytes()
+			record.columnar = true
+		}
+	}
+
 	if db.compress {
 		compressedRec := db.recPool.Get().(*Record)
 		defer db.recPool.Put(compressedRec)
 
-		n, err := lz4.CompressBlock(tmp, compressedRec.buf.Bytes, db.compressHashtable)
+		n, err := lz4.CompressBlock(data, compressedRec.buf.Bytes, db.compressHashtable)
 		if err != nil {
 			return fmt.Errorf("failed to compress: %w", err)
 		}
 
+		data = compressedRec.buf.Bytes[:n]
+	}
+
+	if db.aead != nil {
+		data = sealRecord(db.aead, offset, data)
+	}
+
+	if db.prefixed() {
 		length := ioutil.LittleEndianBuffer{
 			Bytes: make([]byte, 4),
 			Pos:   0,
 		}
-		length.WriteUint32(uint32(n))
+		length.WriteUint32(uint32(len(data)))
 		db.file.WriteAt(length.Bytes, db.eof)
 		db.eof += 4
+	}
 
-		ctmp := compressedRec.buf.Bytes[:n]
-		n, err = db.file.WriteAt(ctmp, db.eof)
+	n, err := db.file.WriteAt(data, db.eof)
+	if err != nil {
+		return err
+	}
 
-		if n != len(ctmp) {
-			return fmt.Errorf("file did not accept full cbuffer")
-		}
+	if n != len(data) {
+		return fmt.Errorf("file did not accept full buffer")
+	}
 
-		db.eof += int64(len(ctmp))
-		record.Reset()
+	db.eof += int64(len(data))
 
-	} else {
-		n, err := db.file.WriteAt(tmp, db.eof)
-		if err != nil {
+	if err := db.commitRecord(offset, record); err != nil {
+		return err
+	}
+
+	record.Reset()
+	db.header.AddTxCount(1)
+	return nil
+}
+
+// commitRecord adds the record, which has been written at the file offset, to the record index and
+// completes the zone maps, bloom filters and secondary indexes of its objects.
+func (db *DB) commitRecord(offset int64, record *Record) error {
+	if err := db.records.add(offset, uint32(db.eof-offset), record.Size(), record.ObjectCount(), record.columnar); err != nil {
+		return err
+	}
+
+	if db.zones != nil {
+		if err := db.zones.add(db.zones.builder.build(offset)); err != nil {
 			return err
 		}
+	}
 
-		if n != len(tmp) {
-			return fmt.Errorf("file did not accept full buffer")
+	if db.blooms != nil {
+		if err := db.blooms.add(db.blooms.builder.build(offset)); err != nil {
+			return err
 		}
+	}
 
-		db.eof += int64(len(tmp))
-		record.Reset()
+	if len(db.indexes) > 0 {
+		last, _ := db.records.last()
+		for _, x := range db.indexes {
+			if err := x.addRun(x.pending, []recordInfo{last}); err != nil {
+				return fmt.Errorf("unable to update index: %w", err)
+			}
+			x.pending = x.pending[:0]
+		}
 	}
 
-	db.header.AddTxCount(1)
 	return nil
 }
 
 func (db *DB) flushHeader() error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
 	header := db.header
 	header.Flush()
 
 	_, err := db.file.WriteAt(header.buf.Bytes, 0)
+	header.release()
+	if err == nil {
+		db.headerUsed = header.usedBytes()
+	}
 	return err
 }
 
 func (db *DB) Close() error {
-	if err := db.Flush(); err != nil {
-		return err
+	if !db.opts.ReadOnly {
+		if err := db.Flush(); err != nil {
+			return err
+		}
+
+		if err := db.flushHeader(); err != nil {
+			return err
+		}
+	}
+
+	if db.mmapFile != nil {
+		if err := syscall.Munmap(db.mmapFile); err != nil {
+			return err
+		}
+		db.mmapFile = nil
 	}
 
-	if err := db.flushHeader(); err != nil {
+	if err := db.records.Close(); err != nil {
 		return err
 	}
 
+	if db.zones != nil {
+		if err := db.zones.Close(); err != nil {
+			return err
+		}
+	}
+
+	if db.blooms != nil {
+		if err := db.blooms.Close(); err != nil {
+			return err
+		}
+	}
+
 	return db.file.Close()
 }
 
 // Read seeks to the id (currently just the offset) and reads the object. It is safe to be used
 // concurrently.
 func (db *DB) Read(id uint64, f func(obj *Object) error) error {
+	if db.prefixed() || db.records.hasColumnar() {
+		return db.readCompressed(id, f)
+	}
+
 	obj := db.objPool.Get().(*Object)
 	defer db.objPool.Put(obj)
 
@@ -229,6 +503,10 @@ func (db *DB) Read(id uint64, f func(obj *Object) error) error {
 	if err != nil {
 		return err
 	}
+	if hasRelativeFields(obj.Bytes()) {
+		// the values can only be resolved with the preceding objects of the record
+		return db.readCompressed(id, f)
+	}
 	if err := f(obj); err != nil {
 		return err
 	}
@@ -236,32 +514,52 @@ func (db *DB) Read(id uint64, f func(obj *Object) error) error {
 	return nil
 }
 
+// readCompressed looks up the record of the id in the record index, because the ids of compressed, encrypted
+// or columnar records are logical offsets into the plain row records. It also reads objects with relative
+// values, which are resolved by their record.
+func (db *DB) readCompressed(id uint64, f func(obj *Object) error) error {
+	info, ok := db.records.byID(id)
+	if !ok {
+		return fmt.Errorf("id %d is out of range", id)
+	}
+
+	reader := db.readerPool.Get().(*recordReader)
+	defer db.readerPool.Put(reader)
+
+	record, err := reader.readInfo(info)
+	if err != nil {
+		return err
+	}
+
+	obj := db.objPool.Get().(*Object)
+	buf := obj.buf.Bytes
+	defer func() {
+		// At slices the object into the record, but Read needs its own buffer
+		obj.buf.Bytes = buf
+		db.objPool.Put(obj)
+	}()
+
+	return record.At(int(id-info.base), obj, func(offset int, object *Object) error {
+		return f(object)
+	})
+}
+
 // ForEach is safe to be used concurrently. It allocates its own buffer
 // on each call, which is an easy design and is negligible for large datasets.
 func (db *DB) ForEach(f func(id uint64, obj *Object) error) error {
-	record := newRecord(db.pendingWriteRecord.MaxSize())
+	reader := newRecordReader(db)
 	obj := newObject(db.maxObjSize)
 
-	offset := int64(len(db.header.buf.Bytes))
-	for offset < db.eof {
-		lBuf, err := db.file.ReadAt(record.buf.Bytes, offset)
+	for _, info := range db.findRecords() {
+		record, err := reader.readInfo(info)
 		if err != nil {
-			if err != io.EOF {
-				return err
-			}
-		}
-
-		if lBuf < offsetRecObjList {
-			return fmt.Errorf("unable to read a record at offset %d", offset)
+			return err
 		}
 
-		record.reverseFlush()
 		err = record.ForEach(obj, func(recOffset int, object *Object) error {
-			return f(uint64(offset)+uint64(recOffset), object)
+			return f(info.base+uint64(recOffset), object)
 		})
 
-		offset += int64(record.Size())
-
 		if err != nil {
 			return err
 		}
@@ -271,81 +569,35 @@ func (db *DB) ForEach(f func(id uint64, obj *Object) error) error {
 	return nil
 }
 
-// findRecords parses over the entire file and returns all record offset
-func (db *DB) findRecords() ([]int64, error) {
-	res := make([]int64, 0, db.header.txCount)
-
-	if db.compress {
-		tmp := make([]byte, 4)
-		buf := ioutil.LittleEndianBuffer{
-			Bytes: tmp,
-			Pos:   0,
-		}
-
-		offset := int64(len(db.header.buf.Bytes))
-		for offset < db.eof {
-			res = append(res, offset)
-			_, err := db.file.ReadAt(tmp, offset)
-			if err != nil {
-				if err != io.EOF {
-					return nil, err
-				}
-			}
-			offset += 4
-
-			buf.Pos = 0
-			size := buf.ReadUint32()
-
-			offset += int64(size)
-		}
-		return res, nil
-
-	} else {
-
-		tmp := make([]byte, offsetRecObjCount)
-		buf := ioutil.LittleEndianBuffer{
-			Bytes: tmp,
-			Pos:   0,
-		}
-
-		offset := int64(len(db.header.buf.Bytes))
-		for offset < db.eof {
-			res = append(res, offset)
-			lBuf, err := db.file.ReadAt(tmp, offset)
-			if err != nil {
-				if err != io.EOF {
-					return nil, err
-				}
-			}
-
-			if lBuf < offsetRecObjCount {
-				return nil, fmt.Errorf("unable to read a record bound at offset %d", offset)
-			}
-
-			buf.Pos = offsetRecSize
-			size := buf.ReadUint32()
-
-			offset += int64(size)
-		}
-
-		return res, nil
-	}
+// findRecords returns all records which have been flushed so far.
+func (db *DB) findRecords() []recordInfo {
+	return db.records.snapshot()
 }
 
 // ForEachP walks parallel over records and makes things worse: looks like we are crashing the cpu-memory bandwidth barrier with
 // more than 1 routine already. On big cloud machines this effect is even worse and makes everything
 // slower (e.g. from 20m to 13m for 2 cores, without any locking effects). Instruments shows
 // cache-misses increases on macos linearly.
-func (db *DB) ForEachP(routines int, f func(gid int, id uint64, obj *Object) error) (e error) {
-	records, err := db.findRecords()
-	if err != nil {
-		return err
-	}
+func (db *DB) ForEachP(routines int, f func(gid int, id uint64, obj *Object) error) error {
+	records := db.findRecords()
+
+	db.logf("found %d records\n", len(records))
+
+	return db.forEachRecordP(routines, records, nil, f)
+}
 
-	fmt.Printf("found %d records\n", len(records))
+// forEachRecordP distributes the given records equally across the amount of routines and returns the
+// first error which has occurred, which stops all routines with their next object. If columns is not nil, the objects of columnar records only contain the
+// fields of these names, see ScanOptions.Columns.
+func (db *DB) forEachRecordP(routines int, records []recordInfo, columns []uint16, f func(gid int, id uint64, obj *Object) error) (e error) {
+	if routines < 1 {
+		routines = 1
+	}
 
 	wg := sync.WaitGroup{}
 	wg.Add(routines)
+	errMutex := sync.Mutex{}
+	var stopped int32 // set by the first error, so that all routines stop with the next object
 
 	batchSize := len(records) / routines // 11/2 = 5
 
@@ -361,70 +613,47 @@ func (db *DB) ForEachP(routines int, f func(gid int, id uint64, obj *Object) err
 
 			defer wg.Done()
 
-			record := newRecord(db.pendingWriteRecord.MaxSize())
-			compressedRec := newRecord(db.pendingWriteRecord.MaxSize())
-
+			reader := newRecordReader(db)
 			obj := newObject(db.maxObjSize)
+			var scratch []byte
 
 			for r := fromRec; r < toRec; r++ {
-
-				offset := records[r]
-
-				if db.useMmap {
-					max := db.maxRecSize
-					avail := int(db.eof) - int(offset)
-					if max > avail {
-						max = avail
-					}
-					record.buf.Bytes = db.mmapFile[offset : int(offset)+max]
-					if db.compress {
-						panic("not yet implemented")
-					}
-				} else {
-					if db.compress {
-						db.file.ReadAt(compressedRec.buf.Bytes, offset)
-						offset += 4
-						clen := compressedRec.buf.ReadUint32()
-						compressedRec.buf.Pos = 0
-
-						buf := compressedRec.buf.Bytes[:clen]
-						_, err := db.file.ReadAt(buf, offset)
-						n, err := lz4.UncompressBlock(buf, record.buf.Bytes)
-						if err != nil {
-							panic(err)
-						}
-
-						if n < offsetRecObjList {
-							panic(fmt.Errorf("unable to read a record at offset %d", offset))
-						}
-
-					} else {
-
-						lBuf, err := db.file.ReadAt(record.buf.Bytes, offset)
-						if err != nil {
-							if err != io.EOF {
-								panic(err)
-							}
-						}
-
-						if lBuf < offsetRecObjList {
-							panic(fmt.Errorf("unable to read a record at offset %d", offset))
-						}
+				info := records[r]
+				visit := func(recOffset int, object *Object) error {
+					if atomic.LoadInt32(&stopped) != 0 {
+						return errStopped
 					}
-
+					return f(id, info.base+uint64(recOffset), object)
 				}
 
-				record.reverseFlush()
-				err = record.ForEach(obj, func(recOffset int, object *Object) error {
-					return f(id, uint64(offset)+uint64(recOffset), object)
-				})
+				var record *Record
+				var cols *columnar
+				var err error
+				if columns != nil && info.columnar {
+					record, cols, err = reader.readColumns(info)
+				} else {
+					record, err = reader.readInfo(info)
+				}
 
-				offset += int64(record.Size())
+				switch {
+				case err != nil:
+				case cols != nil:
+					err = cols.forEach(columns, obj, &scratch, visit)
+				default:
+					err = record.ForEach(obj, visit)
+				}
 
 				if err != nil {
-					if e != nil {
+					atomic.StoreInt32(&stopped, 1)
+					if err == errStopped {
+						return
+					}
+
+					errMutex.Lock()
+					if e == nil {
 						e = err
 					}
+					errMutex.Unlock()
 					return
 				}
 			}
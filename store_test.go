@@ -2,6 +2,7 @@ package logdb
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/worldiety/ioutil"
 	ioutil2 "io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -32,7 +34,7 @@ func TestOpen(t *testing.T) {
 
 	fname := filepath.Join(dir, "mydb.bin")
 	_ = os.Remove(fname)
-	db, err := Open(fname)
+	db, err := Open(fname, false, false)
 	assertNil(t, err)
 	err = db.Add(func(obj *Object) error {
 		obj.AddField(1, func(f *FieldWriter) {
@@ -45,7 +47,7 @@ func TestOpen(t *testing.T) {
 	err = db.Close()
 	assertNil(t, err)
 
-	db, err = Open(fname)
+	db, err = Open(fname, false, false)
 	assertNil(t, err)
 
 	is3 := false
@@ -87,7 +89,7 @@ func BenchmarkWrite(b *testing.B) {
 
 	fname := filepath.Join(dir, "tabledb.bin")
 	_ = os.Remove(fname)
-	db, err := Open(fname)
+	db, err := Open(fname, false, false)
 	assertNil(b, err)
 
 	b.Run("table", func(b *testing.B) {
@@ -121,7 +123,7 @@ func BenchmarkRead(b *testing.B) {
 
 	fname := filepath.Join(dir, "tabledb.bin")
 	_ = os.Remove(fname)
-	db, err := Open(fname)
+	db, err := Open(fname, false, false)
 	assertNil(b, err)
 
 	start := time.Now()
@@ -140,7 +142,7 @@ func BenchmarkRead(b *testing.B) {
 	eps := float64(len(testSet)) / float64(time.Now().Sub(start)) * float64(time.Second)
 	fmt.Printf("needed %v to insert %d entries (%2.f entries/second)\n", time.Now().Sub(start), len(testSet), eps)
 
-	db, err = Open(fname)
+	db, err = Open(fname, false, false)
 	assertNil(b, err)
 	defer db.Close()
 
@@ -185,7 +187,7 @@ func TestTable(t *testing.T) {
 
 	fname := filepath.Join(dir, "tabledb.bin")
 	_ = os.Remove(fname)
-	db, err := Open(fname)
+	db, err := Open(fname, false, false)
 	assertNil(t, err)
 
 	for _, field := range fields {
@@ -225,7 +227,7 @@ func TestTable(t *testing.T) {
 	fmt.Printf("needed %v to insert %d entries (%2.f entries/second)\n", time.Now().Sub(start), len(testSet), eps)
 
 	start = time.Now()
-	db, err = Open(fname)
+	db, err = Open(fname, false, false)
 	assertNil(t, err)
 	defer db.Close()
 
@@ -516,3 +518,149 @@ func generateValue(kind ioutil.Type) interface{} {
 		panic("not implemented " + strconv.Itoa(int(kind)))
 	}
 }
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "readOnlyTest")
+	assertNil(t, err)
+
+	fname := filepath.Join(dir, "db.bin")
+	if _, err := OpenOptions(fname, Options{Quiet: true, ReadOnly: true}); err == nil {
+		t.Fatalf("expected error for a missing file")
+	}
+
+	opts := Options{Quiet: true, ZoneMaps: true, BloomFilters: []string{"id"}}
+	writer, err := OpenOptions(fname, opts)
+	assertNil(t, err)
+
+	id := writer.PutName("id")
+	add := func(from, to int) {
+		for i := from; i < to; i++ {
+			assertNil(t, writer.Add(func(obj *Object) error {
+				obj.AddInt(id, int64(i))
+				return nil
+			}))
+		}
+		assertNil(t, writer.Flush())
+	}
+
+	add(0, 100)
+	add(100, 200)
+	assertNil(t, writer.CreateIndex(id, IndexOptions{}))
+	assertNil(t, writer.Close())
+
+	// the record index and zone maps are missing, but are neither rebuilt into files nor used
+	assertNil(t, os.Remove(fname+recordIndexSuffix))
+	assertNil(t, os.Remove(fname+zoneMapSuffix))
+	files := func() map[string]string {
+		res := make(map[string]string)
+		matches, err := filepath.Glob(fname + "*")
+		assertNil(t, err)
+		for _, match := range matches {
+			b, err := ioutil2.ReadFile(match)
+			assertNil(t, err)
+			res[match] = string(b)
+		}
+		return res
+	}
+	before := files()
+
+	opts.ReadOnly = true
+	db, err := OpenOptions(fname, opts)
+	assertNil(t, err)
+	if err := db.Add(func(obj *Object) error { return nil }); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly but got %v", err)
+	}
+	if err := db.CreateIndex(id, IndexOptions{}); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly but got %v", err)
+	}
+
+	count := 0
+	assertNil(t, db.Scan(ScanOptions{Ranges: []Range{{Name: id, Min: 150, Max: 159}}, Equals: []Equal{EqualInt(id, 155)}},
+		func(gid int, oid uint64, obj *Object) error {
+			count++
+			return nil
+		}))
+	if count != 1 || len(db.Records()) != 2 {
+		t.Fatalf("unexpected count %d in %d records", count, len(db.Records()))
+	}
+
+	assertNil(t, db.IndexRange(id, 150, 159, func(value float64, oid uint64) error {
+		count++
+		return nil
+	}))
+	if count != 11 {
+		t.Fatalf("expected 10 index matches, but got %d", count-1)
+	}
+	assertNil(t, db.Close())
+
+	if after := files(); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Fatalf("read-only database has modified its files")
+	}
+
+	// a reader next to a writer must not overwrite the newer header of the writer
+	opts.ReadOnly = false
+	writer, err = OpenOptions(fname, opts)
+	assertNil(t, err)
+
+	opts.ReadOnly = true
+	db, err = OpenOptions(fname, opts)
+	assertNil(t, err)
+
+	add(200, 300)
+	assertNil(t, writer.Close())
+	assertNil(t, db.Close())
+
+	db, err = OpenOptions(fname, opts)
+	assertNil(t, err)
+	if db.ObjectCount() != 300 || len(db.Records()) != 3 {
+		t.Fatalf("unexpected %d objects in %d records", db.ObjectCount(), len(db.Records()))
+	}
+	assertNil(t, db.Verify())
+	assertNil(t, db.Close())
+}
+
+func TestForEachPStop(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "forEachTest")
+	assertNil(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := OpenOptions(filepath.Join(dir, "db.bin"), Options{Quiet: true})
+	assertNil(t, err)
+	defer db.Close()
+
+	id := db.PutName("id")
+	const count = 100_000
+	for i := 0; i < count; i++ {
+		assertNil(t, db.Add(func(obj *Object) error {
+			obj.AddInt(id, int64(i))
+			return nil
+		}))
+
+		if i%1000 == 999 {
+			assertNil(t, db.Flush())
+		}
+	}
+
+	// the other routines start only after the first one has failed, but must not walk over their records
+	stop := errors.New("stop")
+	failed := make(chan struct{})
+	var visited int64
+	err = db.ForEachP(4, func(gid int, id uint64, obj *Object) error {
+		if gid == 0 {
+			close(failed)
+			return stop
+		}
+
+		<-failed
+		atomic.AddInt64(&visited, 1)
+		return nil
+	})
+
+	if err != stop {
+		t.Fatalf("expected the stop error but got %v", err)
+	}
+
+	if visited > count/2 {
+		t.Fatalf("the routines have not been stopped: %d objects visited", visited)
+	}
+}
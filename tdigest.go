@@ -0,0 +1,153 @@
+package logdb
+
+import (
+	"math"
+	"sort"
+)
+
+// centroid is the mean of a cluster of values.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest estimates quantiles using a merging t-digest. Clusters near the tails are kept small, so that
+// extreme quantiles are more accurate than the median.
+type TDigest struct {
+	compression float64
+	centroids   []centroid // sorted by mean
+	buffer      []centroid // unsorted values, which have not been merged yet
+	count       float64
+	min         float64
+	max         float64
+}
+
+// NewTDigest creates an empty digest. The compression limits the amount of centroids, a typical value
+// is 100.
+func NewTDigest(compression float64) *TDigest {
+	if compression < 10 {
+		compression = 10
+	}
+
+	return &TDigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// Add inserts a single value.
+func (d *TDigest) Add(v float64) {
+	d.buffer = append(d.buffer, centroid{mean: v, weight: 1})
+	d.count++
+	if v < d.min {
+		d.min = v
+	}
+	if v > d.max {
+		d.max = v
+	}
+
+	if len(d.buffer) >= int(d.compression)*5 {
+		d.compress()
+	}
+}
+
+// Merge inserts all centroids of the other digest.
+func (d *TDigest) Merge(o *TDigest) {
+	d.buffer = append(d.buffer, o.centroids...)
+	d.buffer = append(d.buffer, o.buffer...)
+	d.count += o.count
+	d.min = math.Min(d.min, o.min)
+	d.max = math.Max(d.max, o.max)
+	d.compress()
+}
+
+// Count returns the amount of added values.
+func (d *TDigest) Count() uint64 {
+	return uint64(d.count)
+}
+
+// compress merges the buffer into the centroids. Neighbouring centroids are combined as long as the
+// combined weight stays below the size limit of its quantile, which is 4*n*q*(1-q)/compression.
+func (d *TDigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+
+	all := append(d.buffer, d.centroids...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	cur := all[0]
+	soFar := 0.0
+	for _, c := range all[1:] {
+		q := (soFar + (cur.weight+c.weight)/2) / d.count
+		limit := 4 * d.count * q * (1 - q) / d.compression
+		if cur.weight+c.weight <= limit {
+			cur.mean += (c.mean - cur.mean) * c.weight / (cur.weight + c.weight)
+			cur.weight += c.weight
+			continue
+		}
+
+		merged = append(merged, cur)
+		soFar += cur.weight
+		cur = c
+	}
+
+	d.centroids = append(merged, cur)
+	d.buffer = d.buffer[:0]
+}
+
+// Quantile returns the estimated value at the quantile q within [0, 1] or NaN, if the digest is empty.
+func (d *TDigest) Quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return math.NaN()
+	}
+
+	if q <= 0 {
+		return d.min
+	}
+
+	if q >= 1 {
+		return d.max
+	}
+
+	// each centroid represents its weight, centered at its mean
+	target := q * d.count
+	soFar := 0.0
+	prevMean, prevCenter := d.min, 0.0
+	for _, c := range d.centroids {
+		center := soFar + c.weight/2
+		if target < center {
+			if center == prevCenter {
+				return c.mean
+			}
+			return prevMean + (c.mean-prevMean)*(target-prevCenter)/(center-prevCenter)
+		}
+
+		soFar += c.weight
+		prevMean, prevCenter = c.mean, center
+	}
+
+	if d.count == prevCenter {
+		return d.max
+	}
+
+	return prevMean + (d.max-prevMean)*(target-prevCenter)/(d.count-prevCenter)
+}
+
+// Quantiles estimates quantiles of the numeric field with the given name using a t-digest with the given
+// compression. The state is a *TDigest.
+func Quantiles(name uint16, compression float64) Aggregator {
+	return AggregatorFuncs{
+		NewState: func() interface{} { return NewTDigest(compression) },
+		UpdateState: func(state interface{}, obj *Object) {
+			if v, ok := numberField(obj, name); ok {
+				state.(*TDigest).Add(v)
+			}
+		},
+		MergeStates: func(a, b interface{}) interface{} {
+			a.(*TDigest).Merge(b.(*TDigest))
+			return a
+		},
+	}
+}
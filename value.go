@@ -0,0 +1,68 @@
+package logdb
+
+import (
+	"github.com/worldiety/ioutil"
+	"math"
+)
+
+// appendValue appends a normalized representation of the field value which the reader currently points to.
+// Integer and integral float values of any width result in the same bytes, so that e.g. an AddInt and
+// an AddUint32 with the same value compare equal. The representation is only meant for equality
+// comparison and hashing, not for ordering.
+func appendValue(dst []byte, kind ioutil.Type, f *FieldReader) []byte {
+	buf := (*ioutil.LittleEndianBuffer)(f)
+	switch kind {
+	case ioutil.TFloat32, ioutil.TFloat64:
+		v := f.ReadFloat()
+		if _, frac := math.Modf(v); frac == 0 && v >= math.MinInt64 && v <= math.MaxInt64 {
+			return appendUint64(append(dst, 'i'), uint64(int64(v)))
+		}
+		return appendUint64(append(dst, 'f'), math.Float64bits(v))
+	case ioutil.TString8, ioutil.TString16, ioutil.TString24, ioutil.TString32, TDictString:
+		return appendBytes(append(dst, 's'), rawBytes(buf))
+	case ioutil.TBlob8, ioutil.TBlob16, ioutil.TBlob24, ioutil.TBlob32:
+		return appendBytes(append(dst, 'b'), rawBytes(buf))
+	default:
+		if kind.IsNumber() {
+			return appendUint64(append(dst, 'i'), uint64(f.ReadInt()))
+		}
+
+		start := buf.Pos
+		buf.Pos++
+		drain(buf, kind)
+		return appendBytes(append(dst, 'r'), buf.Bytes[start:buf.Pos])
+	}
+}
+
+// rawBytes reads a typed length prefixed string or blob, or the string of a dictionary string, and returns the
+// slice without copying.
+func rawBytes(buf *ioutil.LittleEndianBuffer) []byte {
+	var n int
+	switch buf.ReadType() {
+	case TDictString:
+		buf.Pos += 4
+		return rawBytes(buf)
+	case ioutil.TString8, ioutil.TBlob8:
+		n = int(buf.ReadUint8())
+	case ioutil.TString16, ioutil.TBlob16:
+		n = int(buf.ReadUint16())
+	case ioutil.TString24, ioutil.TBlob24:
+		n = int(buf.ReadUint24())
+	default:
+		n = int(buf.ReadUint32())
+	}
+
+	b := buf.Bytes[buf.Pos : buf.Pos+n]
+	buf.Pos += n
+	return b
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+func appendBytes(dst []byte, b []byte) []byte {
+	n := uint32(len(b))
+	dst = append(dst, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	return append(dst, b...)
+}
@@ -0,0 +1,321 @@
+package logdb
+
+import (
+	"fmt"
+	"github.com/worldiety/ioutil"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// zoneMapSuffix is appended to the database file name to get the name of the zone map file.
+const zoneMapSuffix = ".zmap"
+
+// Range is a predicate which matches all objects containing a numeric field with the given name whose
+// value is within [Min, Max].
+type Range struct {
+	Name uint16
+	Min  float64
+	Max  float64
+}
+
+// Match returns true, if the object contains the field and its value is within the range.
+func (r Range) Match(obj *Object) bool {
+	matched := false
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+		if !matched && name == r.Name && kind.IsNumber() {
+			v := f.ReadFloat()
+			matched = v >= r.Min && v <= r.Max
+		}
+	})
+
+	return matched
+}
+
+// fieldZone contains the statistics of a single numeric field within a record.
+type fieldZone struct {
+	name      uint16
+	min       float64
+	max       float64
+	nullCount uint32 // amount of objects in the record without a numeric value for this name
+}
+
+// zoneMap contains the statistics of all numeric fields of a record, sorted by name.
+type zoneMap struct {
+	offset int64
+	fields []fieldZone
+}
+
+// mayMatch returns false, if no object of the record can match the range.
+func (z zoneMap) mayMatch(r Range) bool {
+	i := sort.Search(len(z.fields), func(i int) bool {
+		return z.fields[i].name >= r.Name
+	})
+
+	if i == len(z.fields) || z.fields[i].name != r.Name {
+		return false
+	}
+
+	field := z.fields[i]
+	return field.max >= r.Min && field.min <= r.Max
+}
+
+// zoneMapBuilder collects the statistics of the pending record. It uses a table for all possible
+// names, so that collecting causes no allocations.
+type zoneMapBuilder struct {
+	zones    []fieldZone
+	present  []uint32
+	lastSeen []uint32
+	touched  []uint16
+	objCount uint32
+}
+
+func newZoneMapBuilder() *zoneMapBuilder {
+	return &zoneMapBuilder{
+		zones:    make([]fieldZone, int(ioutil.MaxUint16)+1),
+		present:  make([]uint32, int(ioutil.MaxUint16)+1),
+		lastSeen: make([]uint32, int(ioutil.MaxUint16)+1),
+	}
+}
+
+func (b *zoneMapBuilder) add(obj *Object) {
+	b.objCount++
+	obj.WithFields(func(name uint16, kind ioutil.Type, f *FieldReader) {
+		if !kind.IsNumber() || b.lastSeen[name] == b.objCount {
+			return
+		}
+
+		v := f.ReadFloat()
+		if math.IsNaN(v) {
+			return
+		}
+
+		b.lastSeen[name] = b.objCount
+		zone := &b.zones[name]
+		if b.present[name] == 0 {
+			b.touched = append(b.touched, name)
+			zone.min, zone.max = v, v
+		} else if v < zone.min {
+			zone.min = v
+		} else if v > zone.max {
+			zone.max = v
+		}
+		b.present[name]++
+	})
+}
+
+// build returns the zone map of all added objects and resets the builder.
+func (b *zoneMapBuilder) build(offset int64) zoneMap {
+	z := zoneMap{offset: offset, fields: make([]fieldZone, 0, len(b.touched))}
+	for _, name := range b.touched {
+		zone := b.zones[name]
+		zone.name = name
+		zone.nullCount = b.objCount - b.present[name]
+		z.fields = append(z.fields, zone)
+
+		b.present[name] = 0
+		b.lastSeen[name] = 0
+	}
+
+	sort.Slice(z.fields, func(i, j int) bool {
+		return z.fields[i].name < z.fields[j].name
+	})
+
+	b.touched = b.touched[:0]
+	b.objCount = 0
+	return z
+}
+
+// zoneMapIndex keeps the zone maps of all records in memory and appends new ones to the zone map file.
+//
+// Format specification of an entry:
+//  - length              uint32, byte length of the following entry data
+//  - offset              uint64, file offset of the record
+//  - fieldCount          uint16
+//  - []                  fieldCount times
+//     {
+//       - name           uint16
+//       - min            float64
+//       - max            float64
+//       - nullCount      uint32
+//     }
+type zoneMapIndex struct {
+	file     *os.File // nil for a read-only database without zone map file
+	readOnly bool     // the file is never written and missing zone maps are not built
+	maps     []zoneMap
+	size     int64
+	mutex    sync.RWMutex
+	builder  *zoneMapBuilder
+}
+
+func openZoneMapIndex(fname string, readOnly bool) (*zoneMapIndex, error) {
+	file, err := openSideFile(fname, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &zoneMapIndex{file: file, readOnly: readOnly, builder: newZoneMapBuilder()}, nil
+}
+
+// load reads all persisted zone maps which belong to the given records in the same order.
+func (x *zoneMapIndex) load(records []recordInfo) error {
+	x.maps, x.size = x.maps[:0], 0
+	if x.file == nil {
+		return nil
+	}
+
+	stat, err := x.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, stat.Size())}
+	if _, err := x.file.ReadAt(buf.Bytes, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	for buf.Pos+4 <= len(buf.Bytes) && len(x.maps) < len(records) {
+		length := int(buf.ReadUint32())
+		if buf.Pos+length > len(buf.Bytes) || length < 10 {
+			break
+		}
+
+		z := zoneMap{offset: int64(buf.ReadUint64())}
+		count := int(buf.ReadUint16())
+		if length != 10+count*22 || z.offset != records[len(x.maps)].offset {
+			break
+		}
+
+		z.fields = make([]fieldZone, count)
+		for i := range z.fields {
+			z.fields[i].name = buf.ReadUint16()
+			z.fields[i].min = buf.ReadFloat64()
+			z.fields[i].max = buf.ReadFloat64()
+			z.fields[i].nullCount = buf.ReadUint32()
+		}
+
+		x.maps = append(x.maps, z)
+	}
+
+	for _, z := range x.maps {
+		x.size += int64(14 + len(z.fields)*22)
+	}
+
+	if x.readOnly {
+		return nil
+	}
+
+	return x.file.Truncate(x.size)
+}
+
+// add appends the zone map to the file and the in-memory table.
+func (x *zoneMapIndex) add(z zoneMap) error {
+	buf := &ioutil.LittleEndianBuffer{Bytes: make([]byte, 14+len(z.fields)*22)}
+	buf.WriteUint32(uint32(10 + len(z.fields)*22))
+	buf.WriteUint64(uint64(z.offset))
+	buf.WriteUint16(uint16(len(z.fields)))
+	for _, field := range z.fields {
+		buf.WriteUint16(field.name)
+		buf.WriteFloat64(field.min)
+		buf.WriteFloat64(field.max)
+		buf.WriteUint32(field.nullCount)
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if _, err := x.file.WriteAt(buf.Bytes, x.size); err != nil {
+		return fmt.Errorf("unable to write zone map: %w", err)
+	}
+
+	x.size += int64(len(buf.Bytes))
+	x.maps = append(x.maps, z)
+	return nil
+}
+
+// filter returns only those records, which may contain objects matching all ranges. Records without a zone
+// map are always returned.
+func (x *zoneMapIndex) filter(records []recordInfo, ranges []Range) []recordInfo {
+	x.mutex.RLock()
+	maps := x.maps
+	x.mutex.RUnlock()
+
+	res := make([]recordInfo, 0, len(records))
+	j := 0
+	for _, info := range records {
+		for j < len(maps) && maps[j].offset < info.offset {
+			j++
+		}
+
+		if j < len(maps) && maps[j].offset == info.offset {
+			match := true
+			for _, r := range ranges {
+				if !maps[j].mayMatch(r) {
+					match = false
+					break
+				}
+			}
+
+			if !match {
+				continue
+			}
+		}
+
+		res = append(res, info)
+	}
+
+	return res
+}
+
+func (x *zoneMapIndex) Close() error {
+	if x.file == nil {
+		return nil
+	}
+
+	return x.file.Close()
+}
+
+// openZoneMaps loads the zone maps and builds those which are missing for already existing records.
+func (db *DB) openZoneMaps() error {
+	zones, err := openZoneMapIndex(db.fname+zoneMapSuffix, db.opts.ReadOnly)
+	if err != nil {
+		return err
+	}
+
+	records := db.findRecords()
+	if err := zones.load(records); err != nil {
+		_ = zones.Close()
+		return err
+	}
+
+	db.zones = zones
+	if zones.readOnly {
+		return nil
+	}
+
+	if len(zones.maps) < len(records) {
+		db.logf("building zone maps for %d records\n", len(records)-len(zones.maps))
+	}
+
+	reader := newRecordReader(db)
+	obj := newObject(db.maxObjSize)
+	for _, info := range records[len(zones.maps):] {
+		record, err := reader.readInfo(info)
+		if err != nil {
+			return err
+		}
+
+		_ = record.ForEach(obj, func(offset int, object *Object) error {
+			zones.builder.add(object)
+			return nil
+		})
+
+		if err := zones.add(zones.builder.build(info.offset)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package logdb
+
+import (
+	ioutil2 "io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestZoneMapRanges(t *testing.T) {
+	dir, err := ioutil2.TempDir("", "zoneMapTest")
+	assertNil(t, err)
+
+	fname := filepath.Join(dir, "zonedb.bin")
+	opts := Options{Compression: true, ZoneMaps: true}
+	db, err := OpenOptions(fname, opts)
+	assertNil(t, err)
+
+	colTimestamp := db.PutName("Timestamp")
+	colTemperature := db.PutName("Temperature")
+
+	const count = 10_000
+	for i := 0; i < count; i++ {
+		err := db.Add(func(obj *Object) error {
+			obj.AddUint32(colTimestamp, uint32(1594204360+i))
+			if i%2 == 0 {
+				obj.AddInt8(colTemperature, int8(i%100))
+			}
+			return nil
+		})
+		assertNil(t, err)
+
+		if i%1000 == 999 {
+			assertNil(t, db.Flush())
+		}
+	}
+
+	assertNil(t, db.Close())
+
+	db, err = OpenOptions(fname, opts)
+	assertNil(t, err)
+	defer db.Close()
+
+	ranges := []Range{{Name: colTimestamp, Min: 1594204360 + 2500, Max: 1594204360 + 3499}}
+	if n := len(db.zones.filter(db.findRecords(), ranges)); n != 2 {
+		t.Fatalf("expected 2 records but got %d", n)
+	}
+
+	var visited uint64
+	err = db.Scan(ScanOptions{Routines: 2, Ranges: ranges}, func(gid int, id uint64, obj *Object) error {
+		atomic.AddUint64(&visited, 1)
+		return nil
+	})
+	assertNil(t, err)
+
+	if visited != 1000 {
+		t.Fatalf("expected 1000 objects but got %d", visited)
+	}
+
+	ranges = append(ranges, Range{Name: colTemperature, Min: 0, Max: 9})
+	visited = 0
+	err = db.Scan(ScanOptions{Routines: 2, Ranges: ranges}, func(gid int, id uint64, obj *Object) error {
+		atomic.AddUint64(&visited, 1)
+		return nil
+	})
+	assertNil(t, err)
+
+	if visited != 50 {
+		t.Fatalf("expected 50 objects but got %d", visited)
+	}
+}